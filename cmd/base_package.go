@@ -0,0 +1,55 @@
+package cmd
+
+import (
+	"content-prep/pkg/diff"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/sbom"
+	"io/fs"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+)
+
+// changesFileSuffix names the report of the files changed since the base
+// package.
+const changesFileSuffix = ".changes.json"
+
+// openBase opens the base package of a delta build together with the index
+// written next to it and returns a view on the source that reuses the
+// unchanged files of the base package. The returned function closes the base
+// package.
+func openBase(cmd *cobra.Command, source fs.FS, basePackageFilePath string) (*packager.BaseFS, *packager.Index, func(), error) {
+	index, err := packager.LoadIndex(strings.TrimSuffix(basePackageFilePath, packager.PackageFileExtension) + packager.IndexFileSuffix)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "base package has no usable index, build it with --index")
+	}
+
+	base, closeBase, err := openPackage(cmd, basePackageFilePath)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to open base package")
+	}
+
+	baseFS, err := packager.NewBaseFS(source, base, index)
+	if err != nil {
+		closeBase()
+		return nil, nil, nil, err
+	}
+
+	return baseFS, index, closeBase, nil
+}
+
+// indexChanges compares the files of the base package with those of the new
+// package.
+func indexChanges(base, index *packager.Index) *diff.Diff {
+	return diff.Files(indexFiles(base), indexFiles(index))
+}
+
+func indexFiles(index *packager.Index) []sbom.File {
+	files := make([]sbom.File, 0, len(index.Files))
+	for _, f := range index.Files {
+		files = append(files, sbom.File{Name: f.Name, Size: f.Size, SHA256: f.SHA256})
+	}
+
+	return files
+}
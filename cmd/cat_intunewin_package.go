@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/logger"
+	"io"
+
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(catIntuneWinCmd)
+
+	catIntuneWinCmd.Flags().StringP(config.KeyEncryptedPackageFile, "f", "", "Path to the encrypted package file")
+	_ = catIntuneWinCmd.MarkFlagRequired(config.KeyEncryptedPackageFile)
+	_ = catIntuneWinCmd.MarkFlagFilename(config.KeyEncryptedPackageFile)
+	catIntuneWinCmd.Flags().Bool(config.KeyVerify, false, "verify the HMAC of the encrypted content before reading it")
+	catIntuneWinCmd.Flags().StringP(config.KeyEntry, "e", "", "Path of the file inside the package")
+	_ = catIntuneWinCmd.MarkFlagRequired(config.KeyEntry)
+}
+
+var catIntuneWinCmd = &cobra.Command{
+	Use:     "cat",
+	Short:   "writes a single file of an intunewin package to stdout",
+	Example: "content-prep cat --file /path/to/package.intunewin --entry config/settings.ini",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "cat")
+
+		packageFilePath, err := absPath(viper.GetString(config.KeyEncryptedPackageFile))
+		if err != nil {
+			return err
+		}
+		entry := viper.GetString(config.KeyEntry)
+
+		log.Debug("trying to read file from intunewin package", "file", packageFilePath, "entry", entry)
+
+		pkg, closePkg, err := openPackage(cmd, packageFilePath)
+		if err != nil {
+			return err
+		}
+		defer closePkg()
+
+		rc, err := pkg.Open(entry)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open entry")
+		}
+		defer rc.Close()
+
+		_, err = io.Copy(cmd.OutOrStdout(), rc)
+
+		return errors.Wrapf(err, "failed to read entry")
+	},
+}
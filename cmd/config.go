@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configShowCmd)
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "inspects the configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:     "show",
+	Short:   "prints the effective configuration and where each value came from",
+	Example: "content-prep config show --profile ci",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+
+		if configFile != nil {
+			_, _ = fmt.Fprintf(out, "config file: %s\n", configFile.Path)
+			if configFile.Profile != "" {
+				_, _ = fmt.Fprintf(out, "profile:     %s\n", configFile.Profile)
+			}
+		} else {
+			_, _ = fmt.Fprintln(out, "config file: none")
+		}
+		_, _ = fmt.Fprintln(out)
+
+		w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+
+		for _, setting := range config.Settings {
+			source := config.Source(configFile, setting.Key, changedFlags)
+
+			value := formatSetting(viper.Get(setting.Key))
+			if setting.Secret && value != "" {
+				value = "***"
+			}
+
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Key, value, source)
+		}
+
+		return w.Flush()
+	},
+}
+
+func formatSetting(v any) string {
+	switch value := v.(type) {
+	case nil:
+		return ""
+	case []string:
+		return strings.Join(value, ",")
+	case []any:
+		parts := make([]string, len(value))
+		for i, part := range value {
+			parts[i] = fmt.Sprint(part)
+		}
+		return strings.Join(parts, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
@@ -0,0 +1,61 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/diff"
+	"content-prep/pkg/logger"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(diffIntuneWinCmd)
+
+	diffIntuneWinCmd.Flags().String(config.KeyFormat, diff.FormatText, "Output format: text or json")
+	diffIntuneWinCmd.Flags().Bool(config.KeyVerify, false, "verify the HMAC of the encrypted content of both packages before reading them")
+}
+
+var diffIntuneWinCmd = &cobra.Command{
+	Use:     "diff <old.intunewin> <new.intunewin>",
+	Short:   "compares the files and metadata of two intunewin packages without decrypting them to disk",
+	Example: "content-prep diff app-1.0.intunewin app-1.1.intunewin --format json",
+	Args:    cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "diff")
+
+		oldFilePath, err := absPath(args[0])
+		if err != nil {
+			return err
+		}
+
+		newFilePath, err := absPath(args[1])
+		if err != nil {
+			return err
+		}
+
+		log.Debug("trying to diff intunewin packages", "old", oldFilePath, "new", newFilePath)
+
+		oldPkg, closeOld, err := openPackage(cmd, oldFilePath)
+		if err != nil {
+			return err
+		}
+		defer closeOld()
+
+		newPkg, closeNew, err := openPackage(cmd, newFilePath)
+		if err != nil {
+			return err
+		}
+		defer closeNew()
+
+		d, err := diff.Packages(oldPkg, newPkg)
+		if err != nil {
+			return err
+		}
+
+		log.Debug("compared intunewin packages", "added", d.Count(diff.Added), "removed", d.Count(diff.Removed), "modified", d.Count(diff.Modified), "metadata", len(d.Metadata))
+
+		return diff.Write(cmd.OutOrStdout(), d, viper.GetString(config.KeyFormat))
+	},
+}
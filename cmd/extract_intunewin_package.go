@@ -0,0 +1,79 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/logger"
+	"io"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(extractIntuneWinCmd)
+
+	extractIntuneWinCmd.Flags().StringP(config.KeyEncryptedPackageFile, "f", "", "Path to the encrypted package file")
+	_ = extractIntuneWinCmd.MarkFlagRequired(config.KeyEncryptedPackageFile)
+	_ = extractIntuneWinCmd.MarkFlagFilename(config.KeyEncryptedPackageFile)
+	extractIntuneWinCmd.Flags().Bool(config.KeyVerify, false, "verify the HMAC of the encrypted content before reading it")
+	extractIntuneWinCmd.Flags().StringP(config.KeyEntry, "e", "", "Path of the file inside the package")
+	_ = extractIntuneWinCmd.MarkFlagRequired(config.KeyEntry)
+	extractIntuneWinCmd.Flags().StringP(config.KeyOutputFolder, "o", "", "Path to the output folder (defaults to the working directory)")
+	_ = extractIntuneWinCmd.MarkFlagDirname(config.KeyOutputFolder)
+}
+
+var extractIntuneWinCmd = &cobra.Command{
+	Use:     "extract",
+	Short:   "extracts a single file of an intunewin package",
+	Example: "content-prep extract --file /path/to/package.intunewin --entry config/settings.ini --output /path/to/output",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "extract")
+
+		packageFilePath, err := absPath(viper.GetString(config.KeyEncryptedPackageFile))
+		if err != nil {
+			return err
+		}
+		entry := viper.GetString(config.KeyEntry)
+
+		outputFolder, err := absPath(viper.GetString(config.KeyOutputFolder))
+		if err != nil {
+			return err
+		}
+
+		pkg, closePkg, err := openPackage(cmd, packageFilePath)
+		if err != nil {
+			return err
+		}
+		defer closePkg()
+
+		rc, err := pkg.Open(entry)
+		if err != nil {
+			return errors.Wrapf(err, "failed to open entry")
+		}
+		defer rc.Close()
+
+		outputFilePath := path.Join(outputFolder, path.Base(entry))
+		if err := os.MkdirAll(outputFolder, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "failed to create output folder")
+		}
+
+		outputFile, err := os.Create(outputFilePath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to create output file")
+		}
+		defer outputFile.Close()
+
+		if _, err := io.Copy(outputFile, rc); err != nil {
+			return errors.Wrapf(err, "failed to extract entry")
+		}
+
+		log.Info("extracted file from intunewin package", "entry", entry, "output", outputFilePath)
+
+		return nil
+	},
+}
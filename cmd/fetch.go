@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/fetch"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/source"
+	"context"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(fetchCmd)
+
+	fetchCmd.Flags().String(config.KeySources, "", "Path to a sources file listing the URLs and SHA256 of the files to download")
+	_ = fetchCmd.MarkFlagRequired(config.KeySources)
+	_ = fetchCmd.MarkFlagFilename(config.KeySources, "yaml", "yml")
+	fetchCmd.Flags().String(config.KeyCacheDir, "", "Directory downloads are cached in, defaults to content-prep in the user cache directory")
+	_ = fetchCmd.MarkFlagDirname(config.KeyCacheDir)
+}
+
+var fetchCmd = &cobra.Command{
+	Use:     "fetch",
+	Short:   "downloads the files of a sources file into the cache and verifies them",
+	Example: "content-prep fetch --sources sources.yaml",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		_, _, err := fetchSources(cmd.Context(), viper.GetString(config.KeySources))
+
+		return err
+	},
+}
+
+// fetchSources downloads the files of the sources file into the cache
+// directory, unless they are cached already, and mounts them.
+func fetchSources(ctx context.Context, sourcesFilePath string) (*fetch.Sources, *source.FileFS, error) {
+	log := logger.FromContext(ctx).With("component", "cli", "action", "fetch")
+
+	sourcesFilePath, err := absPath(sourcesFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sources, err := fetch.Load(sourcesFilePath)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cacheDir, err := config.CacheDir(viper.GetString(config.KeyCacheDir))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cacheDir, err = absPath(cacheDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	fsys, err := fetch.New(cacheDir).FS(ctx, sources)
+	if err != nil {
+		return nil, nil, err
+	}
+	log.Info("fetched sources", "sources", sourcesFilePath, "files", len(sources.Files), "cacheDir", cacheDir)
+
+	return sources, fsys, nil
+}
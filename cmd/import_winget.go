@@ -0,0 +1,197 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/installer"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/scanner"
+	"content-prep/pkg/source"
+	"content-prep/pkg/winget"
+	"os"
+	"path"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	importCmd.AddCommand(importWingetCmd)
+
+	importWingetCmd.Flags().String(config.KeyManifest, "", "Path to the directory with the winget manifests of a package version, or to a singleton manifest")
+	_ = importWingetCmd.MarkFlagRequired(config.KeyManifest)
+	importWingetCmd.Flags().String(config.KeyInstaller, "", "Path to the downloaded installer; it must match the InstallerSha256 of the manifest and is packaged if given")
+	_ = importWingetCmd.MarkFlagFilename(config.KeyInstaller)
+	importWingetCmd.Flags().String(config.KeyArchitecture, "", "Architecture of the installer, x64 is preferred over x86 and neutral installers by default")
+	importWingetCmd.Flags().String(config.KeyScope, "", "Scope of the installer: machine or user, machine is preferred by default")
+	importWingetCmd.Flags().String(config.KeyLocale, "", "Locale whose manifest overrides the package name and description of the default locale")
+	importWingetCmd.Flags().StringP(config.KeyOutputFolder, "o", "", "Path to the output folder")
+	_ = importWingetCmd.MarkFlagRequired(config.KeyOutputFolder)
+	_ = importWingetCmd.MarkFlagDirname(config.KeyOutputFolder)
+	importWingetCmd.Flags().String(config.KeyOutputName, defaultOutputName, "Template for the package file name, e.g. '{{.Name}}_{{.Version}}_{{.Architecture}}'; .Name is the package identifier")
+	addKeyProviderFlags(importWingetCmd.Flags())
+	importWingetCmd.Flags().String(config.KeyCompression, compressionStore, "Compression of the inner archive: store or deflate")
+	addCheckFlags(importWingetCmd.Flags())
+}
+
+var importWingetCmd = &cobra.Command{
+	Use:     "winget",
+	Short:   "writes the install, uninstall and detection definition of a winget package and packages its installer",
+	Example: "content-prep import winget --manifest manifests/c/Contoso/App/2.1.0 --installer ContosoApp-x64.msi --output out",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "import-winget")
+
+		manifestPath, err := absPath(viper.GetString(config.KeyManifest))
+		if err != nil {
+			return err
+		}
+
+		manifest, err := winget.Load(manifestPath, viper.GetString(config.KeyLocale))
+		if err != nil {
+			return err
+		}
+
+		selected, err := manifest.Select(viper.GetString(config.KeyArchitecture), viper.GetString(config.KeyScope))
+		if err != nil {
+			return err
+		}
+
+		definition, err := manifest.Definition(selected)
+		if err != nil {
+			return err
+		}
+		log.Info("selected installer", "package", definition.PackageIdentifier, "version", definition.Version, "type", definition.InstallerType, "architecture", definition.Architecture, "scope", definition.Scope)
+
+		outputFolder, err := absPath(viper.GetString(config.KeyOutputFolder))
+		if err != nil {
+			return err
+		}
+
+		if err := os.MkdirAll(outputFolder, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "failed to create output folder")
+		}
+
+		packageName, err := outputName(viper.GetString(config.KeyOutputName), outputNameData{
+			Name:         definition.PackageIdentifier,
+			SetupFile:    definition.SetupFile,
+			Type:         wingetInstallerType(definition.InstallerType),
+			DisplayName:  definition.DisplayName,
+			Publisher:    definition.Publisher,
+			Version:      definition.Version,
+			Architecture: definition.Architecture,
+		})
+		if err != nil {
+			return err
+		}
+		packageFilePath := path.Join(outputFolder, packageName)
+
+		if installerFilePath := viper.GetString(config.KeyInstaller); installerFilePath != "" {
+			if err := packageWingetInstaller(cmd, definition, installerFilePath, packageFilePath); err != nil {
+				return err
+			}
+		}
+
+		definitionFilePath, err := writeSidecar(packageFilePath, winget.FileSuffix, definition)
+		if err != nil {
+			return errors.Wrap(err, "failed to write app definition")
+		}
+		log.Info("wrote app definition", "file", definitionFilePath, "install", definition.InstallCommandLine, "uninstall", definition.UninstallCommandLine, "detectionRules", len(definition.DetectionRules))
+
+		for _, warning := range definition.Warnings {
+			log.Warn(warning, "file", definitionFilePath)
+		}
+
+		return nil
+	},
+}
+
+// packageWingetInstaller checks the installer against the definition and
+// packages it under the setup file name of the definition.
+func packageWingetInstaller(cmd *cobra.Command, definition *winget.Definition, installerFilePath, packageFilePath string) error {
+	ctx := cmd.Context()
+	log := logger.FromContext(ctx).With("component", "cli", "action", "import-winget")
+
+	installerFilePath, err := absPath(installerFilePath)
+	if err != nil {
+		return err
+	}
+
+	if err := definition.CheckInstaller(installerFilePath); err != nil {
+		return err
+	}
+
+	sourceFS, err := source.NewFileFS(map[string]string{definition.SetupFile: installerFilePath})
+	if err != nil {
+		return err
+	}
+
+	keyProvider, err := keyProviderFromConfig()
+	if err != nil {
+		return err
+	}
+
+	compression, err := compressionMethod(viper.GetString(config.KeyCompression))
+	if err != nil {
+		return err
+	}
+
+	checkOpts, err := checkOptionsFromConfig(ctx)
+	if err != nil {
+		return err
+	}
+
+	outputFile, err := os.Create(packageFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create output file")
+	}
+	defer outputFile.Close()
+
+	log.Info("trying to create intunewin package", "installer", installerFilePath, "setupFile", definition.SetupFile, "outputFile", outputFile.Name())
+
+	opts := append([]packager.Option{
+		packager.WithKeyProvider(keyProvider),
+		packager.WithCompression(compression),
+	}, checkOpts...)
+
+	result, err := packager.New(opts...).CreatePackage(ctx, sourceFS, definition.SetupFile, outputFile)
+	var detectionErr *scanner.DetectionError
+	if errors.As(err, &detectionErr) {
+		_ = writeScanResults(log, outputFile.Name(), detectionErr.Report)
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to create intunewin package")
+	}
+
+	if result.Scan != nil {
+		if err := writeScanResults(log, outputFile.Name(), result.Scan); err != nil {
+			return err
+		}
+	}
+
+	if err := writeInstallerInfo(log, outputFile.Name(), result.ApplicationInfo.Installer); err != nil {
+		return err
+	}
+
+	if result.Escrow != nil {
+		if err := writeEscrowRecord(log, outputFile.Name(), result.Escrow); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// wingetInstallerType maps a winget installer type to the installer type of
+// the setup file.
+func wingetInstallerType(installerType string) installer.Type {
+	switch installerType {
+	case "msi", "wix":
+		return installer.TypeMSI
+	case "msix", "appx":
+		return installer.TypeMSIX
+	default:
+		return installer.TypeEXE
+	}
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"bytes"
+	"content-prep/pkg/config"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/sbom"
+
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(inspectIntuneWinCmd)
+
+	inspectIntuneWinCmd.Flags().StringP(config.KeyEncryptedPackageFile, "f", "", "Path to the encrypted package file")
+	_ = inspectIntuneWinCmd.MarkFlagRequired(config.KeyEncryptedPackageFile)
+	_ = inspectIntuneWinCmd.MarkFlagFilename(config.KeyEncryptedPackageFile)
+	inspectIntuneWinCmd.Flags().String(config.KeyFormat, packager.MetadataFormatXML, "Output format: xml (Detection.xml) or graph (Graph JSON)")
+	inspectIntuneWinCmd.Flags().Bool(config.KeyValidate, false, "validate the Detection.xml against its schema")
+	inspectIntuneWinCmd.Flags().String(config.KeySBOM, "", "print a bill of materials of the package contents instead of the metadata: cyclonedx or spdx")
+	inspectIntuneWinCmd.Flags().Lookup(config.KeySBOM).NoOptDefVal = sbom.FormatCycloneDX
+	// The sbom setting of new lists the formats written next to a package,
+	// keep it from selecting the output of inspect.
+	_ = inspectIntuneWinCmd.Flags().SetAnnotation(config.KeySBOM, viperKeyAnnotation, []string{config.KeySBOMFormat})
+}
+
+var inspectIntuneWinCmd = &cobra.Command{
+	Use:     "inspect",
+	Short:   "prints the metadata of an intunewin package",
+	Example: "content-prep inspect --file /path/to/package.intunewin --format graph",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "inspect")
+
+		packageFilePath, err := absPath(viper.GetString(config.KeyEncryptedPackageFile))
+		if err != nil {
+			return err
+		}
+
+		format := viper.GetString(config.KeyFormat)
+		if format == packager.MetadataFormatSealed {
+			return errors.New("sealed metadata can only be emitted when creating a package")
+		}
+
+		log.Debug("trying to inspect intunewin package", "file", packageFilePath, "format", format)
+
+		pkg, closePkg, err := openPackage(cmd, packageFilePath)
+		if err != nil {
+			return err
+		}
+		defer closePkg()
+
+		if viper.GetBool(config.KeyValidate) {
+			if err := packager.ValidateDetectionXML(bytes.NewReader(pkg.DetectionXML), false); err != nil {
+				return err
+			}
+		}
+
+		if sbomFormat := viper.GetString(config.KeySBOMFormat); sbomFormat != "" {
+			files, err := sbom.Collect(pkg.FS())
+			if err != nil {
+				return errors.Wrap(err, "failed to read package contents")
+			}
+
+			// Detection.xml does not record the installer and project, detect
+			// them in the contents as new does for its bill of materials.
+			if err := packager.Default.DetectContents(ctx, pkg); err != nil {
+				return err
+			}
+
+			return sbom.Write(cmd.OutOrStdout(), packager.NewSBOM(&pkg.ApplicationInfo, files), sbomFormat)
+		}
+
+		return packager.ExportMetadata(cmd.OutOrStdout(), &pkg.ApplicationInfo, format, nil)
+	},
+}
@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/keyprovider"
+	"content-prep/pkg/packager"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// addKeyProviderFlags adds the flags selecting the source of the package keys.
+func addKeyProviderFlags(flags *pflag.FlagSet) {
+	flags.String(config.KeyKeyProvider, "", "Source of the package keys: empty for ephemeral keys, 'file' or 'kms'")
+	flags.String(config.KeyKeystore, "", "Path to the keystore directory used by the 'file' key provider")
+	_ = cobra.MarkFlagDirname(flags, config.KeyKeystore)
+	flags.String(config.KeyKMSURL, "", "Base URL of the key service used by the 'kms' key provider")
+	flags.String(config.KeyKMSToken, "", "Bearer token for the key service (CONTENT_PREP_KMSTOKEN)")
+}
+
+// keyProviderFromConfig returns the key provider selected by the flags, or nil
+// to fall back to ephemeral keys.
+func keyProviderFromConfig() (packager.KeyProvider, error) {
+	switch provider := viper.GetString(config.KeyKeyProvider); provider {
+	case "":
+		return nil, nil
+	case keyprovider.ProviderFile:
+		keystore := viper.GetString(config.KeyKeystore)
+		if keystore == "" {
+			return nil, errors.New("the file key provider requires a keystore directory")
+		}
+
+		keystore, err := absPath(keystore)
+		if err != nil {
+			return nil, err
+		}
+
+		store, err := keyprovider.NewFileStore(keystore)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to open keystore")
+		}
+
+		return store, nil
+	case keyprovider.ProviderKMS:
+		kmsURL := viper.GetString(config.KeyKMSURL)
+		if kmsURL == "" {
+			return nil, errors.New("the kms key provider requires a key service URL")
+		}
+
+		return keyprovider.NewKMS(kmsURL, viper.GetString(config.KeyKMSToken), nil), nil
+	default:
+		return nil, errors.Errorf("unknown key provider %q", provider)
+	}
+}
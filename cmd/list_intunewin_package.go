@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/packager"
+	"fmt"
+	"os"
+	"path"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(listIntuneWinCmd)
+
+	listIntuneWinCmd.Flags().StringP(config.KeyEncryptedPackageFile, "f", "", "Path to the encrypted package file")
+	_ = listIntuneWinCmd.MarkFlagRequired(config.KeyEncryptedPackageFile)
+	_ = listIntuneWinCmd.MarkFlagFilename(config.KeyEncryptedPackageFile)
+	listIntuneWinCmd.Flags().Bool(config.KeyVerify, false, "verify the HMAC of the encrypted content before reading it")
+}
+
+var listIntuneWinCmd = &cobra.Command{
+	Use:     "ls",
+	Short:   "lists the files inside an intunewin package without decrypting it to disk",
+	Example: "content-prep ls --file /path/to/package.intunewin",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "ls")
+
+		packageFilePath, err := absPath(viper.GetString(config.KeyEncryptedPackageFile))
+		if err != nil {
+			return err
+		}
+
+		log.Debug("trying to list intunewin package", "file", packageFilePath)
+
+		pkg, closePkg, err := openPackage(cmd, packageFilePath)
+		if err != nil {
+			return err
+		}
+		defer closePkg()
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "NAME\tSIZE\tCRC32\tMODIFIED")
+		for _, e := range pkg.Entries() {
+			_, _ = fmt.Fprintf(w, "%s\t%d\t%08x\t%s\n", e.Name, e.Size, e.CRC32, e.Modified.Format(time.RFC3339))
+		}
+
+		return w.Flush()
+	},
+}
+
+// openPackage opens the intunewin package at packageFilePath for random access.
+// The returned function closes both the package and the underlying file.
+func openPackage(cmd *cobra.Command, packageFilePath string) (*packager.Package, func(), error) {
+	file, err := os.Open(packageFilePath)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to open package file")
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, errors.Wrapf(err, "failed to get package file info")
+	}
+
+	pkg, err := packager.Default.OpenPackage(cmd.Context(), file, info.Size())
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, errors.Wrap(err, "failed to open intunewin package")
+	}
+
+	if viper.GetBool(config.KeyVerify) {
+		if err := pkg.Verify(); err != nil {
+			_ = pkg.Close()
+			_ = file.Close()
+			return nil, nil, errors.Wrap(err, "failed to verify intunewin package")
+		}
+	}
+
+	return pkg, func() {
+		_ = pkg.Close()
+		_ = file.Close()
+	}, nil
+}
+
+// absPath resolves p relative to the current working directory.
+func absPath(p string) (string, error) {
+	if path.IsAbs(p) {
+		return p, nil
+	}
+
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to get working directory")
+	}
+
+	return path.Join(wd, p), nil
+}
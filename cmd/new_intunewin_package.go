@@ -1,12 +1,31 @@
 package cmd
 
 import (
+	"archive/zip"
 	"content-prep/pkg/config"
+	"content-prep/pkg/diff"
+	"content-prep/pkg/fetch"
+	"content-prep/pkg/installer"
 	"content-prep/pkg/logger"
 	"content-prep/pkg/packager"
+	"content-prep/pkg/policy"
+	"content-prep/pkg/provenance"
+	"content-prep/pkg/relationship"
+	"content-prep/pkg/sbom"
+	"content-prep/pkg/scanner"
+	"content-prep/pkg/signing"
+	"content-prep/pkg/source"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"log/slog"
 	"os"
 	"path"
+	"path/filepath"
+	"slices"
 	"strings"
+	"text/template"
+	"time"
 
 	"github.com/pkg/errors"
 
@@ -17,82 +36,572 @@ import (
 func init() {
 	RootCmd.AddCommand(newCmd)
 
-	newCmd.Flags().StringP(config.KeySourceFolder, "p", "", "Path to the source folder")
-	_ = newCmd.MarkFlagRequired(config.KeySourceFolder)
-	_ = newCmd.MarkFlagDirname(config.KeySourceFolder)
-	newCmd.Flags().StringP(config.KeySetupFile, "s", "", "Path to the setup file (must be inside the source folder)")
-	_ = newCmd.MarkFlagRequired(config.KeySetupFile)
+	newCmd.Flags().StringP(config.KeySourceFolder, "p", "", "Path to the source folder, a .zip, .tar or .tar.gz archive or an oci://registry/repository:tag reference")
+	newCmd.Flags().String(config.KeySources, "", "Path to a sources file listing the URLs and SHA256 of the files to download into the cache and package, instead of --path")
+	_ = newCmd.MarkFlagFilename(config.KeySources, "yaml", "yml")
+	newCmd.MarkFlagsOneRequired(config.KeySourceFolder, config.KeySources)
+	newCmd.MarkFlagsMutuallyExclusive(config.KeySourceFolder, config.KeySources)
+	newCmd.Flags().String(config.KeyCacheDir, "", "Directory downloads of --sources are cached in, defaults to content-prep in the user cache directory")
+	_ = newCmd.MarkFlagDirname(config.KeyCacheDir)
+	newCmd.Flags().StringP(config.KeySetupFile, "s", "", "Path to the setup file (must be inside the source folder, relative to the archive root for archives), defaults to the setup file of a detected project")
 	_ = newCmd.MarkFlagFilename(config.KeySetupFile)
 	newCmd.Flags().StringP(config.KeyOutputFolder, "o", "", "Path to the output folder")
 	_ = newCmd.MarkFlagRequired(config.KeyOutputFolder)
 	_ = newCmd.MarkFlagDirname(config.KeyOutputFolder)
-
+	addKeyProviderFlags(newCmd.Flags())
+	newCmd.Flags().String(config.KeyRegistryUsername, "", "Username for OCI registries")
+	newCmd.Flags().String(config.KeyRegistryPassword, "", "Password or token for OCI registries (CONTENT_PREP_REGISTRYPASSWORD)")
+	newCmd.Flags().Bool(config.KeyRegistryPlainHTTP, false, "Talk to OCI registries over plain HTTP")
+	newCmd.Flags().String(config.KeyCompression, compressionStore, "Compression of the inner archive: store or deflate")
+	newCmd.Flags().StringSlice(config.KeyExclude, nil, "Leave files matching the given patterns out of the package")
+	newCmd.Flags().String(config.KeyOutputName, defaultOutputName, "Template for the package file name, e.g. '{{.Name}}-x64' or '{{.Name}}_{{.Version}}_{{.Architecture}}' for MSIX")
+	newCmd.Flags().StringSlice(config.KeyEmitMetadata, nil, "Write the package metadata next to the package in the given formats: json (Graph), xml (Detection.xml), sealed (encrypted Detection.xml)")
+	newCmd.Flags().String(config.KeyMetadataKey, "", "Path to the 32 byte key (raw or base64) used to seal the metadata")
+	_ = newCmd.MarkFlagFilename(config.KeyMetadataKey)
+	newCmd.Flags().String(config.KeyProvenanceKey, "", "Path to an ed25519 or ECDSA private key (PEM) to sign a provenance attestation written next to the package")
+	_ = newCmd.MarkFlagFilename(config.KeyProvenanceKey)
+	newCmd.Flags().String(config.KeyBuilderID, "", "Builder ID recorded in the provenance, detected from GitHub Actions, GitLab CI or Azure Pipelines by default")
+	newCmd.Flags().String(config.KeySignKey, "", "Path to an ed25519 or ECDSA private key (PEM) to sign the package, written to <package>"+signing.SignatureFileSuffix)
+	_ = newCmd.MarkFlagFilename(config.KeySignKey)
+	newCmd.Flags().String(config.KeyReport, "", "Write a build report (JSON) to the given path, also if the build fails")
+	_ = newCmd.MarkFlagFilename(config.KeyReport, "json")
+	newCmd.Flags().String(config.KeyJUnit, "", "Add the build stages as a JUnit test suite to the given file, also if the build fails")
+	_ = newCmd.MarkFlagFilename(config.KeyJUnit, "xml")
+	newCmd.Flags().StringSlice(config.KeySBOM, nil, "Write a bill of materials of the package contents next to the package in the given formats: cyclonedx, spdx")
+	newCmd.Flags().Bool(config.KeyIndex, false, "Write an index of the package files to <package>"+packager.IndexFileSuffix+" for later builds with --base")
+	newCmd.Flags().String(config.KeyBase, "", "Path to a previous package built with --index; files unchanged since are read from it instead of the source")
+	_ = newCmd.MarkFlagFilename(config.KeyBase, "intunewin")
+	newCmd.Flags().String(config.KeyRelationships, "", "Path to a relationships file; the Graph relationship payload of the package is written to <package>"+relationship.FileSuffix)
+	_ = newCmd.MarkFlagFilename(config.KeyRelationships, "yaml", "yml")
+	addCheckFlags(newCmd.Flags())
 }
 
 var newCmd = &cobra.Command{
 	Use:     "new",
 	Short:   "creates a new intunewin package from a setup file",
 	Example: "content-prep new --path /path/to/folder --setupFile setup.exe --output /path/to/output",
-	RunE: func(cmd *cobra.Command, args []string) error {
+	RunE: func(cmd *cobra.Command, args []string) (err error) {
 		ctx := cmd.Context()
 		log := logger.FromContext(ctx).With("component", "cli", "action", "new")
 
+		started := time.Now()
+
+		// The build report is written even if the build fails.
+		var report *packager.Report
+		defer func() {
+			if reportErr := writeReports(report, started, err); reportErr != nil {
+				log.Error("failed to write build report", "error", reportErr)
+			}
+		}()
+
+		var (
+			src     *source.Source
+			sources *fetch.Sources
+		)
 		sourceFolder := viper.GetString(config.KeySourceFolder)
-		setupFile := viper.GetString(config.KeySetupFile)
-		outputFolder := viper.GetString(config.KeyOutputFolder)
+		if sourcesFilePath := viper.GetString(config.KeySources); sourcesFilePath != "" {
+			sourceFolder, err = absPath(sourcesFilePath)
+			if err != nil {
+				return err
+			}
+
+			var sourcesFS *source.FileFS
+			sources, sourcesFS, err = fetchSources(ctx, sourceFolder)
+			if err != nil {
+				return err
+			}
+			src = &source.Source{FS: sourcesFS}
+		} else {
+			if !source.IsRemote(sourceFolder) {
+				sourceFolder, err = absPath(sourceFolder)
+				if err != nil {
+					return err
+				}
+			}
 
-		if !path.IsAbs(sourceFolder) {
-			wd, err := os.Getwd()
+			src, err = source.Open(ctx, sourceFolder,
+				source.WithRegistryAuth(viper.GetString(config.KeyRegistryUsername), viper.GetString(config.KeyRegistryPassword)),
+				source.WithPlainHTTP(viper.GetBool(config.KeyRegistryPlainHTTP)),
+			)
 			if err != nil {
-				return errors.Wrapf(err, "failed to get working directory")
+				return err
 			}
-			viper.Set(config.KeySourceFolder, path.Join(wd, sourceFolder))
+		}
+		defer src.Close()
+
+		outputFolder, err := absPath(viper.GetString(config.KeyOutputFolder))
+		if err != nil {
+			return err
+		}
+
+		if src.Dir != "" && strings.HasPrefix(outputFolder, sourceFolder) {
+			return errors.New("output folder must not be inside the source folder")
+		}
+
+		if err := os.MkdirAll(outputFolder, os.ModePerm); err != nil {
+			return errors.Wrapf(err, "failed to create output folder")
 		}
 
-		if !path.IsAbs(setupFile) {
-			wd, err := os.Getwd()
+		sourceFS := src.FS
+
+		var (
+			baseFS    *packager.BaseFS
+			baseIndex *packager.Index
+		)
+		basePackageFilePath := viper.GetString(config.KeyBase)
+		if basePackageFilePath != "" {
+			basePackageFilePath, err = absPath(basePackageFilePath)
+			if err != nil {
+				return err
+			}
+
+			var closeBase func()
+			baseFS, baseIndex, closeBase, err = openBase(cmd, src.FS, basePackageFilePath)
 			if err != nil {
-				return errors.Wrapf(err, "failed to get working directory")
+				return err
 			}
-			viper.Set(config.KeySetupFile, path.Join(wd, setupFile))
+			defer closeBase()
+			sourceFS = baseFS
+
+			log.Info("reusing unchanged files of base package", "base", basePackageFilePath, "files", len(baseIndex.Files))
 		}
 
-		if !strings.HasPrefix(setupFile, sourceFolder) {
-			return errors.New("setup file must be inside the source folder")
+		keyProvider, err := keyProviderFromConfig()
+		if err != nil {
+			return err
 		}
 
-		if !path.IsAbs(outputFolder) {
-			wd, err := os.Getwd()
+		metadataFormats := viper.GetStringSlice(config.KeyEmitMetadata)
+		for _, format := range metadataFormats {
+			if !slices.Contains(metadataFormatNames, format) {
+				return errors.Errorf("unknown metadata format %q, expected one of %s", format, strings.Join(metadataFormatNames, ", "))
+			}
+		}
+
+		var metadataKey []byte
+		if slices.Contains(metadataFormats, packager.MetadataFormatSealed) {
+			metadataKey, err = readKeyFile(viper.GetString(config.KeyMetadataKey))
 			if err != nil {
-				return errors.Wrapf(err, "failed to get working directory")
+				return errors.Wrap(err, "failed to read metadata key")
 			}
-			viper.Set(config.KeyOutputFolder, path.Join(wd, outputFolder))
 		}
 
-		if strings.HasPrefix(outputFolder, sourceFolder) {
-			return errors.New("output folder must not be inside the source folder")
+		compression, err := compressionMethod(viper.GetString(config.KeyCompression))
+		if err != nil {
+			return err
 		}
 
-		if err := os.MkdirAll(outputFolder, os.ModePerm); err != nil {
-			return errors.Wrapf(err, "failed to create output folder")
+		sbomFormats := viper.GetStringSlice(config.KeySBOM)
+		for _, format := range sbomFormats {
+			if format != sbom.FormatCycloneDX && format != sbom.FormatSPDX {
+				return errors.Errorf("unknown SBOM format %q, expected %s or %s", format, sbom.FormatCycloneDX, sbom.FormatSPDX)
+			}
+		}
+
+		checkOpts, err := checkOptionsFromConfig(ctx)
+		if err != nil {
+			return err
 		}
 
-		setupFileName := path.Base(setupFile)
-		setupFileExt := path.Ext(setupFileName)
-		packageName := strings.TrimSuffix(setupFileName, setupFileExt) + packager.PackageFileExtension
+		opts := append([]packager.Option{
+			packager.WithKeyProvider(keyProvider),
+			packager.WithCompression(compression),
+			packager.WithExclude(viper.GetStringSlice(config.KeyExclude)...),
+		}, checkOpts...)
+		if len(sbomFormats) > 0 {
+			opts = append(opts, packager.WithSBOM())
+		}
+		if viper.GetString(config.KeyProvenanceKey) != "" {
+			opts = append(opts, packager.WithSourceDigest())
+		}
+		if viper.GetBool(config.KeyIndex) || baseFS != nil {
+			opts = append(opts, packager.WithIndex())
+		}
+
+		var graph *relationship.Graph
+		if relationshipsFilePath := viper.GetString(config.KeyRelationships); relationshipsFilePath != "" {
+			graph, err = loadRelationships(relationshipsFilePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		project, err := packager.New(opts...).DetectProject(ctx, sourceFS)
+		if err != nil {
+			return err
+		}
+		p := packager.New(append(opts, packager.WithProject(project))...)
+
+		var setupFile string
+		switch {
+		case viper.GetString(config.KeySetupFile) != "":
+			setupFile, err = resolveSetupFile(src, viper.GetString(config.KeySetupFile))
+			if err != nil {
+				return err
+			}
+		case sources != nil && sources.SetupFile != "":
+			setupFile = sources.SetupFile
+		case project != nil:
+			setupFile = project.SetupFile
+			log.Info("using setup file of project", "type", project.Type, "setupFile", setupFile)
+		default:
+			return errors.New("no setup file given and no known project found in the source")
+		}
+
+		installerInfo, err := installer.Detect(sourceFS, setupFile)
+		if err != nil {
+			return err
+		}
+
+		packageName, err := outputName(viper.GetString(config.KeyOutputName), newOutputNameData(setupFile, installerInfo, project))
+		if err != nil {
+			return err
+		}
+
+		if path.Join(outputFolder, packageName) == basePackageFilePath {
+			return errors.New("package must not overwrite the base package")
+		}
 
 		outputFile, err := os.Create(path.Join(outputFolder, packageName))
 		if err != nil {
 			return errors.Wrapf(err, "failed to create output file")
 		}
+		defer outputFile.Close()
 
-		source := os.DirFS(sourceFolder)
+		log.Info("trying to create intunewin package", "source", sourceFolder, "setupFile", setupFile, "outputFile", outputFile.Name())
 
-		log.Info("trying to create intunewin package", "setupFile", setupFile, "outputFolder", outputFile)
+		result, err := p.CreatePackage(ctx, sourceFS, setupFile, outputFile)
 
-		return errors.Wrap(
-			packager.Default.CreatePackage(ctx, source, setupFile, outputFile),
-			"failed to create intunewin package",
-		)
+		var buildErr *packager.BuildError
+		switch {
+		case err == nil:
+			report = result.Report
+		case errors.As(err, &buildErr):
+			report = buildErr.Report
+		}
+		if report != nil {
+			report.Source, report.Output = sourceFolder, outputFile.Name()
+			if baseFS != nil {
+				report.FilesReused, report.BytesReused = baseFS.Reused()
+			}
+		}
+
+		var detectionErr *scanner.DetectionError
+		if errors.As(err, &detectionErr) {
+			_ = writeScanResults(log, outputFile.Name(), detectionErr.Report)
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to create intunewin package")
+		}
+
+		if result.Scan != nil {
+			if err := writeScanResults(log, outputFile.Name(), result.Scan); err != nil {
+				return err
+			}
+		}
+
+		if err := writeInstallerInfo(log, outputFile.Name(), result.ApplicationInfo.Installer); err != nil {
+			return err
+		}
+
+		if result.Index != nil {
+			indexFilePath, err := writeSidecar(outputFile.Name(), packager.IndexFileSuffix, result.Index)
+			if err != nil {
+				return errors.Wrap(err, "failed to write index")
+			}
+			log.Info("wrote index", "file", indexFilePath, "files", len(result.Index.Files))
+		}
+
+		if baseFS != nil {
+			changes := indexChanges(baseIndex, result.Index)
+
+			changesFilePath, err := writeSidecar(outputFile.Name(), changesFileSuffix, changes)
+			if err != nil {
+				return errors.Wrap(err, "failed to write changed files")
+			}
+
+			reused, reusedBytes := baseFS.Reused()
+			log.Info("wrote changed files", "file", changesFilePath, "added", changes.Count(diff.Added), "removed", changes.Count(diff.Removed), "modified", changes.Count(diff.Modified), "unchanged", changes.Unchanged, "reused", reused, "reusedBytes", reusedBytes)
+		}
+
+		if result.Escrow != nil {
+			if err := writeEscrowRecord(log, outputFile.Name(), result.Escrow); err != nil {
+				return err
+			}
+		}
+
+		for _, format := range metadataFormats {
+			metadataFilePath := strings.TrimSuffix(outputFile.Name(), packager.PackageFileExtension) + packager.MetadataFileSuffix(format)
+
+			if err := writeMetadata(metadataFilePath, result.ApplicationInfo, format, metadataKey); err != nil {
+				return errors.Wrapf(err, "failed to write %s metadata", format)
+			}
+			log.Info("wrote package metadata", "format", format, "file", metadataFilePath)
+		}
+
+		if viper.GetString(config.KeyProvenanceKey) != "" {
+			provenanceFilePath, err := writeProvenance(outputFile.Name(), provenance.Build{
+				Source:        sourceFolder,
+				SourceDigest:  result.SourceDigest,
+				SetupFile:     setupFile,
+				Package:       packageName,
+				PackageDigest: result.PackageDigest,
+				Parameters: map[string]any{
+					"compression": viper.GetString(config.KeyCompression),
+					"exclude":     viper.GetStringSlice(config.KeyExclude),
+					"outputName":  viper.GetString(config.KeyOutputName),
+					"keyProvider": viper.GetString(config.KeyKeyProvider),
+				},
+				ToolVersion: cmd.Root().Version,
+				Started:     started,
+				Finished:    time.Now(),
+			})
+			if err != nil {
+				return errors.Wrap(err, "failed to write provenance")
+			}
+			log.Info("wrote provenance", "file", provenanceFilePath, "packageDigest", result.PackageDigest, "sourceDigest", result.SourceDigest)
+		}
+
+		if viper.GetString(config.KeySignKey) != "" {
+			signatureFilePath, keyID, err := writePackageSignature(outputFile.Name())
+			if err != nil {
+				return errors.Wrap(err, "failed to sign package")
+			}
+			log.Info("signed package", "file", signatureFilePath, "keyId", keyID)
+		}
+
+		if graph != nil {
+			if app := findApp(cmd, graph, outputFile.Name(), result.ApplicationInfo); app != nil {
+				if err := writeRelationships(cmd, graph, outputFile.Name(), app); err != nil {
+					return errors.Wrap(err, "failed to write relationships")
+				}
+			}
+		}
+
+		for _, format := range sbomFormats {
+			sbomFilePath := strings.TrimSuffix(outputFile.Name(), packager.PackageFileExtension) + sbom.FileSuffix(format)
+
+			if err := writeSBOM(sbomFilePath, result.SBOM, format); err != nil {
+				return errors.Wrapf(err, "failed to write %s SBOM", format)
+			}
+			log.Info("wrote bill of materials", "format", format, "file", sbomFilePath)
+		}
+
+		return nil
 	},
 }
+
+// writeEscrowRecord writes the escrow record of the package keys next to the
+// package, from which the keys can be recovered.
+func writeEscrowRecord(log *slog.Logger, packageFilePath string, escrow *packager.EscrowRecord) error {
+	escrowFilePath, err := writeSidecar(packageFilePath, escrowFileSuffix, escrow)
+	if err != nil {
+		return errors.Wrap(err, "failed to write escrow record")
+	}
+	log.Info("wrote escrow record", "provider", escrow.Provider, "keyId", escrow.KeyID, "file", escrowFilePath)
+
+	return nil
+}
+
+// writeInstallerInfo writes the detected installer type and command lines next
+// to the package, as Detection.xml has no place for them.
+func writeInstallerInfo(log *slog.Logger, packageFilePath string, info *installer.Info) error {
+	installerFilePath, err := writeSidecar(packageFilePath, installer.FileSuffix, info)
+	if err != nil {
+		return errors.Wrap(err, "failed to write installer info")
+	}
+	log.Info("wrote installer info", "type", info.Type, "file", installerFilePath)
+
+	return nil
+}
+
+func writeSBOM(sbomFilePath string, d *sbom.Document, format string) error {
+	sbomFile, err := os.Create(sbomFilePath)
+	if err != nil {
+		return err
+	}
+	defer sbomFile.Close()
+
+	return sbom.Write(sbomFile, d, format)
+}
+
+// resolveSetupFile returns the path of the setup file inside the source. For
+// directories the setup file is a path on disk inside the directory, for
+// archives it is relative to the archive root.
+func resolveSetupFile(src *source.Source, setupFile string) (string, error) {
+	name := strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(setupFile)), "/")
+
+	if src.Dir != "" {
+		abs, err := absPath(setupFile)
+		if err != nil {
+			return "", err
+		}
+
+		rel, err := filepath.Rel(src.Dir, abs)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", errors.New("setup file must be inside the source folder")
+		}
+
+		name = filepath.ToSlash(rel)
+	}
+
+	info, err := fs.Stat(src.FS, name)
+	if err != nil {
+		return "", errors.Wrapf(err, "setup file not found in source")
+	}
+
+	if info.IsDir() {
+		return "", errors.Errorf("setup file %s is a directory", name)
+	}
+
+	return name, nil
+}
+
+func writeMetadata(metadataFilePath string, ai *packager.ApplicationInfo, format string, key []byte) error {
+	metadataFile, err := os.Create(metadataFilePath)
+	if err != nil {
+		return err
+	}
+	defer metadataFile.Close()
+
+	return packager.ExportMetadata(metadataFile, ai, format, key)
+}
+
+// readKeyFile reads a 32 byte key stored either raw or base64 encoded.
+func readKeyFile(keyFilePath string) ([]byte, error) {
+	if keyFilePath == "" {
+		return nil, errors.New("no key file given")
+	}
+
+	data, err := os.ReadFile(keyFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 32 {
+		return data, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil || len(key) != 32 {
+		return nil, errors.New("key must be 32 bytes, either raw or base64 encoded")
+	}
+
+	return key, nil
+}
+
+// metadataFormatNames are the formats accepted by --emitMetadata.
+var metadataFormatNames = []string{packager.MetadataFormatJSON, packager.MetadataFormatGraph, packager.MetadataFormatXML, packager.MetadataFormatSealed}
+
+const (
+	compressionStore   = "store"
+	compressionDeflate = "deflate"
+
+	defaultOutputName = "{{.Name}}"
+
+	// escrowFileSuffix names the escrow record written next to the package.
+	escrowFileSuffix = ".escrow.json"
+)
+
+func compressionMethod(name string) (uint16, error) {
+	switch strings.ToLower(name) {
+	case "", compressionStore:
+		return zip.Store, nil
+	case compressionDeflate:
+		return zip.Deflate, nil
+	default:
+		return 0, errors.Errorf("unknown compression %q, expected %s or %s", name, compressionStore, compressionDeflate)
+	}
+}
+
+// outputNameData is available to the output name template.
+type outputNameData struct {
+	// Name is the setup file name without its extension, or the identity name
+	// of MSIX packages.
+	Name      string
+	SetupFile string
+	Type      installer.Type
+
+	// Set for MSIX packages and detected projects.
+	DisplayName  string
+	Publisher    string
+	Version      string
+	Architecture string
+}
+
+func newOutputNameData(setupFile string, info *installer.Info, project *packager.Project) outputNameData {
+	setupFileName := path.Base(setupFile)
+
+	data := outputNameData{
+		Name:      strings.TrimSuffix(setupFileName, path.Ext(setupFileName)),
+		SetupFile: setupFileName,
+		Type:      info.Type,
+	}
+
+	if info.Msix != nil {
+		data.Name = info.Msix.Name
+		data.DisplayName = info.Msix.DisplayName
+		data.Publisher = info.Msix.Publisher
+		data.Version = info.Msix.Version
+		data.Architecture = info.Msix.ProcessorArchitecture
+	}
+
+	if project != nil && project.SetupFile == setupFile && project.Name != "" {
+		data.Name = project.Name
+		data.DisplayName = project.Name
+		data.Publisher = project.Vendor
+		data.Version = project.Version
+	}
+
+	return data
+}
+
+// outputName renders the package file name from the output name template.
+func outputName(tmpl string, data outputNameData) (string, error) {
+	if tmpl == "" {
+		tmpl = defaultOutputName
+	}
+
+	t, err := template.New("outputName").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid output name template")
+	}
+
+	var name strings.Builder
+	if err := t.Execute(&name, data); err != nil {
+		return "", errors.Wrapf(err, "failed to render output name")
+	}
+
+	if name.Len() == 0 || strings.ContainsAny(name.String(), "/\\") {
+		return "", errors.Errorf("invalid output name %q", name.String())
+	}
+
+	if !strings.HasSuffix(name.String(), packager.PackageFileExtension) {
+		name.WriteString(packager.PackageFileExtension)
+	}
+
+	return name.String(), nil
+}
+
+// loadPolicy reads the package policy.
+func loadPolicy(policyFilePath string) (*policy.Policy, error) {
+	policyFilePath, err := absPath(policyFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return policy.Load(policyFilePath)
+}
+
+// writeSidecar writes v as JSON into a file next to the package file, named
+// after the package with the given suffix.
+func writeSidecar(packageFilePath string, suffix string, v any) (string, error) {
+	sidecarFilePath := strings.TrimSuffix(packageFilePath, packager.PackageFileExtension) + suffix
+
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	return sidecarFilePath, os.WriteFile(sidecarFilePath, append(data, '\n'), 0644)
+}
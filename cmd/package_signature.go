@@ -0,0 +1,77 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/signing"
+	"os"
+
+	"github.com/spf13/viper"
+)
+
+// writePackageSignature signs the package with the configured key and writes
+// the detached signature next to it.
+func writePackageSignature(packageFilePath string) (string, string, error) {
+	keyFilePath, err := absPath(viper.GetString(config.KeySignKey))
+	if err != nil {
+		return "", "", err
+	}
+
+	signer, err := signing.LoadSigner(keyFilePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	packageFile, err := os.Open(packageFilePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer packageFile.Close()
+
+	signature, err := signing.SignDetached(packageFile, signer)
+	if err != nil {
+		return "", "", err
+	}
+
+	signatureFilePath := packageFilePath + signing.SignatureFileSuffix
+
+	signatureFile, err := os.Create(signatureFilePath)
+	if err != nil {
+		return "", "", err
+	}
+	defer signatureFile.Close()
+
+	return signatureFilePath, signer.KeyID, signing.WriteDetached(signatureFile, signature)
+}
+
+// verifyPackageSignature checks the detached signature of the package against
+// the trusted keys and returns the ID of the signing key.
+func verifyPackageSignature(packageFilePath string) (string, error) {
+	keys, err := trustedKeysFromConfig()
+	if err != nil {
+		return "", err
+	}
+
+	signatureFilePath := viper.GetString(config.KeySignatureFile)
+	if signatureFilePath == "" {
+		signatureFilePath = packageFilePath + signing.SignatureFileSuffix
+	}
+
+	signatureFile, err := os.Open(signatureFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer signatureFile.Close()
+
+	signature, err := signing.ReadDetached(signatureFile)
+	if err != nil {
+		return "", err
+	}
+
+	packageFile, err := os.Open(packageFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer packageFile.Close()
+
+	return signature.KeyID, signing.VerifyDetached(packageFile, signature, keys)
+}
@@ -0,0 +1,87 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/provenance"
+	"content-prep/pkg/signing"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// builderFromEnvironment identifies the pipeline running the build, falling
+// back to the host name outside of known CI systems.
+func builderFromEnvironment() (builderID, invocationID string) {
+	switch {
+	case os.Getenv("GITHUB_ACTIONS") == "true":
+		server := os.Getenv("GITHUB_SERVER_URL")
+		invocationID = server + "/" + os.Getenv("GITHUB_REPOSITORY") + "/actions/runs/" + os.Getenv("GITHUB_RUN_ID") + "/attempts/" + os.Getenv("GITHUB_RUN_ATTEMPT")
+
+		return server + "/" + os.Getenv("GITHUB_WORKFLOW_REF"), invocationID
+	case os.Getenv("GITLAB_CI") == "true":
+		return os.Getenv("CI_PROJECT_URL") + "/-/blob/" + os.Getenv("CI_COMMIT_SHA") + "/" + os.Getenv("CI_CONFIG_PATH"), os.Getenv("CI_JOB_URL")
+	case os.Getenv("TF_BUILD") == "True":
+		project := strings.TrimSuffix(os.Getenv("SYSTEM_COLLECTIONURI"), "/") + "/" + os.Getenv("SYSTEM_TEAMPROJECT")
+
+		return project + "/_build?definitionId=" + os.Getenv("SYSTEM_DEFINITIONID"), project + "/_build/results?buildId=" + os.Getenv("BUILD_BUILDID")
+	default:
+		hostname, _ := os.Hostname()
+
+		return "content-prep://" + hostname, ""
+	}
+}
+
+// writeProvenance signs the provenance statement of the build with the
+// configured key and writes it next to the package.
+func writeProvenance(packageFilePath string, build provenance.Build) (string, error) {
+	keyFilePath, err := absPath(viper.GetString(config.KeyProvenanceKey))
+	if err != nil {
+		return "", err
+	}
+
+	signer, err := signing.LoadSigner(keyFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	build.BuilderID, build.InvocationID = builderFromEnvironment()
+	if builderID := viper.GetString(config.KeyBuilderID); builderID != "" {
+		build.BuilderID = builderID
+	}
+
+	envelope, err := provenance.Sign(provenance.NewStatement(build), signer)
+	if err != nil {
+		return "", err
+	}
+
+	provenanceFilePath := strings.TrimSuffix(packageFilePath, packager.PackageFileExtension) + provenance.FileSuffix
+
+	provenanceFile, err := os.Create(provenanceFilePath)
+	if err != nil {
+		return "", err
+	}
+	defer provenanceFile.Close()
+
+	return provenanceFilePath, provenance.Write(provenanceFile, envelope)
+}
+
+// trustedKeysFromConfig loads the trusted public keys.
+func trustedKeysFromConfig() (*signing.KeySet, error) {
+	keyFilePaths := viper.GetStringSlice(config.KeyTrustedKeys)
+	if len(keyFilePaths) == 0 {
+		return nil, errors.New("no trusted keys given")
+	}
+
+	for i, keyFilePath := range keyFilePaths {
+		abs, err := absPath(keyFilePath)
+		if err != nil {
+			return nil, err
+		}
+		keyFilePaths[i] = abs
+	}
+
+	return signing.LoadKeySet(keyFilePaths...)
+}
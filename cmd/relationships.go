@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/relationship"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(relationshipsCmd)
+
+	relationshipsCmd.Flags().String(config.KeyRelationships, "", "Path to the relationships file declaring dependencies and supersedence between apps")
+	_ = relationshipsCmd.MarkFlagRequired(config.KeyRelationships)
+	_ = relationshipsCmd.MarkFlagFilename(config.KeyRelationships, "yaml", "yml")
+}
+
+var relationshipsCmd = &cobra.Command{
+	Use:     "relationships <package.intunewin>...",
+	Short:   "writes the Graph relationship payloads of intunewin packages next to them",
+	Example: "content-prep relationships --relationships apps.yaml out/*.intunewin",
+	Args:    cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "relationships")
+
+		graph, err := loadRelationships(viper.GetString(config.KeyRelationships))
+		if err != nil {
+			return err
+		}
+		log.Debug("loaded relationships", "apps", len(graph.Apps))
+
+		type declaredPackage struct {
+			path string
+			app  *relationship.App
+		}
+
+		var (
+			packages []declaredPackage
+			built    []*relationship.App
+		)
+		for _, arg := range args {
+			packageFilePath, err := absPath(arg)
+			if err != nil {
+				return err
+			}
+
+			pkg, closePkg, err := openPackage(cmd, packageFilePath)
+			if err != nil {
+				return err
+			}
+			err = packager.Default.DetectContents(ctx, pkg)
+			info := pkg.ApplicationInfo
+			closePkg()
+			if err != nil {
+				return err
+			}
+
+			app := findApp(cmd, graph, packageFilePath, &info)
+			if app == nil {
+				continue
+			}
+
+			packages = append(packages, declaredPackage{path: packageFilePath, app: app})
+			built = append(built, app)
+		}
+
+		if missing := graph.Missing(built); len(missing) > 0 {
+			return errors.Wrapf(relationship.ErrInvalid, "no package and no app id for %s", strings.Join(missing, ", "))
+		}
+
+		for _, p := range packages {
+			if err := writeRelationships(cmd, graph, p.path, p.app); err != nil {
+				return errors.Wrap(err, "failed to write relationships")
+			}
+		}
+
+		return nil
+	},
+}
+
+// loadRelationships reads and validates the relationships file.
+func loadRelationships(relationshipsFilePath string) (*relationship.Graph, error) {
+	relationshipsFilePath, err := absPath(relationshipsFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return relationship.Load(relationshipsFilePath)
+}
+
+// findApp returns the app declared for a package, or nil with a warning.
+func findApp(cmd *cobra.Command, graph *relationship.Graph, packageFilePath string, info *packager.ApplicationInfo) *relationship.App {
+	app := graph.Find(info.Name, info.Version())
+	if app == nil {
+		logger.FromContext(cmd.Context()).Warn("package is not declared in the relationships", "component", "cli", "action", "relationships", "file", packageFilePath, "name", info.Name, "version", info.Version())
+	}
+
+	return app
+}
+
+// writeRelationships writes the Graph relationship payload of the app next to
+// its package.
+func writeRelationships(cmd *cobra.Command, graph *relationship.Graph, packageFilePath string, app *relationship.App) error {
+	log := logger.FromContext(cmd.Context()).With("component", "cli", "action", "relationships")
+
+	payload := graph.Payload(app)
+	relationshipsFilePath, err := writeSidecar(packageFilePath, relationship.FileSuffix, payload)
+	if err != nil {
+		return err
+	}
+
+	log.Info("wrote relationships", "app", app.Ref, "file", relationshipsFilePath, "dependencies", len(app.Dependencies), "supersedes", len(app.Supersedes))
+	for i, r := range payload.Relationships {
+		if r.TargetID == "" {
+			log.Warn("relationship target has no app id yet", "file", relationshipsFilePath, "relationship", i, "target", r.TargetDisplayName, "version", r.TargetDisplayVersion)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"bytes"
+	"content-prep/pkg/config"
+	"content-prep/pkg/packager"
+	"encoding/json"
+	"io/fs"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+// writeReports writes the build report as JSON and JUnit XML, if requested. A
+// build that failed before the packager ran is reported with its error only.
+func writeReports(report *packager.Report, started time.Time, buildErr error) error {
+	reportFilePath, junitFilePath := viper.GetString(config.KeyReport), viper.GetString(config.KeyJUnit)
+	if reportFilePath == "" && junitFilePath == "" {
+		return nil
+	}
+
+	if report == nil {
+		report = &packager.Report{
+			Source:    viper.GetString(config.KeySourceFolder),
+			SetupFile: viper.GetString(config.KeySetupFile),
+			Started:   started,
+			Seconds:   time.Since(started).Seconds(),
+		}
+	}
+	if buildErr != nil && !report.Failed() {
+		report.Error = buildErr.Error()
+	}
+
+	if reportFilePath != "" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(reportFilePath, append(data, '\n'), 0644); err != nil {
+			return errors.Wrapf(err, "failed to write report")
+		}
+	}
+
+	// The JUnit report collects the builds of a batch, each build adds its
+	// suite to the file.
+	if junitFilePath != "" {
+		existing, err := os.ReadFile(junitFilePath)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return errors.Wrapf(err, "failed to read JUnit report")
+		}
+
+		var buf bytes.Buffer
+		if err := packager.AppendJUnit(bytes.NewReader(existing), &buf, report); err != nil {
+			return errors.Wrapf(err, "failed to write JUnit report")
+		}
+
+		if err := os.WriteFile(junitFilePath, buf.Bytes(), 0644); err != nil {
+			return errors.Wrapf(err, "failed to write JUnit report")
+		}
+	}
+
+	return nil
+}
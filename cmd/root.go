@@ -1,10 +1,20 @@
 package cmd
 
 import (
+	"content-prep/pkg/authenticode"
 	"content-prep/pkg/config"
+	"content-prep/pkg/fetch"
 	"content-prep/pkg/logger"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/policy"
+	"content-prep/pkg/provenance"
+	"content-prep/pkg/scanner"
+	"content-prep/pkg/signing"
+	"content-prep/pkg/winget"
 	"os"
 
+	"github.com/pkg/errors"
+
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -12,22 +22,98 @@ import (
 
 func init() {
 	cobra.OnInitialize(func() {
-		viper.SetEnvPrefix("CONTENT_PREP")
+		viper.SetEnvPrefix(config.EnvPrefix)
 		viper.AutomaticEnv()
 
+		recordChangedFlags([]*cobra.Command{RootCmd})
+		cobra.CheckErr(loadConfigFile())
+
 		walkBindCommands([]*cobra.Command{RootCmd})
 	})
 
 	RootCmd.PersistentFlags().Bool(config.KeyJSONLogging, false, "enable JSON logging (CONTENT_PREP_JSON)")
 	RootCmd.PersistentFlags().Bool(config.KeyVerboseLogging, false, "enable verbose logging (CONTENT_PREP_VERBOSE)")
+	RootCmd.PersistentFlags().String(config.KeyConfigFile, "", "path to the config file (defaults to ./content-prep.yaml or $XDG_CONFIG_HOME/content-prep/content-prep.yaml)")
+	_ = RootCmd.MarkPersistentFlagFilename(config.KeyConfigFile, "yaml", "yml")
+	RootCmd.PersistentFlags().String(config.KeyProfile, "", "named profile of the config file to apply (CONTENT_PREP_PROFILE)")
+}
+
+var (
+	// configFile is the loaded config file, nil if there is none.
+	configFile *config.File
+
+	// changedFlags holds the flags given on the command line.
+	changedFlags = map[string]bool{}
+)
+
+func loadConfigFile() error {
+	explicit, _ := RootCmd.PersistentFlags().GetString(config.KeyConfigFile)
+	if explicit == "" {
+		explicit = os.Getenv(config.EnvName(config.KeyConfigFile))
+	}
+
+	configFilePath, err := config.Discover(explicit)
+	if err != nil {
+		return err
+	}
+
+	profile, _ := RootCmd.PersistentFlags().GetString(config.KeyProfile)
+	if profile == "" {
+		profile = os.Getenv(config.EnvName(config.KeyProfile))
+	}
+
+	if configFilePath == "" {
+		if profile != "" {
+			return errors.Errorf("profile %q requires a config file", profile)
+		}
+
+		return nil
+	}
+
+	configFile, err = config.Load(viper.GetViper(), configFilePath, profile)
+
+	return err
+}
+
+func recordChangedFlags(commands []*cobra.Command) {
+	for _, cmd := range commands {
+		cmd.Flags().VisitAll(func(f *pflag.Flag) {
+			if f.Changed {
+				changedFlags[viperKey(f)] = true
+			}
+		})
+
+		if cmd.HasSubCommands() {
+			recordChangedFlags(cmd.Commands())
+		}
+	}
+}
+
+// viperKeyAnnotation binds a flag to a key other than its name, for flags
+// whose name is a setting of a different meaning in other commands.
+const viperKeyAnnotation = "content-prep/key"
+
+// viperKey returns the key a flag is bound to.
+func viperKey(f *pflag.Flag) string {
+	if keys := f.Annotations[viperKeyAnnotation]; len(keys) > 0 {
+		return keys[0]
+	}
+
+	return f.Name
+}
+
+func bindFlags(flags *pflag.FlagSet) {
+	flags.VisitAll(func(f *pflag.Flag) {
+		_ = viper.BindPFlag(viperKey(f), f)
+	})
 }
 
 func walkBindCommands(commands []*cobra.Command) {
 	for _, cmd := range commands {
-		_ = viper.BindPFlags(cmd.Flags())
+		bindFlags(cmd.Flags())
 		cmd.Flags().VisitAll(func(f *pflag.Flag) {
-			if viper.IsSet(f.Name) && viper.GetString(f.Name) != "" {
-				_ = cmd.Flags().Set(f.Name, viper.GetString(f.Name))
+			if key := viperKey(f); viper.IsSet(key) && viper.GetString(key) != "" {
+				_ = cmd.Flags().Set(f.Name, viper.GetString(key))
 			}
 		})
 
@@ -42,6 +128,10 @@ var RootCmd = &cobra.Command{
 	Short:            "open-source implementation of the Microsoft ContentPrep tool",
 	TraverseChildren: true,
 	PersistentPreRun: func(cmd *cobra.Command, args []string) {
+		// Several commands share flag names, so the flags of the executed command
+		// have to win over whichever command was bound last during initialization.
+		bindFlags(cmd.Flags())
+
 		l := logger.Init(viper.GetBool(config.KeyJSONLogging), viper.GetBool(config.KeyVerboseLogging))
 
 		ctx := cmd.Context()
@@ -51,11 +141,54 @@ var RootCmd = &cobra.Command{
 	},
 }
 
-func Execute() {
+// Exit codes returned when a package does not match its Detection.xml, its
+// binaries violate the signature policy, its provenance cannot be verified,
+// its content contains a threat or it violates the package policy.
+const (
+	ExitCodeError                  = 1
+	ExitCodeHMACMismatch           = 10
+	ExitCodeDigestMismatch         = 11
+	ExitCodeSizeMismatch           = 12
+	ExitCodeUnknownProfile         = 13
+	ExitCodeUnknownDigestAlgorithm = 14
+	ExitCodeSignaturePolicy        = 15
+	ExitCodeUntrusted              = 16
+	ExitCodeThreatDetected         = 17
+	ExitCodePolicyViolation        = 18
+)
+
+func Execute(version string) {
+	RootCmd.Version = version
+
 	if err := RootCmd.Execute(); err != nil {
 		l := logger.FromContext(RootCmd.Context())
 
 		l.Error("error executing command", "error", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
+	}
+}
+
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, packager.ErrHMACMismatch):
+		return ExitCodeHMACMismatch
+	case errors.Is(err, packager.ErrDigestMismatch), errors.Is(err, winget.ErrChecksum), errors.Is(err, fetch.ErrChecksum):
+		return ExitCodeDigestMismatch
+	case errors.Is(err, packager.ErrSizeMismatch):
+		return ExitCodeSizeMismatch
+	case errors.Is(err, packager.ErrUnknownProfile):
+		return ExitCodeUnknownProfile
+	case errors.Is(err, packager.ErrUnknownDigestAlgorithm):
+		return ExitCodeUnknownDigestAlgorithm
+	case errors.Is(err, authenticode.ErrPolicyViolation):
+		return ExitCodeSignaturePolicy
+	case errors.Is(err, signing.ErrInvalidSignature), errors.Is(err, signing.ErrUntrustedKey), errors.Is(err, provenance.ErrSubjectMismatch):
+		return ExitCodeUntrusted
+	case errors.Is(err, scanner.ErrDetected):
+		return ExitCodeThreatDetected
+	case errors.Is(err, policy.ErrViolation):
+		return ExitCodePolicyViolation
+	default:
+		return ExitCodeError
 	}
 }
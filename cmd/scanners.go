@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/scanner"
+	"context"
+	"log/slog"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// addCheckFlags adds the flags of the checks run on the source before it is
+// packaged: content scanners, the package policy and the signature policy.
+func addCheckFlags(flags *pflag.FlagSet) {
+	flags.String(config.KeyClamd, "", "Scan the source with clamd before packaging, e.g. unix:///run/clamav/clamd.ctl or tcp://localhost:3310")
+	flags.String(config.KeyScanCommand, "", "Scan each file of the source with a command before packaging; the file is passed on stdin or as {}, exit code 1 reports a threat")
+	flags.String(config.KeyPolicy, "", "Path to a policy file with rules the package must comply with")
+	_ = flags.SetAnnotation(config.KeyPolicy, cobra.BashCompFilenameExt, []string{"yaml", "yml"})
+	addSignatureFlags(flags)
+}
+
+// checkOptionsFromConfig returns the packager options of the configured
+// checks.
+func checkOptionsFromConfig(ctx context.Context) ([]packager.Option, error) {
+	signaturePolicy, signatureOpts, err := signaturePolicyFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	scanners, err := scannersFromConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []packager.Option{packager.WithScanners(scanners...)}
+
+	// Signatures are only inspected to enforce the policy or to list their
+	// problems in a build report.
+	if signaturePolicy.Enabled() || viper.GetString(config.KeyReport) != "" || viper.GetString(config.KeyJUnit) != "" {
+		opts = append(opts, packager.WithSignaturePolicy(signaturePolicy, signatureOpts))
+	}
+
+	if policyFilePath := viper.GetString(config.KeyPolicy); policyFilePath != "" {
+		packagePolicy, err := loadPolicy(policyFilePath)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, packager.WithPolicy(packagePolicy))
+	}
+
+	return opts, nil
+}
+
+// writeScanResults writes the results of the content scanners next to the
+// package.
+func writeScanResults(log *slog.Logger, packageFilePath string, scan *scanner.Report) error {
+	scanFilePath, err := writeSidecar(packageFilePath, scanner.FileSuffix, scan)
+	if err != nil {
+		return errors.Wrap(err, "failed to write scan results")
+	}
+	log.Info("wrote scan results", "file", scanFilePath)
+
+	return nil
+}
+
+// scannersFromConfig returns the configured content scanners. clamd is pinged,
+// so an unreachable daemon fails before anything is packaged.
+func scannersFromConfig(ctx context.Context) ([]scanner.Scanner, error) {
+	var scanners []scanner.Scanner
+
+	if address := viper.GetString(config.KeyClamd); address != "" {
+		clamd, err := scanner.NewClamd(address)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := clamd.Ping(ctx); err != nil {
+			return nil, errors.Wrapf(err, "clamd at %s is not available", address)
+		}
+
+		scanners = append(scanners, clamd)
+	}
+
+	command, err := scanner.ParseCommand(viper.GetString(config.KeyScanCommand))
+	if err != nil {
+		return nil, err
+	}
+	if len(command) > 0 {
+		scanners = append(scanners, scanner.NewExec(command[0], command[1:]...))
+	}
+
+	return scanners, nil
+}
@@ -0,0 +1,163 @@
+package cmd
+
+import (
+	"content-prep/pkg/authenticode"
+	"content-prep/pkg/config"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/source"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+const (
+	signaturesFormatTable = "table"
+	signaturesFormatJSON  = "json"
+)
+
+func init() {
+	RootCmd.AddCommand(signaturesCmd)
+
+	signaturesCmd.Flags().StringP(config.KeySourceFolder, "p", "", "Path to the source folder or a .zip, .tar or .tar.gz archive")
+	_ = signaturesCmd.MarkFlagRequired(config.KeySourceFolder)
+	signaturesCmd.Flags().StringSlice(config.KeyExclude, nil, "Skip files matching the given patterns")
+	signaturesCmd.Flags().String(config.KeyFormat, signaturesFormatTable, "Output format: table or json")
+	addSignatureFlags(signaturesCmd.Flags())
+}
+
+// addSignatureFlags adds the flags of the signature policy.
+func addSignatureFlags(flags *pflag.FlagSet) {
+	flags.Bool(config.KeyRequireSigned, false, "Fail on unsigned .exe, .dll and .msi files or files not matching their signature")
+	flags.Bool(config.KeyRequireTrusted, false, "Fail on binaries not signed by a trusted code signing certificate")
+	flags.StringSlice(config.KeyAllowedPublishers, nil, "Fail on binaries not signed by one of the given publishers (name, subject or thumbprint)")
+	flags.String(config.KeyTrustedRoots, "", "Path to PEM root certificates to trust instead of the system roots")
+	_ = flags.SetAnnotation(config.KeyTrustedRoots, cobra.BashCompFilenameExt, []string{"pem", "crt"})
+}
+
+var signaturesCmd = &cobra.Command{
+	Use:     "signatures",
+	Short:   "inspects the Authenticode signatures of the binaries in a source folder",
+	Example: "content-prep signatures --path /path/to/folder --requireTrusted --allowedPublishers \"Contoso Ltd\"",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "signatures")
+
+		sourceFolder, err := absPath(viper.GetString(config.KeySourceFolder))
+		if err != nil {
+			return err
+		}
+
+		src, err := source.Open(ctx, sourceFolder)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+
+		policy, opts, err := signaturePolicyFromConfig()
+		if err != nil {
+			return err
+		}
+		opts.Exclude = viper.GetStringSlice(config.KeyExclude)
+
+		log.Debug("inspecting signatures", "source", sourceFolder)
+
+		signatures, err := authenticode.Scan(ctx, src.FS, opts)
+		if err != nil {
+			return err
+		}
+
+		if err := printSignatures(cmd, signatures, viper.GetString(config.KeyFormat)); err != nil {
+			return err
+		}
+
+		var violations int
+		for i := range signatures {
+			if err := policy.Check(&signatures[i]); err != nil {
+				log.Error("signature policy violation", "file", signatures[i].File, "error", err)
+				violations++
+			}
+		}
+
+		if violations > 0 {
+			return errors.Wrapf(authenticode.ErrPolicyViolation, "%d of %d binaries violate the signature policy", violations, len(signatures))
+		}
+
+		return nil
+	},
+}
+
+func printSignatures(cmd *cobra.Command, signatures []authenticode.Signature, format string) error {
+	switch strings.ToLower(format) {
+	case signaturesFormatJSON:
+		if signatures == nil {
+			signatures = []authenticode.Signature{}
+		}
+
+		enc := json.NewEncoder(cmd.OutOrStdout())
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(signatures)
+	case "", signaturesFormatTable:
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		_, _ = fmt.Fprintln(w, "FILE\tSIGNED\tDIGEST\tTRUSTED\tPUBLISHER\tTHUMBPRINT\tTIMESTAMP")
+		for _, s := range signatures {
+			timestamp := "-"
+			if s.Timestamp != nil {
+				timestamp = s.Timestamp.Format(time.RFC3339)
+			}
+
+			_, _ = fmt.Fprintf(w, "%s\t%t\t%s\t%t\t%s\t%s\t%s\n", s.File, s.Signed, digestStatus(s), s.Trusted, s.Publisher, s.Thumbprint, timestamp)
+		}
+
+		return w.Flush()
+	default:
+		return errors.Errorf("unknown format %q, expected %s or %s", format, signaturesFormatTable, signaturesFormatJSON)
+	}
+}
+
+func digestStatus(s authenticode.Signature) string {
+	switch {
+	case !s.Signed:
+		return "-"
+	case s.Valid():
+		return "valid"
+	default:
+		return "invalid"
+	}
+}
+
+// signaturePolicyFromConfig returns the signature policy and verification
+// options of the configuration.
+func signaturePolicyFromConfig() (authenticode.Policy, authenticode.Options, error) {
+	policy := authenticode.Policy{
+		RequireSigned:     viper.GetBool(config.KeyRequireSigned),
+		RequireTrusted:    viper.GetBool(config.KeyRequireTrusted),
+		AllowedPublishers: viper.GetStringSlice(config.KeyAllowedPublishers),
+	}
+
+	var opts authenticode.Options
+
+	if rootsFilePath := viper.GetString(config.KeyTrustedRoots); rootsFilePath != "" {
+		data, err := os.ReadFile(rootsFilePath)
+		if err != nil {
+			return policy, opts, errors.Wrapf(err, "failed to read trusted roots")
+		}
+
+		opts.Roots = x509.NewCertPool()
+		if !opts.Roots.AppendCertsFromPEM(data) {
+			return policy, opts, errors.Errorf("no certificates found in %s", rootsFilePath)
+		}
+	}
+
+	return policy, opts, nil
+}
@@ -0,0 +1,133 @@
+package cmd
+
+import (
+	"content-prep/pkg/config"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/provenance"
+	"content-prep/pkg/signing"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+func init() {
+	RootCmd.AddCommand(verifyIntuneWinCmd)
+
+	verifyIntuneWinCmd.Flags().StringP(config.KeyEncryptedPackageFile, "f", "", "Path to the encrypted package file")
+	_ = verifyIntuneWinCmd.MarkFlagRequired(config.KeyEncryptedPackageFile)
+	_ = verifyIntuneWinCmd.MarkFlagFilename(config.KeyEncryptedPackageFile)
+	verifyIntuneWinCmd.Flags().Bool(config.KeyProvenance, false, "verify the signed provenance of the package")
+	verifyIntuneWinCmd.Flags().String(config.KeyProvenanceFile, "", "Path to the provenance, defaults to <package>"+provenance.FileSuffix)
+	_ = verifyIntuneWinCmd.MarkFlagFilename(config.KeyProvenanceFile)
+	verifyIntuneWinCmd.Flags().Bool(config.KeySignature, false, "verify the detached signature of the package")
+	verifyIntuneWinCmd.Flags().String(config.KeySignatureFile, "", "Path to the detached signature, defaults to <package>"+signing.SignatureFileSuffix)
+	_ = verifyIntuneWinCmd.MarkFlagFilename(config.KeySignatureFile)
+	verifyIntuneWinCmd.Flags().StringSlice(config.KeyTrustedKeys, nil, "Paths to trusted public keys, certificates or private keys (PEM)")
+}
+
+var verifyIntuneWinCmd = &cobra.Command{
+	Use:     "verify",
+	Short:   "verifies an intunewin package against its Detection.xml and optionally its signature and provenance",
+	Example: "content-prep verify --file /path/to/package.intunewin --signature --provenance --trustedKeys /path/to/key.pub",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		log := logger.FromContext(ctx).With("component", "cli", "action", "verify")
+
+		packageFilePath, err := absPath(viper.GetString(config.KeyEncryptedPackageFile))
+		if err != nil {
+			return err
+		}
+
+		// The signature is checked first, so tampering is reported as such
+		// rather than as a mismatch with Detection.xml.
+		if viper.GetBool(config.KeySignature) {
+			keyID, err := verifyPackageSignature(packageFilePath)
+			if err != nil {
+				return errors.Wrap(err, "failed to verify package signature")
+			}
+			log.Info("verified package signature", "keyId", keyID)
+		}
+
+		pkg, closePkg, err := openPackage(cmd, packageFilePath)
+		if err != nil {
+			return err
+		}
+		defer closePkg()
+
+		if err := pkg.Verify(); err != nil {
+			return errors.Wrap(err, "failed to verify intunewin package")
+		}
+		log.Info("verified package content", "file", packageFilePath)
+
+		if viper.GetBool(config.KeyProvenance) {
+			statement, err := verifyProvenance(packageFilePath)
+			if err != nil {
+				return errors.Wrap(err, "failed to verify provenance")
+			}
+			log.Info("verified provenance", "builder", statement.Predicate.RunDetails.Builder.ID, "invocation", statement.Predicate.RunDetails.Metadata.InvocationID)
+		}
+
+		return nil
+	},
+}
+
+// verifyProvenance checks that the provenance of the package is signed by a
+// trusted key and names the package as its subject.
+func verifyProvenance(packageFilePath string) (*provenance.Statement, error) {
+	keys, err := trustedKeysFromConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	provenanceFilePath := viper.GetString(config.KeyProvenanceFile)
+	if provenanceFilePath == "" {
+		provenanceFilePath = strings.TrimSuffix(packageFilePath, packager.PackageFileExtension) + provenance.FileSuffix
+	}
+
+	provenanceFile, err := os.Open(provenanceFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer provenanceFile.Close()
+
+	envelope, err := provenance.Read(provenanceFile)
+	if err != nil {
+		return nil, err
+	}
+
+	statement, err := provenance.Verify(envelope, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := fileDigest(packageFilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return statement, statement.MatchSubject(digest)
+}
+
+// fileDigest returns the hex encoded SHA-256 of the file.
+func fileDigest(filePath string) (string, error) {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", errors.Wrapf(err, "failed to hash %s", filePath)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
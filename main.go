@@ -2,6 +2,9 @@ package main
 
 import "content-prep/cmd"
 
+// version is set by the release build.
+var version = "dev"
+
 func main() {
-	cmd.Execute()
+	cmd.Execute(version)
 }
@@ -0,0 +1,290 @@
+// Package authenticode inspects Authenticode signatures of PE files and MSI
+// databases.
+package authenticode
+
+import (
+	"bytes"
+	"content-prep/pkg/cfb"
+	"content-prep/pkg/source"
+	"content-prep/pkg/zipper"
+	"context"
+	"crypto"
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Extensions lists the file extensions inspected by Scan.
+var Extensions = []string{".exe", ".dll", ".msi"}
+
+// Signature is the result of inspecting the Authenticode signature of a file.
+type Signature struct {
+	File   string `json:"file"`
+	Signed bool   `json:"signed"`
+
+	// Publisher is the common name of the signer, Subject and Issuer the full
+	// names of the signer certificate.
+	Publisher  string `json:"publisher,omitempty"`
+	Subject    string `json:"subject,omitempty"`
+	Issuer     string `json:"issuer,omitempty"`
+	Thumbprint string `json:"thumbprint,omitempty"`
+
+	// Timestamp is the time of signing attested by a counter signature or
+	// RFC 3161 timestamp.
+	Timestamp       *time.Time `json:"timestamp,omitempty"`
+	DigestAlgorithm string     `json:"digestAlgorithm,omitempty"`
+
+	// DigestValid reports whether the file matches the signed digest,
+	// SignatureValid whether the signer signed that digest and Trusted whether
+	// the signer chains to a trusted root for code signing.
+	DigestValid    bool `json:"digestValid"`
+	SignatureValid bool `json:"signatureValid"`
+	Trusted        bool `json:"trusted"`
+
+	// Problems explains why a signed file is not valid or trusted.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// Valid reports whether the file is signed and unmodified since signing.
+func (s *Signature) Valid() bool {
+	return s.Signed && s.DigestValid && s.SignatureValid
+}
+
+// Options control the verification of signatures.
+type Options struct {
+	// Roots are the trusted root certificates, the system roots if nil.
+	Roots *x509.CertPool
+
+	// CurrentTime is used to verify certificates of signatures without a
+	// timestamp of a trusted authority, the current time if zero.
+	CurrentTime time.Time
+
+	// Exclude skips files matching the patterns during Scan.
+	Exclude []string
+}
+
+// Inspect reads and verifies the signature of a single file.
+func Inspect(fsys fs.FS, name string, opts Options) (*Signature, error) {
+	f, err := source.OpenFile(fsys, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Verify(f, f.Size, name, opts)
+}
+
+// Verify reads and verifies the signature of the MSI database or PE file of the
+// given size. The name is only used for reporting.
+func Verify(r io.ReaderAt, size int64, name string, opts Options) (*Signature, error) {
+	magic := make([]byte, len(cfb.Signature))
+	if _, err := r.ReadAt(magic, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	result := &Signature{File: name}
+
+	var digest func(crypto.Hash) ([]byte, error)
+	var der []byte
+
+	if bytes.Equal(magic, cfb.Signature) {
+		f, signature, err := msiSignature(r, size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", name)
+		}
+
+		if _, err := f.Open(msiDigitalSignatureEx); err == nil {
+			result.Problems = append(result.Problems, "extended MSI signatures (MsiDigitalSignatureEx) are not supported")
+		}
+
+		der = signature
+		digest = func(hash crypto.Hash) ([]byte, error) {
+			h := hash.New()
+			if err := hashMSI(h, f, f.Root()); err != nil {
+				return nil, err
+			}
+
+			return h.Sum(nil), nil
+		}
+	} else {
+		ranges, signature, err := peSignature(r, size)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read %s", name)
+		}
+
+		der = signature
+		digest = func(hash crypto.Hash) ([]byte, error) {
+			h := hash.New()
+			for _, br := range ranges {
+				if _, err := io.Copy(h, io.NewSectionReader(r, br.start, br.end-br.start)); err != nil {
+					return nil, err
+				}
+			}
+
+			return h.Sum(nil), nil
+		}
+	}
+
+	if der == nil {
+		return result, nil
+	}
+	result.Signed = true
+
+	s, err := parseSignature(der)
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		return result, nil
+	}
+
+	hash, err := hashOf(s.indirect.MessageDigest.DigestAlgorithm.Algorithm)
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		return result, nil
+	}
+	result.DigestAlgorithm = hash.String()
+
+	actual, err := digest(hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to hash %s", name)
+	}
+
+	if bytes.Equal(actual, s.indirect.MessageDigest.Digest) && len(result.Problems) == 0 {
+		result.DigestValid = true
+	} else if len(result.Problems) == 0 {
+		result.Problems = append(result.Problems, "file does not match the signed digest")
+	}
+
+	certificate, err := s.signerCertificate(s.signer)
+	if err != nil {
+		result.Problems = append(result.Problems, err.Error())
+		return result, nil
+	}
+
+	result.Publisher = certificate.Subject.CommonName
+	result.Subject = certificate.Subject.String()
+	result.Issuer = certificate.Issuer.String()
+	result.Thumbprint = thumbprint(certificate)
+
+	if err := verifySigner(s.signer, certificate, s.content); err != nil {
+		result.Problems = append(result.Problems, "invalid signature: "+err.Error())
+	} else {
+		result.SignatureValid = true
+	}
+
+	currentTime := opts.CurrentTime
+
+	// Only a timestamp of a trusted authority may move the time the signer
+	// certificate is verified at.
+	timestamp, err := s.timestamp()
+	switch {
+	case err != nil:
+		result.Problems = append(result.Problems, "invalid timestamp: "+err.Error())
+	case timestamp != nil:
+		if err := verifyChain(timestamp.certificate, timestamp.certificates, opts.Roots, timestamp.time, x509.ExtKeyUsageTimeStamping); err != nil {
+			result.Problems = append(result.Problems, "untrusted timestamp: "+err.Error())
+		} else {
+			result.Timestamp = &timestamp.time
+			currentTime = timestamp.time
+		}
+	}
+
+	if err := verifyChain(certificate, s.certificates, opts.Roots, currentTime, x509.ExtKeyUsageCodeSigning); err != nil {
+		result.Problems = append(result.Problems, "untrusted signer: "+err.Error())
+	} else {
+		result.Trusted = result.SignatureValid
+	}
+
+	return result, nil
+}
+
+// verifyChain checks that the certificate chains to one of the roots for the
+// usage at the given time, the current time if zero.
+func verifyChain(certificate *x509.Certificate, intermediates []*x509.Certificate, roots *x509.CertPool, currentTime time.Time, usage x509.ExtKeyUsage) error {
+	verifyOpts := x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+		CurrentTime:   currentTime,
+		KeyUsages:     []x509.ExtKeyUsage{usage},
+	}
+	for _, c := range intermediates {
+		verifyOpts.Intermediates.AddCert(c)
+	}
+
+	_, err := certificate.Verify(verifyOpts)
+
+	return err
+}
+
+// Scan inspects all binaries of the file system, see Extensions. Binaries that
+// are not valid PE files or MSI databases are reported as unsigned.
+func Scan(ctx context.Context, fsys fs.FS, opts Options) ([]Signature, error) {
+	var signatures []Signature
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if zipper.Excluded(name, opts.Exclude) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if d.IsDir() || !IsBinary(name) {
+			return nil
+		}
+
+		f, err := source.OpenFile(fsys, name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		signature, err := Verify(f, f.Size, name, opts)
+		if err != nil {
+			signature = &Signature{File: name, Problems: []string{err.Error()}}
+		}
+
+		signatures = append(signatures, *signature)
+
+		return nil
+	})
+
+	return signatures, err
+}
+
+// IsBinary reports whether Scan inspects the file.
+func IsBinary(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+	for _, e := range Extensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// thumbprint is the SHA-1 hash of the certificate as shown by Windows.
+func thumbprint(c *x509.Certificate) string {
+	h := crypto.SHA1.New()
+	h.Write(c.Raw)
+
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
@@ -0,0 +1,408 @@
+package authenticode
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"math/big"
+	"os"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestAuthenticodeTestSuite(t *testing.T) {
+	suite.Run(t, new(AuthenticodeTestSuite))
+}
+
+type AuthenticodeTestSuite struct {
+	suite.Suite
+
+	ca, other *testSigner
+	signer    *testSigner
+	tsa       *testSigner
+	roots     *x509.CertPool
+	msi       []byte
+	msiRoots  *x509.CertPool
+}
+
+var signingTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// testSigner is a certificate and its key.
+type testSigner struct {
+	certificate *x509.Certificate
+	key         *ecdsa.PrivateKey
+}
+
+func newTestSigner(name string, parent *testSigner, usage ...x509.ExtKeyUsage) *testSigner {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name, Organization: []string{"Contoso"}},
+		NotBefore:             time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC),
+		NotAfter:              time.Date(2100, 1, 1, 0, 0, 0, 0, time.UTC),
+		ExtKeyUsage:           usage,
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+
+	issuer, issuerKey := template, key
+	if parent == nil {
+		template.IsCA = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		issuer, issuerKey = parent.certificate, parent.key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, issuerKey)
+	if err != nil {
+		panic(err)
+	}
+
+	certificate, _ := x509.ParseCertificate(der)
+
+	return &testSigner{certificate: certificate, key: key}
+}
+
+func mustMarshal(v any) []byte {
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		panic(err)
+	}
+
+	return der
+}
+
+func contextTag(content []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content}
+}
+
+func testAttribute(oid asn1.ObjectIdentifier, value any) []byte {
+	return mustMarshal(attribute{
+		Type:   oid,
+		Values: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: mustMarshal(value)},
+	})
+}
+
+var algorithmSHA256 = pkix.AlgorithmIdentifier{Algorithm: oidDigestSHA256}
+
+// signerInfo signs content with the given additional authenticated attributes.
+func (t *testSigner) signerInfo(content []byte, attributes ...[]byte) signerInfo {
+	digest := crypto.SHA256.New()
+	digest.Write(content)
+
+	authenticated := bytes.Join(append([][]byte{testAttribute(oidAttributeMessageDigest, digest.Sum(nil))}, attributes...), nil)
+
+	h := crypto.SHA256.New()
+	h.Write(mustMarshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: authenticated}))
+
+	sig, err := ecdsa.SignASN1(rand.Reader, t.key, h.Sum(nil))
+	if err != nil {
+		panic(err)
+	}
+
+	return signerInfo{
+		Version:                   1,
+		IssuerAndSerialNumber:     issuerAndSerial{Issuer: asn1.RawValue{FullBytes: t.certificate.RawIssuer}, SerialNumber: t.certificate.SerialNumber},
+		DigestAlgorithm:           algorithmSHA256,
+		AuthenticatedAttributes:   contextTag(authenticated),
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		EncryptedDigest:           sig,
+	}
+}
+
+func signedContentInfo(contentType asn1.ObjectIdentifier, content []byte, signer signerInfo, certificates ...*x509.Certificate) []byte {
+	var raw []byte
+	for _, c := range certificates {
+		raw = append(raw, c.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{algorithmSHA256},
+		ContentInfo:      contentInfo{ContentType: contentType, Content: contextTag(content)},
+		Certificates:     contextTag(raw),
+		SignerInfos:      []signerInfo{signer},
+	}
+
+	return mustMarshal(contentInfo{ContentType: oidSignedData, Content: contextTag(mustMarshal(sd))})
+}
+
+type timestampKind int
+
+const (
+	noTimestamp timestampKind = iota
+	rfc3161
+	counterSignature
+)
+
+// sign creates an Authenticode signature over the file digest.
+func (s *AuthenticodeTestSuite) sign(signer *testSigner, digest []byte, timestamp timestampKind) []byte {
+	indirect := mustMarshal(spcIndirectDataContent{
+		Data:          asn1.RawValue{FullBytes: mustMarshal(struct{ Type asn1.ObjectIdentifier }{asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}})},
+		MessageDigest: digestInfo{DigestAlgorithm: algorithmSHA256, Digest: digest},
+	})
+
+	var body asn1.RawValue
+	_, _ = asn1.Unmarshal(indirect, &body)
+
+	info := signer.signerInfo(body.Bytes)
+	certificates := []*x509.Certificate{signer.certificate, s.ca.certificate}
+
+	imprint := crypto.SHA256.New()
+	imprint.Write(info.EncryptedDigest)
+
+	switch timestamp {
+	case rfc3161:
+		tst := mustMarshal(tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3},
+			MessageImprint: digestInfo{DigestAlgorithm: algorithmSHA256, Digest: imprint.Sum(nil)},
+			SerialNumber:   big.NewInt(1),
+			GenTime:        signingTime,
+		})
+		token := signedContentInfo(oidTSTInfo, mustMarshal(tst), s.tsa.signerInfo(tst), s.tsa.certificate)
+		info.UnauthenticatedAttributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: testAttribute(oidAttributeRFC3161Timestamp, asn1.RawValue{FullBytes: token})}
+	case counterSignature:
+		counter := s.tsa.signerInfo(info.EncryptedDigest, testAttribute(oidAttributeSigningTime, signingTime))
+		info.UnauthenticatedAttributes = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true, Bytes: testAttribute(oidAttributeCounterSignature, counter)}
+		certificates = append(certificates, s.tsa.certificate)
+	}
+
+	return signedContentInfo(oidSpcIndirectData, body.FullBytes, info, certificates...)
+}
+
+// peFile builds a minimal 32-bit PE file, signed by signer unless it is nil.
+func (s *AuthenticodeTestSuite) peFile(signer *testSigner, timestamp timestampKind) []byte {
+	data := make([]byte, 512)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3C:], 64)
+	copy(data[64:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(data[64+24:], peMagic32)
+	binary.LittleEndian.PutUint32(data[64+24+92:], 16)
+	copy(data[400:], "section data")
+
+	if signer == nil {
+		return data
+	}
+
+	h := crypto.SHA256.New()
+	ranges, _, err := peSignature(bytes.NewReader(data), int64(len(data)))
+	s.Require().NoError(err)
+	for _, r := range ranges {
+		h.Write(data[r.start:r.end])
+	}
+
+	signature := s.sign(signer, h.Sum(nil), timestamp)
+
+	certificate := make([]byte, winCertHeader+len(signature))
+	binary.LittleEndian.PutUint32(certificate, uint32(len(certificate)))
+	binary.LittleEndian.PutUint16(certificate[4:], 0x0200)
+	binary.LittleEndian.PutUint16(certificate[6:], winCertPKCS7)
+	copy(certificate[winCertHeader:], signature)
+	for len(certificate)%8 != 0 {
+		certificate = append(certificate, 0)
+	}
+
+	binary.LittleEndian.PutUint32(data[64+24+96+4*8:], uint32(len(data)))
+	binary.LittleEndian.PutUint32(data[64+24+96+4*8+4:], uint32(len(certificate)))
+
+	return append(data, certificate...)
+}
+
+func (s *AuthenticodeTestSuite) SetupSuite() {
+	s.ca = newTestSigner("Contoso Root", nil)
+	s.signer = newTestSigner("Contoso Ltd", s.ca, x509.ExtKeyUsageCodeSigning)
+	s.tsa = newTestSigner("Contoso Timestamping", s.ca, x509.ExtKeyUsageTimeStamping)
+	s.other = newTestSigner("Fabrikam", nil, x509.ExtKeyUsageCodeSigning)
+
+	s.roots = x509.NewCertPool()
+	s.roots.AddCert(s.ca.certificate)
+
+	var err error
+	s.msi, err = os.ReadFile("testdata/signed.msi")
+	s.Require().NoError(err)
+
+	root, err := os.ReadFile("testdata/root.pem")
+	s.Require().NoError(err)
+
+	s.msiRoots = x509.NewCertPool()
+	s.Require().True(s.msiRoots.AppendCertsFromPEM(root))
+}
+
+func (s *AuthenticodeTestSuite) TestInspectPE() {
+	fsys := fstest.MapFS{
+		"rfc3161.exe": {Data: s.peFile(s.signer, rfc3161)},
+		"counter.exe": {Data: s.peFile(s.signer, counterSignature)},
+		"plain.dll":   {Data: s.peFile(s.signer, noTimestamp)},
+	}
+
+	for name := range fsys {
+		signature, err := Inspect(fsys, name, Options{Roots: s.roots})
+		s.Require().NoError(err, name)
+
+		s.Require().True(signature.Signed, name)
+		s.Require().True(signature.DigestValid, name)
+		s.Require().True(signature.SignatureValid, name)
+		s.Require().True(signature.Trusted, name)
+		s.Require().Empty(signature.Problems, name)
+		s.Require().Equal("Contoso Ltd", signature.Publisher, name)
+		s.Require().Equal("CN=Contoso Root,O=Contoso", signature.Issuer, name)
+		s.Require().Equal(thumbprint(s.signer.certificate), signature.Thumbprint, name)
+		s.Require().Equal("SHA-256", signature.DigestAlgorithm, name)
+
+		if name == "plain.dll" {
+			s.Require().Nil(signature.Timestamp, name)
+		} else {
+			s.Require().Equal(signingTime, signature.Timestamp.UTC(), name)
+		}
+	}
+}
+
+func (s *AuthenticodeTestSuite) TestInspectPEInvalid() {
+	tampered := s.peFile(s.signer, rfc3161)
+	tampered[400] = 'S'
+
+	fsys := fstest.MapFS{
+		"unsigned.exe":  {Data: s.peFile(nil, noTimestamp)},
+		"tampered.exe":  {Data: tampered},
+		"untrusted.exe": {Data: s.peFile(s.other, noTimestamp)},
+	}
+
+	unsigned, err := Inspect(fsys, "unsigned.exe", Options{Roots: s.roots})
+	s.Require().NoError(err)
+	s.Require().False(unsigned.Signed)
+
+	signature, err := Inspect(fsys, "tampered.exe", Options{Roots: s.roots})
+	s.Require().NoError(err)
+	s.Require().True(signature.Signed)
+	s.Require().False(signature.DigestValid)
+	s.Require().True(signature.SignatureValid)
+	s.Require().False(signature.Valid())
+
+	signature, err = Inspect(fsys, "untrusted.exe", Options{Roots: s.roots})
+	s.Require().NoError(err)
+	s.Require().True(signature.Valid())
+	s.Require().False(signature.Trusted)
+	s.Require().Equal("Fabrikam", signature.Publisher)
+
+	_, err = Inspect(fstest.MapFS{"broken.exe": {Data: []byte("MZ")}}, "broken.exe", Options{})
+	s.Require().Error(err)
+}
+
+func (s *AuthenticodeTestSuite) TestInspectPEUntrustedTimestamp() {
+	// The signer certificate has expired by then, only a trusted timestamp
+	// keeps it valid.
+	afterExpiry := time.Date(2200, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	trusted := s.peFile(s.signer, rfc3161)
+
+	tsa := s.tsa
+	s.tsa = newTestSigner("Rogue Timestamping", nil, x509.ExtKeyUsageTimeStamping)
+	defer func() { s.tsa = tsa }()
+
+	fsys := fstest.MapFS{
+		"trusted.exe":     {Data: trusted},
+		"selfsigned.exe":  {Data: s.peFile(s.signer, rfc3161)},
+		"countersign.exe": {Data: s.peFile(s.signer, counterSignature)},
+	}
+
+	signature, err := Inspect(fsys, "trusted.exe", Options{Roots: s.roots, CurrentTime: afterExpiry})
+	s.Require().NoError(err)
+	s.Require().True(signature.Trusted)
+	s.Require().Equal(signingTime, signature.Timestamp.UTC())
+
+	for _, name := range []string{"selfsigned.exe", "countersign.exe"} {
+		signature, err := Inspect(fsys, name, Options{Roots: s.roots, CurrentTime: afterExpiry})
+		s.Require().NoError(err, name)
+		s.Require().True(signature.Valid(), name)
+		s.Require().False(signature.Trusted, name)
+		s.Require().Nil(signature.Timestamp, name)
+		s.Require().Len(signature.Problems, 2, name)
+		s.Require().Contains(signature.Problems[0], "untrusted timestamp", name)
+		s.Require().Contains(signature.Problems[1], "untrusted signer", name)
+
+		signature, err = Inspect(fsys, name, Options{Roots: s.roots, CurrentTime: signingTime})
+		s.Require().NoError(err, name)
+		s.Require().True(signature.Trusted, name)
+		s.Require().Nil(signature.Timestamp, name)
+	}
+}
+
+func (s *AuthenticodeTestSuite) TestInspectMSI() {
+	fsys := fstest.MapFS{"setup.msi": {Data: s.msi}}
+
+	signature, err := Inspect(fsys, "setup.msi", Options{Roots: s.msiRoots})
+	s.Require().NoError(err)
+	s.Require().True(signature.Valid())
+	s.Require().True(signature.Trusted)
+	s.Require().Equal("Contoso Ltd", signature.Publisher)
+	s.Require().NotNil(signature.Timestamp)
+
+	tampered := bytes.Clone(s.msi)
+	i := bytes.Index(tampered, []byte("tamper-me"))
+	s.Require().Positive(i)
+	tampered[i] = 'T'
+
+	signature, err = Inspect(fstest.MapFS{"setup.msi": {Data: tampered}}, "setup.msi", Options{Roots: s.msiRoots})
+	s.Require().NoError(err)
+	s.Require().False(signature.DigestValid)
+}
+
+func (s *AuthenticodeTestSuite) TestScanAndPolicy() {
+	fsys := fstest.MapFS{
+		"setup.exe":        {Data: s.peFile(s.signer, rfc3161)},
+		"bin/helper.dll":   {Data: s.peFile(s.other, noTimestamp)},
+		"bin/unsigned.exe": {Data: s.peFile(nil, noTimestamp)},
+		"bin/broken.dll":   {Data: []byte("MZ")},
+		"readme.txt":       {Data: []byte("not a binary")},
+		"skip/setup.exe":   {Data: []byte("not inspected")},
+	}
+
+	signatures, err := Scan(context.Background(), fsys, Options{Roots: s.roots, Exclude: []string{"skip"}})
+	s.Require().NoError(err)
+	s.Require().Len(signatures, 4)
+
+	byFile := map[string]*Signature{}
+	for i := range signatures {
+		byFile[signatures[i].File] = &signatures[i]
+	}
+
+	s.Require().False(byFile["bin/broken.dll"].Signed)
+	s.Require().NotEmpty(byFile["bin/broken.dll"].Problems)
+	s.Require().NoError(Policy{}.Check(byFile["bin/unsigned.exe"]))
+
+	for _, tc := range []struct {
+		policy   Policy
+		rejected []string
+	}{
+		{Policy{RequireSigned: true}, []string{"bin/unsigned.exe", "bin/broken.dll"}},
+		{Policy{RequireTrusted: true}, []string{"bin/helper.dll", "bin/unsigned.exe", "bin/broken.dll"}},
+		{Policy{AllowedPublishers: []string{"Fabrikam"}}, []string{"setup.exe", "bin/unsigned.exe", "bin/broken.dll"}},
+		{Policy{AllowedPublishers: []string{byFile["setup.exe"].Thumbprint, "CN=Fabrikam,O=Contoso"}}, []string{"bin/unsigned.exe", "bin/broken.dll"}},
+	} {
+		var rejected []string
+		for _, signature := range signatures {
+			if err := tc.policy.Check(&signature); err != nil {
+				s.Require().ErrorIs(err, ErrPolicyViolation)
+				rejected = append(rejected, signature.File)
+			}
+		}
+
+		s.Require().ElementsMatch(tc.rejected, rejected, "%+v", tc.policy)
+	}
+}
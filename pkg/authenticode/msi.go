@@ -0,0 +1,84 @@
+package authenticode
+
+import (
+	"bytes"
+	"content-prep/pkg/cfb"
+	"hash"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	msiDigitalSignature   = "\x05DigitalSignature"
+	msiDigitalSignatureEx = "\x05MsiDigitalSignatureEx"
+)
+
+// msiSignature returns the compound file and the PKCS#7 signature of an MSI
+// database, nil if it is not signed.
+func msiSignature(r io.ReaderAt, size int64) (*cfb.File, []byte, error) {
+	f, err := cfb.Open(r, size)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	stream, err := f.Open(msiDigitalSignature)
+	if err != nil {
+		return f, nil, nil
+	}
+
+	signature, err := io.ReadAll(stream)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read digital signature stream")
+	}
+
+	return f, signature, nil
+}
+
+// hashMSI hashes the streams of the storage in the order defined by
+// Authenticode: entries sorted by their raw UTF-16 names, storages recursed
+// into, each storage followed by its CLSID. The signature streams are skipped.
+func hashMSI(h hash.Hash, f *cfb.File, storage *cfb.DirEntry) error {
+	children := append([]*cfb.DirEntry(nil), f.Children(storage)...)
+
+	names := make(map[*cfb.DirEntry][]byte, len(children))
+	for _, c := range children {
+		names[c] = c.RawName()
+	}
+
+	sort.Slice(children, func(i, j int) bool {
+		a, b := names[children[i]], names[children[j]]
+		if cmp := bytes.Compare(a[:min(len(a), len(b))], b[:min(len(a), len(b))]); cmp != 0 {
+			return cmp < 0
+		}
+
+		return len(a) < len(b)
+	})
+
+	for _, c := range children {
+		switch c.Type {
+		case cfb.TypeStream:
+			if storage == f.Root() && (c.Name == msiDigitalSignature || c.Name == msiDigitalSignatureEx) {
+				continue
+			}
+
+			stream, err := f.OpenEntry(c)
+			if err != nil {
+				return err
+			}
+
+			if _, err := io.Copy(h, stream); err != nil {
+				return errors.Wrapf(err, "failed to hash stream %s", c.Path)
+			}
+		case cfb.TypeStorage:
+			if err := hashMSI(h, f, c); err != nil {
+				return err
+			}
+		}
+	}
+
+	h.Write(storage.CLSID[:])
+
+	return nil
+}
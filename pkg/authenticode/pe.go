@@ -0,0 +1,97 @@
+package authenticode
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	peMagic32     = 0x10b
+	peMagic64     = 0x20b
+	winCertPKCS7  = 0x0002
+	winCertHeader = 8
+)
+
+// byteRange is a half-open range of a file.
+type byteRange struct {
+	start, end int64
+}
+
+// peSignature returns the ranges of the PE file covered by the Authenticode
+// digest and the PKCS#7 signature from its security directory, nil if the
+// file is not signed.
+func peSignature(r io.ReaderAt, size int64) ([]byteRange, []byte, error) {
+	dos := make([]byte, 64)
+	if _, err := r.ReadAt(dos, 0); err != nil || !bytes.HasPrefix(dos, []byte("MZ")) {
+		return nil, nil, errors.New("missing MZ header")
+	}
+
+	peOffset := int64(binary.LittleEndian.Uint32(dos[0x3C:]))
+
+	header := make([]byte, 24+112+16*8)
+	n, err := r.ReadAt(header, peOffset)
+	if n < 24+2 || (err != nil && err != io.EOF) {
+		return nil, nil, errors.New("truncated PE header")
+	}
+	header = header[:n]
+
+	if !bytes.HasPrefix(header, []byte("PE\x00\x00")) {
+		return nil, nil, errors.New("missing PE signature")
+	}
+
+	optional := header[24:]
+
+	var dirs, numDirs int
+	switch binary.LittleEndian.Uint16(optional) {
+	case peMagic32:
+		dirs, numDirs = 96, 92
+	case peMagic64:
+		dirs, numDirs = 112, 108
+	default:
+		return nil, nil, errors.New("unknown optional header magic")
+	}
+
+	securityDir := dirs + 4*8
+	if len(optional) < securityDir+8 || binary.LittleEndian.Uint32(optional[numDirs:]) < 5 {
+		return nil, nil, errors.New("PE file has no security directory")
+	}
+
+	checksumOffset := peOffset + 24 + 64
+	securityDirOffset := peOffset + 24 + int64(securityDir)
+
+	certOffset := int64(binary.LittleEndian.Uint32(optional[securityDir:]))
+	certSize := int64(binary.LittleEndian.Uint32(optional[securityDir+4:]))
+
+	ranges := []byteRange{
+		{0, checksumOffset},
+		{checksumOffset + 4, securityDirOffset},
+	}
+
+	if certSize == 0 {
+		return append(ranges, byteRange{securityDirOffset + 8, size}), nil, nil
+	}
+
+	if certOffset < securityDirOffset+8 || certOffset+certSize > size || certSize < winCertHeader {
+		return nil, nil, errors.New("security directory out of range")
+	}
+	ranges = append(ranges, byteRange{securityDirOffset + 8, certOffset})
+
+	certificate := make([]byte, certSize)
+	if _, err := r.ReadAt(certificate, certOffset); err != nil {
+		return nil, nil, errors.Wrapf(err, "failed to read certificate table")
+	}
+
+	length := int64(binary.LittleEndian.Uint32(certificate))
+	if length < winCertHeader || length > certSize {
+		return nil, nil, errors.New("invalid certificate table entry")
+	}
+
+	if certType := binary.LittleEndian.Uint16(certificate[6:]); certType != winCertPKCS7 {
+		return nil, nil, errors.Errorf("unsupported certificate type %#x", certType)
+	}
+
+	return ranges, certificate[winCertHeader:length], nil
+}
@@ -0,0 +1,367 @@
+package authenticode
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	oidSignedData      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidSpcIndirectData = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidTSTInfo         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+
+	oidAttributeMessageDigest    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidAttributeSigningTime      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidAttributeCounterSignature = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	oidAttributeRFC3161Timestamp = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+
+	oidDigestSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue `asn1:"set"`
+}
+
+type digestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          asn1.RawValue
+	MessageDigest digestInfo
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint digestInfo
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// signature is a parsed Authenticode PKCS#7 signature.
+type signature struct {
+	signed       signedData
+	signer       signerInfo
+	indirect     spcIndirectDataContent
+	certificates []*x509.Certificate
+
+	// content is the signed content, the SpcIndirectDataContent without its
+	// tag and length.
+	content []byte
+}
+
+func parseSignature(der []byte) (*signature, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse PKCS#7 content info")
+	}
+
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, errors.Errorf("unexpected PKCS#7 content type %s", ci.ContentType)
+	}
+
+	s := &signature{}
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &s.signed); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse PKCS#7 signed data")
+	}
+
+	if !s.signed.ContentInfo.ContentType.Equal(oidSpcIndirectData) {
+		return nil, errors.Errorf("unexpected signed content type %s", s.signed.ContentInfo.ContentType)
+	}
+
+	var indirect asn1.RawValue
+	if _, err := asn1.Unmarshal(s.signed.ContentInfo.Content.Bytes, &indirect); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SpcIndirectDataContent")
+	}
+	s.content = indirect.Bytes
+
+	if _, err := asn1.Unmarshal(indirect.FullBytes, &s.indirect); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse SpcIndirectDataContent")
+	}
+
+	signer, err := newSignature(s.signed)
+	if err != nil {
+		return nil, err
+	}
+	s.signer, s.certificates = signer.signer, signer.certificates
+
+	return s, nil
+}
+
+// newSignature picks the single signer and parses the certificates of sd.
+func newSignature(sd signedData) (*signature, error) {
+	if len(sd.SignerInfos) != 1 {
+		return nil, errors.Errorf("expected one signer, found %d", len(sd.SignerInfos))
+	}
+
+	s := &signature{signed: sd, signer: sd.SignerInfos[0]}
+
+	if len(sd.Certificates.Bytes) > 0 {
+		certificates, err := x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse certificates")
+		}
+		s.certificates = certificates
+	}
+
+	return s, nil
+}
+
+// signerCertificate returns the certificate matching the issuer and serial
+// number of the signer.
+func (s *signature) signerCertificate(signer signerInfo) (*x509.Certificate, error) {
+	for _, c := range s.certificates {
+		if bytes.Equal(c.RawIssuer, signer.IssuerAndSerialNumber.Issuer.FullBytes) && c.SerialNumber.Cmp(signer.IssuerAndSerialNumber.SerialNumber) == 0 {
+			return c, nil
+		}
+	}
+
+	return nil, errors.New("signer certificate not included in signature")
+}
+
+// verifySigner checks that the signer's authenticated attributes carry the
+// digest of content and are signed by the signer certificate.
+func verifySigner(signer signerInfo, certificate *x509.Certificate, content []byte) error {
+	hash, err := hashOf(signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return err
+	}
+
+	if len(signer.AuthenticatedAttributes.FullBytes) == 0 {
+		return errors.New("signer has no authenticated attributes")
+	}
+
+	attributes, err := parseAttributes(signer.AuthenticatedAttributes.Bytes)
+	if err != nil {
+		return err
+	}
+
+	var messageDigest []byte
+	if err := attributeValue(attributes, oidAttributeMessageDigest, &messageDigest); err != nil {
+		return err
+	}
+
+	h := hash.New()
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), messageDigest) {
+		return errors.New("message digest does not match signed content")
+	}
+
+	// The signature is calculated over the DER encoding of the attributes as
+	// a SET, rather than the implicitly tagged form they are stored in.
+	signedAttributes := append([]byte{0x31}, signer.AuthenticatedAttributes.FullBytes[1:]...)
+
+	h = hash.New()
+	h.Write(signedAttributes)
+
+	switch pub := certificate.PublicKey.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signer.EncryptedDigest)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, h.Sum(nil), signer.EncryptedDigest) {
+			return errors.New("ECDSA verification failure")
+		}
+
+		return nil
+	default:
+		return errors.Errorf("unsupported public key algorithm %s", certificate.PublicKeyAlgorithm)
+	}
+}
+
+// timestamp is the time of signing attested by a timestamping authority.
+type timestamp struct {
+	time time.Time
+
+	// certificate is the certificate of the authority, certificates those
+	// that may complete its chain.
+	certificate  *x509.Certificate
+	certificates []*x509.Certificate
+}
+
+// timestamp returns the signing time of a legacy counter signature or an
+// RFC 3161 timestamp over the signer's encrypted digest.
+func (s *signature) timestamp() (*timestamp, error) {
+	if len(s.signer.UnauthenticatedAttributes.FullBytes) == 0 {
+		return nil, nil
+	}
+
+	attributes, err := parseAttributes(s.signer.UnauthenticatedAttributes.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, a := range attributes {
+		switch {
+		case a.Type.Equal(oidAttributeCounterSignature):
+			var counter signerInfo
+			if _, err := asn1.Unmarshal(a.Values.Bytes, &counter); err != nil {
+				return nil, errors.Wrapf(err, "failed to parse counter signature")
+			}
+
+			certificate, err := s.signerCertificate(counter)
+			if err != nil {
+				return nil, errors.Wrap(err, "counter signature")
+			}
+
+			if err := verifySigner(counter, certificate, s.signer.EncryptedDigest); err != nil {
+				return nil, errors.Wrap(err, "invalid counter signature")
+			}
+
+			counterAttributes, err := parseAttributes(counter.AuthenticatedAttributes.Bytes)
+			if err != nil {
+				return nil, err
+			}
+
+			var signingTime time.Time
+			if err := attributeValue(counterAttributes, oidAttributeSigningTime, &signingTime); err != nil {
+				return nil, err
+			}
+
+			return &timestamp{time: signingTime, certificate: certificate, certificates: s.certificates}, nil
+		case a.Type.Equal(oidAttributeRFC3161Timestamp):
+			return s.rfc3161Timestamp(a.Values.Bytes)
+		}
+	}
+
+	return nil, nil
+}
+
+func (s *signature) rfc3161Timestamp(der []byte) (*timestamp, error) {
+	var ci contentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse timestamp token")
+	}
+
+	var sd signedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse timestamp token")
+	}
+
+	if !sd.ContentInfo.ContentType.Equal(oidTSTInfo) {
+		return nil, errors.Errorf("unexpected timestamp content type %s", sd.ContentInfo.ContentType)
+	}
+
+	var encoded []byte
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &encoded); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse timestamp info")
+	}
+
+	var info tstInfo
+	if _, err := asn1.Unmarshal(encoded, &info); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse timestamp info")
+	}
+
+	token, err := newSignature(sd)
+	if err != nil {
+		return nil, errors.Wrap(err, "timestamp token")
+	}
+
+	certificate, err := token.signerCertificate(token.signer)
+	if err != nil {
+		return nil, errors.Wrap(err, "timestamp token")
+	}
+
+	if err := verifySigner(token.signer, certificate, encoded); err != nil {
+		return nil, errors.Wrap(err, "invalid timestamp token")
+	}
+
+	hash, err := hashOf(info.MessageImprint.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	h := hash.New()
+	h.Write(s.signer.EncryptedDigest)
+	if !bytes.Equal(h.Sum(nil), info.MessageImprint.Digest) {
+		return nil, errors.New("timestamp does not match signature")
+	}
+
+	return &timestamp{time: info.GenTime, certificate: certificate, certificates: append(token.certificates, s.certificates...)}, nil
+}
+
+func parseAttributes(data []byte) ([]attribute, error) {
+	var attributes []attribute
+	for len(data) > 0 {
+		var a attribute
+
+		rest, err := asn1.Unmarshal(data, &a)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse attributes")
+		}
+
+		attributes = append(attributes, a)
+		data = rest
+	}
+
+	return attributes, nil
+}
+
+func attributeValue(attributes []attribute, oid asn1.ObjectIdentifier, v any) error {
+	for _, a := range attributes {
+		if a.Type.Equal(oid) {
+			_, err := asn1.Unmarshal(a.Values.Bytes, v)
+			return errors.Wrapf(err, "failed to parse attribute %s", oid)
+		}
+	}
+
+	return errors.Errorf("missing attribute %s", oid)
+}
+
+func hashOf(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid.Equal(oidDigestSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidDigestSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidDigestSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidDigestSHA512):
+		return crypto.SHA512, nil
+	default:
+		return 0, errors.Errorf("unsupported digest algorithm %s", oid)
+	}
+}
@@ -0,0 +1,67 @@
+package authenticode
+
+import (
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var ErrPolicyViolation = errors.New("signature policy violation")
+
+// Policy decides which binaries may be packaged.
+type Policy struct {
+	// RequireSigned rejects unsigned binaries and binaries whose signature does
+	// not match their content.
+	RequireSigned bool
+
+	// RequireTrusted rejects binaries whose signer does not chain to a trusted
+	// root for code signing. It implies RequireSigned.
+	RequireTrusted bool
+
+	// AllowedPublishers restricts the signers of binaries. Entries match the
+	// publisher name, the full subject or the certificate thumbprint.
+	AllowedPublishers []string
+}
+
+// Enabled reports whether the policy rejects any binaries.
+func (p Policy) Enabled() bool {
+	return p.RequireSigned || p.RequireTrusted || len(p.AllowedPublishers) > 0
+}
+
+// Check returns an error wrapping ErrPolicyViolation if the signature is not
+// acceptable.
+func (p Policy) Check(s *Signature) error {
+	if !p.Enabled() {
+		return nil
+	}
+
+	if !s.Signed {
+		return errors.Wrapf(ErrPolicyViolation, "%s is not signed", s.File)
+	}
+
+	if !s.Valid() {
+		return errors.Wrapf(ErrPolicyViolation, "%s has an invalid signature: %s", s.File, strings.Join(s.Problems, "; "))
+	}
+
+	if p.RequireTrusted && !s.Trusted {
+		return errors.Wrapf(ErrPolicyViolation, "%s is signed by untrusted publisher %q", s.File, s.Publisher)
+	}
+
+	if len(p.AllowedPublishers) > 0 && !p.allowed(s) {
+		return errors.Wrapf(ErrPolicyViolation, "%s is signed by publisher %q, which is not allowed", s.File, s.Publisher)
+	}
+
+	return nil
+}
+
+func (p Policy) allowed(s *Signature) bool {
+	for _, publisher := range p.AllowedPublishers {
+		publisher = strings.TrimSpace(publisher)
+
+		if publisher == s.Publisher || publisher == s.Subject || strings.EqualFold(strings.ReplaceAll(publisher, " ", ""), s.Thumbprint) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,443 @@
+// Package cfb reads Compound File Binary files, the container format of MSI
+// databases.
+package cfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"path"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// Signature is the magic number at the start of every compound file.
+var Signature = []byte{0xD0, 0xCF, 0x11, 0xE0, 0xA1, 0xB1, 0x1A, 0xE1}
+
+// ErrInvalid is returned for files that are not valid compound files.
+var ErrInvalid = errors.New("invalid compound file")
+
+const (
+	headerSize     = 512
+	dirEntrySize   = 128
+	miniSectorSize = 64
+	headerDIFAT    = 109
+
+	maxRegSect = 0xFFFFFFFA
+	endOfChain = 0xFFFFFFFE
+	noStream   = 0xFFFFFFFF
+)
+
+// Object types of directory entries.
+const (
+	TypeStorage = 1
+	TypeStream  = 2
+	TypeRoot    = 5
+)
+
+// DirEntry is an entry of the directory of a compound file.
+type DirEntry struct {
+	// Path is the slash separated path of the entry below the root storage.
+	Path  string
+	Name  string
+	Type  byte
+	CLSID [16]byte
+	Size  int64
+
+	left, right, child uint32
+	start              uint32
+	children           []*DirEntry
+}
+
+// RawName returns the name as stored, UTF-16LE including the terminating NUL.
+func (e *DirEntry) RawName() []byte {
+	name := utf16.Encode([]rune(e.Name))
+	raw := make([]byte, 0, len(name)*2+2)
+	for _, c := range name {
+		raw = binary.LittleEndian.AppendUint16(raw, c)
+	}
+
+	return append(raw, 0, 0)
+}
+
+// File is an open compound file.
+type File struct {
+	r          io.ReaderAt
+	sectorSize int64
+	cutoff     int64
+	fat        []uint32
+	miniFat    []uint32
+	entries    []*DirEntry
+	byPath     map[string]*DirEntry
+	miniStream io.ReaderAt
+}
+
+type header struct {
+	Signature         [8]byte
+	CLSID             [16]byte
+	MinorVersion      uint16
+	MajorVersion      uint16
+	ByteOrder         uint16
+	SectorShift       uint16
+	MiniSectorShift   uint16
+	Reserved          [6]byte
+	NumDirSectors     uint32
+	NumFATSectors     uint32
+	FirstDirSector    uint32
+	TransactionSig    uint32
+	MiniStreamCutoff  uint32
+	FirstMiniFATSect  uint32
+	NumMiniFATSectors uint32
+	FirstDIFATSector  uint32
+	NumDIFATSectors   uint32
+	DIFAT             [headerDIFAT]uint32
+}
+
+// Open reads the header, allocation tables and directory of the compound
+// file of the given size.
+func Open(r io.ReaderAt, size int64) (*File, error) {
+	var h header
+	if err := binary.Read(io.NewSectionReader(r, 0, headerSize), binary.LittleEndian, &h); err != nil {
+		return nil, errors.Wrap(ErrInvalid, "file is too short")
+	}
+
+	if !bytes.Equal(h.Signature[:], Signature) {
+		return nil, errors.Wrap(ErrInvalid, "missing signature")
+	}
+
+	if h.ByteOrder != 0xFFFE {
+		return nil, errors.Wrap(ErrInvalid, "invalid byte order")
+	}
+
+	switch {
+	case h.MajorVersion == 3 && h.SectorShift == 9, h.MajorVersion == 4 && h.SectorShift == 12:
+	default:
+		return nil, errors.Wrapf(ErrInvalid, "unsupported version %d with sector shift %d", h.MajorVersion, h.SectorShift)
+	}
+
+	if h.MiniSectorShift != 6 || h.MiniStreamCutoff != 4096 {
+		return nil, errors.Wrap(ErrInvalid, "invalid mini stream parameters")
+	}
+
+	f := &File{
+		r:          r,
+		sectorSize: 1 << h.SectorShift,
+		cutoff:     int64(h.MiniStreamCutoff),
+		byPath:     map[string]*DirEntry{},
+	}
+
+	numSectors := uint32((size - headerSize + f.sectorSize - 1) / f.sectorSize)
+
+	fatSectors, err := f.difat(&h, numSectors)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sector := range fatSectors {
+		entries, err := f.readUint32s(sector, numSectors)
+		if err != nil {
+			return nil, err
+		}
+		f.fat = append(f.fat, entries...)
+	}
+
+	dir, err := f.readChain(f.fat, h.FirstDirSector)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read directory")
+	}
+
+	if err := f.readDirectory(dir); err != nil {
+		return nil, err
+	}
+
+	if h.NumMiniFATSectors > 0 {
+		miniFat, err := f.readChain(f.fat, h.FirstMiniFATSect)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read mini FAT")
+		}
+
+		f.miniFat = make([]uint32, len(miniFat)/4)
+		for i := range f.miniFat {
+			f.miniFat[i] = binary.LittleEndian.Uint32(miniFat[i*4:])
+		}
+	}
+
+	root := f.entries[0]
+	miniStream, err := chainReader(f.r, f.fat, f.sectorSize, f.sectorSize, root.start, root.Size)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read mini stream")
+	}
+	f.miniStream = miniStream
+
+	return f, nil
+}
+
+// Root returns the root storage entry. Its CLSID identifies the application
+// that created the file.
+func (f *File) Root() *DirEntry {
+	return f.entries[0]
+}
+
+// Entries returns all storages and streams below the root, in directory order.
+func (f *File) Entries() []*DirEntry {
+	entries := make([]*DirEntry, 0, len(f.byPath))
+	for _, e := range f.entries[1:] {
+		if e.Path != "" {
+			entries = append(entries, e)
+		}
+	}
+
+	return entries
+}
+
+// Children returns the entries directly below the given storage.
+func (f *File) Children(storage *DirEntry) []*DirEntry {
+	return storage.children
+}
+
+// Open returns a reader for the stream at the given path.
+func (f *File) Open(name string) (*io.SectionReader, error) {
+	e, ok := f.byPath[name]
+	if !ok || e.Type != TypeStream {
+		return nil, errors.Wrapf(fs.ErrNotExist, "%s", name)
+	}
+
+	return f.OpenEntry(e)
+}
+
+// OpenEntry returns a reader for the given stream entry.
+func (f *File) OpenEntry(e *DirEntry) (*io.SectionReader, error) {
+	if e.Type != TypeStream {
+		return nil, errors.Errorf("%s is not a stream", e.Path)
+	}
+
+	if e.Size < f.cutoff {
+		r, err := chainReader(f.miniStream, f.miniFat, miniSectorSize, 0, e.start, e.Size)
+		if err != nil {
+			return nil, err
+		}
+
+		return io.NewSectionReader(r, 0, e.Size), nil
+	}
+
+	r, err := chainReader(f.r, f.fat, f.sectorSize, f.sectorSize, e.start, e.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NewSectionReader(r, 0, e.Size), nil
+}
+
+func (f *File) sectorOffset(sector uint32) int64 {
+	return (int64(sector) + 1) * f.sectorSize
+}
+
+func (f *File) readUint32s(sector uint32, limit uint32) ([]uint32, error) {
+	if sector >= limit {
+		return nil, errors.Wrapf(ErrInvalid, "sector %d out of range", sector)
+	}
+
+	values := make([]uint32, f.sectorSize/4)
+	if err := binary.Read(io.NewSectionReader(f.r, f.sectorOffset(sector), f.sectorSize), binary.LittleEndian, values); err != nil {
+		return nil, errors.Wrapf(ErrInvalid, "failed to read sector %d", sector)
+	}
+
+	return values, nil
+}
+
+// difat returns the sectors holding the FAT, from the header and the DIFAT chain.
+func (f *File) difat(h *header, numSectors uint32) ([]uint32, error) {
+	// Both counts come from the header, check them against the file before
+	// allocating or following anything.
+	if h.NumFATSectors > numSectors || h.NumDIFATSectors > numSectors {
+		return nil, errors.Wrapf(ErrInvalid, "%d FAT and %d DIFAT sectors in a file of %d sectors", h.NumFATSectors, h.NumDIFATSectors, numSectors)
+	}
+
+	sectors := make([]uint32, 0, h.NumFATSectors)
+	for _, s := range h.DIFAT {
+		if s <= maxRegSect {
+			sectors = append(sectors, s)
+		}
+	}
+
+	next := h.FirstDIFATSector
+	for i := uint32(0); i < h.NumDIFATSectors && next <= maxRegSect; i++ {
+		values, err := f.readUint32s(next, numSectors)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, s := range values[:len(values)-1] {
+			if s <= maxRegSect {
+				sectors = append(sectors, s)
+			}
+		}
+		next = values[len(values)-1]
+	}
+
+	if uint32(len(sectors)) != h.NumFATSectors {
+		return nil, errors.Wrapf(ErrInvalid, "expected %d FAT sectors, found %d", h.NumFATSectors, len(sectors))
+	}
+
+	return sectors, nil
+}
+
+// chain follows the allocation table from start and returns the sectors.
+func chain(table []uint32, start uint32) ([]uint32, error) {
+	var sectors []uint32
+
+	for s := start; s != endOfChain; s = table[s] {
+		if s >= uint32(len(table)) || len(sectors) > len(table) {
+			return nil, errors.Wrap(ErrInvalid, "broken sector chain")
+		}
+		sectors = append(sectors, s)
+	}
+
+	return sectors, nil
+}
+
+func (f *File) readChain(table []uint32, start uint32) ([]byte, error) {
+	sectors, err := chain(table, start)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, int64(len(sectors))*f.sectorSize)
+	for i, s := range sectors {
+		if _, err := f.r.ReadAt(data[int64(i)*f.sectorSize:int64(i+1)*f.sectorSize], f.sectorOffset(s)); err != nil {
+			return nil, errors.Wrapf(ErrInvalid, "failed to read sector %d", s)
+		}
+	}
+
+	return data, nil
+}
+
+// chainReader maps a stream stored in the given chain onto a contiguous
+// ReaderAt. Sector n starts at base + n*sectorSize of r.
+func chainReader(r io.ReaderAt, table []uint32, sectorSize int64, base int64, start uint32, size int64) (io.ReaderAt, error) {
+	if size == 0 {
+		return &sectorReader{}, nil
+	}
+
+	sectors, err := chain(table, start)
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(sectors))*sectorSize < size {
+		return nil, errors.Wrap(ErrInvalid, "stream is longer than its sector chain")
+	}
+
+	return &sectorReader{r: r, sectors: sectors, sectorSize: sectorSize, base: base, size: size}, nil
+}
+
+func (f *File) readDirectory(data []byte) error {
+	for off := 0; off+dirEntrySize <= len(data); off += dirEntrySize {
+		raw := data[off : off+dirEntrySize]
+
+		nameLen := int(binary.LittleEndian.Uint16(raw[64:]))
+		if nameLen > 64 || nameLen%2 != 0 {
+			return errors.Wrap(ErrInvalid, "invalid directory entry name")
+		}
+
+		name := make([]uint16, 0, 32)
+		for i := 0; i+2 <= nameLen; i += 2 {
+			if c := binary.LittleEndian.Uint16(raw[i:]); c != 0 {
+				name = append(name, c)
+			}
+		}
+
+		e := &DirEntry{
+			Name:  string(utf16.Decode(name)),
+			Type:  raw[66],
+			left:  binary.LittleEndian.Uint32(raw[68:]),
+			right: binary.LittleEndian.Uint32(raw[72:]),
+			child: binary.LittleEndian.Uint32(raw[76:]),
+			start: binary.LittleEndian.Uint32(raw[116:]),
+			Size:  int64(binary.LittleEndian.Uint64(raw[120:])),
+		}
+		copy(e.CLSID[:], raw[80:96])
+
+		if f.sectorSize == 512 {
+			e.Size &= 0xFFFFFFFF
+		}
+
+		f.entries = append(f.entries, e)
+	}
+
+	if len(f.entries) == 0 || f.entries[0].Type != TypeRoot {
+		return errors.Wrap(ErrInvalid, "missing root entry")
+	}
+
+	visited := make([]bool, len(f.entries))
+
+	return f.walk(f.entries[0].child, f.entries[0], visited)
+}
+
+// walk assigns paths to the entries of the red-black tree rooted at id.
+func (f *File) walk(id uint32, parent *DirEntry, visited []bool) error {
+	if id == noStream {
+		return nil
+	}
+
+	if int(id) >= len(f.entries) || visited[id] {
+		return errors.Wrap(ErrInvalid, "broken directory tree")
+	}
+	visited[id] = true
+
+	e := f.entries[id]
+	e.Path = path.Join(parent.Path, e.Name)
+	f.byPath[e.Path] = e
+	parent.children = append(parent.children, e)
+
+	if err := f.walk(e.left, parent, visited); err != nil {
+		return err
+	}
+
+	if err := f.walk(e.right, parent, visited); err != nil {
+		return err
+	}
+
+	if e.Type == TypeStorage {
+		return f.walk(e.child, e, visited)
+	}
+
+	return nil
+}
+
+type sectorReader struct {
+	r          io.ReaderAt
+	sectors    []uint32
+	sectorSize int64
+	base       int64
+	size       int64
+}
+
+func (s *sectorReader) ReadAt(p []byte, off int64) (int, error) {
+	if off >= s.size {
+		return 0, io.EOF
+	}
+
+	n := 0
+	for n < len(p) && off < s.size {
+		sector := s.sectors[off/s.sectorSize]
+		within := off % s.sectorSize
+
+		chunk := min(int64(len(p)-n), s.sectorSize-within, s.size-off)
+		m, err := s.r.ReadAt(p[n:n+int(chunk)], s.base+int64(sector)*s.sectorSize+within)
+		n += m
+		off += int64(m)
+
+		if err != nil && (err != io.EOF || int64(m) < chunk) {
+			return n, err
+		}
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+
+	return n, nil
+}
@@ -0,0 +1,235 @@
+package cfb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestCFBTestSuite(t *testing.T) {
+	suite.Run(t, new(CFBTestSuite))
+}
+
+type CFBTestSuite struct {
+	suite.Suite
+}
+
+type testStream struct {
+	name string
+	data []byte
+}
+
+// buildCompoundFile writes a version 3 compound file with the given streams in
+// its root storage. Streams below the cutoff go into the mini stream.
+func buildCompoundFile(clsid [16]byte, streams ...testStream) []byte {
+	const sectorSize = 512
+
+	sectors := func(n, size int) int { return (n + size - 1) / size }
+
+	var miniStream []byte
+	var miniFat []uint32
+	var big [][]byte
+	starts := make([]uint32, len(streams))
+
+	for i, s := range streams {
+		switch {
+		case len(s.data) == 0:
+			starts[i] = endOfChain
+		case len(s.data) < 4096:
+			starts[i] = uint32(len(miniFat))
+			n := sectors(len(s.data), miniSectorSize)
+			for j := 1; j < n; j++ {
+				miniFat = append(miniFat, uint32(len(miniFat)+1))
+			}
+			miniFat = append(miniFat, endOfChain)
+			miniStream = append(miniStream, s.data...)
+			miniStream = append(miniStream, make([]byte, n*miniSectorSize-len(s.data))...)
+		default:
+			big = append(big, s.data)
+		}
+	}
+
+	fat := []uint32{0xFFFFFFFD}
+	region := func(n int) uint32 {
+		if n == 0 {
+			return endOfChain
+		}
+		start := uint32(len(fat))
+		for j := 1; j < n; j++ {
+			fat = append(fat, uint32(len(fat)+1))
+		}
+		fat = append(fat, endOfChain)
+
+		return start
+	}
+
+	dirStart := region(sectors((len(streams)+1)*dirEntrySize, sectorSize))
+	miniFatSectors := sectors(len(miniFat)*4, sectorSize)
+	miniFatStart := region(miniFatSectors)
+	miniStreamStart := region(sectors(len(miniStream), sectorSize))
+
+	bigIndex := 0
+	for i, s := range streams {
+		if len(s.data) >= 4096 {
+			starts[i] = region(sectors(len(big[bigIndex]), sectorSize))
+			bigIndex++
+		}
+	}
+
+	for len(fat) < sectorSize/4 {
+		fat = append(fat, 0xFFFFFFFF)
+	}
+
+	out := &bytes.Buffer{}
+	h := header{
+		MinorVersion:      0x3E,
+		MajorVersion:      3,
+		ByteOrder:         0xFFFE,
+		SectorShift:       9,
+		MiniSectorShift:   6,
+		NumFATSectors:     1,
+		FirstDirSector:    dirStart,
+		MiniStreamCutoff:  4096,
+		FirstMiniFATSect:  miniFatStart,
+		NumMiniFATSectors: uint32(miniFatSectors),
+		FirstDIFATSector:  endOfChain,
+	}
+	copy(h.Signature[:], Signature)
+	for i := range h.DIFAT {
+		h.DIFAT[i] = 0xFFFFFFFF
+	}
+	h.DIFAT[0] = 0
+	_ = binary.Write(out, binary.LittleEndian, h)
+	_ = binary.Write(out, binary.LittleEndian, fat)
+
+	dirEntry := func(name string, typ byte, child, right, start uint32, size int, clsid [16]byte) {
+		raw := make([]byte, dirEntrySize)
+		encoded := utf16.Encode([]rune(name))
+		for i, c := range encoded {
+			binary.LittleEndian.PutUint16(raw[i*2:], c)
+		}
+		binary.LittleEndian.PutUint16(raw[64:], uint16(len(encoded)*2+2))
+		raw[66] = typ
+		binary.LittleEndian.PutUint32(raw[68:], noStream)
+		binary.LittleEndian.PutUint32(raw[72:], right)
+		binary.LittleEndian.PutUint32(raw[76:], child)
+		copy(raw[80:], clsid[:])
+		binary.LittleEndian.PutUint32(raw[116:], start)
+		binary.LittleEndian.PutUint64(raw[120:], uint64(size))
+		out.Write(raw)
+	}
+
+	child := uint32(noStream)
+	if len(streams) > 0 {
+		child = 1
+	}
+	dirEntry("Root Entry", TypeRoot, child, noStream, miniStreamStart, len(miniStream), clsid)
+	for i, s := range streams {
+		right := uint32(noStream)
+		if i+2 <= len(streams) {
+			right = uint32(i + 2)
+		}
+		dirEntry(s.name, TypeStream, noStream, right, starts[i], len(s.data), [16]byte{})
+	}
+
+	pad := func() { out.Write(make([]byte, (sectorSize-out.Len()%sectorSize)%sectorSize)) }
+	pad()
+	_ = binary.Write(out, binary.LittleEndian, miniFat)
+	pad()
+	out.Write(miniStream)
+	pad()
+	for _, b := range big {
+		out.Write(b)
+		pad()
+	}
+
+	return out.Bytes()
+}
+
+func (s *CFBTestSuite) TestOpen() {
+	clsid := [16]byte{0x84, 0x10, 0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, 0xC0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x46}
+	streams := []testStream{
+		{name: "\x05SummaryInformation", data: []byte("summary")},
+		{name: "small", data: bytes.Repeat([]byte("0123456789"), 100)},
+		{name: "large", data: bytes.Repeat([]byte("abcdefghij"), 1000)},
+		{name: "empty"},
+	}
+
+	data := buildCompoundFile(clsid, streams...)
+
+	f, err := Open(bytes.NewReader(data), int64(len(data)))
+	s.Require().NoError(err)
+	s.Require().Equal(clsid, f.Root().CLSID)
+	s.Require().Len(f.Entries(), len(streams))
+
+	for _, stream := range streams {
+		r, err := f.Open(stream.name)
+		s.Require().NoError(err, stream.name)
+
+		content, err := io.ReadAll(r)
+		s.Require().NoError(err)
+		s.Require().Equal(len(stream.data), len(content), stream.name)
+		s.Require().True(bytes.Equal(stream.data, content), stream.name)
+
+		buf := make([]byte, 7)
+		if len(stream.data) > 600 {
+			_, err = r.ReadAt(buf, 505)
+			s.Require().NoError(err)
+			s.Require().Equal(stream.data[505:512], buf)
+		}
+	}
+
+	_, err = f.Open("missing")
+	s.Require().ErrorIs(err, fs.ErrNotExist)
+}
+
+func (s *CFBTestSuite) TestOpenInvalid() {
+	valid := buildCompoundFile([16]byte{}, testStream{name: "a", data: []byte("a")})
+
+	for name, data := range map[string][]byte{
+		"empty":     nil,
+		"text":      []byte(strings.Repeat("not a compound file", 50)),
+		"signature": append(append([]byte{}, valid[:7]...), valid[8:]...),
+		"version":   func() []byte { d := bytes.Clone(valid); d[0x1A] = 5; return d }(),
+		"fat":       func() []byte { d := bytes.Clone(valid); d[0x4C] = 0xFF; return d }(),
+		"directory": func() []byte { d := bytes.Clone(valid); d[0x30] = 0x7F; return d }(),
+		"fatCount": func() []byte {
+			d := bytes.Clone(valid)
+			binary.LittleEndian.PutUint32(d[0x2C:], 0xFFFFFFFF)
+			return d
+		}(),
+		"difatCount": func() []byte {
+			d := bytes.Clone(valid)
+			binary.LittleEndian.PutUint32(d[0x44:], 0)
+			binary.LittleEndian.PutUint32(d[0x48:], 0xFFFFFFFF)
+			return d
+		}(),
+	} {
+		_, err := Open(bytes.NewReader(data), int64(len(data)))
+		s.Require().ErrorIs(err, ErrInvalid, name)
+	}
+}
+
+func FuzzOpen(f *testing.F) {
+	f.Add(buildCompoundFile([16]byte{}, testStream{name: "a", data: []byte("a")}))
+	f.Add(buildCompoundFile([16]byte{}, testStream{name: "large", data: bytes.Repeat([]byte("abcdefghij"), 410)}))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		file, err := Open(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			return
+		}
+
+		for _, e := range file.Entries() {
+			if r, err := file.OpenEntry(e); err == nil {
+				_, _ = io.Copy(io.Discard, r)
+			}
+		}
+	})
+}
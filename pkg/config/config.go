@@ -4,12 +4,117 @@ package config
 const (
 	KeyJSONLogging    = "json"
 	KeyVerboseLogging = "verbose"
+	KeyConfigFile     = "config"
+	KeyProfile        = "profile"
+	KeyCacheDir       = "cacheDir"
+	KeyTenantID       = "tenantId"
+	KeyClientID       = "clientId"
 
 	// Flags for new-intunewin-package
-	KeySourceFolder = "path"
-	KeySetupFile    = "setupFile"
-	KeyOutputFolder = "output"
+	KeySourceFolder  = "path"
+	KeySetupFile     = "setupFile"
+	KeyOutputFolder  = "output"
+	KeyKeyProvider   = "keyProvider"
+	KeyKeystore      = "keystore"
+	KeyKMSURL        = "kmsUrl"
+	KeyKMSToken      = "kmsToken"
+	KeyEmitMetadata  = "emitMetadata"
+	KeyMetadataKey   = "metadataKey"
+	KeyCompression   = "compression"
+	KeyExclude       = "exclude"
+	KeyOutputName    = "outputName"
+	KeySBOM          = "sbom"
+	KeySBOMFormat    = "sbomFormat"
+	KeyProvenanceKey = "provenanceKey"
+	KeyBuilderID     = "builderId"
+	KeySignKey       = "signKey"
+	KeyClamd         = "clamd"
+	KeyScanCommand   = "scanCommand"
+	KeyPolicy        = "policy"
+	KeyReport        = "report"
+	KeyJUnit         = "junit"
+	KeyIndex         = "index"
+	KeyBase          = "base"
+	KeyRelationships = "relationships"
+	KeySources       = "sources"
+
+	// Flags for signature inspection
+	KeyRequireSigned     = "requireSigned"
+	KeyRequireTrusted    = "requireTrusted"
+	KeyAllowedPublishers = "allowedPublishers"
+	KeyTrustedRoots      = "trustedRoots"
+
+	// Flags for remote sources
+	KeyRegistryUsername  = "registryUsername"
+	KeyRegistryPassword  = "registryPassword"
+	KeyRegistryPlainHTTP = "registryPlainHttp"
 
 	// Flags for decrypt-intunewin-package
 	KeyEncryptedPackageFile = "file"
+
+	// Flags for inspect
+	KeyFormat   = "format"
+	KeyValidate = "validate"
+
+	// Flags for verify
+	KeyProvenance     = "provenance"
+	KeyProvenanceFile = "provenanceFile"
+	KeySignature      = "signature"
+	KeySignatureFile  = "signatureFile"
+	KeyTrustedKeys    = "trustedKeys"
+
+	// Flags for import winget
+	KeyManifest     = "manifest"
+	KeyInstaller    = "installer"
+	KeyArchitecture = "architecture"
+	KeyScope        = "scope"
+	KeyLocale       = "locale"
+
+	// Flags for ls, cat and extract
+	KeyEntry  = "entry"
+	KeyVerify = "verify"
 )
+
+// Setting describes a configuration value shown by `config show`.
+type Setting struct {
+	Key    string
+	Secret bool
+}
+
+// Settings lists the values that can be set in a configuration file.
+var Settings = []Setting{
+	{Key: KeyJSONLogging},
+	{Key: KeyVerboseLogging},
+	{Key: KeyCompression},
+	{Key: KeyExclude},
+	{Key: KeyOutputName},
+	{Key: KeyOutputFolder},
+	{Key: KeyCacheDir},
+	{Key: KeyKeyProvider},
+	{Key: KeyKeystore},
+	{Key: KeyKMSURL},
+	{Key: KeyKMSToken, Secret: true},
+	{Key: KeyRegistryUsername},
+	{Key: KeyRegistryPassword, Secret: true},
+	{Key: KeyRegistryPlainHTTP},
+	{Key: KeyRequireSigned},
+	{Key: KeyRequireTrusted},
+	{Key: KeyAllowedPublishers},
+	{Key: KeyTrustedRoots},
+	{Key: KeyEmitMetadata},
+	{Key: KeySBOM},
+	{Key: KeyIndex},
+	{Key: KeyProvenanceKey},
+	{Key: KeyBuilderID},
+	{Key: KeySignKey},
+	{Key: KeyClamd},
+	{Key: KeyScanCommand},
+	{Key: KeyPolicy},
+	{Key: KeyTrustedKeys},
+	{Key: KeyMetadataKey},
+	{Key: KeyTenantID},
+	{Key: KeyClientID},
+	{Key: KeyArchitecture},
+	{Key: KeyScope},
+	{Key: KeyLocale},
+}
@@ -0,0 +1,128 @@
+package config
+
+import (
+	"os"
+	"path"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestConfigTestSuite(t *testing.T) {
+	suite.Run(t, new(ConfigTestSuite))
+}
+
+type ConfigTestSuite struct {
+	suite.Suite
+
+	testDir string
+	wd      string
+}
+
+const testConfig = `
+verbose: true
+compression: store
+exclude: ["*.log"]
+profile: local
+profiles:
+  ci:
+    json: true
+    compression: deflate
+  local:
+    verbose: false
+`
+
+func (s *ConfigTestSuite) SetupTest() {
+	var err error
+	s.testDir, err = os.MkdirTemp("", "config-test-*")
+	s.Require().NoError(err)
+
+	s.wd, err = os.Getwd()
+	s.Require().NoError(err)
+	s.Require().NoError(os.Chdir(s.testDir))
+}
+
+func (s *ConfigTestSuite) TearDownTest() {
+	s.Require().NoError(os.Chdir(s.wd))
+	s.Require().NoError(os.RemoveAll(s.testDir))
+}
+
+func (s *ConfigTestSuite) TestDiscover() {
+	configHome := path.Join(s.testDir, "xdg")
+	s.T().Setenv("XDG_CONFIG_HOME", configHome)
+
+	found, err := Discover("")
+	s.Require().NoError(err)
+	s.Require().Empty(found)
+
+	s.Require().NoError(os.MkdirAll(path.Join(configHome, "content-prep"), 0755))
+	s.Require().NoError(os.WriteFile(path.Join(configHome, "content-prep", FileName), []byte(testConfig), 0644))
+
+	found, err = Discover("")
+	s.Require().NoError(err)
+	s.Require().Equal(path.Join(configHome, "content-prep", FileName), found)
+
+	s.Require().NoError(os.WriteFile(path.Join(s.testDir, FileName), []byte(testConfig), 0644))
+
+	found, err = Discover("")
+	s.Require().NoError(err)
+	s.Require().Equal(path.Join(s.testDir, FileName), found)
+
+	_, err = Discover(path.Join(s.testDir, "missing.yaml"))
+	s.Require().Error(err)
+}
+
+func (s *ConfigTestSuite) TestLoadProfile() {
+	configFile := path.Join(s.testDir, FileName)
+	s.Require().NoError(os.WriteFile(configFile, []byte(testConfig), 0644))
+
+	v := viper.New()
+	f, err := Load(v, configFile, "ci")
+	s.Require().NoError(err)
+	s.Require().Equal("ci", f.Profile)
+
+	s.Require().True(v.GetBool(KeyJSONLogging))
+	s.Require().True(v.GetBool(KeyVerboseLogging))
+	s.Require().Equal("deflate", v.GetString(KeyCompression))
+	s.Require().Equal([]string{"*.log"}, v.GetStringSlice(KeyExclude))
+
+	s.Require().Equal(SourceProfile+" ci ("+configFile+")", Source(f, KeyCompression, nil))
+	s.Require().Equal(SourceFile+" ("+configFile+")", Source(f, KeyExclude, nil))
+	s.Require().Equal(SourceDefault, Source(f, KeyCacheDir, nil))
+	s.Require().Equal(SourceFlag, Source(f, KeyCompression, map[string]bool{KeyCompression: true}))
+
+	s.T().Setenv(EnvName(KeyCompression), "store")
+	s.Require().Equal(SourceEnv+" (CONTENT_PREP_COMPRESSION)", Source(f, KeyCompression, nil))
+}
+
+func (s *ConfigTestSuite) TestLoadDefaultProfile() {
+	configFile := path.Join(s.testDir, FileName)
+	s.Require().NoError(os.WriteFile(configFile, []byte(testConfig), 0644))
+
+	v := viper.New()
+	f, err := Load(v, configFile, "")
+	s.Require().NoError(err)
+	s.Require().Equal("local", f.Profile)
+	s.Require().False(v.GetBool(KeyVerboseLogging))
+
+	_, err = Load(viper.New(), configFile, "missing")
+	s.Require().ErrorContains(err, `profile "missing" is not defined`)
+}
+
+func (s *ConfigTestSuite) TestCacheDir() {
+	s.T().Setenv("HOME", s.testDir)
+	s.T().Setenv("XDG_CACHE_HOME", path.Join(s.testDir, "cache"))
+
+	for dir, expected := range map[string]string{
+		"":                    path.Join(s.testDir, "cache", "content-prep"),
+		"~":                   s.testDir,
+		"~/.cache/installers": path.Join(s.testDir, ".cache", "installers"),
+		"/var/cache/x":        "/var/cache/x",
+		"~other/x":            "~other/x",
+	} {
+		cacheDir, err := CacheDir(dir)
+		s.Require().NoError(err)
+		s.Require().Equal(expected, cacheDir, dir)
+	}
+}
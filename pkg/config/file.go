@@ -0,0 +1,172 @@
+package config
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/viper"
+)
+
+const (
+	FileName      = "content-prep.yaml"
+	EnvPrefix     = "CONTENT_PREP"
+	appConfigDir  = "content-prep"
+	profilesKey   = "profiles"
+	xdgConfigHome = "XDG_CONFIG_HOME"
+)
+
+// Sources a setting can take its effective value from, in increasing priority.
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceProfile = "profile"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// File is a loaded configuration file.
+type File struct {
+	Path    string
+	Profile string
+
+	values  map[string]any
+	profile map[string]any
+}
+
+// Discover returns the configuration file to load. An explicit path always
+// wins, otherwise content-prep.yaml is searched in the working directory and in
+// $XDG_CONFIG_HOME/content-prep (defaulting to ~/.config). An empty path is
+// returned if there is no configuration file.
+func Discover(explicit string) (string, error) {
+	if explicit != "" {
+		if _, err := os.Stat(explicit); err != nil {
+			return "", errors.Wrapf(err, "failed to find config file")
+		}
+
+		return explicit, nil
+	}
+
+	var candidates []string
+
+	if wd, err := os.Getwd(); err == nil {
+		candidates = append(candidates, path.Join(wd, FileName))
+	}
+
+	configHome := os.Getenv(xdgConfigHome)
+	if configHome == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			configHome = path.Join(home, ".config")
+		}
+	}
+	if configHome != "" {
+		candidates = append(candidates, path.Join(configHome, appConfigDir, FileName))
+	}
+
+	for _, candidate := range candidates {
+		if info, err := os.Stat(candidate); err == nil && !info.IsDir() {
+			return candidate, nil
+		}
+	}
+
+	return "", nil
+}
+
+// CacheDir returns the cache directory with a leading ~ expanded to the home
+// directory. It defaults to content-prep in the user cache directory
+// ($XDG_CACHE_HOME, defaulting to ~/.cache).
+func CacheDir(dir string) (string, error) {
+	if dir == "" {
+		cacheHome, err := os.UserCacheDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to find cache directory")
+		}
+
+		return path.Join(cacheHome, appConfigDir), nil
+	}
+
+	if dir == "~" || strings.HasPrefix(dir, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to expand %s", dir)
+		}
+
+		return path.Join(home, dir[1:]), nil
+	}
+
+	return dir, nil
+}
+
+// Load reads the configuration file into v and merges the named profile over
+// its top-level values. If profile is empty, the profile named by the "profile"
+// setting of the file (or environment) is used.
+func Load(v *viper.Viper, configFile string, profile string) (*File, error) {
+	fileViper := viper.New()
+	fileViper.SetConfigFile(configFile)
+	if err := fileViper.ReadInConfig(); err != nil {
+		return nil, errors.Wrapf(err, "failed to read config file %s", configFile)
+	}
+
+	f := &File{
+		Path:   configFile,
+		values: fileViper.AllSettings(),
+	}
+
+	if err := v.MergeConfigMap(f.values); err != nil {
+		return nil, errors.Wrapf(err, "failed to apply config file %s", configFile)
+	}
+
+	if profile == "" {
+		profile = fileViper.GetString(KeyProfile)
+	}
+
+	if profile == "" {
+		return f, nil
+	}
+
+	profiles := fileViper.GetStringMap(profilesKey)
+
+	values, ok := profiles[strings.ToLower(profile)].(map[string]any)
+	if !ok {
+		return nil, errors.Errorf("profile %q is not defined in %s", profile, configFile)
+	}
+
+	if err := v.MergeConfigMap(values); err != nil {
+		return nil, errors.Wrapf(err, "failed to apply profile %q", profile)
+	}
+
+	f.Profile = profile
+	f.profile = values
+
+	return f, nil
+}
+
+// Source describes where the effective value of key comes from. changedFlags
+// holds the flags that were set on the command line.
+func Source(f *File, key string, changedFlags map[string]bool) string {
+	if changedFlags[key] {
+		return SourceFlag
+	}
+
+	if _, ok := os.LookupEnv(EnvName(key)); ok {
+		return SourceEnv + " (" + EnvName(key) + ")"
+	}
+
+	if f != nil {
+		if _, ok := f.profile[strings.ToLower(key)]; ok {
+			return SourceProfile + " " + f.Profile + " (" + f.Path + ")"
+		}
+
+		if _, ok := f.values[strings.ToLower(key)]; ok {
+			return SourceFile + " (" + f.Path + ")"
+		}
+	}
+
+	return SourceDefault
+}
+
+// EnvName returns the environment variable that sets the given key.
+func EnvName(key string) string {
+	return EnvPrefix + "_" + strings.ToUpper(key)
+}
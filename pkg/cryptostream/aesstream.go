@@ -7,14 +7,45 @@ import (
 	"crypto/sha256"
 	"errors"
 	"io"
+	"runtime"
+	"sync"
+	"sync/atomic"
 )
 
 const BufferSize int = 2097152
 const IvSize int = 16
 const HMACKeySize = 32
 
+// maxWorkers caps the encryption workers. At most 2*maxWorkers+2 buffers of
+// BufferSize are in flight, so an encryption holds no more than 36 MiB
+// regardless of the number of CPUs.
+const maxWorkers = 8
+
+var bufPool = sync.Pool{
+	New: func() any {
+		buf := make([]byte, BufferSize)
+		return &buf
+	},
+}
+
+// chunk is a part of the plaintext that is encrypted in place by a worker.
+type chunk struct {
+	buf    *[]byte
+	n      int
+	offset int64
+	done   chan struct{}
+}
+
 // Encrypt the stream using the given AES-CTR and SHA256-HMAC key
+//
+// The keystream is split into chunks of BufferSize that are encrypted by one
+// worker per CPU, up to maxWorkers, while the ciphertext is written and fed
+// into the HMAC in order.
 func Encrypt(in io.ReadSeeker, out io.WriteSeeker, keyAes []byte, iv []byte, hmacKey []byte) error {
+	return encrypt(in, out, keyAes, iv, hmacKey, runtime.GOMAXPROCS(0))
+}
+
+func encrypt(in io.Reader, out io.WriteSeeker, keyAes []byte, iv []byte, hmacKey []byte, workers int) error {
 	AES, err := aes.NewCipher(keyAes)
 	if err != nil {
 		return err
@@ -35,8 +66,9 @@ func Encrypt(in io.ReadSeeker, out io.WriteSeeker, keyAes []byte, iv []byte, hma
 		return errors.New("invalid HMAC key length, expected 32 bytes")
 	}
 
+	workers = min(max(workers, 1), maxWorkers)
+
 	hasher := HMAC.New(sha256.New, hmacKey)
-	ctr := cipher.NewCTR(AES, iv)
 
 	_, err = out.Seek(sha256.Size, io.SeekStart)
 	if err != nil {
@@ -50,26 +82,79 @@ func Encrypt(in io.ReadSeeker, out io.WriteSeeker, keyAes []byte, iv []byte, hma
 		return err
 	}
 
-	var n int
-	buf := make([]byte, BufferSize)
-	for {
-		n, err = in.Read(buf)
-		if err != nil && err != io.EOF {
-			return err
+	// Every chunk is queued in ordered, which bounds the buffers in flight to
+	// its capacity plus the one being read and the one being written.
+	jobs := make(chan *chunk, workers)
+	ordered := make(chan *chunk, 2*workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for c := range jobs {
+				buf := (*c.buf)[:c.n]
+				newCTRAt(AES, iv, c.offset).XORKeyStream(buf, buf)
+				close(c.done)
+			}
+		}()
+	}
+
+	var failed atomic.Bool
+	writeErr := make(chan error, 1)
+	go func() {
+		var err error
+		for c := range ordered {
+			<-c.done
+
+			if err == nil {
+				if _, err = w.Write((*c.buf)[:c.n]); err != nil {
+					failed.Store(true)
+				}
+			}
+
+			bufPool.Put(c.buf)
 		}
 
+		writeErr <- err
+	}()
+
+	var readErr error
+	var offset int64
+	for !failed.Load() {
+		buf := bufPool.Get().(*[]byte)
+
+		n, err := io.ReadFull(in, *buf)
 		if n > 0 {
-			outBuf := make([]byte, n)
-			ctr.XORKeyStream(outBuf, buf[:n])
-			_, err = w.Write(outBuf)
-			if err != nil {
-				return err
-			}
+			c := &chunk{buf: buf, n: n, offset: offset, done: make(chan struct{})}
+			ordered <- c
+			jobs <- c
+			offset += int64(n)
+		} else {
+			bufPool.Put(buf)
 		}
 
-		if err == io.EOF {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			break
 		}
+
+		if err != nil {
+			readErr = err
+			break
+		}
+	}
+
+	close(jobs)
+	close(ordered)
+	wg.Wait()
+
+	if err := <-writeErr; err != nil {
+		return err
+	}
+
+	if readErr != nil {
+		return readErr
 	}
 
 	_, err = out.Seek(0, io.SeekStart)
@@ -144,7 +229,7 @@ func Decrypt(in io.Reader, out io.Writer, keyAes []byte, hmacKey []byte) error {
 	}
 
 	if !HMAC.Equal(hasher.Sum(nil), hash) {
-		return errors.New("HMAC mismatch")
+		return ErrHMACMismatch
 	}
 
 	return nil
@@ -1,8 +1,14 @@
 package cryptostream
 
 import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
 	"crypto/sha256"
+	"fmt"
 	"io"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -96,3 +102,144 @@ func (s *AESStreamTestSuite) TestEncrypt() {
 
 	s.Require().Equal("test", string(decryptedPlaintext.buf))
 }
+
+func (s *AESStreamTestSuite) TestReaderAt() {
+	plaintext := make([]byte, 1000)
+	for i := range plaintext {
+		plaintext[i] = byte(i % 251)
+	}
+
+	ciphertext := &mywriter{}
+
+	err := Encrypt(bytes.NewReader(plaintext), ciphertext, s.aesKey, s.iv, s.hmacKey)
+	s.Require().NoError(err)
+
+	r, err := NewReaderAt(bytes.NewReader(ciphertext.buf), int64(len(ciphertext.buf)), s.aesKey, s.iv)
+	s.Require().NoError(err)
+	s.Require().Equal(int64(len(plaintext)), r.Size())
+
+	for _, tc := range []struct {
+		off, length int
+	}{
+		{0, 16},
+		{3, 5},
+		{15, 2},
+		{17, 100},
+		{512, 488},
+	} {
+		buf := make([]byte, tc.length)
+		n, err := r.ReadAt(buf, int64(tc.off))
+		s.Require().NoError(err)
+		s.Require().Equal(tc.length, n)
+		s.Require().Equal(plaintext[tc.off:tc.off+tc.length], buf)
+	}
+
+	buf := make([]byte, 10)
+	n, err := r.ReadAt(buf, 995)
+	s.Require().ErrorIs(err, io.EOF)
+	s.Require().Equal(5, n)
+	s.Require().Equal(plaintext[995:], buf[:n])
+}
+
+func (s *AESStreamTestSuite) TestVerifyHMAC() {
+	ciphertext := &mywriter{}
+
+	err := Encrypt(strings.NewReader("test"), ciphertext, s.aesKey, s.iv, s.hmacKey)
+	s.Require().NoError(err)
+
+	err = VerifyHMAC(bytes.NewReader(ciphertext.buf), int64(len(ciphertext.buf)), s.hmacKey)
+	s.Require().NoError(err)
+
+	ciphertext.buf[len(ciphertext.buf)-1] ^= 0xff
+
+	err = VerifyHMAC(bytes.NewReader(ciphertext.buf), int64(len(ciphertext.buf)), s.hmacKey)
+	s.Require().ErrorIs(err, ErrHMACMismatch)
+}
+
+func TestAddCounter(t *testing.T) {
+	counter := []byte{0, 0, 0, 0, 0, 0, 0, 1, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+
+	addCounter(counter, 2)
+
+	if !bytes.Equal([]byte{0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0, 1}, counter) {
+		t.Fatalf("unexpected counter %x", counter)
+	}
+}
+
+func (s *AESStreamTestSuite) TestEncryptParallel() {
+	plaintext := make([]byte, 5*BufferSize/2+7)
+	_, err := rand.Read(plaintext)
+	s.Require().NoError(err)
+
+	serial := &mywriter{}
+	err = encrypt(bytes.NewReader(plaintext), serial, s.aesKey, s.iv, s.hmacKey, 1)
+	s.Require().NoError(err)
+
+	parallel := &mywriter{}
+	err = encrypt(bytes.NewReader(plaintext), parallel, s.aesKey, s.iv, s.hmacKey, 4)
+	s.Require().NoError(err)
+
+	s.Require().Equal(serial.buf, parallel.buf)
+
+	block, err := aes.NewCipher(s.aesKey)
+	s.Require().NoError(err)
+
+	expected := make([]byte, len(plaintext))
+	cipher.NewCTR(block, s.iv).XORKeyStream(expected, plaintext)
+	s.Require().Equal(expected, parallel.buf[HeaderSize:])
+
+	_, err = parallel.Seek(0, io.SeekStart)
+	s.Require().NoError(err)
+
+	decrypted := &mywriter{}
+	err = Decrypt(parallel, decrypted, s.aesKey, s.hmacKey)
+	s.Require().NoError(err)
+	s.Require().Equal(plaintext, decrypted.buf)
+}
+
+// discardSeeker is an io.WriteSeeker that throws away everything written to it.
+type discardSeeker struct{}
+
+func (discardSeeker) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+func (discardSeeker) Seek(int64, int) (int64, error) {
+	return 0, nil
+}
+
+// BenchmarkEncrypt compares worker counts up to the number of CPUs, capped at
+// maxWorkers. Each encryption keeps at most 2*workers+2 buffers of BufferSize
+// in flight, 36 MiB with maxWorkers.
+func BenchmarkEncrypt(b *testing.B) {
+	key := []byte(strings.Repeat("a", 32))
+	iv := []byte(strings.Repeat("i", 16))
+	hmacKey := []byte(strings.Repeat("h", 32))
+
+	plaintext := make([]byte, 64<<20)
+	if _, err := rand.Read(plaintext); err != nil {
+		b.Fatal(err)
+	}
+
+	cpus := min(runtime.GOMAXPROCS(0), maxWorkers)
+	workerCounts := []int{1}
+	for n := 2; n < cpus; n *= 2 {
+		workerCounts = append(workerCounts, n)
+	}
+	if cpus > 1 {
+		workerCounts = append(workerCounts, cpus)
+	}
+
+	for _, workers := range workerCounts {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			b.SetBytes(int64(len(plaintext)))
+			b.ReportAllocs()
+
+			for i := 0; i < b.N; i++ {
+				if err := encrypt(bytes.NewReader(plaintext), discardSeeker{}, key, iv, hmacKey, workers); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package cryptostream
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	HMAC "crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// HeaderSize is the number of bytes preceding the ciphertext in an encrypted stream.
+const HeaderSize = sha256.Size + IvSize
+
+var ErrHMACMismatch = errors.New("HMAC mismatch")
+
+var _ io.ReaderAt = &ReaderAt{}
+
+// ReaderAt decrypts arbitrary ranges of a stream produced by Encrypt. AES-CTR
+// allows decryption to start at any block by advancing the counter, so a
+// ReaderAt can be handed to archive/zip.NewReader without materialising the
+// plaintext.
+//
+// ReaderAt does not authenticate the data it returns, use VerifyHMAC for that.
+type ReaderAt struct {
+	r     io.ReaderAt
+	block cipher.Block
+	iv    []byte
+	size  int64
+}
+
+// NewReaderAt returns a ReaderAt for the encrypted stream of the given size
+// (including the HMAC and IV header) using the given AES key and IV.
+func NewReaderAt(encrypted io.ReaderAt, size int64, keyAes []byte, iv []byte) (*ReaderAt, error) {
+	if size < int64(HeaderSize) {
+		return nil, errors.New("encrypted stream is too short")
+	}
+
+	if len(iv) != IvSize {
+		return nil, errors.New("invalid IV length, expected 16 bytes")
+	}
+
+	AES, err := aes.NewCipher(keyAes)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ReaderAt{
+		r:     encrypted,
+		block: AES,
+		iv:    append([]byte(nil), iv...),
+		size:  size - int64(HeaderSize),
+	}, nil
+}
+
+// Size returns the size of the plaintext.
+func (d *ReaderAt) Size() int64 {
+	return d.size
+}
+
+func (d *ReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+
+	if off >= d.size {
+		return 0, io.EOF
+	}
+
+	truncated := false
+	if remaining := d.size - off; int64(len(p)) > remaining {
+		p = p[:remaining]
+		truncated = true
+	}
+
+	n, err := d.r.ReadAt(p, off+int64(HeaderSize))
+	if n == 0 {
+		return 0, err
+	}
+
+	ctr := newCTRAt(d.block, d.iv, off)
+	ctr.XORKeyStream(p[:n], p[:n])
+
+	if err == nil && truncated {
+		err = io.EOF
+	}
+
+	return n, err
+}
+
+// VerifyHMAC reads the encrypted stream of the given size and checks the HMAC
+// stored in its header.
+func VerifyHMAC(encrypted io.ReaderAt, size int64, hmacKey []byte) error {
+	if size < int64(HeaderSize) {
+		return errors.New("encrypted stream is too short")
+	}
+
+	hash := make([]byte, sha256.Size)
+	if _, err := encrypted.ReadAt(hash, 0); err != nil {
+		return err
+	}
+
+	hasher := HMAC.New(sha256.New, hmacKey)
+	if _, err := io.Copy(hasher, io.NewSectionReader(encrypted, sha256.Size, size-sha256.Size)); err != nil {
+		return err
+	}
+
+	if !HMAC.Equal(hasher.Sum(nil), hash) {
+		return ErrHMACMismatch
+	}
+
+	return nil
+}
+
+// newCTRAt returns a CTR stream whose keystream starts at the given byte offset.
+func newCTRAt(block cipher.Block, iv []byte, offset int64) cipher.Stream {
+	blockSize := int64(block.BlockSize())
+
+	counter := make([]byte, len(iv))
+	copy(counter, iv)
+	addCounter(counter, uint64(offset/blockSize))
+
+	ctr := cipher.NewCTR(block, counter)
+
+	if skip := offset % blockSize; skip > 0 {
+		discard := make([]byte, skip)
+		ctr.XORKeyStream(discard, discard)
+	}
+
+	return ctr
+}
+
+// addCounter adds n to the big endian 128 bit counter block.
+func addCounter(counter []byte, n uint64) {
+	lo := binary.BigEndian.Uint64(counter[8:])
+	hi := binary.BigEndian.Uint64(counter[:8])
+
+	sum := lo + n
+	if sum < lo {
+		hi++
+	}
+
+	binary.BigEndian.PutUint64(counter[8:], sum)
+	binary.BigEndian.PutUint64(counter[:8], hi)
+}
@@ -0,0 +1,254 @@
+// Package diff compares the contents and metadata of two packages.
+package diff
+
+import (
+	"content-prep/pkg/installer"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/sbom"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+)
+
+// Output formats of Write.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Change is how a file differs between the packages.
+type Change string
+
+const (
+	Added    Change = "added"
+	Removed  Change = "removed"
+	Modified Change = "modified"
+)
+
+// Diff lists the differences between an old and a new package.
+type Diff struct {
+	// Metadata lists the Detection.xml fields that differ. Keys are not
+	// compared, as they differ for every package.
+	Metadata []FieldChange `json:"metadata"`
+	Files    []FileChange  `json:"files"`
+
+	// Unchanged counts the files with identical content.
+	Unchanged int `json:"unchanged"`
+}
+
+// FieldChange is a metadata field with different values.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// FileChange is a file that was added, removed or modified. Old fields are
+// empty for added files and new fields for removed files.
+type FileChange struct {
+	Name      string `json:"name"`
+	Change    Change `json:"change"`
+	OldSize   int64  `json:"oldSize,omitempty"`
+	NewSize   int64  `json:"newSize,omitempty"`
+	OldSHA256 string `json:"oldSha256,omitempty"`
+	NewSHA256 string `json:"newSha256,omitempty"`
+
+	// Version lists the version fields of PE files and MSI databases that
+	// differ.
+	Version []FieldChange `json:"version,omitempty"`
+}
+
+// Empty reports whether the packages are equal.
+func (d *Diff) Empty() bool {
+	return len(d.Metadata) == 0 && len(d.Files) == 0
+}
+
+// Count returns the number of files with the change.
+func (d *Diff) Count(change Change) int {
+	var n int
+	for _, f := range d.Files {
+		if f.Change == change {
+			n++
+		}
+	}
+
+	return n
+}
+
+// Packages compares two packages. The inner archives of both are decrypted
+// while their files are read.
+func Packages(old, new *packager.Package) (*Diff, error) {
+	oldFiles, err := sbom.Collect(old.FS())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read old package")
+	}
+
+	newFiles, err := sbom.Collect(new.FS())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read new package")
+	}
+
+	d := Files(oldFiles, newFiles)
+	d.Metadata = Metadata(&old.ApplicationInfo, &new.ApplicationInfo)
+
+	return d, nil
+}
+
+// Files compares two file lists by name.
+func Files(oldFiles, newFiles []sbom.File) *Diff {
+	d := &Diff{Metadata: []FieldChange{}, Files: []FileChange{}}
+
+	oldByName := make(map[string]sbom.File, len(oldFiles))
+	for _, f := range oldFiles {
+		oldByName[f.Name] = f
+	}
+
+	for _, n := range newFiles {
+		o, ok := oldByName[n.Name]
+		delete(oldByName, n.Name)
+
+		switch {
+		case !ok:
+			d.Files = append(d.Files, FileChange{Name: n.Name, Change: Added, NewSize: n.Size, NewSHA256: n.SHA256, Version: fieldChanges("", nil, n.Version)})
+		case o.SHA256 != n.SHA256:
+			d.Files = append(d.Files, FileChange{
+				Name:      n.Name,
+				Change:    Modified,
+				OldSize:   o.Size,
+				NewSize:   n.Size,
+				OldSHA256: o.SHA256,
+				NewSHA256: n.SHA256,
+				Version:   fieldChanges("", o.Version, n.Version),
+			})
+		default:
+			d.Unchanged++
+		}
+	}
+
+	for _, o := range oldByName {
+		d.Files = append(d.Files, FileChange{Name: o.Name, Change: Removed, OldSize: o.Size, OldSHA256: o.SHA256, Version: fieldChanges("", o.Version, nil)})
+	}
+
+	sort.Slice(d.Files, func(i, j int) bool { return d.Files[i].Name < d.Files[j].Name })
+
+	return d
+}
+
+// Metadata compares the Detection.xml fields, except for key material.
+func Metadata(old, new *packager.ApplicationInfo) []FieldChange {
+	changes := []FieldChange{}
+	for _, field := range []struct {
+		name     string
+		old, new string
+	}{
+		{"Name", old.Name, new.Name},
+		{"SetupFile", old.SetupFile, new.SetupFile},
+		{"FileName", old.FileName, new.FileName},
+		{"UnencryptedContentSize", strconv.FormatInt(old.UnencryptedContentSize, 10), strconv.FormatInt(new.UnencryptedContentSize, 10)},
+		{"ToolVersion", old.ToolVersion, new.ToolVersion},
+		{"EncryptionInfo.FileDigest", hex.EncodeToString(old.EncryptionInfo.FileDigest), hex.EncodeToString(new.EncryptionInfo.FileDigest)},
+	} {
+		if field.old != field.new {
+			changes = append(changes, FieldChange{Field: field.name, Old: field.old, New: field.new})
+		}
+	}
+
+	return append(changes, fieldChanges("MsiInfo.", old.MsiInfo, new.MsiInfo)...)
+}
+
+// fieldChanges compares the string fields of two structs of the same type,
+// either of which may be nil.
+func fieldChanges[T packager.MsiInfo | installer.VersionInfo](prefix string, old, new *T) []FieldChange {
+	if old == nil && new == nil {
+		return nil
+	}
+
+	var zero T
+	if old == nil {
+		old = &zero
+	}
+	if new == nil {
+		new = &zero
+	}
+
+	var changes []FieldChange
+
+	o, n := reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem()
+	for i := 0; i < o.NumField(); i++ {
+		if o.Field(i).Kind() != reflect.String {
+			continue
+		}
+
+		if ov, nv := o.Field(i).String(), n.Field(i).String(); ov != nv {
+			changes = append(changes, FieldChange{Field: prefix + o.Type().Field(i).Name, Old: ov, New: nv})
+		}
+	}
+
+	return changes
+}
+
+// Write writes the diff as text or JSON.
+func Write(w io.Writer, d *Diff, format string) error {
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(d)
+	case "", FormatText:
+		return writeText(w, d)
+	default:
+		return errors.Errorf("unknown diff format %q, expected %s or %s", format, FormatText, FormatJSON)
+	}
+}
+
+func writeText(w io.Writer, d *Diff) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	if len(d.Metadata) > 0 {
+		_, _ = fmt.Fprintln(tw, "Detection.xml:")
+		for _, c := range d.Metadata {
+			_, _ = fmt.Fprintf(tw, "  %s\t%s -> %s\n", c.Field, quote(c.Old), quote(c.New))
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+
+	if len(d.Files) > 0 {
+		_, _ = fmt.Fprintln(tw, "Files:")
+		for _, f := range d.Files {
+			switch f.Change {
+			case Added:
+				_, _ = fmt.Fprintf(tw, "+ %s\t%d\t%s\n", f.Name, f.NewSize, f.NewSHA256)
+			case Removed:
+				_, _ = fmt.Fprintf(tw, "- %s\t%d\t%s\n", f.Name, f.OldSize, f.OldSHA256)
+			case Modified:
+				_, _ = fmt.Fprintf(tw, "~ %s\t%d -> %d\t%s -> %s\n", f.Name, f.OldSize, f.NewSize, f.OldSHA256, f.NewSHA256)
+			}
+
+			for _, c := range f.Version {
+				_, _ = fmt.Fprintf(tw, "    %s\t%s -> %s\n", c.Field, quote(c.Old), quote(c.New))
+			}
+		}
+		_, _ = fmt.Fprintln(tw)
+	}
+
+	_, _ = fmt.Fprintf(tw, "%d added, %d removed, %d modified, %d unchanged\n", d.Count(Added), d.Count(Removed), d.Count(Modified), d.Unchanged)
+
+	return tw.Flush()
+}
+
+func quote(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+
+	return s
+}
@@ -0,0 +1,139 @@
+package diff
+
+import (
+	"bytes"
+	"content-prep/pkg/packager"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestDiffTestSuite(t *testing.T) {
+	suite.Run(t, new(DiffTestSuite))
+}
+
+type DiffTestSuite struct {
+	suite.Suite
+}
+
+// build creates a package of the source and opens it.
+func (s *DiffTestSuite) build(source fstest.MapFS, setupFile string) *packager.Package {
+	var buf bytes.Buffer
+	_, err := packager.Default.CreatePackage(context.Background(), source, setupFile, &buf)
+	s.Require().NoError(err)
+
+	pkg, err := packager.Default.OpenPackage(context.Background(), bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { _ = pkg.Close() })
+
+	return pkg
+}
+
+func (s *DiffTestSuite) TestPackages() {
+	msi, err := os.ReadFile(filepath.Join("..", "installer", "testdata", "product.msi"))
+	s.Require().NoError(err)
+
+	old := s.build(fstest.MapFS{
+		"install.cmd":   {Data: []byte("msiexec /i setup.msi")},
+		"config.ini":    {Data: []byte("[app]\nmode=1\n")},
+		"docs/old.txt":  {Data: []byte("old")},
+		"unchanged.txt": {Data: []byte("same")},
+	}, "install.cmd")
+
+	new := s.build(fstest.MapFS{
+		"install.cmd":   {Data: []byte("msiexec /i setup.msi")},
+		"config.ini":    {Data: []byte("[app]\nmode=2\nlog=1\n")},
+		"setup.msi":     {Data: msi},
+		"unchanged.txt": {Data: []byte("same")},
+	}, "setup.msi")
+
+	d, err := Packages(old, new)
+	s.Require().NoError(err)
+	s.Require().False(d.Empty())
+	s.Require().Equal(2, d.Unchanged)
+
+	s.Require().Len(d.Files, 3)
+	s.Require().Equal("config.ini", d.Files[0].Name)
+	s.Require().Equal(Modified, d.Files[0].Change)
+	s.Require().Equal(int64(13), d.Files[0].OldSize)
+	s.Require().Equal(int64(19), d.Files[0].NewSize)
+	s.Require().NotEqual(d.Files[0].OldSHA256, d.Files[0].NewSHA256)
+
+	s.Require().Equal("docs/old.txt", d.Files[1].Name)
+	s.Require().Equal(Removed, d.Files[1].Change)
+	s.Require().Empty(d.Files[1].NewSHA256)
+
+	s.Require().Equal("setup.msi", d.Files[2].Name)
+	s.Require().Equal(Added, d.Files[2].Change)
+	s.Require().Equal(int64(len(msi)), d.Files[2].NewSize)
+	s.Require().NotEmpty(d.Files[2].Version)
+	for _, c := range d.Files[2].Version {
+		s.Require().Empty(c.Old)
+	}
+
+	fields := map[string]FieldChange{}
+	for _, c := range d.Metadata {
+		fields[c.Field] = c
+	}
+	s.Require().Equal(FieldChange{Field: "SetupFile", Old: "install.cmd", New: "setup.msi"}, fields["SetupFile"])
+	s.Require().Contains(fields, "EncryptionInfo.FileDigest")
+	s.Require().Contains(fields, "UnencryptedContentSize")
+
+	same, err := Packages(old, old)
+	s.Require().NoError(err)
+	s.Require().True(same.Empty())
+	s.Require().Equal(4, same.Unchanged)
+}
+
+func (s *DiffTestSuite) TestMetadata() {
+	old := &packager.ApplicationInfo{Name: "app", SetupFile: "setup.msi", MsiInfo: &packager.MsiInfo{MsiProductVersion: "1.0", MsiPublisher: "Contoso"}}
+	old.EncryptionInfo.EncryptionKey = []byte("old key")
+
+	new := &packager.ApplicationInfo{Name: "app", SetupFile: "setup.msi", MsiInfo: &packager.MsiInfo{MsiProductVersion: "1.1", MsiPublisher: "Contoso"}}
+	new.EncryptionInfo.EncryptionKey = []byte("new key")
+
+	s.Require().Equal([]FieldChange{{Field: "MsiInfo.MsiProductVersion", Old: "1.0", New: "1.1"}}, Metadata(old, new))
+
+	new.MsiInfo = nil
+	s.Require().Len(Metadata(old, new), 2)
+}
+
+func (s *DiffTestSuite) TestWrite() {
+	d := &Diff{
+		Metadata: []FieldChange{{Field: "MsiInfo.MsiProductVersion", Old: "1.0", New: "1.1"}},
+		Files: []FileChange{
+			{Name: "a.txt", Change: Added, NewSize: 1, NewSHA256: "aa"},
+			{Name: "b.txt", Change: Removed, OldSize: 2, OldSHA256: "bb"},
+			{Name: "c.exe", Change: Modified, OldSize: 3, NewSize: 4, OldSHA256: "cc", NewSHA256: "dd", Version: []FieldChange{{Field: "FileVersion", New: "2.0"}}},
+		},
+		Unchanged: 5,
+	}
+
+	var text bytes.Buffer
+	s.Require().NoError(Write(&text, d, FormatText))
+	s.Require().Equal(`Detection.xml:
+  MsiInfo.MsiProductVersion  1.0 -> 1.1
+
+Files:
++ a.txt          1       aa
+- b.txt          2       bb
+~ c.exe          3 -> 4  cc -> dd
+    FileVersion  (none) -> 2.0
+
+1 added, 1 removed, 1 modified, 5 unchanged
+`, text.String())
+
+	var data bytes.Buffer
+	s.Require().NoError(Write(&data, d, FormatJSON))
+
+	var decoded Diff
+	s.Require().NoError(json.Unmarshal(data.Bytes(), &decoded))
+	s.Require().Equal(*d, decoded)
+
+	s.Require().Error(Write(&data, d, "yaml"))
+}
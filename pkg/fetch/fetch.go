@@ -0,0 +1,432 @@
+// Package fetch downloads the installers listed in a sources file into a
+// content-addressed cache and mounts them as the source of a package.
+package fetch
+
+import (
+	"bytes"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/source"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalid is returned for sources files that cannot be fetched.
+var ErrInvalid = errors.New("invalid sources")
+
+// ErrChecksum is returned when a download does not match its pinned SHA256.
+var ErrChecksum = errors.New("download checksum mismatch")
+
+// ErrTooLarge is returned when a download exceeds the maximum size.
+var ErrTooLarge = errors.New("download too large")
+
+// errStalled cancels a download that received no data for the idle timeout.
+var errStalled = errors.New("download stalled")
+
+const (
+	partialFileSuffix = ".partial"
+
+	defaultRetries     = 3
+	defaultBackoff     = time.Second
+	defaultIdleTimeout = time.Minute
+
+	// defaultMaxSize is the largest Win32 app Intune accepts.
+	defaultMaxSize = 30 << 30
+)
+
+// Sources is a sources file: the files making up the source of a package.
+type Sources struct {
+	// SetupFile is the name of the setup file, defaulting to the only file.
+	SetupFile string `yaml:"setupFile,omitempty"`
+
+	Files []File `yaml:"files"`
+}
+
+// File is a file downloaded from URL and pinned to its SHA256.
+type File struct {
+	URL    string `yaml:"url"`
+	SHA256 string `yaml:"sha256"`
+
+	// Name is the path of the file in the source, defaulting to the last
+	// segment of the URL path.
+	Name string `yaml:"name,omitempty"`
+}
+
+// Load reads a sources file.
+func Load(sourcesFilePath string) (*Sources, error) {
+	data, err := os.ReadFile(sourcesFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read sources")
+	}
+
+	s, err := Parse(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid sources %s", sourcesFilePath)
+	}
+
+	return s, nil
+}
+
+// Parse decodes a YAML sources file and validates it.
+func Parse(data []byte) (*Sources, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var s Sources
+	if err := dec.Decode(&s); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode sources")
+	}
+
+	if len(s.Files) == 0 {
+		return nil, errors.Wrap(ErrInvalid, "no files")
+	}
+
+	names := map[string]bool{}
+	for i := range s.Files {
+		f := &s.Files[i]
+
+		u, err := url.Parse(f.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, errors.Wrapf(ErrInvalid, "file %d has no http or https URL", i+1)
+		}
+
+		digest, err := hex.DecodeString(f.SHA256)
+		if err != nil || len(digest) != sha256.Size {
+			return nil, errors.Wrapf(ErrInvalid, "%s has no valid SHA256", f.URL)
+		}
+		f.SHA256 = hex.EncodeToString(digest)
+
+		if f.Name == "" {
+			f.Name = path.Base(u.Path)
+		}
+		if !fs.ValidPath(f.Name) || f.Name == "." {
+			return nil, errors.Wrapf(ErrInvalid, "%s has no valid name, set one", f.URL)
+		}
+
+		if names[f.Name] {
+			return nil, errors.Wrapf(ErrInvalid, "%s is listed twice", f.Name)
+		}
+		names[f.Name] = true
+	}
+
+	if s.SetupFile == "" && len(s.Files) == 1 {
+		s.SetupFile = s.Files[0].Name
+	}
+	if s.SetupFile != "" && !names[s.SetupFile] {
+		return nil, errors.Wrapf(ErrInvalid, "setup file %s is not listed", s.SetupFile)
+	}
+
+	return &s, nil
+}
+
+// Fetcher downloads files into a cache directory, where they are stored under
+// their SHA256. Interrupted downloads are resumed. Fetchers of several
+// processes can share the cache directory.
+type Fetcher struct {
+	cacheDir    string
+	client      *http.Client
+	retries     int
+	backoff     time.Duration
+	idleTimeout time.Duration
+	maxSize     int64
+}
+
+type Option func(*Fetcher)
+
+// WithHTTPClient sets the client used for downloads.
+func WithHTTPClient(client *http.Client) Option {
+	return func(f *Fetcher) {
+		f.client = client
+	}
+}
+
+// WithRetries sets how often a failed download is retried.
+func WithRetries(retries int) Option {
+	return func(f *Fetcher) {
+		f.retries = retries
+	}
+}
+
+// WithBackoff sets the delay before the first retry, which doubles with every
+// further retry.
+func WithBackoff(backoff time.Duration) Option {
+	return func(f *Fetcher) {
+		f.backoff = backoff
+	}
+}
+
+// WithIdleTimeout sets how long a download may wait for a response or for
+// more data before it fails and is retried.
+func WithIdleTimeout(idleTimeout time.Duration) Option {
+	return func(f *Fetcher) {
+		f.idleTimeout = idleTimeout
+	}
+}
+
+// WithMaxSize sets the size in bytes a download may not exceed.
+func WithMaxSize(maxSize int64) Option {
+	return func(f *Fetcher) {
+		f.maxSize = maxSize
+	}
+}
+
+// New creates a fetcher caching downloads in cacheDir. Downloads fail when no
+// data arrives for a minute or when they exceed 30 GiB.
+func New(cacheDir string, opts ...Option) *Fetcher {
+	f := &Fetcher{
+		cacheDir:    cacheDir,
+		client:      http.DefaultClient,
+		retries:     defaultRetries,
+		backoff:     defaultBackoff,
+		idleTimeout: defaultIdleTimeout,
+		maxSize:     defaultMaxSize,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// FS fetches all files and mounts them under their names.
+func (f *Fetcher) FS(ctx context.Context, sources *Sources) (*source.FileFS, error) {
+	files := make(map[string]string, len(sources.Files))
+	for _, file := range sources.Files {
+		cacheFilePath, err := f.Fetch(ctx, file)
+		if err != nil {
+			return nil, err
+		}
+		files[file.Name] = cacheFilePath
+	}
+
+	return source.NewFileFS(files)
+}
+
+// Fetch returns the path of the file in the cache, downloading it if it is not
+// cached yet.
+func (f *Fetcher) Fetch(ctx context.Context, file File) (string, error) {
+	log := logger.FromContext(ctx).With("component", "fetch", "action", "fetch")
+
+	dir := filepath.Join(f.cacheDir, "sha256")
+	if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return "", errors.Wrapf(err, "failed to create cache directory")
+	}
+	cacheFilePath := filepath.Join(dir, file.SHA256)
+
+	if err := checkFile(cacheFilePath, file.SHA256); err == nil {
+		log.Debug("using cached file", "name", file.Name, "file", cacheFilePath)
+		return cacheFilePath, nil
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		log.Warn("discarding cached file", "name", file.Name, "file", cacheFilePath, "error", err)
+		if err := os.Remove(cacheFilePath); err != nil {
+			return "", errors.Wrapf(err, "failed to discard cached file")
+		}
+	}
+
+	partialFilePath := cacheFilePath + partialFileSuffix
+	downloadFilePath, err := claimDownload(partialFilePath)
+	if err != nil {
+		return "", err
+	}
+
+	backoff := f.backoff
+	for attempt := 0; ; attempt++ {
+		err := f.download(ctx, file.URL, downloadFilePath)
+		if err == nil {
+			break
+		}
+
+		var permanent *permanentError
+		if errors.As(err, &permanent) || errors.Is(err, ErrTooLarge) || attempt >= f.retries || ctx.Err() != nil {
+			// Leave the download to be resumed by the next fetch.
+			_ = os.Rename(downloadFilePath, partialFilePath)
+			return "", errors.Wrapf(err, "failed to download %s", file.URL)
+		}
+
+		log.Warn("download failed, retrying", "url", file.URL, "attempt", attempt+1, "backoff", backoff, "error", err)
+		select {
+		case <-ctx.Done():
+			_ = os.Rename(downloadFilePath, partialFilePath)
+			return "", ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	if err := checkFile(downloadFilePath, file.SHA256); err != nil {
+		_ = os.Remove(downloadFilePath)
+		return "", errors.Wrapf(err, "failed to verify %s", file.URL)
+	}
+
+	if err := os.Rename(downloadFilePath, cacheFilePath); err != nil {
+		_ = os.Remove(downloadFilePath)
+		return "", errors.Wrapf(err, "failed to store download")
+	}
+	log.Info("downloaded file", "name", file.Name, "url", file.URL, "file", cacheFilePath)
+
+	return cacheFilePath, nil
+}
+
+// claimDownload returns a file of its own to download to, taking over the
+// interrupted download at partialFilePath if there is one. Renaming is atomic,
+// so of concurrent fetches of the same file only one resumes it and the others
+// start over, instead of writing to the same file.
+func claimDownload(partialFilePath string) (string, error) {
+	f, err := os.CreateTemp(filepath.Dir(partialFilePath), filepath.Base(partialFilePath)+".*")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create download")
+	}
+	downloadFilePath := f.Name()
+	if err := f.Close(); err != nil {
+		return "", errors.Wrapf(err, "failed to create download")
+	}
+
+	if err := os.Rename(partialFilePath, downloadFilePath); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		_ = os.Remove(downloadFilePath)
+		return "", errors.Wrapf(err, "failed to resume download")
+	}
+
+	return downloadFilePath, nil
+}
+
+// permanentError is a download error that retrying would not fix.
+type permanentError struct {
+	status string
+}
+
+func (e *permanentError) Error() string {
+	return "unexpected status " + e.status
+}
+
+// download appends the rest of the file to what was downloaded before.
+func (f *Fetcher) download(ctx context.Context, fileURL string, partialFilePath string) (err error) {
+	partialFile, err := os.OpenFile(partialFilePath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open download")
+	}
+	defer partialFile.Close()
+
+	offset, err := partialFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open download")
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	idle := time.AfterFunc(f.idleTimeout, func() { cancel(errStalled) })
+	defer idle.Stop()
+	defer func() {
+		if err != nil && errors.Is(context.Cause(ctx), errStalled) {
+			err = errors.Wrapf(errStalled, "no data for %s", f.idleTimeout)
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, fileURL, nil)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create request")
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusPartialContent && offset > 0:
+		if contentRange := resp.Header.Get("Content-Range"); !strings.HasPrefix(contentRange, fmt.Sprintf("bytes %d-", offset)) {
+			// Resuming elsewhere would corrupt the download, start over.
+			if err := partialFile.Truncate(0); err != nil {
+				return errors.Wrapf(err, "failed to reset download")
+			}
+
+			return errors.Errorf("unexpected content range %q", contentRange)
+		}
+	case resp.StatusCode == http.StatusOK:
+		// The server ignored the range, start over.
+		if err := partialFile.Truncate(0); err != nil {
+			return errors.Wrapf(err, "failed to reset download")
+		}
+		if _, err := partialFile.Seek(0, io.SeekStart); err != nil {
+			return errors.Wrapf(err, "failed to reset download")
+		}
+		offset = 0
+	case resp.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// The download is complete or longer than the file, checking its
+		// digest tells.
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError:
+		return errors.Errorf("unexpected status %s", resp.Status)
+	default:
+		return &permanentError{status: resp.Status}
+	}
+
+	remaining := f.maxSize - offset
+	if resp.ContentLength > remaining {
+		_ = partialFile.Truncate(0)
+		return errors.Wrapf(ErrTooLarge, "%d bytes exceed %d bytes", offset+resp.ContentLength, f.maxSize)
+	}
+
+	n, err := io.Copy(partialFile, io.LimitReader(&idleReader{r: resp.Body, timer: idle, timeout: f.idleTimeout}, remaining+1))
+	if err != nil {
+		return errors.Wrapf(err, "failed to read response")
+	}
+	if n > remaining {
+		_ = partialFile.Truncate(0)
+		return errors.Wrapf(ErrTooLarge, "more than %d bytes", f.maxSize)
+	}
+
+	return partialFile.Close()
+}
+
+// idleReader restarts the idle timer whenever data arrives.
+type idleReader struct {
+	r       io.Reader
+	timer   *time.Timer
+	timeout time.Duration
+}
+
+func (r *idleReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.timer.Reset(r.timeout)
+	}
+
+	return n, err
+}
+
+// checkFile verifies the SHA256 of a file.
+func checkFile(filePath string, sha256Hex string) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return errors.Wrapf(err, "failed to read %s", filePath)
+	}
+
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != sha256Hex {
+		return errors.Wrapf(ErrChecksum, "got SHA256 %s, expected %s", digest, sha256Hex)
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestFetchTestSuite(t *testing.T) {
+	suite.Run(t, new(FetchTestSuite))
+}
+
+type FetchTestSuite struct {
+	suite.Suite
+
+	cacheDir string
+	content  []byte
+	digest   string
+	server   *httptest.Server
+
+	mu       sync.Mutex
+	requests []string
+	failures []int
+}
+
+const (
+	// failAbort fails a request by cutting the connection after half of the
+	// body.
+	failAbort = -1
+	// failStall fails a request by sending half of the body and then nothing
+	// until the client gives up.
+	failStall = -2
+)
+
+func (s *FetchTestSuite) SetupTest() {
+	s.cacheDir = s.T().TempDir()
+	s.content = []byte(strings.Repeat("installer content ", 1024))
+	sum := sha256.Sum256(s.content)
+	s.digest = hex.EncodeToString(sum[:])
+	s.requests, s.failures = nil, nil
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests = append(s.requests, r.URL.Path+" "+r.Header.Get("Range"))
+		var failure int
+		if len(s.failures) > 0 {
+			failure, s.failures = s.failures[0], s.failures[1:]
+		}
+		s.mu.Unlock()
+
+		switch {
+		case r.URL.Path == "/missing.msi":
+			http.NotFound(w, r)
+		case failure == failAbort:
+			w.Header().Set("Content-Length", fmt.Sprint(len(s.content)))
+			_, _ = w.Write(s.content[:len(s.content)/2])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		case failure == failStall:
+			w.Header().Set("Content-Length", fmt.Sprint(len(s.content)))
+			_, _ = w.Write(s.content[:len(s.content)/2])
+			w.(http.Flusher).Flush()
+			<-r.Context().Done()
+		case failure != 0:
+			w.WriteHeader(failure)
+		default:
+			http.ServeContent(w, r, "setup.msi", time.Time{}, strings.NewReader(string(s.content)))
+		}
+	}))
+	s.T().Cleanup(s.server.Close)
+}
+
+func (s *FetchTestSuite) fetcher() *Fetcher {
+	return New(s.cacheDir, WithHTTPClient(s.server.Client()), WithBackoff(time.Millisecond))
+}
+
+func (s *FetchTestSuite) sources(name string) *Sources {
+	sources, err := Parse([]byte(fmt.Sprintf("files:\n  - url: %s/app/setup.msi\n    sha256: %s\n    name: %s\n  - url: %s/app/readme.txt\n    sha256: %s\n", s.server.URL, strings.ToUpper(s.digest), name, s.server.URL, s.digest)))
+	s.Require().NoError(err)
+
+	return sources
+}
+
+func (s *FetchTestSuite) TestFS() {
+	sources := s.sources("bin/setup.msi")
+	s.Require().Empty(sources.SetupFile)
+
+	fsys, err := s.fetcher().FS(context.Background(), sources)
+	s.Require().NoError(err)
+	s.Require().NoError(fstest.TestFS(fsys, "bin/setup.msi", "readme.txt"))
+
+	data, err := fs.ReadFile(fsys, "bin/setup.msi")
+	s.Require().NoError(err)
+	s.Require().Equal(s.content, data)
+	data, err = fs.ReadFile(fsys, "readme.txt")
+	s.Require().NoError(err)
+	s.Require().Equal(s.content, data)
+
+	// Both files have the same content, so the second one is a cache hit.
+	s.Require().Equal([]string{"/app/setup.msi "}, s.requests)
+
+	_, err = s.fetcher().FS(context.Background(), sources)
+	s.Require().NoError(err)
+	s.Require().Len(s.requests, 1)
+
+	entries, err := os.ReadDir(filepath.Join(s.cacheDir, "sha256"))
+	s.Require().NoError(err)
+	s.Require().Len(entries, 1)
+	s.Require().Equal(s.digest, entries[0].Name())
+}
+
+func (s *FetchTestSuite) TestResume() {
+	dir := filepath.Join(s.cacheDir, "sha256")
+	s.Require().NoError(os.MkdirAll(dir, 0755))
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, s.digest+partialFileSuffix), s.content[:100], 0644))
+
+	cacheFilePath, err := s.fetcher().Fetch(context.Background(), s.sources("setup.msi").Files[0])
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"/app/setup.msi bytes=100-"}, s.requests)
+
+	data, err := os.ReadFile(cacheFilePath)
+	s.Require().NoError(err)
+	s.Require().Equal(s.content, data)
+}
+
+func (s *FetchTestSuite) TestRetry() {
+	s.failures = []int{http.StatusServiceUnavailable, failAbort}
+
+	cacheFilePath, err := s.fetcher().Fetch(context.Background(), s.sources("setup.msi").Files[0])
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"/app/setup.msi ", "/app/setup.msi ", fmt.Sprintf("/app/setup.msi bytes=%d-", len(s.content)/2)}, s.requests)
+
+	data, err := os.ReadFile(cacheFilePath)
+	s.Require().NoError(err)
+	s.Require().Equal(s.content, data)
+
+	s.requests = nil
+	s.failures = []int{http.StatusBadGateway, http.StatusBadGateway}
+	file := s.sources("setup.msi").Files[0]
+	file.SHA256 = strings.Repeat("0", 64)
+	_, err = New(s.cacheDir, WithHTTPClient(s.server.Client()), WithBackoff(time.Millisecond), WithRetries(1)).Fetch(context.Background(), file)
+	s.Require().ErrorContains(err, "502")
+	s.Require().Len(s.requests, 2)
+}
+
+func (s *FetchTestSuite) TestConcurrent() {
+	dir := filepath.Join(s.cacheDir, "sha256")
+	s.Require().NoError(os.MkdirAll(dir, 0755))
+	s.Require().NoError(os.WriteFile(filepath.Join(dir, s.digest+partialFileSuffix), s.content[:100], 0644))
+
+	// Both requests are answered only once both are in flight.
+	var requests sync.WaitGroup
+	requests.Add(2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Done()
+		requests.Wait()
+		s.server.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	file := File{URL: server.URL + "/app/setup.msi", SHA256: s.digest, Name: "setup.msi"}
+	errs := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := New(s.cacheDir, WithHTTPClient(server.Client()), WithBackoff(time.Millisecond)).Fetch(context.Background(), file)
+			errs <- err
+		}()
+	}
+	s.Require().NoError(<-errs)
+	s.Require().NoError(<-errs)
+
+	// One fetch resumed the interrupted download, the other started over.
+	s.Require().ElementsMatch([]string{"/app/setup.msi bytes=100-", "/app/setup.msi "}, s.requests)
+
+	data, err := os.ReadFile(filepath.Join(dir, s.digest))
+	s.Require().NoError(err)
+	s.Require().Equal(s.content, data)
+
+	entries, err := os.ReadDir(dir)
+	s.Require().NoError(err)
+	s.Require().Len(entries, 1)
+}
+
+func (s *FetchTestSuite) TestIdleTimeout() {
+	s.failures = []int{failStall}
+
+	cacheFilePath, err := New(s.cacheDir, WithHTTPClient(s.server.Client()), WithBackoff(time.Millisecond), WithIdleTimeout(100*time.Millisecond)).Fetch(context.Background(), s.sources("setup.msi").Files[0])
+	s.Require().NoError(err)
+	s.Require().Equal([]string{"/app/setup.msi ", fmt.Sprintf("/app/setup.msi bytes=%d-", len(s.content)/2)}, s.requests)
+
+	data, err := os.ReadFile(cacheFilePath)
+	s.Require().NoError(err)
+	s.Require().Equal(s.content, data)
+
+	s.requests = nil
+	s.failures = []int{failStall}
+	file := s.sources("setup.msi").Files[0]
+	file.SHA256 = strings.Repeat("0", 64)
+	_, err = New(s.cacheDir, WithHTTPClient(s.server.Client()), WithIdleTimeout(100*time.Millisecond), WithRetries(0)).Fetch(context.Background(), file)
+	s.Require().True(errors.Is(err, errStalled))
+}
+
+func (s *FetchTestSuite) TestMaxSize() {
+	file := s.sources("setup.msi").Files[0]
+	fetcher := New(s.cacheDir, WithHTTPClient(s.server.Client()), WithBackoff(time.Millisecond), WithMaxSize(int64(len(s.content)-1)))
+
+	_, err := fetcher.Fetch(context.Background(), file)
+	s.Require().True(errors.Is(err, ErrTooLarge))
+	s.Require().Len(s.requests, 1)
+
+	// Without a Content-Length the limit is enforced while reading.
+	chunked := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.(http.Flusher).Flush()
+		_, _ = w.Write(s.content)
+	}))
+	defer chunked.Close()
+	_, err = New(s.cacheDir, WithHTTPClient(chunked.Client()), WithMaxSize(int64(len(s.content)/4))).Fetch(context.Background(), File{URL: chunked.URL + "/setup.msi", SHA256: s.digest, Name: "setup.msi"})
+	s.Require().True(errors.Is(err, ErrTooLarge))
+	s.Require().ErrorContains(err, "more than")
+
+	info, err := os.Stat(filepath.Join(s.cacheDir, "sha256", s.digest+partialFileSuffix))
+	s.Require().NoError(err)
+	s.Require().Zero(info.Size())
+
+	_, err = New(s.cacheDir, WithHTTPClient(s.server.Client()), WithMaxSize(int64(len(s.content)))).Fetch(context.Background(), file)
+	s.Require().NoError(err)
+}
+
+func (s *FetchTestSuite) TestChecksum() {
+	file := s.sources("setup.msi").Files[0]
+	file.SHA256 = strings.Repeat("0", 64)
+
+	_, err := s.fetcher().Fetch(context.Background(), file)
+	s.Require().True(errors.Is(err, ErrChecksum))
+
+	entries, err := os.ReadDir(filepath.Join(s.cacheDir, "sha256"))
+	s.Require().NoError(err)
+	s.Require().Empty(entries)
+
+	// A corrupted cache entry is downloaded again.
+	cacheFilePath, err := s.fetcher().Fetch(context.Background(), s.sources("setup.msi").Files[0])
+	s.Require().NoError(err)
+	s.Require().NoError(os.WriteFile(cacheFilePath, []byte("corrupted"), 0644))
+
+	_, err = s.fetcher().Fetch(context.Background(), s.sources("setup.msi").Files[0])
+	s.Require().NoError(err)
+	s.Require().Len(s.requests, 3)
+
+	_, err = s.fetcher().Fetch(context.Background(), File{URL: s.server.URL + "/missing.msi", SHA256: strings.Repeat("0", 64), Name: "missing.msi"})
+	s.Require().ErrorContains(err, "404")
+	s.Require().Len(s.requests, 4)
+}
+
+func (s *FetchTestSuite) TestParse() {
+	sources, err := Parse([]byte("files:\n  - url: https://example.com/dl/setup.exe?x=1\n    sha256: " + s.digest + "\n"))
+	s.Require().NoError(err)
+	s.Require().Equal("setup.exe", sources.SetupFile)
+
+	for data, message := range map[string]string{
+		"files: []\n": "no files",
+		"files:\n  - url: ftp://example.com/a\n    sha256: " + s.digest + "\n":                                                                 "file 1 has no http or https URL",
+		"files:\n  - url: https://example.com/a\n    sha256: abc\n":                                                                            "has no valid SHA256",
+		"files:\n  - url: https://example.com/\n    sha256: " + s.digest + "\n":                                                                "has no valid name",
+		"files:\n  - url: https://example.com/a\n    sha256: " + s.digest + "\n  - url: https://example.org/a\n    sha256: " + s.digest + "\n": "a is listed twice",
+		"setupFile: b\nfiles:\n  - url: https://example.com/a\n    sha256: " + s.digest + "\n":                                                 "setup file b is not listed",
+		"files:\n  - url: https://example.com/a\n    sha256: " + s.digest + "\n    typo: true\n":                                               "field typo not found",
+	} {
+		_, err := Parse([]byte(data))
+		s.Require().ErrorContains(err, message, data)
+	}
+}
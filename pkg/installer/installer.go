@@ -0,0 +1,241 @@
+// Package installer detects the type of a setup file and derives the
+// type-specific install and uninstall commands.
+package installer
+
+import (
+	"bytes"
+	"content-prep/pkg/cfb"
+	"content-prep/pkg/source"
+	"encoding/binary"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// FileSuffix is appended to the package name for the installer info written
+// next to the package.
+const FileSuffix = ".installer.json"
+
+// Type is the kind of installer a setup file is.
+type Type string
+
+const (
+	TypeMSI        Type = "msi"
+	TypeEXE        Type = "exe"
+	TypePowerShell Type = "powershell"
+	TypeScript     Type = "script"
+	TypeMSIX       Type = "msix"
+	TypeUnknown    Type = "unknown"
+)
+
+// ErrInvalid is returned when a setup file does not match its installer type.
+var ErrInvalid = errors.New("invalid setup file")
+
+// msiCLSID is the CLSID of the root storage of MSI databases.
+var msiCLSID = [16]byte{0x84, 0x10, 0x0C, 0x00, 0x00, 0x00, 0x00, 0x00, 0xC0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x46}
+
+// Info describes the installer of a package.
+type Info struct {
+	Type                 Type   `json:"type"`
+	SetupFile            string `json:"setupFile"`
+	InstallCommandLine   string `json:"installCommandLine,omitempty"`
+	UninstallCommandLine string `json:"uninstallCommandLine,omitempty"`
+
+	// Msix is the package identity of MSIX and APPX setup files.
+	Msix *MsixInfo `json:"msix,omitempty"`
+}
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Detect determines the installer type of the setup file from its extension
+// and content and validates the file against that type.
+func Detect(fsys fs.FS, setupFile string) (*Info, error) {
+	f, err := source.OpenFile(fsys, setupFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open setup file")
+	}
+	defer f.Close()
+
+	r, size := f.ReaderAt, f.Size
+
+	magic := make([]byte, 8)
+	n, _ := r.ReadAt(magic, 0)
+	magic = magic[:n]
+
+	info := &Info{Type: typeOf(setupFile, magic), SetupFile: setupFile}
+
+	switch info.Type {
+	case TypeMSI:
+		err = validateMSI(r, size)
+	case TypeEXE:
+		err = validateEXE(r, size)
+	case TypePowerShell:
+		err = validatePowerShell(r, size)
+	case TypeScript:
+		err = validateScript(r, size)
+	case TypeMSIX:
+		info.Msix, err = ReadMsix(r, size, IsBundle(setupFile))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s is not a valid %s installer", setupFile, info.Type)
+	}
+
+	info.InstallCommandLine, info.UninstallCommandLine = CommandLines(info)
+
+	return info, nil
+}
+
+// typeOf maps the extension to a type, falling back to the magic number for
+// unknown extensions.
+func typeOf(name string, magic []byte) Type {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".msi":
+		return TypeMSI
+	case ".exe":
+		return TypeEXE
+	case ".ps1":
+		return TypePowerShell
+	case ".cmd", ".bat":
+		return TypeScript
+	case ".msix", ".appx", ".msixbundle", ".appxbundle":
+		return TypeMSIX
+	}
+
+	switch {
+	case bytes.HasPrefix(magic, cfb.Signature):
+		return TypeMSI
+	case bytes.HasPrefix(magic, []byte("MZ")):
+		return TypeEXE
+	default:
+		return TypeUnknown
+	}
+}
+
+// IsBundle reports whether the setup file is an MSIX or APPX bundle.
+func IsBundle(name string) bool {
+	ext := strings.ToLower(path.Ext(name))
+
+	return ext == ".msixbundle" || ext == ".appxbundle"
+}
+
+func validateMSI(r io.ReaderAt, size int64) error {
+	f, err := cfb.Open(r, size)
+	if err != nil {
+		return errors.Wrap(ErrInvalid, err.Error())
+	}
+
+	if f.Root().CLSID != msiCLSID {
+		return errors.Wrap(ErrInvalid, "compound file is not an MSI database")
+	}
+
+	return nil
+}
+
+func validateEXE(r io.ReaderAt, size int64) error {
+	header := make([]byte, 64)
+	if _, err := r.ReadAt(header, 0); err != nil || !bytes.HasPrefix(header, []byte("MZ")) {
+		return errors.Wrap(ErrInvalid, "missing MZ header")
+	}
+
+	offset := int64(binary.LittleEndian.Uint32(header[0x3C:]))
+	signature := make([]byte, 4)
+	if offset+4 > size {
+		return errors.Wrap(ErrInvalid, "PE header out of range")
+	}
+
+	if _, err := r.ReadAt(signature, offset); err != nil || !bytes.Equal(signature, []byte("PE\x00\x00")) {
+		return errors.Wrap(ErrInvalid, "missing PE signature")
+	}
+
+	return nil
+}
+
+func readText(r io.ReaderAt, size int64) ([]byte, error) {
+	const maxScriptSize = 16 << 20
+	if size > maxScriptSize {
+		return nil, errors.Wrapf(ErrInvalid, "script is larger than %d bytes", maxScriptSize)
+	}
+
+	data := make([]byte, size)
+	if _, err := r.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// validatePowerShell accepts UTF-8 with or without BOM and UTF-16 with BOM,
+// the encodings Windows PowerShell reads reliably.
+func validatePowerShell(r io.ReaderAt, size int64) error {
+	data, err := readText(r, size)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case bytes.HasPrefix(data, bomUTF16LE), bytes.HasPrefix(data, bomUTF16BE):
+		if len(data)%2 != 0 {
+			return errors.Wrap(ErrInvalid, "truncated UTF-16 script")
+		}
+
+		return nil
+	case !utf8.Valid(bytes.TrimPrefix(data, bomUTF8)):
+		return errors.Wrap(ErrInvalid, "script is neither UTF-8 nor has a byte order mark")
+	}
+
+	return nil
+}
+
+// validateScript rejects batch files cmd.exe cannot run, i.e. UTF-16 and
+// binary files.
+func validateScript(r io.ReaderAt, size int64) error {
+	data, err := readText(r, size)
+	if err != nil {
+		return err
+	}
+
+	if bytes.HasPrefix(data, bomUTF16LE) || bytes.HasPrefix(data, bomUTF16BE) || bytes.IndexByte(data, 0) >= 0 {
+		return errors.Wrap(ErrInvalid, "batch files must not be UTF-16 or binary")
+	}
+
+	return nil
+}
+
+// CommandLines returns the default install and uninstall commands. Intune runs
+// them from the root of the package. The uninstall command is empty when it
+// cannot be derived from the setup file alone.
+func CommandLines(info *Info) (string, string) {
+	file := strings.ReplaceAll(info.SetupFile, "/", `\`)
+
+	switch info.Type {
+	case TypeMSI:
+		return `msiexec /i "` + file + `" /qn /norestart`, `msiexec /x "` + file + `" /qn /norestart`
+	case TypeEXE:
+		return `"` + file + `"`, ""
+	case TypePowerShell:
+		return `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -File ".\` + file + `"`, ""
+	case TypeScript:
+		return `cmd.exe /c "` + file + `"`, ""
+	case TypeMSIX:
+		install := powershellCommand(`Add-AppxProvisionedPackage -Online -PackagePath '.\` + file + `' -SkipLicense`)
+		if info.Msix == nil {
+			return install, ""
+		}
+
+		return install, powershellCommand(`Get-AppxProvisionedPackage -Online | Where-Object DisplayName -eq '` + info.Msix.Name + `' | Remove-AppxProvisionedPackage -Online`)
+	default:
+		return `"` + file + `"`, ""
+	}
+}
+
+func powershellCommand(command string) string {
+	return `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command "` + command + `"`
+}
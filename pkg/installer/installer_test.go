@@ -0,0 +1,296 @@
+package installer
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestInstallerTestSuite(t *testing.T) {
+	suite.Run(t, new(InstallerTestSuite))
+}
+
+type InstallerTestSuite struct {
+	suite.Suite
+
+	msi []byte
+}
+
+func (s *InstallerTestSuite) SetupSuite() {
+	var err error
+	s.msi, err = os.ReadFile("testdata/minimal.msi")
+	s.Require().NoError(err)
+}
+
+func exeStub() []byte {
+	data := make([]byte, 128)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3C:], 64)
+	copy(data[64:], "PE\x00\x00")
+
+	return data
+}
+
+// zipWith builds an archive of name and content pairs. Nested .msix packages
+// are stored uncompressed, like the packages inside a bundle.
+func zipWith(entries ...string) []byte {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for i := 0; i+1 < len(entries); i += 2 {
+		method := zip.Deflate
+		if strings.HasSuffix(entries[i], ".msix") {
+			method = zip.Store
+		}
+
+		f, _ := w.CreateHeader(&zip.FileHeader{Name: entries[i], Method: method})
+		_, _ = f.Write([]byte(entries[i+1]))
+	}
+	_ = w.Close()
+
+	return buf.Bytes()
+}
+
+func appxManifestXML(name, arch, displayName string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<Package xmlns="http://schemas.microsoft.com/appx/manifest/foundation/windows10">
+  <Identity Name="` + name + `" Publisher="CN=Contoso" Version="1.2.3.0" ProcessorArchitecture="` + arch + `"/>
+  <Properties>
+    <DisplayName>` + displayName + `</DisplayName>
+    <PublisherDisplayName>Contoso</PublisherDisplayName>
+  </Properties>
+</Package>`
+}
+
+func appxBundleArchive() []byte {
+	manifest := `<?xml version="1.0" encoding="utf-8"?>
+<Bundle xmlns="http://schemas.microsoft.com/appx/2013/bundle" SchemaVersion="5.0">
+  <Identity Name="Contoso.App" Publisher="CN=Contoso" Version="1.2.3.0"/>
+  <Packages>
+    <Package Type="resource" FileName="App_language-de.msix"/>
+    <Package Type="application" Architecture="x64" FileName="App_x64.msix"/>
+    <Package Type="application" Architecture="arm64" FileName="App_arm64.msix"/>
+  </Packages>
+</Bundle>`
+
+	return zipWith(
+		"AppxMetadata/AppxBundleManifest.xml", manifest,
+		"App_x64.msix", string(zipWith("AppxManifest.xml", appxManifestXML("Contoso.App", "x64", "Contoso App"))),
+		"App_arm64.msix", string(zipWith("AppxManifest.xml", appxManifestXML("Contoso.App", "arm64", "Contoso App"))),
+	)
+}
+
+func (s *InstallerTestSuite) TestDetect() {
+	fsys := fstest.MapFS{
+		"setup.msi":      {Data: s.msi},
+		"bin/setup.exe":  {Data: exeStub()},
+		"install.ps1":    {Data: []byte("\xEF\xBB\xBFWrite-Host 'Grüße'")},
+		"install16.ps1":  {Data: []byte("\xFF\xFEW\x00")},
+		"install.cmd":    {Data: []byte("@echo off\r\n")},
+		"app.msix":       {Data: zipWith("AppxManifest.xml", appxManifestXML("Contoso.App", "x64", "Contoso App"))},
+		"app.msixbundle": {Data: appxBundleArchive()},
+		"renamed":        {Data: s.msi},
+		"install.vbs":    {Data: []byte("WScript.Echo 1")},
+	}
+
+	for name, expected := range map[string]Info{
+		"setup.msi":     {Type: TypeMSI, InstallCommandLine: `msiexec /i "setup.msi" /qn /norestart`, UninstallCommandLine: `msiexec /x "setup.msi" /qn /norestart`},
+		"bin/setup.exe": {Type: TypeEXE, InstallCommandLine: `"bin\setup.exe"`},
+		"install.ps1":   {Type: TypePowerShell, InstallCommandLine: `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -File ".\install.ps1"`},
+		"install16.ps1": {Type: TypePowerShell, InstallCommandLine: `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -File ".\install16.ps1"`},
+		"install.cmd":   {Type: TypeScript, InstallCommandLine: `cmd.exe /c "install.cmd"`},
+		"app.msix": {
+			Type:                 TypeMSIX,
+			InstallCommandLine:   `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command "Add-AppxProvisionedPackage -Online -PackagePath '.\app.msix' -SkipLicense"`,
+			UninstallCommandLine: `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command "Get-AppxProvisionedPackage -Online | Where-Object DisplayName -eq 'Contoso.App' | Remove-AppxProvisionedPackage -Online"`,
+			Msix:                 &MsixInfo{Name: "Contoso.App", Publisher: "CN=Contoso", Version: "1.2.3.0", ProcessorArchitecture: "x64", DisplayName: "Contoso App", PublisherDisplayName: "Contoso"},
+		},
+		"app.msixbundle": {
+			Type:                 TypeMSIX,
+			InstallCommandLine:   `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command "Add-AppxProvisionedPackage -Online -PackagePath '.\app.msixbundle' -SkipLicense"`,
+			UninstallCommandLine: `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -Command "Get-AppxProvisionedPackage -Online | Where-Object DisplayName -eq 'Contoso.App' | Remove-AppxProvisionedPackage -Online"`,
+			Msix:                 &MsixInfo{Name: "Contoso.App", Publisher: "CN=Contoso", Version: "1.2.3.0", ProcessorArchitecture: "x64,arm64", DisplayName: "Contoso App", PublisherDisplayName: "Contoso"},
+		},
+		"renamed":     {Type: TypeMSI, InstallCommandLine: `msiexec /i "renamed" /qn /norestart`, UninstallCommandLine: `msiexec /x "renamed" /qn /norestart`},
+		"install.vbs": {Type: TypeUnknown, InstallCommandLine: `"install.vbs"`},
+	} {
+		info, err := Detect(fsys, name)
+		s.Require().NoError(err, name)
+
+		expected.SetupFile = name
+		s.Require().Equal(expected, *info, name)
+	}
+}
+
+func (s *InstallerTestSuite) TestDetectInvalid() {
+	otherCompoundFile := bytes.Clone(s.msi)
+	otherCompoundFile[0x400+80] = 0x86
+
+	fsys := fstest.MapFS{
+		"setup.msi":      {Data: []byte("not an msi")},
+		"patch.msi":      {Data: otherCompoundFile},
+		"setup.exe":      {Data: []byte("MZ")},
+		"install.ps1":    {Data: []byte("Write-Host \xff\xfe\xfd")},
+		"install.cmd":    {Data: []byte("\xFF\xFE@\x00e\x00")},
+		"install.bat":    {Data: []byte("echo\x00")},
+		"app.msix":       {Data: zipWith("content.txt", "")},
+		"app.appx":       {Data: []byte("not a zip")},
+		"app.msixbundle": {Data: zipWith("AppxManifest.xml", appxManifestXML("Contoso.App", "x64", "Contoso App"))},
+		"name.msix":      {Data: zipWith("AppxManifest.xml", appxManifestXML("Contoso App'", "x64", "Contoso App"))},
+	}
+
+	for name := range fsys {
+		_, err := Detect(fsys, name)
+		s.Require().ErrorIs(err, ErrInvalid, name)
+	}
+
+	_, err := Detect(fsys, "missing.exe")
+	s.Require().Error(err)
+}
+
+func (s *InstallerTestSuite) TestParseAppxManifest() {
+	info, err := ParseAppxManifest([]byte(appxManifestXML("Contoso.App", "neutral", "ms-resource:AppName")))
+	s.Require().NoError(err)
+	s.Require().Equal("Contoso.App", info.DisplayName)
+	s.Require().Equal("neutral", info.ProcessorArchitecture)
+
+	for _, manifest := range []string{
+		"<Package>",
+		strings.Replace(appxManifestXML("Contoso.App", "x64", "App"), "1.2.3.0", "1.2", 1),
+		strings.Replace(appxManifestXML("Contoso.App", "x64", "App"), `Publisher="CN=Contoso"`, "", 1),
+	} {
+		_, err := ParseAppxManifest([]byte(manifest))
+		s.Require().ErrorIs(err, ErrInvalid, manifest)
+	}
+}
+
+// versionResourceNode encodes a structure of a version resource.
+func versionResourceNode(key string, value []byte, text bool, children ...[]byte) []byte {
+	utf16le := func(s string) []byte {
+		var b []byte
+		for _, c := range utf16.Encode([]rune(s + "\x00")) {
+			b = binary.LittleEndian.AppendUint16(b, c)
+		}
+		return b
+	}
+	pad := func(b []byte) []byte {
+		for len(b)%4 != 0 {
+			b = append(b, 0)
+		}
+		return b
+	}
+
+	valueLength := len(value)
+	typ := uint16(0)
+	if text {
+		typ = 1
+		value = utf16le(string(value))
+		valueLength = len(value) / 2
+	}
+
+	node := pad(append(make([]byte, 6), utf16le(key)...))
+	node = pad(append(node, value...))
+	for _, c := range children {
+		node = pad(append(node, c...))
+	}
+
+	binary.LittleEndian.PutUint16(node, uint16(len(node)))
+	binary.LittleEndian.PutUint16(node[2:], uint16(valueLength))
+	binary.LittleEndian.PutUint16(node[4:], typ)
+
+	return node
+}
+
+// exeWithVersion builds a 32-bit PE file with a version resource.
+func exeWithVersion() []byte {
+	fixed := make([]byte, 52)
+	binary.LittleEndian.PutUint32(fixed, 0xFEEF04BD)
+	binary.LittleEndian.PutUint32(fixed[8:], 2<<16|1)
+	binary.LittleEndian.PutUint32(fixed[12:], 4<<16|3)
+	binary.LittleEndian.PutUint32(fixed[16:], 2<<16|1)
+
+	versionInfo := versionResourceNode("VS_VERSION_INFO", fixed, false,
+		versionResourceNode("StringFileInfo", nil, true,
+			versionResourceNode("040904b0", nil, true,
+				versionResourceNode("CompanyName", []byte("Contoso Ltd"), true),
+				versionResourceNode("ProductName", []byte("Contoso App"), true),
+				versionResourceNode("ProductVersion", []byte("2.1"), true),
+			),
+		),
+	)
+
+	const sectionRVA, sectionOffset = 0x1000, 0x200
+
+	rsrc := make([]byte, 0x58)
+	binary.LittleEndian.PutUint16(rsrc[14:], 1)
+	binary.LittleEndian.PutUint32(rsrc[16:], 16)
+	binary.LittleEndian.PutUint32(rsrc[20:], 0x80000000|0x18)
+	binary.LittleEndian.PutUint16(rsrc[0x18+14:], 1)
+	binary.LittleEndian.PutUint32(rsrc[0x18+16:], 1)
+	binary.LittleEndian.PutUint32(rsrc[0x18+20:], 0x80000000|0x30)
+	binary.LittleEndian.PutUint16(rsrc[0x30+14:], 1)
+	binary.LittleEndian.PutUint32(rsrc[0x30+16:], 0x409)
+	binary.LittleEndian.PutUint32(rsrc[0x30+20:], 0x48)
+	binary.LittleEndian.PutUint32(rsrc[0x48:], sectionRVA+0x58)
+	binary.LittleEndian.PutUint32(rsrc[0x48+4:], uint32(len(versionInfo)))
+	rsrc = append(rsrc, versionInfo...)
+
+	data := make([]byte, sectionOffset)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3C:], 64)
+	copy(data[64:], "PE\x00\x00")
+	binary.LittleEndian.PutUint16(data[68:], 0x14c)
+	binary.LittleEndian.PutUint16(data[70:], 1)
+	binary.LittleEndian.PutUint16(data[84:], 224)
+	binary.LittleEndian.PutUint16(data[88:], 0x10b)
+	binary.LittleEndian.PutUint32(data[88+92:], 16)
+	binary.LittleEndian.PutUint32(data[88+96+16:], sectionRVA)
+	binary.LittleEndian.PutUint32(data[88+96+20:], uint32(len(rsrc)))
+
+	section := data[88+224:]
+	copy(section, ".rsrc")
+	binary.LittleEndian.PutUint32(section[8:], uint32(len(rsrc)))
+	binary.LittleEndian.PutUint32(section[12:], sectionRVA)
+	binary.LittleEndian.PutUint32(section[16:], uint32(len(rsrc)))
+	binary.LittleEndian.PutUint32(section[20:], sectionOffset)
+
+	return append(data, rsrc...)
+}
+
+func (s *InstallerTestSuite) TestReadVersion() {
+	product, err := os.ReadFile("testdata/product.msi")
+	s.Require().NoError(err)
+
+	info, err := ReadVersion("product.msi", bytes.NewReader(product), int64(len(product)))
+	s.Require().NoError(err)
+	s.Require().Equal(&VersionInfo{
+		ProductName:    "Contoso App",
+		ProductVersion: "1.2.3",
+		Publisher:      "Contoso Ltd",
+		ProductCode:    "{6F330B47-2577-43AD-9095-1861BA25889B}",
+		UpgradeCode:    "{E1A2B3C4-0000-4000-8000-000000000001}",
+	}, info)
+
+	exe := exeWithVersion()
+	info, err = ReadVersion("setup.exe", bytes.NewReader(exe), int64(len(exe)))
+	s.Require().NoError(err)
+	s.Require().Equal(&VersionInfo{
+		ProductName:    "Contoso App",
+		ProductVersion: "2.1",
+		FileVersion:    "2.1.4.3",
+		Publisher:      "Contoso Ltd",
+	}, info)
+
+	info, err = ReadVersion("install.cmd", strings.NewReader("@echo off"), 9)
+	s.Require().NoError(err)
+	s.Require().Nil(info)
+
+	_, err = ReadVersion("setup.msi", bytes.NewReader(s.msi), int64(len(s.msi)))
+	s.Require().ErrorIs(err, ErrInvalid)
+}
@@ -0,0 +1,204 @@
+package installer
+
+import (
+	"content-prep/pkg/cfb"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ReadMSIProperties reads the Property table of an MSI database.
+func ReadMSIProperties(r io.ReaderAt, size int64) (map[string]string, error) {
+	if err := validateMSI(r, size); err != nil {
+		return nil, err
+	}
+
+	f, err := cfb.Open(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := readStringPool(f)
+	if err != nil {
+		return nil, err
+	}
+
+	table, err := readStream(f, msiTableName("Property"))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalid, "missing Property table")
+	}
+
+	// Tables are stored by column, the Property table has two string columns.
+	row := 2 * pool.refSize
+	if len(table)%row != 0 {
+		return nil, errors.Wrap(ErrInvalid, "invalid Property table")
+	}
+	rows := len(table) / row
+
+	properties := make(map[string]string, rows)
+	for i := 0; i < rows; i++ {
+		name := pool.lookup(table[i*pool.refSize:])
+		value := pool.lookup(table[(rows+i)*pool.refSize:])
+		if name != "" {
+			properties[name] = value
+		}
+	}
+
+	return properties, nil
+}
+
+// ReadMSIVersion reads the product metadata of an MSI database.
+func ReadMSIVersion(r io.ReaderAt, size int64) (*VersionInfo, error) {
+	properties, err := ReadMSIProperties(r, size)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VersionInfo{
+		ProductName:    properties["ProductName"],
+		ProductVersion: properties["ProductVersion"],
+		Publisher:      properties["Manufacturer"],
+		ProductCode:    properties["ProductCode"],
+		UpgradeCode:    properties["UpgradeCode"],
+	}, nil
+}
+
+// ReadVersion reads the version metadata of a PE file or MSI database, nil if
+// the file is neither or carries no version metadata.
+func ReadVersion(name string, r io.ReaderAt, size int64) (*VersionInfo, error) {
+	magic := make([]byte, 8)
+	n, _ := r.ReadAt(magic, 0)
+
+	switch typeOf(name, magic[:n]) {
+	case TypeMSI:
+		return ReadMSIVersion(r, size)
+	case TypeEXE:
+		return ReadPEVersion(r, size)
+	default:
+		return nil, nil
+	}
+}
+
+type stringPool struct {
+	strings []string
+	refSize int
+}
+
+// lookup resolves the string reference at the start of b.
+func (p *stringPool) lookup(b []byte) string {
+	id := int(binary.LittleEndian.Uint16(b))
+	if p.refSize == 3 {
+		id |= int(b[2]) << 16
+	}
+
+	if id <= 0 || id > len(p.strings) {
+		return ""
+	}
+
+	return p.strings[id-1]
+}
+
+// readStringPool reads the shared strings of the database. Each entry of the
+// pool holds the length and reference count of a string in the string data.
+// Strings longer than 64 KiB take two entries.
+func readStringPool(f *cfb.File) (*stringPool, error) {
+	poolData, err := readStream(f, msiTableName("_StringPool"))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalid, "missing string pool")
+	}
+
+	data, err := readStream(f, msiTableName("_StringData"))
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalid, "missing string data")
+	}
+
+	if len(poolData) < 4 || len(poolData)%4 != 0 {
+		return nil, errors.Wrap(ErrInvalid, "invalid string pool")
+	}
+
+	pool := &stringPool{refSize: 2}
+	if binary.LittleEndian.Uint32(poolData)&0x80000000 != 0 {
+		pool.refSize = 3
+	}
+
+	entries := poolData[4:]
+	offset := 0
+	for i := 0; i+4 <= len(entries); i += 4 {
+		length := int(binary.LittleEndian.Uint16(entries[i:]))
+		refs := binary.LittleEndian.Uint16(entries[i+2:])
+
+		if length == 0 && refs != 0 {
+			if i+8 > len(entries) {
+				return nil, errors.Wrap(ErrInvalid, "truncated string pool")
+			}
+
+			length = int(binary.LittleEndian.Uint16(entries[i+6:]))<<16 | int(binary.LittleEndian.Uint16(entries[i+4:]))
+			i += 4
+		}
+
+		if offset+length > len(data) {
+			return nil, errors.Wrap(ErrInvalid, "string pool exceeds string data")
+		}
+
+		pool.strings = append(pool.strings, string(data[offset:offset+length]))
+		offset += length
+	}
+
+	return pool, nil
+}
+
+func readStream(f *cfb.File, name string) ([]byte, error) {
+	r, err := f.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return io.ReadAll(r)
+}
+
+// msiTableName encodes the name of a table stream. MSI packs two characters
+// of the names into one code point and prefixes tables with U+4840.
+func msiTableName(name string) string {
+	var b strings.Builder
+	b.WriteRune(0x4840)
+
+	in := []rune(name)
+	for i := 0; i < len(in); i++ {
+		c := msiNameChar(in[i])
+		if c < 0 {
+			b.WriteRune(in[i])
+			continue
+		}
+
+		if i+1 < len(in) {
+			if next := msiNameChar(in[i+1]); next >= 0 {
+				b.WriteRune(0x3800 + c + next<<6)
+				i++
+				continue
+			}
+		}
+
+		b.WriteRune(0x4800 + c)
+	}
+
+	return b.String()
+}
+
+func msiNameChar(c rune) rune {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0'
+	case c >= 'A' && c <= 'Z':
+		return c - 'A' + 10
+	case c >= 'a' && c <= 'z':
+		return c - 'a' + 36
+	case c == '.':
+		return 62
+	case c == '_':
+		return 63
+	default:
+		return -1
+	}
+}
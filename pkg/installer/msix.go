@@ -0,0 +1,186 @@
+package installer
+
+import (
+	"archive/zip"
+	"content-prep/pkg/zipper"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	appxManifest       = "AppxManifest.xml"
+	appxBundleManifest = "AppxMetadata/AppxBundleManifest.xml"
+
+	maxManifestSize = 4 << 20
+)
+
+var (
+	appxNamePattern    = regexp.MustCompile(`^[-.A-Za-z0-9]{3,50}$`)
+	appxVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+\.\d+$`)
+)
+
+// MsixInfo is the identity of an MSIX or APPX package or bundle.
+type MsixInfo struct {
+	Name      string `json:"name"`
+	Publisher string `json:"publisher"`
+	Version   string `json:"version"`
+	// ProcessorArchitecture lists the architectures of all application
+	// packages of a bundle, separated by commas.
+	ProcessorArchitecture string `json:"processorArchitecture,omitempty"`
+	DisplayName           string `json:"displayName,omitempty"`
+	PublisherDisplayName  string `json:"publisherDisplayName,omitempty"`
+}
+
+type appxIdentity struct {
+	Name                  string `xml:"Name,attr"`
+	Publisher             string `xml:"Publisher,attr"`
+	Version               string `xml:"Version,attr"`
+	ProcessorArchitecture string `xml:"ProcessorArchitecture,attr"`
+}
+
+type appxPackage struct {
+	Identity   appxIdentity `xml:"Identity"`
+	Properties struct {
+		DisplayName          string `xml:"DisplayName"`
+		PublisherDisplayName string `xml:"PublisherDisplayName"`
+	} `xml:"Properties"`
+}
+
+type appxBundle struct {
+	Identity appxIdentity `xml:"Identity"`
+	Packages []struct {
+		Type         string `xml:"Type,attr"`
+		Architecture string `xml:"Architecture,attr"`
+		FileName     string `xml:"FileName,attr"`
+	} `xml:"Packages>Package"`
+}
+
+// ParseAppxManifest reads the identity from an AppxManifest.xml.
+func ParseAppxManifest(data []byte) (*MsixInfo, error) {
+	var manifest appxPackage
+	if err := xml.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrapf(ErrInvalid, "failed to parse %s: %s", appxManifest, err)
+	}
+
+	info := &MsixInfo{
+		ProcessorArchitecture: manifest.Identity.ProcessorArchitecture,
+		DisplayName:           resolvedString(manifest.Properties.DisplayName),
+		PublisherDisplayName:  resolvedString(manifest.Properties.PublisherDisplayName),
+	}
+
+	return info, info.setIdentity(manifest.Identity, appxManifest)
+}
+
+// ReadMsix reads the identity of the MSIX or APPX package or bundle. The display
+// name of a bundle is taken from its first application package.
+func ReadMsix(r io.ReaderAt, size int64, bundle bool) (*MsixInfo, error) {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalid, "not a zip archive")
+	}
+
+	if !bundle {
+		data, err := zipper.ReadFile(zr, appxManifest, maxManifestSize)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalid, err.Error())
+		}
+
+		return ParseAppxManifest(data)
+	}
+
+	data, err := zipper.ReadFile(zr, appxBundleManifest, maxManifestSize)
+	if err != nil {
+		return nil, errors.Wrap(ErrInvalid, err.Error())
+	}
+
+	var manifest appxBundle
+	if err := xml.Unmarshal(data, &manifest); err != nil {
+		return nil, errors.Wrapf(ErrInvalid, "failed to parse %s: %s", appxBundleManifest, err)
+	}
+
+	info := &MsixInfo{}
+	if err := info.setIdentity(manifest.Identity, appxBundleManifest); err != nil {
+		return nil, err
+	}
+
+	var architectures []string
+	var application string
+	for _, p := range manifest.Packages {
+		if p.Type != "application" {
+			continue
+		}
+
+		if application == "" {
+			application = p.FileName
+		}
+
+		if !slices.Contains(architectures, p.Architecture) {
+			architectures = append(architectures, p.Architecture)
+		}
+	}
+
+	if application == "" {
+		return nil, errors.Wrapf(ErrInvalid, "%s lists no application package", appxBundleManifest)
+	}
+	info.ProcessorArchitecture = strings.Join(architectures, ",")
+
+	for _, f := range zr.File {
+		if f.Name != application {
+			continue
+		}
+
+		section, err := zipper.StoredSection(r, f)
+		if err != nil {
+			return nil, errors.Wrap(ErrInvalid, err.Error())
+		}
+
+		inner, err := ReadMsix(section, section.Size(), false)
+		if err != nil {
+			return nil, errors.Wrapf(err, "application package %s", application)
+		}
+
+		info.DisplayName = inner.DisplayName
+		info.PublisherDisplayName = inner.PublisherDisplayName
+
+		return info, nil
+	}
+
+	return nil, errors.Wrapf(ErrInvalid, "missing application package %s", application)
+}
+
+func (m *MsixInfo) setIdentity(identity appxIdentity, manifest string) error {
+	if !appxNamePattern.MatchString(identity.Name) {
+		return errors.Wrapf(ErrInvalid, "invalid identity name %q in %s", identity.Name, manifest)
+	}
+
+	if identity.Publisher == "" {
+		return errors.Wrapf(ErrInvalid, "missing identity publisher in %s", manifest)
+	}
+
+	if !appxVersionPattern.MatchString(identity.Version) {
+		return errors.Wrapf(ErrInvalid, "invalid identity version %q in %s", identity.Version, manifest)
+	}
+
+	m.Name, m.Publisher, m.Version = identity.Name, identity.Publisher, identity.Version
+	if m.DisplayName == "" {
+		m.DisplayName = m.Name
+	}
+
+	return nil
+}
+
+// resolvedString drops ms-resource references, which can only be resolved
+// through the resources.pri of the package.
+func resolvedString(s string) string {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(strings.ToLower(s), "ms-resource:") {
+		return ""
+	}
+
+	return s
+}
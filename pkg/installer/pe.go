@@ -0,0 +1,317 @@
+package installer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	peResourceDirectory = 2
+	resourceTypeVersion = 16
+	fixedFileInfoMagic  = 0xFEEF04BD
+
+	// Bound the resources read into memory.
+	maxResourceSectionSize = 64 << 20
+	maxVersionResourceSize = 1 << 20
+)
+
+// VersionInfo is the version metadata of a PE file or MSI database.
+type VersionInfo struct {
+	ProductName    string `json:"productName,omitempty"`
+	ProductVersion string `json:"productVersion,omitempty"`
+	FileVersion    string `json:"fileVersion,omitempty"`
+
+	// Publisher is the CompanyName of PE files and the Manufacturer of MSI
+	// databases.
+	Publisher string `json:"publisher,omitempty"`
+
+	// Set for MSI databases.
+	ProductCode string `json:"productCode,omitempty"`
+	UpgradeCode string `json:"upgradeCode,omitempty"`
+}
+
+type peSection struct {
+	virtualAddress, virtualSize, rawOffset, rawSize uint32
+}
+
+// ReadPEVersion reads the version resource of a PE file, nil if it has none.
+func ReadPEVersion(r io.ReaderAt, size int64) (*VersionInfo, error) {
+	if err := validateEXE(r, size); err != nil {
+		return nil, err
+	}
+
+	dos := make([]byte, 64)
+	if _, err := r.ReadAt(dos, 0); err != nil {
+		return nil, err
+	}
+	peOffset := int64(binary.LittleEndian.Uint32(dos[0x3C:]))
+
+	coff := make([]byte, 24)
+	if _, err := r.ReadAt(coff, peOffset); err != nil {
+		return nil, errors.Wrap(ErrInvalid, "truncated COFF header")
+	}
+	numSections := int(binary.LittleEndian.Uint16(coff[6:]))
+	optionalSize := int(binary.LittleEndian.Uint16(coff[20:]))
+
+	optional := make([]byte, optionalSize)
+	if _, err := r.ReadAt(optional, peOffset+24); err != nil || optionalSize < 2 {
+		return nil, errors.Wrap(ErrInvalid, "truncated optional header")
+	}
+
+	var dirs int
+	switch binary.LittleEndian.Uint16(optional) {
+	case 0x10b:
+		dirs = 96
+	case 0x20b:
+		dirs = 112
+	default:
+		return nil, errors.Wrap(ErrInvalid, "unknown optional header magic")
+	}
+
+	resourceDir := dirs + peResourceDirectory*8
+	if len(optional) < resourceDir+8 {
+		return nil, nil
+	}
+
+	resourceRVA := binary.LittleEndian.Uint32(optional[resourceDir:])
+	if resourceRVA == 0 {
+		return nil, nil
+	}
+
+	table := make([]byte, 40*numSections)
+	if _, err := r.ReadAt(table, peOffset+24+int64(optionalSize)); err != nil {
+		return nil, errors.Wrap(ErrInvalid, "truncated section table")
+	}
+
+	sections := make([]peSection, numSections)
+	for i := range sections {
+		entry := table[40*i:]
+		sections[i] = peSection{
+			virtualSize:    binary.LittleEndian.Uint32(entry[8:]),
+			virtualAddress: binary.LittleEndian.Uint32(entry[12:]),
+			rawSize:        binary.LittleEndian.Uint32(entry[16:]),
+			rawOffset:      binary.LittleEndian.Uint32(entry[20:]),
+		}
+	}
+
+	var rsrc *peSection
+	for i := range sections {
+		s := &sections[i]
+		if resourceRVA >= s.virtualAddress && resourceRVA < s.virtualAddress+max(s.virtualSize, s.rawSize) {
+			rsrc = s
+		}
+	}
+	if rsrc == nil {
+		return nil, errors.Wrap(ErrInvalid, "resource directory outside of sections")
+	}
+
+	section := make([]byte, min(rsrc.rawSize, maxResourceSectionSize))
+	if _, err := r.ReadAt(section, int64(rsrc.rawOffset)); err != nil && err != io.EOF {
+		return nil, errors.Wrap(ErrInvalid, "truncated resource section")
+	}
+	base := resourceRVA - rsrc.virtualAddress
+
+	// The version resource is found below type, name and language.
+	offset, found := base, false
+	for level := 0; level < 3; level++ {
+		id := uint32(0)
+		if level == 0 {
+			id = resourceTypeVersion
+		}
+
+		offset, found = resourceEntry(section, base, offset, level == 0, id)
+		if !found {
+			return nil, nil
+		}
+	}
+
+	if int(offset)+16 > len(section) {
+		return nil, errors.Wrap(ErrInvalid, "resource data entry out of range")
+	}
+	dataRVA := binary.LittleEndian.Uint32(section[offset:])
+	dataSize := binary.LittleEndian.Uint32(section[offset+4:])
+	dataOffset := int64(dataRVA) - int64(rsrc.virtualAddress)
+
+	if dataSize > maxVersionResourceSize || dataOffset < 0 || dataOffset+int64(dataSize) > int64(len(section)) {
+		return nil, errors.Wrap(ErrInvalid, "version resource out of range")
+	}
+
+	return parseVersionResource(section[dataOffset : dataOffset+int64(dataSize)])
+}
+
+// resourceEntry looks up an entry of the resource directory at offset, either
+// by id or the first one. It returns the offset of the subdirectory or data
+// entry the entry points to.
+func resourceEntry(section []byte, base, offset uint32, byID bool, id uint32) (uint32, bool) {
+	if int(offset)+16 > len(section) {
+		return 0, false
+	}
+
+	named := int(binary.LittleEndian.Uint16(section[offset+12:]))
+	ids := int(binary.LittleEndian.Uint16(section[offset+14:]))
+
+	for i := 0; i < named+ids; i++ {
+		entry := int(offset) + 16 + 8*i
+		if entry+8 > len(section) {
+			return 0, false
+		}
+
+		name := binary.LittleEndian.Uint32(section[entry:])
+		if byID && name != id {
+			continue
+		}
+
+		next := base + binary.LittleEndian.Uint32(section[entry+4:])&0x7FFFFFFF
+
+		return next, true
+	}
+
+	return 0, false
+}
+
+// versionNode is a structure of the version resource: a key, a value and
+// nested structures.
+type versionNode struct {
+	length   int
+	key      string
+	value    []byte
+	text     bool
+	children []byte
+}
+
+func readVersionNode(data []byte) (versionNode, error) {
+	if len(data) < 6 {
+		return versionNode{}, errors.Wrap(ErrInvalid, "truncated version resource")
+	}
+
+	n := versionNode{length: int(binary.LittleEndian.Uint16(data))}
+	valueLength := int(binary.LittleEndian.Uint16(data[2:]))
+	n.text = binary.LittleEndian.Uint16(data[4:]) == 1
+
+	if n.length < 6 || n.length > len(data) {
+		return versionNode{}, errors.Wrap(ErrInvalid, "invalid version resource length")
+	}
+	data = data[:n.length]
+
+	offset := 6
+	var key []uint16
+	for ; offset+1 < len(data); offset += 2 {
+		c := binary.LittleEndian.Uint16(data[offset:])
+		if c == 0 {
+			break
+		}
+		key = append(key, c)
+	}
+	n.key = string(utf16.Decode(key))
+	offset = align4(offset + 2)
+
+	if n.text {
+		valueLength *= 2
+	}
+
+	if offset < len(data) {
+		n.value = data[offset:min(offset+valueLength, len(data))]
+		offset = align4(offset + valueLength)
+	}
+
+	if offset < len(data) {
+		n.children = data[offset:]
+	}
+
+	return n, nil
+}
+
+// eachVersionNode calls fn for every structure in data.
+func eachVersionNode(data []byte, fn func(versionNode) error) error {
+	for len(data) >= 6 {
+		n, err := readVersionNode(data)
+		if err != nil {
+			return err
+		}
+
+		if err := fn(n); err != nil {
+			return err
+		}
+
+		data = data[min(align4(n.length), len(data)):]
+	}
+
+	return nil
+}
+
+func parseVersionResource(data []byte) (*VersionInfo, error) {
+	root, err := readVersionNode(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if root.key != "VS_VERSION_INFO" {
+		return nil, errors.Wrap(ErrInvalid, "missing VS_VERSION_INFO")
+	}
+
+	info := &VersionInfo{}
+
+	if len(root.value) >= 52 && binary.LittleEndian.Uint32(root.value) == fixedFileInfoMagic {
+		info.FileVersion = fixedVersion(root.value[8:])
+		info.ProductVersion = fixedVersion(root.value[16:])
+	}
+
+	strs := map[string]string{}
+	err = eachVersionNode(root.children, func(n versionNode) error {
+		if n.key != "StringFileInfo" {
+			return nil
+		}
+
+		// Only the first string table is used, usually the only language.
+		table, err := readVersionNode(n.children)
+		if err != nil {
+			return err
+		}
+
+		return eachVersionNode(table.children, func(s versionNode) error {
+			strs[s.key] = utf16String(s.value)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	info.ProductName = strs["ProductName"]
+	info.Publisher = strs["CompanyName"]
+	if v := strs["ProductVersion"]; v != "" {
+		info.ProductVersion = v
+	}
+	if v := strs["FileVersion"]; v != "" {
+		info.FileVersion = v
+	}
+
+	return info, nil
+}
+
+func fixedVersion(b []byte) string {
+	ms, ls := binary.LittleEndian.Uint32(b), binary.LittleEndian.Uint32(b[4:])
+
+	return fmt.Sprintf("%d.%d.%d.%d", ms>>16, ms&0xFFFF, ls>>16, ls&0xFFFF)
+}
+
+func utf16String(b []byte) string {
+	s := make([]uint16, 0, len(b)/2)
+	for i := 0; i+1 < len(b); i += 2 {
+		c := binary.LittleEndian.Uint16(b[i:])
+		if c == 0 {
+			break
+		}
+		s = append(s, c)
+	}
+
+	return string(utf16.Decode(s))
+}
+
+func align4(n int) int {
+	return (n + 3) &^ 3
+}
@@ -0,0 +1,140 @@
+package keyprovider
+
+import (
+	"content-prep/pkg/packager"
+	"context"
+	"encoding/json"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	ProviderFile = "file"
+
+	masterKeyFileName = "master.key"
+)
+
+var (
+	_ packager.KeyProvider = &FileStore{}
+	_ Recoverer            = &FileStore{}
+)
+
+// FileStore is a local keystore. Package keys are wrapped with a master key and
+// stored as one file per package next to it.
+type FileStore struct {
+	dir       string
+	masterKey []byte
+}
+
+type fileStoreEntry struct {
+	KeyID       string    `json:"keyId"`
+	WrappedKeys []byte    `json:"wrappedKeys"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// NewFileStore opens the keystore in dir, creating it and its master key if
+// they do not exist yet.
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "failed to create keystore directory")
+	}
+
+	masterKeyPath := path.Join(dir, masterKeyFileName)
+
+	masterKey, err := os.ReadFile(masterKeyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		masterKey, err = randomBytes(aesKeySize)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to generate master key")
+		}
+
+		if err := os.WriteFile(masterKeyPath, masterKey, 0600); err != nil {
+			return nil, errors.Wrapf(err, "failed to write master key")
+		}
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "failed to read master key")
+	}
+
+	if len(masterKey) != aesKeySize {
+		return nil, errors.Errorf("invalid master key length %d, expected %d bytes", len(masterKey), aesKeySize)
+	}
+
+	return &FileStore{
+		dir:       dir,
+		masterKey: masterKey,
+	}, nil
+}
+
+func (s *FileStore) ProvideKeys(_ context.Context) (*packager.PackageKeys, error) {
+	keys, err := generateKeys(randomBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	keyID, err := newKeyID()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to generate key id")
+	}
+
+	wrapped, err := seal(s.masterKey, marshalKeys(keys), []byte(keyID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to wrap package keys")
+	}
+
+	entry := fileStoreEntry{
+		KeyID:       keyID,
+		WrappedKeys: wrapped,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(s.entryPath(keyID), data, 0600); err != nil {
+		return nil, errors.Wrapf(err, "failed to write keystore entry")
+	}
+
+	keys.Escrow = &packager.EscrowRecord{
+		Provider:    ProviderFile,
+		KeyID:       keyID,
+		WrappingKey: masterKeyFileName,
+		WrappedKeys: wrapped,
+		CreatedAt:   entry.CreatedAt,
+	}
+
+	return keys, nil
+}
+
+// Recover returns the keys stored for an escrow record issued by this
+// keystore.
+func (s *FileStore) Recover(_ context.Context, record *packager.EscrowRecord) (*packager.PackageKeys, error) {
+	if record.Provider != ProviderFile {
+		return nil, errors.Errorf("escrow record was issued by provider %q", record.Provider)
+	}
+
+	data, err := os.ReadFile(s.entryPath(record.KeyID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read keystore entry")
+	}
+
+	var entry fileStoreEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse keystore entry")
+	}
+
+	b, err := open(s.masterKey, entry.WrappedKeys, []byte(entry.KeyID))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unwrap package keys")
+	}
+
+	return unmarshalKeys(b)
+}
+
+func (s *FileStore) entryPath(keyID string) string {
+	return path.Join(s.dir, path.Base(keyID)+".json")
+}
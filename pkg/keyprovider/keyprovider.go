@@ -0,0 +1,114 @@
+package keyprovider
+
+import (
+	"content-prep/pkg/cryptostream"
+	"content-prep/pkg/packager"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// Recoverer recovers the keys of a package from the escrow record written
+// next to it.
+type Recoverer interface {
+	Recover(ctx context.Context, record *packager.EscrowRecord) (*packager.PackageKeys, error)
+}
+
+const (
+	aesKeySize = 32
+
+	// keyMaterialSize is the size of the concatenated keys of a package.
+	keyMaterialSize = aesKeySize + cryptostream.HMACKeySize + cryptostream.IvSize
+)
+
+// marshalKeys concatenates the keys of a package for wrapping.
+func marshalKeys(keys *packager.PackageKeys) []byte {
+	b := make([]byte, 0, keyMaterialSize)
+	b = append(b, keys.EncryptionKey...)
+	b = append(b, keys.MACKey...)
+	b = append(b, keys.InitializationVector...)
+
+	return b
+}
+
+func unmarshalKeys(b []byte) (*packager.PackageKeys, error) {
+	if len(b) != keyMaterialSize {
+		return nil, errors.Errorf("invalid key material length %d, expected %d bytes", len(b), keyMaterialSize)
+	}
+
+	return &packager.PackageKeys{
+		EncryptionKey:        append([]byte(nil), b[:aesKeySize]...),
+		MACKey:               append([]byte(nil), b[aesKeySize:aesKeySize+cryptostream.HMACKeySize]...),
+		InitializationVector: append([]byte(nil), b[aesKeySize+cryptostream.HMACKeySize:]...),
+	}, nil
+}
+
+// generateKeys draws fresh key material for a package from random.
+func generateKeys(random func(length int) ([]byte, error)) (*packager.PackageKeys, error) {
+	b, err := random(keyMaterialSize)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to generate key material")
+	}
+
+	return unmarshalKeys(b)
+}
+
+func randomBytes(length int) ([]byte, error) {
+	b := make([]byte, length)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+func newKeyID() (string, error) {
+	b, err := randomBytes(16)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// seal encrypts plaintext with AES-GCM, binding it to additionalData. The nonce
+// is prepended to the ciphertext.
+func seal(key, plaintext, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func open(key, sealed, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("sealed data is too short")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
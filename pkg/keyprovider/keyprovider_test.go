@@ -0,0 +1,206 @@
+package keyprovider
+
+import (
+	"content-prep/pkg/cryptostream"
+	"content-prep/pkg/packager"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestKeyProviderTestSuite(t *testing.T) {
+	suite.Run(t, new(KeyProviderTestSuite))
+}
+
+type KeyProviderTestSuite struct {
+	suite.Suite
+
+	testDir string
+}
+
+func (s *KeyProviderTestSuite) SetupTest() {
+	var err error
+	s.testDir, err = os.MkdirTemp("", "keyprovider-test-*")
+	s.Require().NoError(err)
+}
+
+func (s *KeyProviderTestSuite) TearDownTest() {
+	s.Require().NoError(os.RemoveAll(s.testDir))
+}
+
+func (s *KeyProviderTestSuite) requireValidKeys(keys *packager.PackageKeys) {
+	s.Require().Len(keys.EncryptionKey, aesKeySize)
+	s.Require().Len(keys.MACKey, cryptostream.HMACKeySize)
+	s.Require().Len(keys.InitializationVector, cryptostream.IvSize)
+	s.Require().NotNil(keys.Escrow)
+	s.Require().NotEmpty(keys.Escrow.KeyID)
+}
+
+func (s *KeyProviderTestSuite) TestFileStore() {
+	keystore := path.Join(s.testDir, "keystore")
+
+	store, err := NewFileStore(keystore)
+	s.Require().NoError(err)
+
+	keys, err := store.ProvideKeys(context.Background())
+	s.Require().NoError(err)
+	s.requireValidKeys(keys)
+	s.Require().Equal(ProviderFile, keys.Escrow.Provider)
+
+	reopened, err := NewFileStore(keystore)
+	s.Require().NoError(err)
+
+	recovered, err := reopened.Recover(context.Background(), keys.Escrow)
+	s.Require().NoError(err)
+	s.Require().Equal(keys.EncryptionKey, recovered.EncryptionKey)
+	s.Require().Equal(keys.MACKey, recovered.MACKey)
+	s.Require().Equal(keys.InitializationVector, recovered.InitializationVector)
+
+	s.Require().NoError(os.WriteFile(path.Join(keystore, masterKeyFileName), []byte(strings.Repeat("x", aesKeySize)), 0600))
+
+	other, err := NewFileStore(keystore)
+	s.Require().NoError(err)
+
+	_, err = other.Recover(context.Background(), keys.Escrow)
+	s.Require().Error(err)
+
+	_, err = reopened.Recover(context.Background(), &packager.EscrowRecord{Provider: ProviderKMS, KeyID: keys.Escrow.KeyID})
+	s.Require().ErrorContains(err, `escrow record was issued by provider "kms"`)
+}
+
+// softToken stands in for a PKCS#11 token holding AES key encryption keys.
+type softToken struct {
+	keys map[string][]byte
+}
+
+func (t *softToken) GenerateRandom(length int) ([]byte, error) {
+	return randomBytes(length)
+}
+
+func (t *softToken) WrapKey(label string, key []byte) ([]byte, error) {
+	kek, ok := t.keys[label]
+	if !ok {
+		return nil, errors.Errorf("no key with label %q", label)
+	}
+
+	return seal(kek, key, nil)
+}
+
+func (t *softToken) UnwrapKey(label string, wrapped []byte) ([]byte, error) {
+	kek, ok := t.keys[label]
+	if !ok {
+		return nil, errors.Errorf("no key with label %q", label)
+	}
+
+	return open(kek, wrapped, nil)
+}
+
+func (s *KeyProviderTestSuite) TestPKCS11() {
+	token := &softToken{keys: map[string][]byte{"intune-kek": []byte(strings.Repeat("k", aesKeySize))}}
+
+	provider := NewPKCS11(token, "intune-kek")
+
+	keys, err := provider.ProvideKeys(context.Background())
+	s.Require().NoError(err)
+	s.requireValidKeys(keys)
+	s.Require().Equal(ProviderPKCS11, keys.Escrow.Provider)
+	s.Require().Equal("intune-kek", keys.Escrow.WrappingKey)
+	s.Require().NotContains(string(keys.Escrow.WrappedKeys), string(keys.EncryptionKey))
+
+	var recoverer Recoverer = provider
+	recovered, err := recoverer.Recover(context.Background(), keys.Escrow)
+	s.Require().NoError(err)
+	s.Require().Equal(keys.EncryptionKey, recovered.EncryptionKey)
+	s.Require().Equal(keys.MACKey, recovered.MACKey)
+	s.Require().Equal(keys.InitializationVector, recovered.InitializationVector)
+
+	_, err = recoverer.Recover(context.Background(), &packager.EscrowRecord{Provider: ProviderFile, KeyID: keys.Escrow.KeyID})
+	s.Require().ErrorContains(err, `escrow record was issued by provider "file"`)
+
+	_, err = NewPKCS11(token, "missing").ProvideKeys(context.Background())
+	s.Require().Error(err)
+}
+
+// fakeKMS is a minimal in-memory implementation of the key service API.
+type fakeKMS struct {
+	token string
+	kek   []byte
+}
+
+func (f *fakeKMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("Authorization") != "Bearer "+f.token {
+		w.WriteHeader(http.StatusUnauthorized)
+		_ = json.NewEncoder(w).Encode(kmsError{Message: "invalid token"})
+		return
+	}
+
+	var req kmsDataKey
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var b []byte
+	var err error
+
+	switch {
+	case r.URL.Path == "/v1/datakeys":
+		b, err = randomBytes(keyMaterialSize)
+		if err == nil {
+			req.KeyID, err = newKeyID()
+		}
+		if err == nil {
+			req.WrappedKeys, err = seal(f.kek, b, []byte(req.KeyID))
+		}
+	case strings.HasPrefix(r.URL.Path, "/v1/datakeys/") && strings.HasSuffix(r.URL.Path, "/unwrap"):
+		req.KeyID = strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/v1/datakeys/"), "/unwrap")
+		b, err = open(f.kek, req.WrappedKeys, []byte(req.KeyID))
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		_ = json.NewEncoder(w).Encode(kmsError{Message: err.Error()})
+		return
+	}
+
+	keys, _ := unmarshalKeys(b)
+	req.WrappingKey = "fake-kek"
+	req.EncryptionKey = keys.EncryptionKey
+	req.MACKey = keys.MACKey
+	req.InitializationVector = keys.InitializationVector
+
+	_ = json.NewEncoder(w).Encode(req)
+}
+
+func (s *KeyProviderTestSuite) TestKMS() {
+	server := httptest.NewServer(&fakeKMS{token: "secret", kek: []byte(strings.Repeat("k", aesKeySize))})
+	defer server.Close()
+
+	kms := NewKMS(server.URL+"/", "secret", server.Client())
+
+	keys, err := kms.ProvideKeys(context.Background())
+	s.Require().NoError(err)
+	s.requireValidKeys(keys)
+	s.Require().Equal(ProviderKMS, keys.Escrow.Provider)
+	s.Require().Equal("fake-kek", keys.Escrow.WrappingKey)
+
+	recovered, err := kms.Recover(context.Background(), keys.Escrow)
+	s.Require().NoError(err)
+	s.Require().Equal(keys.EncryptionKey, recovered.EncryptionKey)
+	s.Require().Equal(keys.MACKey, recovered.MACKey)
+	s.Require().Equal(keys.InitializationVector, recovered.InitializationVector)
+
+	_, err = NewKMS(server.URL, "wrong", server.Client()).ProvideKeys(context.Background())
+	s.Require().ErrorContains(err, "invalid token")
+}
@@ -0,0 +1,155 @@
+package keyprovider
+
+import (
+	"bytes"
+	"content-prep/pkg/cryptostream"
+	"content-prep/pkg/packager"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const ProviderKMS = "kms"
+
+var (
+	_ packager.KeyProvider = &KMS{}
+	_ Recoverer            = &KMS{}
+)
+
+// KMS sources package keys from an HTTP key management service. The service
+// generates the keys, keeps them escrowed under the returned key id and hands
+// out the plaintext keys together with a wrapped copy.
+//
+//	POST {baseURL}/v1/datakeys                 -> kmsDataKey
+//	POST {baseURL}/v1/datakeys/{keyId}/unwrap  -> kmsDataKey
+type KMS struct {
+	baseURL string
+	token   string
+	client  *http.Client
+}
+
+type kmsDataKey struct {
+	KeyID                string `json:"keyId,omitempty"`
+	WrappingKey          string `json:"wrappingKey,omitempty"`
+	WrappedKeys          []byte `json:"wrappedKeys,omitempty"`
+	EncryptionKey        []byte `json:"encryptionKey,omitempty"`
+	MACKey               []byte `json:"macKey,omitempty"`
+	InitializationVector []byte `json:"initializationVector,omitempty"`
+}
+
+type kmsError struct {
+	Message string `json:"error"`
+}
+
+// NewKMS creates a client for the key service at baseURL. The token is sent as
+// bearer token if it is not empty.
+func NewKMS(baseURL string, token string, client *http.Client) *KMS {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &KMS{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		client:  client,
+	}
+}
+
+func (k *KMS) ProvideKeys(ctx context.Context) (*packager.PackageKeys, error) {
+	var dataKey kmsDataKey
+	if err := k.do(ctx, "/v1/datakeys", struct {
+		Purpose string `json:"purpose"`
+	}{Purpose: "intunewin"}, &dataKey); err != nil {
+		return nil, errors.Wrapf(err, "failed to generate data key")
+	}
+
+	keys, err := dataKey.keys()
+	if err != nil {
+		return nil, err
+	}
+
+	keys.Escrow = &packager.EscrowRecord{
+		Provider:    ProviderKMS,
+		KeyID:       dataKey.KeyID,
+		WrappingKey: dataKey.WrappingKey,
+		WrappedKeys: dataKey.WrappedKeys,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	return keys, nil
+}
+
+// Recover asks the key service to unwrap the keys of an escrow record.
+func (k *KMS) Recover(ctx context.Context, record *packager.EscrowRecord) (*packager.PackageKeys, error) {
+	if record.Provider != ProviderKMS {
+		return nil, errors.Errorf("escrow record was issued by provider %q", record.Provider)
+	}
+
+	var dataKey kmsDataKey
+	if err := k.do(ctx, "/v1/datakeys/"+url.PathEscape(record.KeyID)+"/unwrap", kmsDataKey{
+		WrappingKey: record.WrappingKey,
+		WrappedKeys: record.WrappedKeys,
+	}, &dataKey); err != nil {
+		return nil, errors.Wrapf(err, "failed to unwrap data key")
+	}
+
+	return dataKey.keys()
+}
+
+func (k *KMS) do(ctx context.Context, endpoint string, in any, out any) error {
+	body, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.baseURL+endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if k.token != "" {
+		req.Header.Set("Authorization", "Bearer "+k.token)
+	}
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var kErr kmsError
+		if json.Unmarshal(data, &kErr) == nil && kErr.Message != "" {
+			return fmt.Errorf("key service returned %s: %s", resp.Status, kErr.Message)
+		}
+
+		return fmt.Errorf("key service returned %s", resp.Status)
+	}
+
+	return json.Unmarshal(data, out)
+}
+
+func (d *kmsDataKey) keys() (*packager.PackageKeys, error) {
+	if len(d.EncryptionKey) != aesKeySize || len(d.MACKey) != cryptostream.HMACKeySize || len(d.InitializationVector) != cryptostream.IvSize {
+		return nil, errors.New("key service returned key material of unexpected length")
+	}
+
+	return &packager.PackageKeys{
+		EncryptionKey:        d.EncryptionKey,
+		MACKey:               d.MACKey,
+		InitializationVector: d.InitializationVector,
+	}, nil
+}
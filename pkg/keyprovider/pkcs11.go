@@ -0,0 +1,82 @@
+package keyprovider
+
+import (
+	"content-prep/pkg/packager"
+	"context"
+	"encoding/hex"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const ProviderPKCS11 = "pkcs11"
+
+// PKCS11Session is the subset of a PKCS#11 session needed to source package
+// keys from a hardware security module. Implementations map the methods to
+// C_GenerateRandom, C_WrapKey and C_UnwrapKey using the key encryption key
+// stored on the token under the given label.
+type PKCS11Session interface {
+	GenerateRandom(length int) ([]byte, error)
+	WrapKey(wrappingKeyLabel string, key []byte) ([]byte, error)
+	UnwrapKey(wrappingKeyLabel string, wrapped []byte) ([]byte, error)
+}
+
+var (
+	_ packager.KeyProvider = &PKCS11{}
+	_ Recoverer            = &PKCS11{}
+)
+
+// PKCS11 draws package keys from the random generator of a token and escrows
+// them wrapped with a key that never leaves the token.
+type PKCS11 struct {
+	session          PKCS11Session
+	wrappingKeyLabel string
+}
+
+func NewPKCS11(session PKCS11Session, wrappingKeyLabel string) *PKCS11 {
+	return &PKCS11{
+		session:          session,
+		wrappingKeyLabel: wrappingKeyLabel,
+	}
+}
+
+func (p *PKCS11) ProvideKeys(_ context.Context) (*packager.PackageKeys, error) {
+	keys, err := generateKeys(p.session.GenerateRandom)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := p.session.GenerateRandom(16)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to generate key id")
+	}
+
+	wrapped, err := p.session.WrapKey(p.wrappingKeyLabel, marshalKeys(keys))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to wrap package keys")
+	}
+
+	keys.Escrow = &packager.EscrowRecord{
+		Provider:    ProviderPKCS11,
+		KeyID:       hex.EncodeToString(id),
+		WrappingKey: p.wrappingKeyLabel,
+		WrappedKeys: wrapped,
+		CreatedAt:   time.Now().UTC(),
+	}
+
+	return keys, nil
+}
+
+// Recover unwraps the keys of an escrow record issued by this provider.
+func (p *PKCS11) Recover(_ context.Context, record *packager.EscrowRecord) (*packager.PackageKeys, error) {
+	if record.Provider != ProviderPKCS11 {
+		return nil, errors.Errorf("escrow record was issued by provider %q", record.Provider)
+	}
+
+	b, err := p.session.UnwrapKey(record.WrappingKey, record.WrappedKeys)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to unwrap package keys")
+	}
+
+	return unmarshalKeys(b)
+}
@@ -1,10 +1,16 @@
 package packager
 
 import (
+	"content-prep/pkg/installer"
 	"encoding/base64"
 	"encoding/xml"
 )
 
+const (
+	ProfileVersion1           = "ProfileVersion1"
+	FileDigestAlgorithmSHA256 = "SHA256"
+)
+
 const (
 	ToolVersion  = "1.8.4.0"
 	XSDNamespace = "http://www.w3.org/2001/XMLSchema"
@@ -20,47 +26,221 @@ type ApplicationInfo struct {
 	UnencryptedContentSize int64          `xml:"UnencryptedContentSize"`
 	SetupFile              string         `xml:"SetupFile"`
 	EncryptionInfo         EncryptionInfo `xml:"EncryptionInfo"`
+	MsiInfo                *MsiInfo       `xml:"MsiInfo,omitempty"`
+
+	// ToolVersion is the version of the tool that wrote the Detection.xml. It
+	// defaults to the version of the Microsoft tool this implementation mirrors.
+	ToolVersion string `xml:"-"`
+
+	// Installer and Project are detected from the source while creating a
+	// package. They are not part of Detection.xml.
+	Installer *installer.Info `xml:"-"`
+	Project   *Project        `xml:"-"`
+
+	// Namespaces keeps the namespace declarations other than xsi and xsd, and
+	// Attrs and Extra the attributes and elements that are not modelled
+	// explicitly, so re-encoding a vendor file is lossless. Prefixed names are
+	// kept as written, e.g. xmlns:v and v:Build.
+	Namespaces []xml.Attr   `xml:"-"`
+	Attrs      []xml.Attr   `xml:"-"`
+	Extra      []RawElement `xml:"-"`
+
+	// elementOrder records the order of the elements as read.
+	elementOrder []string
+}
+
+// RawElement is an element that is not modelled explicitly and kept verbatim.
+type RawElement struct {
+	XMLName xml.Name
+	Attrs   []xml.Attr `xml:",any,attr"`
+	Inner   string     `xml:",innerxml"`
 }
 
+// MsiInfo is written by the Microsoft tool when the setup file is an MSI.
+type MsiInfo struct {
+	MsiProductCode                string       `xml:"MsiProductCode,omitempty"`
+	MsiProductVersion             string       `xml:"MsiProductVersion,omitempty"`
+	MsiPackageCode                string       `xml:"MsiPackageCode,omitempty"`
+	MsiUpgradeCode                string       `xml:"MsiUpgradeCode,omitempty"`
+	MsiExecutionContext           string       `xml:"MsiExecutionContext,omitempty"`
+	MsiRequiresLogon              string       `xml:"MsiRequiresLogon,omitempty"`
+	MsiRequiresReboot             string       `xml:"MsiRequiresReboot,omitempty"`
+	MsiIsMachineInstall           string       `xml:"MsiIsMachineInstall,omitempty"`
+	MsiIsUserInstall              string       `xml:"MsiIsUserInstall,omitempty"`
+	MsiIncludesServices           string       `xml:"MsiIncludesServices,omitempty"`
+	MsiIncludesODBCDataSource     string       `xml:"MsiIncludesODBCDataSource,omitempty"`
+	MsiContainsSystemRegistryKeys string       `xml:"MsiContainsSystemRegistryKeys,omitempty"`
+	MsiContainsSystemFolders      string       `xml:"MsiContainsSystemFolders,omitempty"`
+	MsiPublisher                  string       `xml:"MsiPublisher,omitempty"`
+	Extra                         []RawElement `xml:",any"`
+}
+
+// Version returns the version of the application: the version of a detected
+// project or MSIX package, or the product version of an MSI. Packages opened
+// from disk only know the latter, as the others are not part of Detection.xml.
+func (a *ApplicationInfo) Version() string {
+	switch {
+	case a.Project != nil && a.Project.Version != "":
+		return a.Project.Version
+	case a.Installer != nil && a.Installer.Msix != nil:
+		return a.Installer.Msix.Version
+	case a.MsiInfo != nil:
+		return a.MsiInfo.MsiProductVersion
+	default:
+		return ""
+	}
+}
+
+var defaultElementOrder = []string{"FileName", "Name", "UnencryptedContentSize", "SetupFile", "EncryptionInfo", "MsiInfo"}
+
 func (a *ApplicationInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
-	type Alias ApplicationInfo
-	aux := &struct {
-		XSI         string `xml:"xmlns:xsi,attr"`
-		XSD         string `xml:"xmlns:xsd,attr"`
-		ToolVersion string `xml:"ToolVersion,attr"`
-		Alias
-	}{
-		ToolVersion: ToolVersion,
-		XSI:         XSINamespace,
-		XSD:         XSDNamespace,
-		Alias:       (Alias)(*a),
+	toolVersion := a.ToolVersion
+	if toolVersion == "" {
+		toolVersion = ToolVersion
 	}
 
-	return e.EncodeElement(aux, start)
+	start.Attr = []xml.Attr{
+		{Name: xml.Name{Local: "xmlns:xsi"}, Value: XSINamespace},
+		{Name: xml.Name{Local: "xmlns:xsd"}, Value: XSDNamespace},
+	}
+	start.Attr = append(start.Attr, a.Namespaces...)
+	start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "ToolVersion"}, Value: toolVersion})
+	start.Attr = append(start.Attr, a.Attrs...)
+
+	if err := e.EncodeToken(start); err != nil {
+		return err
+	}
+
+	order := a.elementOrder
+	if len(order) == 0 {
+		order = defaultElementOrder
+	}
+
+	written := map[string]bool{}
+	extra := a.Extra
+
+	encode := func(name string) error {
+		if written[name] {
+			return nil
+		}
+
+		el := xml.StartElement{Name: xml.Name{Local: name}}
+
+		var err error
+		switch name {
+		case "FileName":
+			err = e.EncodeElement(a.FileName, el)
+		case "Name":
+			err = e.EncodeElement(a.Name, el)
+		case "UnencryptedContentSize":
+			err = e.EncodeElement(a.UnencryptedContentSize, el)
+		case "SetupFile":
+			err = e.EncodeElement(a.SetupFile, el)
+		case "EncryptionInfo":
+			err = e.EncodeElement(&a.EncryptionInfo, el)
+		case "MsiInfo":
+			if a.MsiInfo != nil {
+				err = e.EncodeElement(a.MsiInfo, el)
+			}
+		default:
+			if len(extra) > 0 {
+				err = e.Encode(extra[0])
+				extra = extra[1:]
+			}
+			return err
+		}
+
+		written[name] = true
+
+		return err
+	}
+
+	for _, name := range order {
+		if err := encode(name); err != nil {
+			return err
+		}
+	}
+
+	for _, name := range defaultElementOrder {
+		if err := encode(name); err != nil {
+			return err
+		}
+	}
+
+	for _, raw := range extra {
+		if err := e.Encode(raw); err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
 }
 
 func (a *ApplicationInfo) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
-	type Alias ApplicationInfo
-	aux := &struct {
-		XSI         string `xml:"xmlns:xsi,attr"`
-		XSD         string `xml:"xmlns:xsd,attr"`
-		ToolVersion string `xml:"ToolVersion,attr"`
-		*Alias
-	}{
-		Alias: (*Alias)(a),
+	*a = ApplicationInfo{}
+
+	// The decoder resolves prefixes to namespace URLs, which are mapped back
+	// to the prefixes declared on the element.
+	prefixes := map[string]string{XSINamespace: "xsi", XSDNamespace: "xsd"}
+	for _, attr := range start.Attr {
+		if attr.Name.Space == "xmlns" {
+			prefixes[attr.Value] = attr.Name.Local
+		}
 	}
 
-	if err := d.DecodeElement(aux, &start); err != nil {
-		return err
+	for _, attr := range start.Attr {
+		switch {
+		case attr.Name.Space == "" && attr.Name.Local == "ToolVersion":
+			a.ToolVersion = attr.Value
+		case attr.Name.Space == "xmlns" && attr.Name.Local == "xsi" && attr.Value == XSINamespace,
+			attr.Name.Space == "xmlns" && attr.Name.Local == "xsd" && attr.Value == XSDNamespace:
+			// always written by MarshalXML
+		case attr.Name.Space == "xmlns":
+			a.Namespaces = append(a.Namespaces, xml.Attr{Name: xml.Name{Local: "xmlns:" + attr.Name.Local}, Value: attr.Value})
+		case attr.Name.Space != "" && prefixes[attr.Name.Space] != "":
+			a.Attrs = append(a.Attrs, xml.Attr{Name: xml.Name{Local: prefixes[attr.Name.Space] + ":" + attr.Name.Local}, Value: attr.Value})
+		default:
+			a.Attrs = append(a.Attrs, attr)
+		}
 	}
 
-	a.FileName = aux.FileName
-	a.Name = aux.Name
-	a.UnencryptedContentSize = aux.UnencryptedContentSize
-	a.SetupFile = aux.SetupFile
-	a.EncryptionInfo = aux.EncryptionInfo
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return err
+		}
 
-	return nil
+		switch t := token.(type) {
+		case xml.StartElement:
+			a.elementOrder = append(a.elementOrder, t.Name.Local)
+
+			switch t.Name.Local {
+			case "FileName":
+				err = d.DecodeElement(&a.FileName, &t)
+			case "Name":
+				err = d.DecodeElement(&a.Name, &t)
+			case "UnencryptedContentSize":
+				err = d.DecodeElement(&a.UnencryptedContentSize, &t)
+			case "SetupFile":
+				err = d.DecodeElement(&a.SetupFile, &t)
+			case "EncryptionInfo":
+				err = d.DecodeElement(&a.EncryptionInfo, &t)
+			case "MsiInfo":
+				a.MsiInfo = &MsiInfo{}
+				err = d.DecodeElement(a.MsiInfo, &t)
+			default:
+				var raw RawElement
+				err = d.DecodeElement(&raw, &t)
+				a.Extra = append(a.Extra, raw)
+			}
+
+			if err != nil {
+				return err
+			}
+		case xml.EndElement:
+			return nil
+		}
+	}
 }
 
 var _ xml.Marshaler = &EncryptionInfo{}
@@ -74,17 +254,21 @@ type EncryptionInfo struct {
 	ProfileIdentifier    string `xml:"ProfileIdentifier"`
 	FileDigest           []byte `xml:"FileDigest"`
 	FileDigestAlgorithm  string `xml:"FileDigestAlgorithm"`
+
+	// Extra keeps elements that are not modelled explicitly.
+	Extra []RawElement `xml:"-"`
 }
 
 func (i *EncryptionInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
 	aux := struct {
-		EncryptionKey        string `xml:"EncryptionKey"`
-		MACKey               string `xml:"MacKey"`
-		InitializationVector string `xml:"InitializationVector"`
-		Mac                  string `xml:"Mac"`
-		ProfileIdentifier    string `xml:"ProfileIdentifier"`
-		FileDigest           string `xml:"FileDigest"`
-		FileDigestAlgorithm  string `xml:"FileDigestAlgorithm"`
+		EncryptionKey        string       `xml:"EncryptionKey"`
+		MACKey               string       `xml:"MacKey"`
+		InitializationVector string       `xml:"InitializationVector"`
+		Mac                  string       `xml:"Mac"`
+		ProfileIdentifier    string       `xml:"ProfileIdentifier"`
+		FileDigest           string       `xml:"FileDigest"`
+		FileDigestAlgorithm  string       `xml:"FileDigestAlgorithm"`
+		Extra                []RawElement `xml:",any"`
 	}{
 		EncryptionKey:        base64.StdEncoding.EncodeToString(i.EncryptionKey),
 		MACKey:               base64.StdEncoding.EncodeToString(i.MACKey),
@@ -93,6 +277,7 @@ func (i *EncryptionInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) erro
 		ProfileIdentifier:    i.ProfileIdentifier,
 		FileDigest:           base64.StdEncoding.EncodeToString(i.FileDigest),
 		FileDigestAlgorithm:  i.FileDigestAlgorithm,
+		Extra:                i.Extra,
 	}
 
 	return e.EncodeElement(aux, start)
@@ -100,13 +285,14 @@ func (i *EncryptionInfo) MarshalXML(e *xml.Encoder, start xml.StartElement) erro
 
 func (i *EncryptionInfo) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
 	aux := struct {
-		EncryptionKey        string `xml:"EncryptionKey"`
-		MACKey               string `xml:"MacKey"`
-		InitializationVector string `xml:"InitializationVector"`
-		Mac                  string `xml:"Mac"`
-		ProfileIdentifier    string `xml:"ProfileIdentifier"`
-		FileDigest           string `xml:"FileDigest"`
-		FileDigestAlgorithm  string `xml:"FileDigestAlgorithm"`
+		EncryptionKey        string       `xml:"EncryptionKey"`
+		MACKey               string       `xml:"MacKey"`
+		InitializationVector string       `xml:"InitializationVector"`
+		Mac                  string       `xml:"Mac"`
+		ProfileIdentifier    string       `xml:"ProfileIdentifier"`
+		FileDigest           string       `xml:"FileDigest"`
+		FileDigestAlgorithm  string       `xml:"FileDigestAlgorithm"`
+		Extra                []RawElement `xml:",any"`
 	}{}
 
 	if err := d.DecodeElement(&aux, &start); err != nil {
@@ -142,6 +328,7 @@ func (i *EncryptionInfo) UnmarshalXML(d *xml.Decoder, start xml.StartElement) er
 	}
 
 	i.FileDigestAlgorithm = aux.FileDigestAlgorithm
+	i.Extra = aux.Extra
 
 	return nil
 }
@@ -1,7 +1,13 @@
 package packager
 
 import (
+	"bytes"
+	"content-prep/pkg/installer"
 	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/suite"
@@ -59,3 +65,152 @@ func (s *ApplicationInfoTestSuite) TestUnmarshalXML() {
 	s.Require().Equal([]byte("test"), ai.EncryptionInfo.FileDigest)
 	s.Require().Equal("SHA256", ai.EncryptionInfo.FileDigestAlgorithm)
 }
+
+var update = flag.Bool("update", false, "update golden files")
+
+func (s *ApplicationInfoTestSuite) TestGoldenRoundTrip() {
+	samples, err := filepath.Glob(filepath.Join("testdata", "detection", "*.xml"))
+	s.Require().NoError(err)
+	s.Require().NotEmpty(samples)
+
+	for _, sample := range samples {
+		if strings.HasSuffix(sample, ".golden.xml") {
+			continue
+		}
+
+		s.Run(filepath.Base(sample), func() {
+			in, err := os.ReadFile(sample)
+			s.Require().NoError(err)
+
+			s.Require().NoError(ValidateDetectionXML(bytes.NewReader(in), false))
+
+			var ai ApplicationInfo
+			s.Require().NoError(xml.Unmarshal(in, &ai))
+			s.Require().Equal(strings.SplitN(filepath.Base(sample), "-", 2)[0], ai.ToolVersion)
+
+			out := &bytes.Buffer{}
+			s.Require().NoError(ExportDetectionXML(out, &ai))
+
+			golden := strings.TrimSuffix(sample, ".xml") + ".golden.xml"
+			if *update {
+				s.Require().NoError(os.WriteFile(golden, out.Bytes(), 0644))
+			}
+
+			expected, err := os.ReadFile(golden)
+			s.Require().NoError(err)
+			s.Require().Equal(string(expected), out.String())
+
+			s.Require().NoError(ValidateDetectionXML(bytes.NewReader(out.Bytes()), false))
+
+			var again ApplicationInfo
+			s.Require().NoError(xml.Unmarshal(out.Bytes(), &again))
+
+			reencoded := &bytes.Buffer{}
+			s.Require().NoError(ExportDetectionXML(reencoded, &again))
+			s.Require().Equal(out.String(), reencoded.String())
+		})
+	}
+}
+
+func (s *ApplicationInfoTestSuite) TestUnmarshalXMLPreservesUnknown() {
+	in, err := os.ReadFile(filepath.Join("testdata", "detection", "1.8.6.0-future.xml"))
+	s.Require().NoError(err)
+
+	var ai ApplicationInfo
+	s.Require().NoError(xml.Unmarshal(in, &ai))
+
+	s.Require().Equal("1.8.6.0", ai.ToolVersion)
+	s.Require().Len(ai.Attrs, 1)
+	s.Require().Equal("Architecture", ai.Attrs[0].Name.Local)
+	s.Require().Len(ai.Extra, 2)
+	s.Require().Equal("CatalogVersion", ai.Extra[0].XMLName.Local)
+	s.Require().Equal("ExtendedInfo", ai.Extra[1].XMLName.Local)
+
+	out, err := xml.Marshal(&ai)
+	s.Require().NoError(err)
+	s.Require().Contains(string(out), `ToolVersion="1.8.6.0" Architecture="x64"`)
+	s.Require().Contains(string(out), `<SetupFile>install.cmd</SetupFile><CatalogVersion Scheme="semver">2.1.0</CatalogVersion><EncryptionInfo>`)
+	s.Require().Contains(string(out), `<Signer>CN=Contoso Ltd</Signer>`)
+
+	s.Require().Error(ValidateDetectionXML(bytes.NewReader(in), true))
+}
+
+func (s *ApplicationInfoTestSuite) TestRoundTripNamespaces() {
+	vendor := bytes.Replace(data, []byte(` ToolVersion="1.8.4.0">`), []byte(` xmlns:v="urn:contoso:packaging" ToolVersion="1.8.4.0" v:Build="42" xsi:noNamespaceSchemaLocation="Detection.xsd">`), 1)
+
+	var ai ApplicationInfo
+	s.Require().NoError(xml.Unmarshal(vendor, &ai))
+	s.Require().Equal([]xml.Attr{{Name: xml.Name{Local: "xmlns:v"}, Value: "urn:contoso:packaging"}}, ai.Namespaces)
+	s.Require().Equal([]xml.Attr{
+		{Name: xml.Name{Local: "v:Build"}, Value: "42"},
+		{Name: xml.Name{Local: "xsi:noNamespaceSchemaLocation"}, Value: "Detection.xsd"},
+	}, ai.Attrs)
+
+	xmlBytes, err := xml.Marshal(&ai)
+	s.Require().NoError(err)
+	s.Require().Equal(string(vendor), string(xmlBytes))
+}
+
+func (s *ApplicationInfoTestSuite) TestUnmarshalXMLMsiInfo() {
+	in, err := os.ReadFile(filepath.Join("testdata", "detection", "1.8.4.0-msi.xml"))
+	s.Require().NoError(err)
+
+	var ai ApplicationInfo
+	s.Require().NoError(xml.Unmarshal(in, &ai))
+
+	s.Require().NotNil(ai.MsiInfo)
+	s.Require().Equal("{23170F69-40C1-2702-2301-000001000000}", ai.MsiInfo.MsiProductCode)
+	s.Require().Equal("23.01.00.0", ai.MsiInfo.MsiProductVersion)
+	s.Require().Equal("Igor Pavlov", ai.MsiInfo.MsiPublisher)
+	s.Require().Empty(ai.MsiInfo.Extra)
+}
+
+func (s *ApplicationInfoTestSuite) TestVersion() {
+	ai := &ApplicationInfo{}
+	s.Require().Empty(ai.Version())
+
+	ai.MsiInfo = &MsiInfo{MsiProductVersion: "23.01.00.0"}
+	s.Require().Equal("23.01.00.0", ai.Version())
+
+	ai.Installer = &installer.Info{Msix: &installer.MsixInfo{Version: "1.2.3.0"}}
+	s.Require().Equal("1.2.3.0", ai.Version())
+
+	ai.Project = &Project{Version: "2.0"}
+	s.Require().Equal("2.0", ai.Version())
+}
+
+func (s *ApplicationInfoTestSuite) TestValidateDetectionXML() {
+	valid, err := os.ReadFile(filepath.Join("testdata", "detection", "1.8.4.0-exe.xml"))
+	s.Require().NoError(err)
+	s.Require().NoError(ValidateDetectionXML(bytes.NewReader(valid), true))
+
+	invalid := strings.NewReplacer(
+		`ToolVersion="1.8.4.0"`, `ToolVersion="latest"`,
+		`<UnencryptedContentSize>0</UnencryptedContentSize>`, `<UnencryptedContentSize>-1</UnencryptedContentSize>`,
+		`<ProfileIdentifier>ProfileVersion1</ProfileIdentifier>`, `<ProfileIdentifier>ProfileVersion2</ProfileIdentifier>`,
+		`<SetupFile>test</SetupFile>`, ``,
+	).Replace(string(data))
+
+	err = ValidateDetectionXML(strings.NewReader(invalid), false)
+	s.Require().Error(err)
+
+	var validationErrors ValidationErrors
+	s.Require().ErrorAs(err, &validationErrors)
+
+	paths := make([]string, 0, len(validationErrors))
+	for _, v := range validationErrors {
+		paths = append(paths, v.Path)
+	}
+
+	s.Require().ElementsMatch([]string{
+		"/ApplicationInfo/@ToolVersion",
+		"/ApplicationInfo/UnencryptedContentSize",
+		"/ApplicationInfo/SetupFile",
+		"/ApplicationInfo/EncryptionInfo/EncryptionKey",
+		"/ApplicationInfo/EncryptionInfo/MacKey",
+		"/ApplicationInfo/EncryptionInfo/InitializationVector",
+		"/ApplicationInfo/EncryptionInfo/Mac",
+		"/ApplicationInfo/EncryptionInfo/ProfileIdentifier",
+		"/ApplicationInfo/EncryptionInfo/FileDigest",
+	}, paths)
+}
@@ -0,0 +1,128 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrBaseMismatch is returned when a file read from the base package does not
+// match its index.
+var ErrBaseMismatch = errors.New("base package does not match its index")
+
+// BaseFS is a view on a source that reads the files unchanged since a base
+// package was built from the base package instead. This saves reading large
+// installers again from slow network shares when only scripts changed.
+//
+// A file is unchanged if its size and modification time in the source match
+// the index of the base package. Directories are listed from the source, so
+// added and removed files are picked up as usual.
+type BaseFS struct {
+	source fs.FS
+	base   *Package
+	files  map[string]IndexFile
+
+	mu     sync.Mutex
+	reused map[string]int64
+}
+
+// NewBaseFS returns a view on the source that reuses the files of the base
+// package. The index must have been written for the base package.
+func NewBaseFS(source fs.FS, base *Package, index *Index) (*BaseFS, error) {
+	if index.FileDigest != hex.EncodeToString(base.ApplicationInfo.EncryptionInfo.FileDigest) {
+		return nil, errors.New("index does not belong to the base package")
+	}
+
+	files := make(map[string]IndexFile, len(index.Files))
+	for _, f := range index.Files {
+		files[f.Name] = f
+	}
+
+	return &BaseFS{source: source, base: base, files: files, reused: map[string]int64{}}, nil
+}
+
+func (b *BaseFS) Open(name string) (fs.File, error) {
+	indexed, ok := b.files[name]
+	if !ok {
+		return b.source.Open(name)
+	}
+
+	info, err := fs.Stat(b.source, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Mode().IsRegular() || info.Size() != indexed.Size || !info.ModTime().Equal(indexed.Modified) {
+		return b.source.Open(name)
+	}
+
+	rc, err := b.base.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return b.source.Open(name)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s in base package", name)
+	}
+
+	b.mu.Lock()
+	b.reused[name] = indexed.Size
+	b.mu.Unlock()
+
+	return &baseFile{ReadCloser: rc, info: info, sha256: indexed.SHA256, h: sha256.New()}, nil
+}
+
+func (b *BaseFS) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(b.source, name)
+}
+
+func (b *BaseFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	return fs.ReadDir(b.source, name)
+}
+
+func (b *BaseFS) String() string {
+	return fmt.Sprint(b.source)
+}
+
+// Reused returns the number and total size of the files read from the base
+// package.
+func (b *BaseFS) Reused() (int, int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var size int64
+	for _, n := range b.reused {
+		size += n
+	}
+
+	return len(b.reused), size
+}
+
+// baseFile is a file of the base package with the info of the source file. Its
+// content is checked against the index once it has been read completely.
+type baseFile struct {
+	io.ReadCloser
+	info   fs.FileInfo
+	sha256 string
+	h      hash.Hash
+}
+
+func (f *baseFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+func (f *baseFile) Read(p []byte) (int, error) {
+	n, err := f.ReadCloser.Read(p)
+	f.h.Write(p[:n])
+
+	if err == io.EOF && hex.EncodeToString(f.h.Sum(nil)) != f.sha256 {
+		return n, errors.Wrapf(ErrBaseMismatch, "%s", f.info.Name())
+	}
+
+	return n, err
+}
@@ -0,0 +1,56 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// treeDigest computes the digest of the files added to an archive. It is the
+// SHA-256 over one "<sha256>  <name>\n" line per file, sorted by name, so it
+// does not depend on timestamps or the archive layout.
+type treeDigest struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (t *treeDigest) Visit(name string, _ fs.FileInfo) (io.WriteCloser, error) {
+	return &treeDigestFile{tree: t, name: name, h: sha256.New()}, nil
+}
+
+func (t *treeDigest) Sum() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := append([]string(nil), t.lines...)
+	sort.Slice(lines, func(i, j int) bool { return lines[i][2*sha256.Size+2:] < lines[j][2*sha256.Size+2:] })
+
+	sum := sha256.Sum256([]byte(strings.Join(lines, "")))
+
+	return hex.EncodeToString(sum[:])
+}
+
+type treeDigestFile struct {
+	tree *treeDigest
+	name string
+	h    hash.Hash
+}
+
+func (f *treeDigestFile) Write(p []byte) (int, error) {
+	return f.h.Write(p)
+}
+
+func (f *treeDigestFile) Close() error {
+	f.tree.mu.Lock()
+	defer f.tree.mu.Unlock()
+
+	f.tree.lines = append(f.tree.lines, fmt.Sprintf("%x  %s\n", f.h.Sum(nil), f.name))
+
+	return nil
+}
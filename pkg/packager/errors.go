@@ -0,0 +1,44 @@
+package packager
+
+import (
+	"bytes"
+	"content-prep/pkg/cryptostream"
+
+	"github.com/pkg/errors"
+)
+
+// Errors returned when a package does not match the claims of its Detection.xml.
+var (
+	ErrHMACMismatch           = cryptostream.ErrHMACMismatch
+	ErrDigestMismatch         = errors.New("file digest mismatch")
+	ErrSizeMismatch           = errors.New("unencrypted content size mismatch")
+	ErrUnknownProfile         = errors.New("unknown encryption profile")
+	ErrUnknownDigestAlgorithm = errors.New("unknown file digest algorithm")
+)
+
+// validate checks that the encryption info describes a profile this package can handle.
+func (i *EncryptionInfo) validate() error {
+	if i.ProfileIdentifier != ProfileVersion1 {
+		return errors.Wrapf(ErrUnknownProfile, "%q", i.ProfileIdentifier)
+	}
+
+	if i.FileDigestAlgorithm != FileDigestAlgorithmSHA256 {
+		return errors.Wrapf(ErrUnknownDigestAlgorithm, "%q", i.FileDigestAlgorithm)
+	}
+
+	return nil
+}
+
+// verifyContent compares the size and digest of the decrypted content against
+// the values recorded in the application info.
+func (a *ApplicationInfo) verifyContent(size int64, digest []byte) error {
+	if size != a.UnencryptedContentSize {
+		return errors.Wrapf(ErrSizeMismatch, "expected %d bytes, got %d", a.UnencryptedContentSize, size)
+	}
+
+	if !bytes.Equal(digest, a.EncryptionInfo.FileDigest) {
+		return errors.Wrapf(ErrDigestMismatch, "expected %x, got %x", a.EncryptionInfo.FileDigest, digest)
+	}
+
+	return nil
+}
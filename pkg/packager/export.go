@@ -0,0 +1,266 @@
+package packager
+
+import (
+	"content-prep/pkg/cryptostream"
+	"content-prep/pkg/installer"
+	"content-prep/pkg/sbom"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/xml"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// Formats the package metadata can be exported in.
+const (
+	MetadataFormatJSON   = "json"
+	MetadataFormatGraph  = "graph"
+	MetadataFormatXML    = "xml"
+	MetadataFormatSealed = "sealed"
+)
+
+const (
+	graphWin32LobAppType          = "#microsoft.graph.win32LobApp"
+	graphContentFileType          = "#microsoft.graph.mobileAppContentFile"
+	graphFileEncryptionInfoType   = "microsoft.graph.fileEncryptionInfo"
+	sealedMetadataAlgorithmAESGCM = "A256GCM"
+)
+
+// GraphMetadata holds the values needed to upload a package through Microsoft Graph.
+type GraphMetadata struct {
+	App                GraphWin32LobApp        `json:"app"`
+	ContentFile        GraphContentFile        `json:"contentFile"`
+	FileEncryptionInfo GraphFileEncryptionInfo `json:"fileEncryptionInfo"`
+
+	// Installer and Project are set for packages created by this tool.
+	Installer *installer.Info `json:"installer,omitempty"`
+	Project   *Project        `json:"project,omitempty"`
+}
+
+// GraphWin32LobApp is the subset of a Graph win32LobApp known from the package.
+type GraphWin32LobApp struct {
+	ODataType            string `json:"@odata.type"`
+	DisplayName          string `json:"displayName"`
+	FileName             string `json:"fileName"`
+	SetupFilePath        string `json:"setupFilePath"`
+	Publisher            string `json:"publisher,omitempty"`
+	DisplayVersion       string `json:"displayVersion,omitempty"`
+	InstallCommandLine   string `json:"installCommandLine,omitempty"`
+	UninstallCommandLine string `json:"uninstallCommandLine,omitempty"`
+}
+
+// GraphContentFile is the Graph mobileAppContentFile of the encrypted content.
+type GraphContentFile struct {
+	ODataType     string `json:"@odata.type"`
+	Name          string `json:"name"`
+	Size          int64  `json:"size"`
+	SizeEncrypted int64  `json:"sizeEncrypted"`
+	IsDependency  bool   `json:"isDependency"`
+}
+
+// GraphFileEncryptionInfo has the exact shape of Graph's fileEncryptionInfo,
+// byte fields are encoded as base64.
+type GraphFileEncryptionInfo struct {
+	ODataType            string `json:"@odata.type"`
+	EncryptionKey        []byte `json:"encryptionKey"`
+	InitializationVector []byte `json:"initializationVector"`
+	Mac                  []byte `json:"mac"`
+	MacKey               []byte `json:"macKey"`
+	ProfileIdentifier    string `json:"profileIdentifier"`
+	FileDigest           []byte `json:"fileDigest"`
+	FileDigestAlgorithm  string `json:"fileDigestAlgorithm"`
+}
+
+type sealedMetadata struct {
+	Algorithm  string `json:"alg"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// GraphMetadata converts the application info into the Graph upload payloads.
+func (a *ApplicationInfo) GraphMetadata() *GraphMetadata {
+	metadata := &GraphMetadata{
+		App: GraphWin32LobApp{
+			ODataType:     graphWin32LobAppType,
+			DisplayName:   a.Name,
+			FileName:      a.FileName,
+			SetupFilePath: a.SetupFile,
+		},
+		ContentFile: GraphContentFile{
+			ODataType:     graphContentFileType,
+			Name:          a.FileName,
+			Size:          a.UnencryptedContentSize,
+			SizeEncrypted: a.UnencryptedContentSize + int64(cryptostream.HeaderSize),
+		},
+		FileEncryptionInfo: GraphFileEncryptionInfo{
+			ODataType:            graphFileEncryptionInfoType,
+			EncryptionKey:        a.EncryptionInfo.EncryptionKey,
+			InitializationVector: a.EncryptionInfo.InitializationVector,
+			Mac:                  a.EncryptionInfo.Mac,
+			MacKey:               a.EncryptionInfo.MACKey,
+			ProfileIdentifier:    a.EncryptionInfo.ProfileIdentifier,
+			FileDigest:           a.EncryptionInfo.FileDigest,
+			FileDigestAlgorithm:  a.EncryptionInfo.FileDigestAlgorithm,
+		},
+	}
+
+	if a.Installer != nil {
+		metadata.Installer = a.Installer
+		metadata.App.InstallCommandLine = a.Installer.InstallCommandLine
+		metadata.App.UninstallCommandLine = a.Installer.UninstallCommandLine
+	}
+
+	if a.Project != nil {
+		metadata.Project = a.Project
+		metadata.App.Publisher = a.Project.Vendor
+		metadata.App.DisplayVersion = a.Project.Version
+	}
+
+	return metadata
+}
+
+// ExportGraphJSON writes the Graph upload payloads of the package.
+func ExportGraphJSON(w io.Writer, ai *ApplicationInfo) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(ai.GraphMetadata())
+}
+
+// ExportDetectionXML writes a standalone Detection.xml.
+func ExportDetectionXML(w io.Writer, ai *ApplicationInfo) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(ai); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+// ExportSealed writes the Detection.xml encrypted at rest with AES-256-GCM
+// using the given key, so the sidecar can be stored next to the package
+// without exposing its keys.
+func ExportSealed(w io.Writer, ai *ApplicationInfo, key []byte) error {
+	data, err := xml.Marshal(ai)
+	if err != nil {
+		return err
+	}
+
+	gcm, err := newMetadataGCM(key)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(sealedMetadata{
+		Algorithm:  sealedMetadataAlgorithmAESGCM,
+		Nonce:      nonce,
+		Ciphertext: gcm.Seal(nil, nonce, data, []byte(sealedMetadataAlgorithmAESGCM)),
+	})
+}
+
+// OpenSealed reads metadata written by ExportSealed.
+func OpenSealed(r io.Reader, key []byte) (*ApplicationInfo, error) {
+	var sealed sealedMetadata
+	if err := json.NewDecoder(r).Decode(&sealed); err != nil {
+		return nil, errors.Wrapf(err, "failed to read sealed metadata")
+	}
+
+	if sealed.Algorithm != sealedMetadataAlgorithmAESGCM {
+		return nil, errors.Errorf("unsupported sealed metadata algorithm %q", sealed.Algorithm)
+	}
+
+	gcm, err := newMetadataGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed.Nonce) != gcm.NonceSize() {
+		return nil, errors.New("invalid sealed metadata nonce")
+	}
+
+	data, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, []byte(sealed.Algorithm))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decrypt sealed metadata")
+	}
+
+	ai := &ApplicationInfo{}
+	if err := xml.Unmarshal(data, ai); err != nil {
+		return nil, errors.Wrapf(err, "failed to read application info")
+	}
+
+	return ai, nil
+}
+
+// ExportMetadata writes the metadata in the given format. The key is only used
+// by the sealed format.
+func ExportMetadata(w io.Writer, ai *ApplicationInfo, format string, key []byte) error {
+	switch format {
+	case MetadataFormatJSON, MetadataFormatGraph:
+		return ExportGraphJSON(w, ai)
+	case MetadataFormatXML:
+		return ExportDetectionXML(w, ai)
+	case MetadataFormatSealed:
+		return ExportSealed(w, ai, key)
+	default:
+		return errors.Errorf("unknown metadata format %q", format)
+	}
+}
+
+// MetadataFileSuffix returns the suffix of the sidecar file for the given format.
+func MetadataFileSuffix(format string) string {
+	switch format {
+	case MetadataFormatXML:
+		return ".detection.xml"
+	case MetadataFormatSealed:
+		return ".detection.sealed.json"
+	default:
+		return ".graph.json"
+	}
+}
+
+func newMetadataGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.New("invalid metadata key length, expected 32 bytes")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// NewSBOM returns the bill of materials of the package files. The version and
+// publisher of a detected project or MSIX package take precedence over those
+// read from the setup file.
+func NewSBOM(a *ApplicationInfo, files []sbom.File) *sbom.Document {
+	d := sbom.NewDocument(a.Name, a.SetupFile, files)
+
+	switch {
+	case a.Project != nil && a.Project.Version != "":
+		d.Version, d.Publisher = a.Project.Version, a.Project.Vendor
+	case a.Installer != nil && a.Installer.Msix != nil:
+		d.Version, d.Publisher = a.Installer.Msix.Version, a.Installer.Msix.PublisherDisplayName
+	}
+
+	return d
+}
@@ -0,0 +1,121 @@
+package packager
+
+import (
+	"bytes"
+	"content-prep/pkg/installer"
+	"content-prep/pkg/sbom"
+	"encoding/json"
+	"encoding/xml"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestExportTestSuite(t *testing.T) {
+	suite.Run(t, new(ExportTestSuite))
+}
+
+type ExportTestSuite struct {
+	suite.Suite
+
+	ai *ApplicationInfo
+}
+
+func (s *ExportTestSuite) SetupTest() {
+	s.ai = &ApplicationInfo{}
+	s.Require().NoError(xml.Unmarshal(data, s.ai))
+	s.ai.UnencryptedContentSize = 100
+}
+
+func (s *ExportTestSuite) TestExportGraphJSON() {
+	buf := &bytes.Buffer{}
+	s.Require().NoError(ExportGraphJSON(buf, s.ai))
+
+	var payload map[string]map[string]any
+	s.Require().NoError(json.Unmarshal(buf.Bytes(), &payload))
+
+	info := payload["fileEncryptionInfo"]
+	s.Require().Equal("microsoft.graph.fileEncryptionInfo", info["@odata.type"])
+	s.Require().Equal("dGVzdA==", info["encryptionKey"])
+	s.Require().Equal("dGVzdA==", info["macKey"])
+	s.Require().Equal("dGVzdA==", info["initializationVector"])
+	s.Require().Equal("dGVzdA==", info["mac"])
+	s.Require().Equal("dGVzdA==", info["fileDigest"])
+	s.Require().Equal("ProfileVersion1", info["profileIdentifier"])
+	s.Require().Equal("SHA256", info["fileDigestAlgorithm"])
+
+	contentFile := payload["contentFile"]
+	s.Require().Equal("test", contentFile["name"])
+	s.Require().EqualValues(100, contentFile["size"])
+	s.Require().EqualValues(148, contentFile["sizeEncrypted"])
+
+	s.Require().Equal("test", payload["app"]["setupFilePath"])
+	s.Require().NotContains(payload, "installer")
+	s.Require().NotContains(payload["app"], "installCommandLine")
+}
+
+func (s *ExportTestSuite) TestExportGraphJSONInstaller() {
+	s.ai.Installer = &installer.Info{
+		Type:                 installer.TypeMSI,
+		SetupFile:            "setup.msi",
+		InstallCommandLine:   `msiexec /i "setup.msi" /qn /norestart`,
+		UninstallCommandLine: `msiexec /x "setup.msi" /qn /norestart`,
+	}
+
+	buf := &bytes.Buffer{}
+	s.Require().NoError(ExportGraphJSON(buf, s.ai))
+
+	var payload map[string]map[string]any
+	s.Require().NoError(json.Unmarshal(buf.Bytes(), &payload))
+
+	s.Require().Equal("msi", payload["installer"]["type"])
+	s.Require().Equal(s.ai.Installer.InstallCommandLine, payload["app"]["installCommandLine"])
+	s.Require().Equal(s.ai.Installer.UninstallCommandLine, payload["app"]["uninstallCommandLine"])
+}
+
+func (s *ExportTestSuite) TestExportDetectionXML() {
+	buf := &bytes.Buffer{}
+	s.Require().NoError(ExportDetectionXML(buf, s.ai))
+	s.Require().True(strings.HasPrefix(buf.String(), xml.Header))
+
+	ai := &ApplicationInfo{}
+	s.Require().NoError(xml.Unmarshal(buf.Bytes(), ai))
+	s.Require().Equal(s.ai.EncryptionInfo, ai.EncryptionInfo)
+	s.Require().Equal(int64(100), ai.UnencryptedContentSize)
+}
+
+func (s *ExportTestSuite) TestExportSealed() {
+	key := []byte(strings.Repeat("k", 32))
+
+	buf := &bytes.Buffer{}
+	s.Require().NoError(ExportSealed(buf, s.ai, key))
+	s.Require().NotContains(buf.String(), "dGVzdA==")
+
+	ai, err := OpenSealed(bytes.NewReader(buf.Bytes()), key)
+	s.Require().NoError(err)
+	s.Require().Equal(s.ai.EncryptionInfo, ai.EncryptionInfo)
+
+	_, err = OpenSealed(bytes.NewReader(buf.Bytes()), []byte(strings.Repeat("x", 32)))
+	s.Require().Error(err)
+
+	s.Require().Error(ExportSealed(buf, s.ai, []byte("short")))
+}
+
+func (s *ExportTestSuite) TestNewSBOM() {
+	files := []sbom.File{{Name: s.ai.SetupFile, Version: &installer.VersionInfo{ProductVersion: "1.0.0", Publisher: "Setup Ltd"}}}
+
+	d := NewSBOM(s.ai, files)
+	s.Require().Equal("1.0.0", d.Version)
+	s.Require().Equal("Setup Ltd", d.Publisher)
+
+	s.ai.Installer = &installer.Info{Msix: &installer.MsixInfo{Version: "2.0.0.0", PublisherDisplayName: "Msix Ltd"}}
+	d = NewSBOM(s.ai, files)
+	s.Require().Equal("2.0.0.0", d.Version)
+	s.Require().Equal("Msix Ltd", d.Publisher)
+
+	s.ai.Project = &Project{Type: ProjectTypePSADT, Vendor: "Project Ltd", Version: "3.0"}
+	d = NewSBOM(s.ai, files)
+	s.Require().Equal("3.0", d.Version)
+	s.Require().Equal("Project Ltd", d.Publisher)
+}
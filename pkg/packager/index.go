@@ -0,0 +1,93 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// IndexFileSuffix is appended to the package name for the index written next
+// to the package.
+const IndexFileSuffix = ".index.json"
+
+// Index records the files of a package with the size and modification time
+// they had in the source, so a later build can tell unchanged files apart
+// without reading them, see NewBaseFS.
+type Index struct {
+	// FileDigest is the hex encoded SHA-256 of the inner archive, as recorded
+	// in Detection.xml. It ties the index to its package.
+	FileDigest string      `json:"fileDigest"`
+	Files      []IndexFile `json:"files"`
+}
+
+// IndexFile is a file of the package.
+type IndexFile struct {
+	Name     string    `json:"name"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	SHA256   string    `json:"sha256"`
+}
+
+// LoadIndex reads an index written next to a package.
+func LoadIndex(indexFilePath string) (*Index, error) {
+	data, err := os.ReadFile(indexFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read index")
+	}
+
+	var index Index
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode index %s", indexFilePath)
+	}
+
+	return &index, nil
+}
+
+// indexer records the files added to an archive.
+type indexer struct {
+	mu    sync.Mutex
+	files []IndexFile
+}
+
+func (i *indexer) Visit(name string, info fs.FileInfo) (io.WriteCloser, error) {
+	return &indexerFile{indexer: i, file: IndexFile{Name: name, Size: info.Size(), Modified: info.ModTime()}, h: sha256.New()}, nil
+}
+
+func (i *indexer) index(fileDigest []byte) *Index {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	files := append([]IndexFile(nil), i.files...)
+	sort.Slice(files, func(a, b int) bool { return files[a].Name < files[b].Name })
+
+	return &Index{FileDigest: hex.EncodeToString(fileDigest), Files: files}
+}
+
+type indexerFile struct {
+	indexer *indexer
+	file    IndexFile
+	h       hash.Hash
+}
+
+func (f *indexerFile) Write(p []byte) (int, error) {
+	return f.h.Write(p)
+}
+
+func (f *indexerFile) Close() error {
+	f.indexer.mu.Lock()
+	defer f.indexer.mu.Unlock()
+
+	f.file.SHA256 = hex.EncodeToString(f.h.Sum(nil))
+	f.indexer.files = append(f.indexer.files, f.file)
+
+	return nil
+}
@@ -1,6 +1,11 @@
 package packager
 
-import "crypto/rand"
+import (
+	"content-prep/pkg/cryptostream"
+	"context"
+	"crypto/rand"
+	"time"
+)
 
 var _ KeyGenerator = &defaultKeyGenerator{}
 
@@ -15,3 +20,61 @@ func (g defaultKeyGenerator) GenerateKey(length int) ([]byte, error) {
 
 	return key, nil
 }
+
+// KeyProvider supplies the key material used to encrypt a single package.
+type KeyProvider interface {
+	ProvideKeys(ctx context.Context) (*PackageKeys, error)
+}
+
+// PackageKeys is the key material of a single package.
+type PackageKeys struct {
+	EncryptionKey        []byte
+	MACKey               []byte
+	InitializationVector []byte
+
+	// Escrow describes where the keys have been escrowed, nil if they only
+	// exist inside the Detection.xml of the package.
+	Escrow *EscrowRecord
+}
+
+// EscrowRecord allows recovering the keys of a package from the provider that
+// issued them. It never contains the plaintext keys.
+type EscrowRecord struct {
+	Provider    string    `json:"provider"`
+	KeyID       string    `json:"keyId"`
+	WrappingKey string    `json:"wrappingKey,omitempty"`
+	WrappedKeys []byte    `json:"wrappedKeys,omitempty"`
+	Package     string    `json:"package,omitempty"`
+	FileDigest  []byte    `json:"fileDigest,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+var _ KeyProvider = &generatorKeyProvider{}
+
+// generatorKeyProvider creates ephemeral keys that are not escrowed anywhere.
+type generatorKeyProvider struct {
+	keygen KeyGenerator
+}
+
+func (g generatorKeyProvider) ProvideKeys(_ context.Context) (*PackageKeys, error) {
+	aesKey, err := g.keygen.GenerateKey(32)
+	if err != nil {
+		return nil, err
+	}
+
+	iv, err := g.keygen.GenerateKey(cryptostream.IvSize)
+	if err != nil {
+		return nil, err
+	}
+
+	hmacKey, err := g.keygen.GenerateKey(cryptostream.HMACKeySize)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PackageKeys{
+		EncryptionKey:        aesKey,
+		MACKey:               hmacKey,
+		InitializationVector: iv,
+	}, nil
+}
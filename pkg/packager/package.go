@@ -0,0 +1,260 @@
+package packager
+
+import (
+	"archive/zip"
+	"content-prep/pkg/cryptostream"
+	"content-prep/pkg/installer"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/zipper"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/xml"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	detectionFilePath = "IntuneWinPackage/Metadata/Detection.xml"
+	contentsFolder    = "IntuneWinPackage/Contents"
+)
+
+// Package is a read-only view on an existing .intunewin package. The inner
+// archive is decrypted on demand, so listing or reading single entries does not
+// require decrypting the whole package.
+//
+// Reading through a Package does not verify the HMAC of the encrypted content.
+type Package struct {
+	ApplicationInfo ApplicationInfo
+
+	// DetectionXML is the Detection.xml of the package as stored.
+	DetectionXML []byte
+
+	encrypted *io.SectionReader
+	decrypted *cryptostream.ReaderAt
+	contents  *zip.Reader
+	closers   []io.Closer
+}
+
+// Entry describes a single file inside the inner archive of a package.
+type Entry struct {
+	Name     string
+	Size     int64
+	CRC32    uint32
+	Modified time.Time
+}
+
+func (p *packager) OpenPackage(ctx context.Context, r io.ReaderAt, size int64) (*Package, error) {
+	log := logger.FromContext(ctx).With("component", "packager", "action", "open")
+
+	outer, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read package archive")
+	}
+
+	pkg := &Package{}
+
+	detectionFile, err := outer.Open(detectionFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open detection file")
+	}
+	defer detectionFile.Close()
+
+	pkg.DetectionXML, err = io.ReadAll(detectionFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read detection file")
+	}
+
+	if err := xml.Unmarshal(pkg.DetectionXML, &pkg.ApplicationInfo); err != nil {
+		return nil, errors.Wrapf(err, "failed to read application info")
+	}
+
+	if err := pkg.ApplicationInfo.EncryptionInfo.validate(); err != nil {
+		return nil, err
+	}
+
+	encryptedFile, err := findFile(outer, path.Join(contentsFolder, pkg.ApplicationInfo.FileName))
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted, closer, err := openRandomAccess(r, encryptedFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open encrypted package file")
+	}
+	if closer != nil {
+		pkg.closers = append(pkg.closers, closer)
+	}
+	log.Debug("opened encrypted package file", "name", encryptedFile.Name, "size", encrypted.Size())
+
+	pkg.encrypted = encrypted
+
+	encryptionInfo := pkg.ApplicationInfo.EncryptionInfo
+	decrypted, err := cryptostream.NewReaderAt(encrypted, encrypted.Size(), encryptionInfo.EncryptionKey, encryptionInfo.InitializationVector)
+	if err != nil {
+		_ = pkg.Close()
+		return nil, errors.Wrapf(err, "failed to set up decryption")
+	}
+
+	pkg.decrypted = decrypted
+
+	pkg.contents, err = zip.NewReader(decrypted, decrypted.Size())
+	if err != nil {
+		_ = pkg.Close()
+		return nil, errors.Wrapf(err, "failed to read decrypted package archive")
+	}
+
+	return pkg, nil
+}
+
+// Entries lists all files of the inner archive.
+func (p *Package) Entries() []Entry {
+	entries := make([]Entry, 0, len(p.contents.File))
+	for _, f := range p.contents.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+
+		entries = append(entries, Entry{
+			Name:     f.Name,
+			Size:     int64(f.UncompressedSize64),
+			CRC32:    f.CRC32,
+			Modified: f.Modified,
+		})
+	}
+
+	return entries
+}
+
+// Open decrypts and returns the contents of a single entry of the inner archive.
+func (p *Package) Open(name string) (io.ReadCloser, error) {
+	f, err := findFile(p.contents, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return f.Open()
+}
+
+// FS returns the contents of the inner archive. Files are decrypted while they
+// are read.
+// DetectContents detects the installer and project in the package contents,
+// which Detection.xml does not record, as CreatePackage did when the package
+// was built, so ApplicationInfo.Version knows their versions.
+func (p *packager) DetectContents(ctx context.Context, pkg *Package) error {
+	log := logger.FromContext(ctx).With("component", "packager", "action", "detect")
+
+	info := &pkg.ApplicationInfo
+
+	installerInfo, err := installer.Detect(pkg.FS(), info.SetupFile)
+	if err != nil {
+		// The setup file may have been packaged from a subfolder, of which
+		// Detection.xml only records the name.
+		log.Debug("failed to detect installer of package", "setupFile", info.SetupFile, "error", err)
+	}
+	info.Installer = installerInfo
+
+	info.Project, err = p.DetectProject(ctx, pkg.FS())
+
+	return err
+}
+
+func (p *Package) FS() fs.FS {
+	return p.contents
+}
+
+// Verify reads the whole package and checks it against its Detection.xml: the
+// MAC in the file header, the HMAC of the encrypted content as well as the size
+// and digest of the decrypted content.
+func (p *Package) Verify() error {
+	encryptionInfo := p.ApplicationInfo.EncryptionInfo
+
+	header := make([]byte, sha256.Size)
+	if _, err := p.encrypted.ReadAt(header, 0); err != nil {
+		return errors.Wrapf(err, "failed to read HMAC from encrypted package file")
+	}
+
+	if !hmac.Equal(header, encryptionInfo.Mac) {
+		return errors.Wrap(ErrHMACMismatch, "MAC in detection file does not match encrypted package file")
+	}
+
+	if err := cryptostream.VerifyHMAC(p.encrypted, p.encrypted.Size(), encryptionInfo.MACKey); err != nil {
+		return err
+	}
+
+	digester := sha256.New()
+	n, err := io.Copy(digester, io.NewSectionReader(p.decrypted, 0, p.decrypted.Size()))
+	if err != nil {
+		return errors.Wrapf(err, "failed to hash decrypted package file")
+	}
+
+	return p.ApplicationInfo.verifyContent(n, digester.Sum(nil))
+}
+
+func (p *Package) Close() error {
+	var err error
+	for _, c := range p.closers {
+		if cErr := c.Close(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	p.closers = nil
+
+	return err
+}
+
+func findFile(r *zip.Reader, name string) (*zip.File, error) {
+	for _, f := range r.File {
+		if f.Name == name {
+			return f, nil
+		}
+	}
+
+	return nil, errors.Wrapf(fs.ErrNotExist, "%s", name)
+}
+
+// openRandomAccess returns a random access reader over the contents of f. Stored
+// entries are read in place, compressed entries are spooled into a temporary file.
+func openRandomAccess(r io.ReaderAt, f *zip.File) (*io.SectionReader, io.Closer, error) {
+	if f.Method == zip.Store {
+		section, err := zipper.StoredSection(r, f)
+
+		return section, nil, err
+	}
+
+	rc, err := f.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rc.Close()
+
+	tmp, err := os.CreateTemp(os.TempDir(), "content-prep-package-*")
+	if err != nil {
+		return nil, nil, err
+	}
+
+	closer := &tempFile{File: tmp}
+
+	n, err := io.Copy(tmp, rc)
+	if err != nil {
+		_ = closer.Close()
+		return nil, nil, err
+	}
+
+	return io.NewSectionReader(tmp, 0, n), closer, nil
+}
+
+type tempFile struct {
+	*os.File
+}
+
+func (t *tempFile) Close() error {
+	_ = t.File.Close()
+
+	return os.Remove(t.Name())
+}
@@ -1,24 +1,29 @@
 package packager
 
 import (
+	"content-prep/pkg/authenticode"
 	"content-prep/pkg/cryptostream"
+	"content-prep/pkg/installer"
 	"content-prep/pkg/logger"
+	"content-prep/pkg/sbom"
+	"content-prep/pkg/scanner"
 	"content-prep/pkg/zipper"
 	"context"
+	"crypto/hmac"
 	"crypto/sha256"
+	"encoding/hex"
 	"encoding/xml"
 	"io"
 	"io/fs"
 	"os"
 	"path"
 	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
 
-var Default = &packager{
-	keygen: defaultKeyGenerator{},
-}
+var Default = New()
 
 type KeyGenerator interface {
 	GenerateKey(length int) ([]byte, error)
@@ -26,6 +31,156 @@ type KeyGenerator interface {
 
 type packager struct {
 	keygen KeyGenerator
+	keys   KeyProvider
+
+	compression uint16
+	exclude     []string
+	detectors   []ProjectDetector
+
+	project         *Project
+	projectDetected bool
+
+	signatures      *authenticode.Options
+	signaturePolicy authenticode.Policy
+	scanners        []scanner.Scanner
+	policy          Policy
+	sbom            bool
+	sourceDigest    bool
+	index           bool
+}
+
+type Option func(p *packager)
+
+// WithKeyProvider sources the package keys from the given provider instead of
+// generating ephemeral keys.
+func WithKeyProvider(keys KeyProvider) Option {
+	return func(p *packager) {
+		p.keys = keys
+	}
+}
+
+// WithCompression sets the compression method of the inner archive.
+func WithCompression(method uint16) Option {
+	return func(p *packager) {
+		p.compression = method
+	}
+}
+
+// WithExclude leaves files matching any of the given patterns out of the package.
+func WithExclude(patterns ...string) Option {
+	return func(p *packager) {
+		p.exclude = append(p.exclude, patterns...)
+	}
+}
+
+// WithSignaturePolicy inspects the Authenticode signatures of the binaries in
+// the source and fails when one of them violates the policy.
+func WithSignaturePolicy(policy authenticode.Policy, opts authenticode.Options) Option {
+	return func(p *packager) {
+		p.signatures = &opts
+		p.signaturePolicy = policy
+	}
+}
+
+// WithScanners streams the files of the source to the scanners before they are
+// archived and fails when a scanner detects a threat.
+func WithScanners(scanners ...scanner.Scanner) Option {
+	return func(p *packager) {
+		p.scanners = append(p.scanners, scanners...)
+	}
+}
+
+// Policy checks a package before it is written.
+type Policy interface {
+	// Evaluate returns an error if the files of the source that are not
+	// excluded or the application info violate the policy, and warnings about
+	// violations that do not fail the build.
+	Evaluate(ctx context.Context, source fs.FS, exclude []string, info *ApplicationInfo) ([]string, error)
+}
+
+// WithPolicy evaluates the policy once the application info of a package is
+// known, before anything is written to the output.
+func WithPolicy(policy Policy) Option {
+	return func(p *packager) {
+		p.policy = policy
+	}
+}
+
+// WithSBOM collects a bill of materials of the package contents while they are
+// archived.
+func WithSBOM() Option {
+	return func(p *packager) {
+		p.sbom = true
+	}
+}
+
+// WithSourceDigest computes a digest of the archived source tree, see
+// Result.SourceDigest.
+func WithSourceDigest() Option {
+	return func(p *packager) {
+		p.sourceDigest = true
+	}
+}
+
+// WithIndex records the size, modification time and hash of the archived files,
+// see Result.Index.
+func WithIndex() Option {
+	return func(p *packager) {
+		p.index = true
+	}
+}
+
+func New(opts ...Option) *packager {
+	p := &packager{
+		keygen: defaultKeyGenerator{},
+	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// Result describes a package created by CreatePackage.
+type Result struct {
+	ApplicationInfo *ApplicationInfo
+
+	// Escrow is set when the key provider escrowed the package keys.
+	Escrow *EscrowRecord
+
+	// Signatures are set when the packager inspects signatures, see
+	// WithSignaturePolicy.
+	Signatures []authenticode.Signature
+
+	// Scan is set when the packager scans the source, see WithScanners.
+	Scan *scanner.Report
+
+	// SBOM is set when the packager collects a bill of materials, see WithSBOM.
+	SBOM *sbom.Document
+
+	// PackageDigest is the hex encoded SHA-256 of the package written to the
+	// output.
+	PackageDigest string
+
+	// SourceDigest is set when the packager computes the digest of the source
+	// tree, see WithSourceDigest.
+	SourceDigest string
+
+	// Index is set when the packager indexes the archived files, see
+	// WithIndex.
+	Index *Index
+
+	// Report records the stages, sizes and digests of the build.
+	Report *Report
+}
+
+func (p *packager) keyProvider() KeyProvider {
+	if p.keys != nil {
+		return p.keys
+	}
+
+	return generatorKeyProvider{keygen: p.keygen}
 }
 
 const (
@@ -33,148 +188,377 @@ const (
 	PackageFileExtension = ".intunewin"
 )
 
-func (p *packager) CreatePackage(ctx context.Context, source fs.FS, setupFile string, output io.Writer) error {
+// CreatePackage packages the source with the setup file and writes the
+// .intunewin to the output. Errors are returned as *BuildError, which carries
+// the report of the failed build.
+func (p *packager) CreatePackage(ctx context.Context, source fs.FS, setupFile string, output io.Writer) (*Result, error) {
+	report := &Report{SetupFile: setupFile, Started: time.Now()}
+
+	result, err := p.createPackage(ctx, source, setupFile, output, report)
+	report.finish(err)
+	if err != nil {
+		return nil, &BuildError{Report: report, err: err}
+	}
+
+	result.Report = report
+
+	return result, nil
+}
+
+func (p *packager) createPackage(ctx context.Context, source fs.FS, setupFile string, output io.Writer, report *Report) (*Result, error) {
 	log := logger.FromContext(ctx).With("component", "packager", "action", "create")
 
 	log.Info("creating package", "source", source, "setupFile", setupFile, "output", output)
 
 	tempDirPath, err := os.MkdirTemp(os.TempDir(), "content-prep-packager-*")
 	if err != nil {
-		return errors.Wrapf(err, "failed to create temporary directory")
+		return nil, errors.Wrapf(err, "failed to create temporary directory")
 	}
 	log.Debug("created temporary directory", "path", tempDirPath)
 
 	workDirPath := path.Join(tempDirPath, "IntuneWinPackage")
 	if err := os.Mkdir(workDirPath, os.ModePerm); err != nil {
-		return errors.Wrapf(err, "failed to create work directory")
+		return nil, errors.Wrapf(err, "failed to create work directory")
 	}
 	log.Debug("created work directory", "path", workDirPath)
 
 	contentsFolderPath := path.Join(workDirPath, "Contents")
 	if err := os.Mkdir(contentsFolderPath, os.ModePerm); err != nil {
-		return errors.Wrapf(err, "failed to create 'Contents' folder")
+		return nil, errors.Wrapf(err, "failed to create 'Contents' folder")
 	}
 	log.Debug("created contents folder", "path", contentsFolderPath)
 
 	compressedPackageFilePath := path.Join(contentsFolderPath, packageFileName+".zip")
 	compressedPackageFile, err := os.Create(compressedPackageFilePath)
 	if err != nil {
-		return errors.Wrapf(err, "failed to open compressed package file")
+		return nil, errors.Wrapf(err, "failed to open compressed package file")
 	}
 	defer compressedPackageFile.Close()
 	log.Debug("created compressed package file", "path", compressedPackageFilePath)
 
-	if err := zipper.Zip(source, compressedPackageFile); err != nil {
-		return errors.Wrapf(err, "failed to create compressed package")
+	report.begin(StageDetect)
+
+	if zipper.Excluded(path.Base(setupFile), p.exclude) {
+		return nil, errors.New("setup file must not be excluded from the package")
 	}
-	log.Info("compressed source folder", "source", source, "archive", compressedPackageFilePath)
 
-	encryptedPackageFilePath := path.Join(contentsFolderPath, packageFileName)
-	encryptedPackageFile, err := os.Create(encryptedPackageFilePath)
+	installerInfo, err := installer.Detect(source, setupFile)
 	if err != nil {
-		return errors.Wrapf(err, "failed to open compressed package file")
+		return nil, err
 	}
-	defer encryptedPackageFile.Close()
-	log.Debug("created encrypted package file", "path", encryptedPackageFilePath)
+	log.Info("detected installer", "type", installerInfo.Type, "installCommandLine", installerInfo.InstallCommandLine)
+	report.InstallerType = installerInfo.Type
 
-	aesKey, err := p.keygen.GenerateKey(32)
+	project, err := p.DetectProject(ctx, source)
 	if err != nil {
-		return errors.Wrapf(err, "failed to generate AES key")
+		return nil, err
+	}
+
+	if project != nil {
+		log.Info("detected project", "type", project.Type, "name", project.Name, "version", project.Version)
+		for _, warning := range project.Warnings {
+			log.Warn("incomplete project", "type", project.Type, "warning", warning)
+			report.warn("%s project: %s", project.Type, warning)
+		}
+
+		// The project only describes the package if its entry point is
+		// installed, not another setup file of the source.
+		if project.SetupFile == setupFile {
+			installerInfo.InstallCommandLine = project.InstallCommandLine
+			installerInfo.UninstallCommandLine = project.UninstallCommandLine
+		} else {
+			project = nil
+		}
+	}
+
+	if p.signatures != nil {
+		report.begin(StageSignatures)
 	}
 
-	iv, err := p.keygen.GenerateKey(cryptostream.IvSize)
+	signatures, err := p.checkSignatures(ctx, source)
 	if err != nil {
-		return errors.Wrapf(err, "failed to generate initialization vector")
+		return nil, err
+	}
+
+	for _, signature := range signatures {
+		for _, problem := range signature.Problems {
+			report.warn("%s: %s", signature.File, problem)
+		}
+	}
+
+	if len(p.scanners) > 0 {
+		report.begin(StageScan)
 	}
 
-	hmacKey, err := p.keygen.GenerateKey(cryptostream.HMACKeySize)
+	scan, err := p.scanContent(ctx, source)
 	if err != nil {
-		return errors.Wrapf(err, "failed to generate HMAC key")
+		return nil, err
+	}
+
+	report.begin(StageCompress)
+
+	counter := &contentCounter{}
+	zipOpts := []zipper.Option{zipper.WithMethod(p.compression), zipper.WithExclude(p.exclude...), zipper.WithVisitor(counter)}
+
+	var collector *sbom.Collector
+	if p.sbom {
+		collector = sbom.NewCollector(source)
+		zipOpts = append(zipOpts, zipper.WithVisitor(collector))
+	}
+
+	if scan != nil {
+		zipOpts = append(zipOpts, zipper.WithVisitor(scan.Visitor()))
+	}
+
+	var tree *treeDigest
+	if p.sourceDigest {
+		tree = &treeDigest{}
+		zipOpts = append(zipOpts, zipper.WithVisitor(tree))
+	}
+
+	var files *indexer
+	if p.index {
+		files = &indexer{}
+		zipOpts = append(zipOpts, zipper.WithVisitor(files))
 	}
 
+	if err := zipper.Zip(source, compressedPackageFile, zipOpts...); err != nil {
+		return nil, errors.Wrapf(err, "failed to create compressed package")
+	}
+	log.Info("compressed source folder", "source", source, "archive", compressedPackageFilePath)
+	report.Files, report.BytesIn = counter.files, counter.bytes
+
+	report.begin(StageEncrypt)
+
+	encryptedPackageFilePath := path.Join(contentsFolderPath, packageFileName)
+	encryptedPackageFile, err := os.Create(encryptedPackageFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open compressed package file")
+	}
+	defer encryptedPackageFile.Close()
+	log.Debug("created encrypted package file", "path", encryptedPackageFilePath)
+
+	keys, err := p.keyProvider().ProvideKeys(ctx)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to provide package keys")
+	}
+	aesKey, iv, hmacKey := keys.EncryptionKey, keys.InitializationVector, keys.MACKey
+
 	_, err = compressedPackageFile.Seek(0, io.SeekStart)
 	if err != nil {
-		return errors.Wrapf(err, "failed to seek to start of compressed package file")
+		return nil, errors.Wrapf(err, "failed to seek to start of compressed package file")
 	}
 
 	if err := cryptostream.Encrypt(compressedPackageFile, encryptedPackageFile, aesKey, iv, hmacKey); err != nil {
-		return errors.Wrapf(err, "failed to encrypt compressed package file")
+		return nil, errors.Wrapf(err, "failed to encrypt compressed package file")
 	}
 	log.Debug("encrypted archive", "archive", compressedPackageFilePath, "encrypted", encryptedPackageFilePath)
 
 	mac := make([]byte, sha256.Size)
 	_, err = encryptedPackageFile.Seek(0, io.SeekStart)
 	if err != nil {
-		return errors.Wrapf(err, "failed to seek to start of encrypted package file")
+		return nil, errors.Wrapf(err, "failed to seek to start of encrypted package file")
 	}
 	_, err = encryptedPackageFile.Read(mac)
 	if err != nil {
-		return errors.Wrapf(err, "failed to read HMAC from encrypted package file")
+		return nil, errors.Wrapf(err, "failed to read HMAC from encrypted package file")
 	}
 
 	compressedPackageFileInfo, err := compressedPackageFile.Stat()
 	if err != nil {
-		return errors.Wrapf(err, "failed to get compressed package file info")
+		return nil, errors.Wrapf(err, "failed to get compressed package file info")
 	}
 	log.Debug("got compressed package file info", "size", compressedPackageFileInfo.Size())
 
+	_, err = compressedPackageFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to seek to start of compressed package file")
+	}
+
 	hash := sha256.New()
 	if _, err := io.Copy(hash, compressedPackageFile); err != nil {
-		return errors.Wrapf(err, "failed to hash compressed package file")
+		return nil, errors.Wrapf(err, "failed to hash compressed package file")
 	}
 
 	digest := hash.Sum(nil)
 	log.Debug("generated digest of compressed Package file", "digest", digest)
 
+	encryptedPackageFileInfo, err := encryptedPackageFile.Stat()
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to get encrypted package file info")
+	}
+
+	report.CompressedSize = compressedPackageFileInfo.Size()
+	report.EncryptedSize = encryptedPackageFileInfo.Size()
+	report.FileDigest = hex.EncodeToString(digest)
+
 	setupFileName := strings.Trim(path.Base(setupFile), path.Ext(setupFile))
+	if installerInfo.Msix != nil {
+		setupFileName = installerInfo.Msix.DisplayName
+	}
+	if project != nil && project.Name != "" {
+		setupFileName = project.Name
+	}
 
 	applicationInfo := &ApplicationInfo{
 		FileName:               packageFileName,
 		Name:                   setupFileName,
 		UnencryptedContentSize: compressedPackageFileInfo.Size(),
 		SetupFile:              path.Base(setupFile),
+		Installer:              installerInfo,
+		Project:                project,
 		EncryptionInfo: EncryptionInfo{
 			EncryptionKey:        aesKey,
 			MACKey:               hmacKey,
 			InitializationVector: iv,
 			Mac:                  mac,
-			ProfileIdentifier:    "ProfileVersion1",
+			ProfileIdentifier:    ProfileVersion1,
 			FileDigest:           digest,
-			FileDigestAlgorithm:  "SHA256",
+			FileDigestAlgorithm:  FileDigestAlgorithmSHA256,
 		},
 	}
 
+	report.Name = applicationInfo.Name
+
+	if p.policy != nil {
+		report.begin(StagePolicy)
+
+		warnings, err := p.policy.Evaluate(ctx, source, p.exclude, applicationInfo)
+		report.Warnings = append(report.Warnings, warnings...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	report.begin(StagePackage)
+
 	metadataFolderPath := path.Join(workDirPath, "Metadata")
 	if err := os.Mkdir(metadataFolderPath, os.ModePerm); err != nil {
-		return errors.Wrapf(err, "failed to create 'Metadata' folder")
+		return nil, errors.Wrapf(err, "failed to create 'Metadata' folder")
 	}
 	log.Debug("created metadata folder", "path", metadataFolderPath)
 
 	detectionFilePath := path.Join(metadataFolderPath, "Detection.xml")
 	detectionFile, err := os.Create(detectionFilePath)
 	if err != nil {
-		return errors.Wrapf(err, "failed to create detection file")
+		return nil, errors.Wrapf(err, "failed to create detection file")
 	}
 	defer detectionFile.Close()
 	log.Debug("created detection file", "path", detectionFilePath)
 
 	if err := xml.NewEncoder(detectionFile).Encode(applicationInfo); err != nil {
-		return errors.Wrapf(err, "failed to write application info")
+		return nil, errors.Wrapf(err, "failed to write application info")
 	}
 	log.Debug("wrote application info to detection file")
 
 	if err := os.Remove(compressedPackageFilePath); err != nil {
-		return errors.Wrapf(err, "failed to remove compressed package file")
+		return nil, errors.Wrapf(err, "failed to remove compressed package file")
 	}
 	log.Debug("removed compressed package file", "path", compressedPackageFilePath)
 
 	packageFS := os.DirFS(tempDirPath)
 
-	if err := zipper.Zip(packageFS, output); err != nil {
-		return errors.Wrapf(err, "failed to create output package")
+	outputDigest := sha256.New()
+	outputSize := &countingWriter{}
+	if err := zipper.Zip(packageFS, io.MultiWriter(output, outputDigest, outputSize)); err != nil {
+		return nil, errors.Wrapf(err, "failed to create output package")
 	}
 
-	return nil
+	result := &Result{
+		ApplicationInfo: applicationInfo,
+		Signatures:      signatures,
+		Scan:            scan,
+		PackageDigest:   hex.EncodeToString(outputDigest.Sum(nil)),
+	}
+
+	if tree != nil {
+		result.SourceDigest = tree.Sum()
+	}
+
+	if files != nil {
+		result.Index = files.index(digest)
+	}
+
+	report.PackageSize = outputSize.n
+	report.PackageDigest, report.SourceDigest = result.PackageDigest, result.SourceDigest
+
+	if collector != nil {
+		result.SBOM = NewSBOM(applicationInfo, collector.Files())
+		log.Info("collected bill of materials", "files", len(result.SBOM.Files))
+	}
+
+	if keys.Escrow != nil {
+		result.Escrow = keys.Escrow
+		result.Escrow.Package = applicationInfo.Name
+		result.Escrow.FileDigest = digest
+	}
+
+	return result, nil
+}
+
+// checkSignatures inspects the signatures of the binaries in the source and
+// checks them against the signature policy.
+func (p *packager) checkSignatures(ctx context.Context, source fs.FS) ([]authenticode.Signature, error) {
+	if p.signatures == nil {
+		return nil, nil
+	}
+
+	log := logger.FromContext(ctx).With("component", "packager", "action", "signatures")
+
+	opts := *p.signatures
+	opts.Exclude = append(opts.Exclude, p.exclude...)
+
+	signatures, err := authenticode.Scan(ctx, source, opts)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to inspect signatures")
+	}
+
+	var violations int
+	for i := range signatures {
+		signature := &signatures[i]
+
+		log.Info("inspected signature", "file", signature.File, "signed", signature.Signed, "publisher", signature.Publisher, "thumbprint", signature.Thumbprint, "timestamp", signature.Timestamp, "digestValid", signature.DigestValid, "trusted", signature.Trusted)
+		for _, problem := range signature.Problems {
+			log.Warn("signature problem", "file", signature.File, "problem", problem)
+		}
+
+		if err := p.signaturePolicy.Check(signature); err != nil {
+			log.Error("signature policy violation", "file", signature.File, "error", err)
+			violations++
+		}
+	}
+
+	if violations > 0 {
+		return nil, errors.Wrapf(authenticode.ErrPolicyViolation, "%d of %d binaries violate the signature policy", violations, len(signatures))
+	}
+
+	return signatures, nil
+}
+
+// scanContent streams the files of the source to the scanners. The returned
+// error carries the scan report when a threat was detected, see
+// scanner.DetectionError.
+func (p *packager) scanContent(ctx context.Context, source fs.FS) (*scanner.Report, error) {
+	if len(p.scanners) == 0 {
+		return nil, nil
+	}
+
+	log := logger.FromContext(ctx).With("component", "packager", "action", "scan")
+
+	report, err := scanner.Scan(ctx, source, p.scanners, p.exclude)
+	if report != nil {
+		for _, file := range report.Files {
+			for _, detection := range file.Detections {
+				log.Error("threat detected", "file", file.File, "scanner", detection.Scanner, "threat", detection.Threat)
+			}
+		}
+		log.Info("scanned source", "scanners", report.Scanners, "files", len(report.Files), "detections", report.Detections())
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to scan source")
+	}
+
+	return report, nil
 }
 
 func (p *packager) DecryptPackage(ctx context.Context, packageFile *os.File, destDir string) error {
@@ -212,14 +596,43 @@ func (p *packager) DecryptPackage(ctx context.Context, packageFile *os.File, des
 	}
 	defer decryptedPackageFile.Close()
 
+	if err := applicationInfo.EncryptionInfo.validate(); err != nil {
+		return err
+	}
+
+	header := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(encryptedPackageFile, header); err != nil {
+		return errors.Wrapf(err, "failed to read HMAC from encrypted package file")
+	}
+
+	if !hmac.Equal(header, applicationInfo.EncryptionInfo.Mac) {
+		return errors.Wrap(ErrHMACMismatch, "MAC in detection file does not match encrypted package file")
+	}
+
+	if _, err := encryptedPackageFile.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrapf(err, "failed to seek to start of encrypted package file")
+	}
+
 	digester := sha256.New()
+	counter := &countingWriter{}
 
-	out := io.MultiWriter(decryptedPackageFile, digester)
+	out := io.MultiWriter(decryptedPackageFile, digester, counter)
 
 	err = cryptostream.Decrypt(encryptedPackageFile, out, applicationInfo.EncryptionInfo.EncryptionKey, applicationInfo.EncryptionInfo.MACKey)
 	if err != nil {
 		return errors.Wrapf(err, "failed to decrypt package file")
 	}
 
-	return nil
+	return applicationInfo.verifyContent(counter.n, digester.Sum(nil))
+}
+
+// countingWriter counts the bytes written to it.
+type countingWriter struct {
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+
+	return len(p), nil
 }
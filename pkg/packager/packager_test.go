@@ -1,10 +1,19 @@
 package packager
 
 import (
+	"bytes"
+	"content-prep/pkg/authenticode"
 	"content-prep/pkg/cryptostream"
+	"content-prep/pkg/installer"
+	"content-prep/pkg/sbom"
+	"content-prep/pkg/scanner"
 	"content-prep/pkg/zipper"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"io"
 	"io/fs"
 	"os"
@@ -12,6 +21,7 @@ import (
 	"strings"
 	"testing"
 	"testing/fstest"
+	"time"
 
 	"github.com/stretchr/testify/suite"
 )
@@ -25,6 +35,16 @@ func (m mykeygen) GenerateKey(length int) ([]byte, error) {
 	return []byte(strings.Repeat(".", length)), nil
 }
 
+// exeStub returns the smallest file that passes as a PE executable.
+func exeStub() []byte {
+	data := make([]byte, 128)
+	copy(data, "MZ")
+	binary.LittleEndian.PutUint32(data[0x3C:], 64)
+	copy(data[64:], "PE\x00\x00")
+
+	return data
+}
+
 func TestPackagerTestSuite(t *testing.T) {
 	suite.Run(t, new(PackagerTestSuite))
 }
@@ -40,7 +60,7 @@ type PackagerTestSuite struct {
 func (s *PackagerTestSuite) SetupSuite() {
 	s.fs = fstest.MapFS{
 		"test.exe": {
-			Data: []byte("test"),
+			Data: exeStub(),
 		},
 	}
 	var err error
@@ -60,7 +80,7 @@ func (s *PackagerTestSuite) TestPackager() {
 	out, err := os.Create(path.Join(s.testDir, "test.intunewin"))
 	s.Require().NoError(err)
 
-	err = p.CreatePackage(context.Background(), s.fs, "test.exe", out)
+	_, err = p.CreatePackage(context.Background(), s.fs, "test.exe", out)
 	s.Require().NoError(err)
 
 	err = zipper.Unzip(out, path.Join(s.testDir, "test.unzip"))
@@ -108,3 +128,466 @@ func (s *PackagerTestSuite) TestPackager() {
 	s.Require().NoError(err)
 
 }
+
+func (s *PackagerTestSuite) TestOpenPackage() {
+	p := &packager{
+		keygen: &mykeygen{},
+	}
+
+	source := fstest.MapFS{
+		"setup.exe":          {Data: exeStub()},
+		"config/config.json": {Data: []byte(`{"foo":"bar"}`)},
+	}
+
+	out, err := os.Create(path.Join(s.testDir, "open.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	_, err = p.CreatePackage(context.Background(), source, "setup.exe", out)
+	s.Require().NoError(err)
+
+	info, err := out.Stat()
+	s.Require().NoError(err)
+
+	pkg, err := p.OpenPackage(context.Background(), out, info.Size())
+	s.Require().NoError(err)
+	defer pkg.Close()
+
+	s.Require().Equal("setup.exe", pkg.ApplicationInfo.SetupFile)
+
+	entries := pkg.Entries()
+	s.Require().Len(entries, 2)
+	s.Require().Equal("config/config.json", entries[0].Name)
+	s.Require().Equal(int64(13), entries[0].Size)
+	s.Require().Equal("setup.exe", entries[1].Name)
+
+	rc, err := pkg.Open("config/config.json")
+	s.Require().NoError(err)
+	defer rc.Close()
+
+	data, err := io.ReadAll(rc)
+	s.Require().NoError(err)
+	s.Require().Equal(`{"foo":"bar"}`, string(data))
+
+	_, err = pkg.Open("missing.txt")
+	s.Require().ErrorIs(err, fs.ErrNotExist)
+}
+
+func (s *PackagerTestSuite) TestDecryptPackageVerifiesDetection() {
+	p := &packager{
+		keygen: &mykeygen{},
+	}
+
+	for _, tc := range []struct {
+		name     string
+		mutate   func(ai *ApplicationInfo)
+		expected error
+	}{
+		{
+			name:     "digest",
+			mutate:   func(ai *ApplicationInfo) { ai.EncryptionInfo.FileDigest[0] ^= 0xff },
+			expected: ErrDigestMismatch,
+		},
+		{
+			name:     "size",
+			mutate:   func(ai *ApplicationInfo) { ai.UnencryptedContentSize++ },
+			expected: ErrSizeMismatch,
+		},
+		{
+			name:     "mac",
+			mutate:   func(ai *ApplicationInfo) { ai.EncryptionInfo.Mac[0] ^= 0xff },
+			expected: ErrHMACMismatch,
+		},
+		{
+			name:     "macKey",
+			mutate:   func(ai *ApplicationInfo) { ai.EncryptionInfo.MACKey[0] ^= 0xff },
+			expected: ErrHMACMismatch,
+		},
+		{
+			name:     "profile",
+			mutate:   func(ai *ApplicationInfo) { ai.EncryptionInfo.ProfileIdentifier = "ProfileVersion2" },
+			expected: ErrUnknownProfile,
+		},
+		{
+			name:     "algorithm",
+			mutate:   func(ai *ApplicationInfo) { ai.EncryptionInfo.FileDigestAlgorithm = "MD5" },
+			expected: ErrUnknownDigestAlgorithm,
+		},
+	} {
+		s.Run(tc.name, func() {
+			dir := path.Join(s.testDir, "tampered-"+tc.name)
+
+			out, err := os.Create(path.Join(s.testDir, "tampered-"+tc.name+".intunewin"))
+			s.Require().NoError(err)
+			defer out.Close()
+
+			_, err = p.CreatePackage(context.Background(), s.fs, "test.exe", out)
+			s.Require().NoError(err)
+
+			err = zipper.Unzip(out, path.Join(dir, "src"))
+			s.Require().NoError(err)
+
+			detectionFilePath := path.Join(dir, "src", "IntuneWinPackage", "Metadata", "Detection.xml")
+			detectionData, err := os.ReadFile(detectionFilePath)
+			s.Require().NoError(err)
+
+			ai := &ApplicationInfo{}
+			s.Require().NoError(xml.Unmarshal(detectionData, ai))
+			tc.mutate(ai)
+
+			detectionData, err = xml.Marshal(ai)
+			s.Require().NoError(err)
+			s.Require().NoError(os.WriteFile(detectionFilePath, detectionData, 0644))
+
+			tampered, err := os.Create(path.Join(dir, "tampered.intunewin"))
+			s.Require().NoError(err)
+			defer tampered.Close()
+
+			s.Require().NoError(zipper.Zip(os.DirFS(path.Join(dir, "src")), tampered))
+
+			err = p.DecryptPackage(context.Background(), tampered, path.Join(dir, "decrypted"))
+			s.Require().ErrorIs(err, tc.expected)
+
+			info, err := tampered.Stat()
+			s.Require().NoError(err)
+
+			pkg, err := p.OpenPackage(context.Background(), tampered, info.Size())
+			if err == nil {
+				defer pkg.Close()
+				err = pkg.Verify()
+			}
+			s.Require().ErrorIs(err, tc.expected)
+		})
+	}
+}
+
+type escrowingKeyProvider struct {
+	generatorKeyProvider
+}
+
+func (e escrowingKeyProvider) ProvideKeys(ctx context.Context) (*PackageKeys, error) {
+	keys, err := e.generatorKeyProvider.ProvideKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	keys.Escrow = &EscrowRecord{Provider: "test", KeyID: "key-1"}
+
+	return keys, nil
+}
+
+func (s *PackagerTestSuite) TestCreatePackageEscrow() {
+	p := New(WithKeyProvider(escrowingKeyProvider{generatorKeyProvider{keygen: mykeygen{}}}))
+
+	out, err := os.Create(path.Join(s.testDir, "escrow.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	result, err := p.CreatePackage(context.Background(), s.fs, "test.exe", out)
+	s.Require().NoError(err)
+
+	s.Require().NotNil(result.Escrow)
+	s.Require().Equal("key-1", result.Escrow.KeyID)
+	s.Require().Equal("test", result.Escrow.Package)
+	s.Require().Equal(result.ApplicationInfo.EncryptionInfo.FileDigest, result.Escrow.FileDigest)
+	s.Require().Equal([]byte(strings.Repeat(".", 32)), result.ApplicationInfo.EncryptionInfo.EncryptionKey)
+}
+
+func (s *PackagerTestSuite) TestCreatePackageInstaller() {
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}))
+
+	out, err := os.Create(path.Join(s.testDir, "installer.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	result, err := p.CreatePackage(context.Background(), s.fs, "test.exe", out)
+	s.Require().NoError(err)
+	s.Require().Equal(installer.TypeEXE, result.ApplicationInfo.Installer.Type)
+	s.Require().Equal(`"test.exe"`, result.ApplicationInfo.Installer.InstallCommandLine)
+
+	invalid := fstest.MapFS{"setup.msi": {Data: []byte("not an msi")}}
+	_, err = p.CreatePackage(context.Background(), invalid, "setup.msi", out)
+	s.Require().ErrorIs(err, installer.ErrInvalid)
+}
+
+func (s *PackagerTestSuite) TestCreatePackageSignaturePolicy() {
+	out, err := os.Create(path.Join(s.testDir, "signatures.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithSignaturePolicy(authenticode.Policy{}, authenticode.Options{}))
+
+	result, err := p.CreatePackage(context.Background(), s.fs, "test.exe", out)
+	s.Require().NoError(err)
+	s.Require().Len(result.Signatures, 1)
+	s.Require().Equal("test.exe", result.Signatures[0].File)
+	s.Require().False(result.Signatures[0].Signed)
+
+	p = New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithSignaturePolicy(authenticode.Policy{RequireSigned: true}, authenticode.Options{}))
+
+	_, err = p.CreatePackage(context.Background(), s.fs, "test.exe", out)
+	s.Require().ErrorIs(err, authenticode.ErrPolicyViolation)
+
+	p = New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithExclude("*.exe"), WithSignaturePolicy(authenticode.Policy{RequireSigned: true}, authenticode.Options{}))
+
+	_, err = p.CreatePackage(context.Background(), fstest.MapFS{"install.cmd": {Data: []byte("@echo off")}, "tools/unsigned.exe": {Data: exeStub()}}, "install.cmd", out)
+	s.Require().NoError(err)
+}
+
+// markerScanner detects files containing a marker.
+type markerScanner struct{}
+
+func (markerScanner) Name() string {
+	return "marker"
+}
+
+func (markerScanner) Scan(ctx context.Context, name string, r io.Reader) (string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+
+	if bytes.Contains(data, []byte("MALWARE")) {
+		return "Test.Marker", nil
+	}
+
+	return "", nil
+}
+
+func (s *PackagerTestSuite) TestCreatePackageScanners() {
+	source := fstest.MapFS{
+		"test.exe":        {Data: exeStub()},
+		"tools/infected":  {Data: []byte("MALWARE")},
+		"config/app.json": {Data: []byte("{}")},
+	}
+
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithScanners(markerScanner{}))
+
+	_, err := p.CreatePackage(context.Background(), source, "test.exe", io.Discard)
+	s.Require().ErrorIs(err, scanner.ErrDetected)
+
+	var detectionErr *scanner.DetectionError
+	s.Require().ErrorAs(err, &detectionErr)
+	s.Require().Equal(1, detectionErr.Report.Detections())
+	s.Require().Len(detectionErr.Report.Files, 3)
+
+	p = New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithScanners(markerScanner{}), WithExclude("tools"))
+
+	result, err := p.CreatePackage(context.Background(), source, "test.exe", io.Discard)
+	s.Require().NoError(err)
+	s.Require().NotNil(result.Scan)
+	s.Require().Equal([]string{"marker"}, result.Scan.Scanners)
+	s.Require().Len(result.Scan.Files, 2)
+}
+
+// policyFunc adapts a function to the Policy interface.
+type policyFunc func(source fs.FS, exclude []string, info *ApplicationInfo) error
+
+func (f policyFunc) Evaluate(ctx context.Context, source fs.FS, exclude []string, info *ApplicationInfo) ([]string, error) {
+	return []string{"checked " + info.SetupFile}, f(source, exclude, info)
+}
+
+func (s *PackagerTestSuite) TestCreatePackagePolicy() {
+	errTooLarge := errors.New("too large")
+
+	var evaluated *ApplicationInfo
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithExclude("*.log"), WithPolicy(policyFunc(func(source fs.FS, exclude []string, info *ApplicationInfo) error {
+		s.Require().Equal([]string{"*.log"}, exclude)
+		evaluated = info
+
+		if info.UnencryptedContentSize > 1<<20 {
+			return errTooLarge
+		}
+
+		return nil
+	})))
+
+	result, err := p.CreatePackage(context.Background(), s.fs, "test.exe", io.Discard)
+	s.Require().NoError(err)
+	s.Require().Same(result.ApplicationInfo, evaluated)
+
+	s.Require().Equal([]string{"checked test.exe"}, result.Report.Warnings)
+
+	out := &bytes.Buffer{}
+	_, err = p.CreatePackage(context.Background(), fstest.MapFS{"test.exe": {Data: exeStub()}, "large.bin": {Data: make([]byte, 2<<20)}}, "test.exe", out)
+	s.Require().ErrorIs(err, errTooLarge)
+	s.Require().Zero(out.Len())
+
+	var buildErr *BuildError
+	s.Require().ErrorAs(err, &buildErr)
+	s.Require().True(buildErr.Report.Failed())
+
+	stages := buildErr.Report.Stages
+	s.Require().Equal(StagePolicy, stages[len(stages)-1].Name)
+	s.Require().Equal("too large", stages[len(stages)-1].Error)
+}
+
+func (s *PackagerTestSuite) TestCreatePackageReport() {
+	source := fstest.MapFS{
+		"test.exe":          {Data: exeStub()},
+		"config/app.json":   {Data: []byte("{}")},
+		"logs/ignored.log":  {Data: []byte("excluded")},
+		"scripts/setup.ps1": {Data: []byte("Write-Host")},
+	}
+
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithExclude("*.log"), WithScanners(markerScanner{}))
+
+	out := &bytes.Buffer{}
+	result, err := p.CreatePackage(context.Background(), source, "test.exe", out)
+	s.Require().NoError(err)
+
+	report := result.Report
+	s.Require().NotNil(report)
+	s.Require().Equal("test.exe", report.SetupFile)
+	s.Require().Equal(installer.TypeEXE, report.InstallerType)
+	s.Require().Equal(result.ApplicationInfo.Name, report.Name)
+	s.Require().Equal(3, report.Files)
+	s.Require().Equal(int64(len(exeStub())+2+10), report.BytesIn)
+	s.Require().Equal(result.ApplicationInfo.UnencryptedContentSize, report.CompressedSize)
+	s.Require().Greater(report.EncryptedSize, report.CompressedSize)
+	s.Require().Equal(int64(out.Len()), report.PackageSize)
+	s.Require().Equal(hex.EncodeToString(result.ApplicationInfo.EncryptionInfo.FileDigest), report.FileDigest)
+	s.Require().Equal(result.PackageDigest, report.PackageDigest)
+	s.Require().False(report.Failed())
+
+	var stages []string
+	for _, stage := range report.Stages {
+		stages = append(stages, stage.Name)
+		s.Require().Empty(stage.Error)
+	}
+	s.Require().Equal([]string{StageDetect, StageScan, StageCompress, StageEncrypt, StagePackage}, stages)
+}
+
+func (s *PackagerTestSuite) TestCreatePackageSBOM() {
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithSBOM(), WithExclude("*.log"))
+
+	source := fstest.MapFS{
+		"setup.exe":          {Data: exeStub()},
+		"config/config.json": {Data: []byte(`{"foo":"bar"}`)},
+		"debug.log":          {Data: []byte("excluded")},
+	}
+
+	out, err := os.Create(path.Join(s.testDir, "sbom.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	result, err := p.CreatePackage(context.Background(), source, "setup.exe", out)
+	s.Require().NoError(err)
+	s.Require().NotNil(result.SBOM)
+	s.Require().Equal("setup", result.SBOM.Name)
+	s.Require().Len(result.SBOM.Files, 2)
+	s.Require().Equal("config/config.json", result.SBOM.Files[0].Name)
+	s.Require().Equal(int64(13), result.SBOM.Files[0].Size)
+
+	info, err := out.Stat()
+	s.Require().NoError(err)
+
+	pkg, err := p.OpenPackage(context.Background(), out, info.Size())
+	s.Require().NoError(err)
+	defer pkg.Close()
+
+	files, err := sbom.Collect(pkg.FS())
+	s.Require().NoError(err)
+	s.Require().Equal(result.SBOM.Files, files)
+}
+
+func (s *PackagerTestSuite) TestCreatePackageDigests() {
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithSourceDigest())
+
+	create := func(source fs.FS) *Result {
+		out := &bytes.Buffer{}
+
+		result, err := p.CreatePackage(context.Background(), source, "test.exe", out)
+		s.Require().NoError(err)
+
+		sum := sha256.Sum256(out.Bytes())
+		s.Require().Equal(hex.EncodeToString(sum[:]), result.PackageDigest)
+
+		return result
+	}
+
+	first := create(s.fs)
+	second := create(fstest.MapFS{"test.exe": {Data: exeStub(), ModTime: time.Now()}})
+	s.Require().Len(first.SourceDigest, 64)
+	s.Require().Equal(first.SourceDigest, second.SourceDigest)
+
+	changed := create(fstest.MapFS{"test.exe": {Data: exeStub()}, "config.ini": {Data: []byte("a=1")}})
+	s.Require().NotEqual(first.SourceDigest, changed.SourceDigest)
+
+	result, err := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}})).CreatePackage(context.Background(), s.fs, "test.exe", io.Discard)
+	s.Require().NoError(err)
+	s.Require().Empty(result.SourceDigest)
+}
+
+func (s *PackagerTestSuite) TestCreatePackageBase() {
+	p := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithIndex())
+
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	source := fstest.MapFS{
+		"test.exe":           {Data: exeStub(), ModTime: modified},
+		"payload/large.bin":  {Data: bytes.Repeat([]byte("x"), 4096), ModTime: modified},
+		"scripts/install.ps": {Data: []byte("v1"), ModTime: modified},
+	}
+
+	build := func(source fs.FS) (*Result, *Package) {
+		out := &bytes.Buffer{}
+
+		result, err := p.CreatePackage(context.Background(), source, "test.exe", out)
+		s.Require().NoError(err)
+
+		pkg, err := p.OpenPackage(context.Background(), bytes.NewReader(out.Bytes()), int64(out.Len()))
+		s.Require().NoError(err)
+		s.T().Cleanup(func() { _ = pkg.Close() })
+
+		return result, pkg
+	}
+
+	first, base := build(source)
+	s.Require().NotNil(first.Index)
+	s.Require().Len(first.Index.Files, 3)
+	s.Require().Equal(hex.EncodeToString(first.ApplicationInfo.EncryptionInfo.FileDigest), first.Index.FileDigest)
+	s.Require().Equal(modified, first.Index.Files[0].Modified)
+
+	source["scripts/install.ps"] = &fstest.MapFile{Data: []byte("v2"), ModTime: modified.Add(time.Hour)}
+	source["scripts/new.ps"] = &fstest.MapFile{Data: []byte("new"), ModTime: modified}
+
+	baseFS, err := NewBaseFS(source, base, first.Index)
+	s.Require().NoError(err)
+
+	// The base package serves unchanged files even if the source changed
+	// underneath without touching size and modification time.
+	source["payload/large.bin"].Data = bytes.Repeat([]byte("y"), 4096)
+
+	second, pkg := build(baseFS)
+	files, size := baseFS.Reused()
+	s.Require().Equal(2, files)
+	s.Require().Equal(int64(4096+len(exeStub())), size)
+	s.Require().Len(second.Index.Files, 4)
+
+	rc, err := pkg.Open("payload/large.bin")
+	s.Require().NoError(err)
+	data, err := io.ReadAll(rc)
+	s.Require().NoError(err)
+	s.Require().NoError(rc.Close())
+	s.Require().Equal(bytes.Repeat([]byte("x"), 4096), data)
+
+	rc, err = pkg.Open("scripts/install.ps")
+	s.Require().NoError(err)
+	data, err = io.ReadAll(rc)
+	s.Require().NoError(err)
+	s.Require().NoError(rc.Close())
+	s.Require().Equal("v2", string(data))
+
+	_, err = NewBaseFS(source, pkg, first.Index)
+	s.Require().Error(err)
+
+	tampered := *first.Index
+	tampered.Files = append([]IndexFile(nil), first.Index.Files...)
+	tampered.Files[0].SHA256 = strings.Repeat("0", 64)
+
+	baseFS, err = NewBaseFS(source, base, &tampered)
+	s.Require().NoError(err)
+
+	_, err = p.CreatePackage(context.Background(), baseFS, "test.exe", io.Discard)
+	s.Require().ErrorIs(err, ErrBaseMismatch)
+}
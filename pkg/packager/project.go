@@ -0,0 +1,96 @@
+package packager
+
+import (
+	"content-prep/pkg/logger"
+	"context"
+	"io/fs"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Project is a packaging project recognised in the source, e.g. a wrapper
+// built with a deployment toolkit.
+type Project struct {
+	Type string `json:"type"`
+
+	// SetupFile is the default setup file of the project.
+	SetupFile            string `json:"setupFile"`
+	InstallCommandLine   string `json:"installCommandLine,omitempty"`
+	UninstallCommandLine string `json:"uninstallCommandLine,omitempty"`
+
+	Vendor  string `json:"vendor,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// Warnings lists problems that do not prevent packaging, such as missing
+	// toolkit files.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// ProjectDetector recognises one kind of project.
+type ProjectDetector interface {
+	// Detect returns nil without an error if the source is not a project of
+	// this kind.
+	Detect(fsys fs.FS) (*Project, error)
+}
+
+var (
+	projectDetectorsMu sync.RWMutex
+	projectDetectors   = []ProjectDetector{PSADTDetector{}}
+)
+
+// RegisterProjectDetector adds a detector to the detectors used by default.
+// Detectors are asked in the order they were registered.
+func RegisterProjectDetector(d ProjectDetector) {
+	projectDetectorsMu.Lock()
+	defer projectDetectorsMu.Unlock()
+
+	projectDetectors = append(projectDetectors, d)
+}
+
+// WithProjectDetectors replaces the registered project detectors.
+func WithProjectDetectors(detectors ...ProjectDetector) Option {
+	return func(p *packager) {
+		p.detectors = detectors
+	}
+}
+
+// WithProject uses the project detected in the source beforehand, nil for
+// none, instead of detecting it again when the package is created.
+func WithProject(project *Project) Option {
+	return func(p *packager) {
+		p.project = project
+		p.projectDetected = true
+	}
+}
+
+// DetectProject returns the first project recognised in the source, or nil.
+func (p *packager) DetectProject(ctx context.Context, fsys fs.FS) (*Project, error) {
+	if p.projectDetected {
+		return p.project, nil
+	}
+
+	log := logger.FromContext(ctx).With("component", "packager", "action", "detect")
+
+	detectors := p.detectors
+	if detectors == nil {
+		projectDetectorsMu.RLock()
+		detectors = append([]ProjectDetector(nil), projectDetectors...)
+		projectDetectorsMu.RUnlock()
+	}
+
+	for _, d := range detectors {
+		project, err := d.Detect(fsys)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to detect project")
+		}
+
+		if project != nil {
+			log.Debug("detected project", "type", project.Type, "setupFile", project.SetupFile)
+			return project, nil
+		}
+	}
+
+	return nil, nil
+}
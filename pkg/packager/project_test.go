@@ -0,0 +1,202 @@
+package packager
+
+import (
+	"bytes"
+	"content-prep/pkg/relationship"
+	"context"
+	"io/fs"
+	"os"
+	"path"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestProjectTestSuite(t *testing.T) {
+	suite.Run(t, new(ProjectTestSuite))
+}
+
+type ProjectTestSuite struct {
+	suite.Suite
+}
+
+const deployApplication = `
+[CmdletBinding()]
+Param ([string]$DeploymentType = 'Install')
+
+Try {
+	##* Variables: Application
+	[string]$appVendor = 'Notepad++ Team'
+	[String]$appName = "Notepad++"
+	[string]$appVersion = '8.6.2'
+	[string]$appArch = ''
+}
+`
+
+func psadtProject() fstest.MapFS {
+	return fstest.MapFS{
+		"Deploy-Application.ps1":                          {Data: []byte(deployApplication)},
+		"Deploy-Application.exe":                          {Data: exeStub()},
+		"AppDeployToolkit/AppDeployToolkitMain.ps1":       {Data: []byte("")},
+		"AppDeployToolkit/AppDeployToolkitConfig.xml":     {Data: []byte("<AppDeployToolkit_Config/>")},
+		"AppDeployToolkit/AppDeployToolkitExtensions.ps1": {Data: []byte("")},
+		"Files/npp.exe":                                   {Data: exeStub()},
+	}
+}
+
+func (s *ProjectTestSuite) TestPSADT() {
+	project, err := New().DetectProject(context.Background(), psadtProject())
+	s.Require().NoError(err)
+	s.Require().Equal(&Project{
+		Type:                 ProjectTypePSADT,
+		SetupFile:            "Deploy-Application.exe",
+		InstallCommandLine:   "Deploy-Application.exe -DeploymentType Install",
+		UninstallCommandLine: "Deploy-Application.exe -DeploymentType Uninstall",
+		Vendor:               "Notepad++ Team",
+		Name:                 "Notepad++",
+		Version:              "8.6.2",
+	}, project)
+}
+
+func (s *ProjectTestSuite) TestPSADTIncomplete() {
+	fsys := psadtProject()
+	delete(fsys, "Deploy-Application.exe")
+	delete(fsys, "AppDeployToolkit/AppDeployToolkitConfig.xml")
+
+	project, err := New().DetectProject(context.Background(), fsys)
+	s.Require().NoError(err)
+	s.Require().Equal("Deploy-Application.ps1", project.SetupFile)
+	s.Require().Equal(`powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -File ".\Deploy-Application.ps1" -DeploymentType Install`, project.InstallCommandLine)
+	s.Require().Equal([]string{
+		"missing toolkit file Deploy-Application.exe",
+		"missing toolkit file AppDeployToolkit/AppDeployToolkitConfig.xml",
+	}, project.Warnings)
+
+	delete(fsys, "Deploy-Application.ps1")
+	project, err = New().DetectProject(context.Background(), fsys)
+	s.Require().NoError(err)
+	s.Require().Nil(project, "toolkit folder without entry point")
+
+	fsys = psadtProject()
+	delete(fsys, "Deploy-Application.ps1")
+	project, err = New().DetectProject(context.Background(), fsys)
+	s.Require().NoError(err)
+	s.Require().Equal("Deploy-Application.exe", project.SetupFile)
+	s.Require().Contains(project.Warnings, "missing Deploy-Application.ps1")
+}
+
+func (s *ProjectTestSuite) TestNoProject() {
+	project, err := New().DetectProject(context.Background(), fstest.MapFS{"setup.exe": {Data: exeStub()}})
+	s.Require().NoError(err)
+	s.Require().Nil(project)
+}
+
+type customDetector struct{}
+
+func (customDetector) Detect(fsys fs.FS) (*Project, error) {
+	if _, err := fs.Stat(fsys, "custom.json"); err != nil {
+		return nil, nil
+	}
+
+	return &Project{Type: "custom", SetupFile: "setup.exe", InstallCommandLine: "setup.exe /custom"}, nil
+}
+
+func (s *ProjectTestSuite) TestCustomDetector() {
+	fsys := fstest.MapFS{"custom.json": {Data: []byte("{}")}, "setup.exe": {Data: exeStub()}}
+
+	project, err := New(WithProjectDetectors(customDetector{})).DetectProject(context.Background(), fsys)
+	s.Require().NoError(err)
+	s.Require().Equal("custom", project.Type)
+
+	project, err = New(WithProjectDetectors(customDetector{})).DetectProject(context.Background(), psadtProject())
+	s.Require().NoError(err)
+	s.Require().Nil(project)
+}
+
+func (s *ProjectTestSuite) TestCreatePackagePSADT() {
+	out, err := os.Create(path.Join(s.T().TempDir(), "psadt.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	result, err := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}})).CreatePackage(context.Background(), psadtProject(), "Deploy-Application.exe", out)
+	s.Require().NoError(err)
+
+	ai := result.ApplicationInfo
+	s.Require().Equal("Notepad++", ai.Name)
+	s.Require().Equal("Deploy-Application.exe -DeploymentType Install", ai.Installer.InstallCommandLine)
+
+	metadata := ai.GraphMetadata()
+	s.Require().Equal("Notepad++ Team", metadata.App.Publisher)
+	s.Require().Equal("8.6.2", metadata.App.DisplayVersion)
+	s.Require().Equal(ProjectTypePSADT, metadata.Project.Type)
+}
+
+func (s *ProjectTestSuite) TestCreatePackageOtherSetupFile() {
+	out, err := os.Create(path.Join(s.T().TempDir(), "npp.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	result, err := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}})).CreatePackage(context.Background(), psadtProject(), "Files/npp.exe", out)
+	s.Require().NoError(err)
+
+	ai := result.ApplicationInfo
+	s.Require().Equal("npp", ai.Name)
+	s.Require().Equal(`"Files\npp.exe"`, ai.Installer.InstallCommandLine)
+	s.Require().Nil(ai.Project)
+	s.Require().Empty(ai.Version())
+}
+
+func (s *ProjectTestSuite) TestWithProject() {
+	project, err := New(WithProject(nil)).DetectProject(context.Background(), psadtProject())
+	s.Require().NoError(err)
+	s.Require().Nil(project)
+
+	custom := &Project{Type: "custom", SetupFile: "Deploy-Application.exe", InstallCommandLine: "Deploy-Application.exe /custom"}
+	out, err := os.Create(path.Join(s.T().TempDir(), "custom.intunewin"))
+	s.Require().NoError(err)
+	defer out.Close()
+
+	result, err := New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}}), WithProject(custom)).CreatePackage(context.Background(), psadtProject(), "Deploy-Application.exe", out)
+	s.Require().NoError(err)
+	s.Require().Equal("Deploy-Application.exe /custom", result.ApplicationInfo.Installer.InstallCommandLine)
+}
+
+func (s *ProjectTestSuite) TestSupersedenceOfPackageOnDisk() {
+	packageFilePath := path.Join(s.T().TempDir(), "npp.intunewin")
+	out, err := os.Create(packageFilePath)
+	s.Require().NoError(err)
+
+	_, err = New(WithKeyProvider(generatorKeyProvider{keygen: mykeygen{}})).CreatePackage(context.Background(), psadtProject(), "Deploy-Application.exe", out)
+	s.Require().NoError(err)
+	s.Require().NoError(out.Close())
+
+	data, err := os.ReadFile(packageFilePath)
+	s.Require().NoError(err)
+
+	pkg, err := Default.OpenPackage(context.Background(), bytes.NewReader(data), int64(len(data)))
+	s.Require().NoError(err)
+	defer pkg.Close()
+	s.Require().Empty(pkg.ApplicationInfo.Version(), "not part of Detection.xml")
+
+	s.Require().NoError(Default.DetectContents(context.Background(), pkg))
+	s.Require().Equal("8.6.2", pkg.ApplicationInfo.Version())
+
+	graph, err := relationship.Parse([]byte(`
+apps:
+  - name: Notepad++
+    version: "8.6.2"
+    supersedes:
+      - name: Notepad++
+        version: "8.5.0"
+  - name: Notepad++
+    version: "8.5.0"
+    id: 2f1c7e0a-1111-4d2b-9c3e-000000000003
+`))
+	s.Require().NoError(err)
+
+	app := graph.Find(pkg.ApplicationInfo.Name, pkg.ApplicationInfo.Version())
+	s.Require().NotNil(app)
+	s.Require().Equal("8.6.2", app.Version)
+	s.Require().Equal("8.5.0", graph.Payload(app).Relationships[0].TargetDisplayVersion)
+}
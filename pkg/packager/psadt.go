@@ -0,0 +1,93 @@
+package packager
+
+import (
+	"io/fs"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ProjectTypePSADT identifies PowerShell App Deployment Toolkit projects.
+const ProjectTypePSADT = "psadt"
+
+const (
+	psadtScript     = "Deploy-Application.ps1"
+	psadtExecutable = "Deploy-Application.exe"
+	psadtToolkit    = "AppDeployToolkit"
+)
+
+// psadtToolkitFiles are the files every toolkit installation ships with.
+var psadtToolkitFiles = []string{
+	psadtExecutable,
+	psadtToolkit + "/AppDeployToolkitMain.ps1",
+	psadtToolkit + "/AppDeployToolkitConfig.xml",
+	psadtToolkit + "/AppDeployToolkitExtensions.ps1",
+}
+
+// PSADTDetector recognises PowerShell App Deployment Toolkit projects by
+// their entry point, Deploy-Application.exe or Deploy-Application.ps1. A
+// toolkit folder without an entry point is not a project that can be run.
+type PSADTDetector struct{}
+
+func (PSADTDetector) Detect(fsys fs.FS) (*Project, error) {
+	_, scriptErr := fs.Stat(fsys, psadtScript)
+	_, executableErr := fs.Stat(fsys, psadtExecutable)
+
+	if scriptErr != nil && executableErr != nil {
+		return nil, nil
+	}
+
+	project := &Project{
+		Type:                 ProjectTypePSADT,
+		SetupFile:            psadtExecutable,
+		InstallCommandLine:   psadtExecutable + " -DeploymentType Install",
+		UninstallCommandLine: psadtExecutable + " -DeploymentType Uninstall",
+	}
+
+	for _, name := range psadtToolkitFiles {
+		if _, err := fs.Stat(fsys, name); err != nil {
+			project.Warnings = append(project.Warnings, "missing toolkit file "+name)
+		}
+	}
+
+	// Without the launcher the script is run through PowerShell directly.
+	if executableErr != nil {
+		command := `powershell.exe -NoProfile -NonInteractive -ExecutionPolicy Bypass -File ".\` + psadtScript + `" -DeploymentType `
+		project.SetupFile = psadtScript
+		project.InstallCommandLine = command + "Install"
+		project.UninstallCommandLine = command + "Uninstall"
+	}
+
+	script, err := fs.ReadFile(fsys, psadtScript)
+	if errors.Is(err, fs.ErrNotExist) {
+		project.Warnings = append(project.Warnings, "missing "+psadtScript)
+		return project, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	project.Vendor = psadtVariable(script, "appVendor")
+	project.Name = psadtVariable(script, "appName")
+	project.Version = psadtVariable(script, "appVersion")
+
+	if project.Name == "" {
+		project.Warnings = append(project.Warnings, "$appName is not set in "+psadtScript)
+	}
+
+	return project, nil
+}
+
+// psadtVariable reads a variable assigned a literal string in the script,
+// e.g. [string]$appName = 'Notepad++'.
+func psadtVariable(script []byte, name string) string {
+	re := regexp.MustCompile(`(?im)^\s*(?:\[string\]\s*)?\$` + regexp.QuoteMeta(name) + `\s*=\s*(?:'([^']*)'|"([^"]*)")`)
+
+	m := re.FindSubmatch(script)
+	if m == nil {
+		return ""
+	}
+
+	return strings.TrimSpace(string(m[1]) + string(m[2]))
+}
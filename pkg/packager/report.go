@@ -0,0 +1,269 @@
+package packager
+
+import (
+	"content-prep/pkg/installer"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Build stages recorded in the report.
+const (
+	StageDetect     = "detect"
+	StageSignatures = "signatures"
+	StageScan       = "scan"
+	StageCompress   = "compress"
+	StageEncrypt    = "encrypt"
+	StagePolicy     = "policy"
+	StagePackage    = "package"
+)
+
+// Report is a machine-readable record of a package build. It never contains
+// key material.
+type Report struct {
+	// Source and Output are set by the caller, the packager only knows the
+	// source as a file system and the output as a writer.
+	Source    string `json:"source,omitempty"`
+	Output    string `json:"output,omitempty"`
+	SetupFile string `json:"setupFile"`
+	Name      string `json:"name,omitempty"`
+
+	// InstallerType is the detected type of the setup file.
+	InstallerType installer.Type `json:"installerType,omitempty"`
+
+	// Files and BytesIn count the files of the source added to the package.
+	Files   int   `json:"files"`
+	BytesIn int64 `json:"bytesIn"`
+
+	// FilesReused and BytesReused count the files read from the base package
+	// instead of the source, see BaseFS. They are set by the caller.
+	FilesReused int   `json:"filesReused,omitempty"`
+	BytesReused int64 `json:"bytesReused,omitempty"`
+
+	// CompressedSize is the size of the inner archive, EncryptedSize that of
+	// the encrypted inner archive and PackageSize that of the .intunewin.
+	CompressedSize int64 `json:"compressedSize"`
+	EncryptedSize  int64 `json:"encryptedSize"`
+	PackageSize    int64 `json:"packageSize"`
+
+	// FileDigest is the hex encoded SHA-256 of the inner archive, as recorded
+	// in Detection.xml.
+	FileDigest    string `json:"fileDigest,omitempty"`
+	PackageDigest string `json:"packageDigest,omitempty"`
+	SourceDigest  string `json:"sourceDigest,omitempty"`
+
+	Started time.Time `json:"started"`
+	Seconds float64   `json:"seconds"`
+	Stages  []Stage   `json:"stages"`
+
+	Warnings []string `json:"warnings,omitempty"`
+	Error    string   `json:"error,omitempty"`
+
+	stageStarted time.Time
+}
+
+// Stage is the duration and outcome of a build stage.
+type Stage struct {
+	Name    string  `json:"name"`
+	Seconds float64 `json:"seconds"`
+	Error   string  `json:"error,omitempty"`
+}
+
+// Failed reports whether the build failed.
+func (r *Report) Failed() bool {
+	return r.Error != ""
+}
+
+// begin ends the current stage and starts the next one.
+func (r *Report) begin(name string) {
+	r.end(nil)
+
+	r.Stages = append(r.Stages, Stage{Name: name})
+	r.stageStarted = time.Now()
+}
+
+// end records the duration and error of the current stage.
+func (r *Report) end(err error) {
+	if r.stageStarted.IsZero() {
+		return
+	}
+
+	stage := &r.Stages[len(r.Stages)-1]
+	stage.Seconds = time.Since(r.stageStarted).Seconds()
+	if err != nil {
+		stage.Error = err.Error()
+	}
+
+	r.stageStarted = time.Time{}
+}
+
+// finish ends the current stage and the build.
+func (r *Report) finish(err error) {
+	r.end(err)
+
+	r.Seconds = time.Since(r.Started).Seconds()
+	if err != nil {
+		r.Error = err.Error()
+	}
+}
+
+func (r *Report) warn(format string, args ...any) {
+	r.Warnings = append(r.Warnings, fmt.Sprintf(format, args...))
+}
+
+// BuildError is returned by CreatePackage when the build fails. It carries the
+// report up to the failed stage.
+type BuildError struct {
+	Report *Report
+	err    error
+}
+
+func (e *BuildError) Error() string {
+	return e.err.Error()
+}
+
+func (e *BuildError) Unwrap() error {
+	return e.err
+}
+
+// contentCounter counts the files and bytes added to an archive.
+type contentCounter struct {
+	files int
+	bytes int64
+}
+
+func (c *contentCounter) Visit(_ string, info fs.FileInfo) (io.WriteCloser, error) {
+	c.files++
+	c.bytes += info.Size()
+
+	return nil, nil
+}
+
+type junitTestSuites struct {
+	XMLName  xml.Name         `xml:"testsuites"`
+	Name     string           `xml:"name,attr"`
+	Tests    int              `xml:"tests,attr"`
+	Failures int              `xml:"failures,attr"`
+	Time     junitTime        `xml:"time,attr"`
+	Suites   []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Time      junitTime       `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+	SystemOut string          `xml:"system-out,omitempty"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      junitTime     `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+// junitTime is a duration in seconds, written with millisecond precision as
+// many JUnit parsers reject exponents.
+type junitTime float64
+
+func (t junitTime) MarshalXMLAttr(name xml.Name) (xml.Attr, error) {
+	return xml.Attr{Name: name, Value: strconv.FormatFloat(float64(t), 'f', 3, 64)}, nil
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the reports as JUnit XML, one test suite per package with
+// one test case per stage, so CI systems can show batch builds as test
+// results. Warnings are written to the system output of the suite.
+func WriteJUnit(w io.Writer, reports ...*Report) error {
+	return writeJUnit(w, nil, reports)
+}
+
+// AppendJUnit adds the reports to the JUnit XML read from r, empty for none,
+// and writes the result to w. Suites of packages already in r are replaced,
+// so a batch writing to the same file ends up with one suite per package.
+func AppendJUnit(r io.Reader, w io.Writer, reports ...*Report) error {
+	var existing junitTestSuites
+	if err := xml.NewDecoder(r).Decode(&existing); err != nil && !errors.Is(err, io.EOF) {
+		return errors.Wrapf(err, "failed to read JUnit report")
+	}
+
+	return writeJUnit(w, existing.Suites, reports)
+}
+
+func writeJUnit(w io.Writer, existing []junitTestSuite, reports []*Report) error {
+	suites := junitTestSuites{Name: "content-prep"}
+
+	var added []junitTestSuite
+	for _, r := range reports {
+		name := r.Name
+		if name == "" {
+			name = r.SetupFile
+		}
+
+		suite := junitTestSuite{
+			Name:      name,
+			Time:      junitTime(r.Seconds),
+			Timestamp: r.Started.UTC().Format("2006-01-02T15:04:05"),
+			SystemOut: strings.Join(r.Warnings, "\n"),
+		}
+
+		for _, stage := range r.Stages {
+			tc := junitTestCase{Name: stage.Name, ClassName: name, Time: junitTime(stage.Seconds)}
+			if stage.Error != "" {
+				tc.Failure = &junitFailure{Message: stage.Error, Text: stage.Error}
+				suite.Failures++
+			}
+			suite.Cases = append(suite.Cases, tc)
+		}
+
+		// Failures outside of the stages, e.g. while opening the source.
+		if r.Failed() && suite.Failures == 0 {
+			suite.Cases = append(suite.Cases, junitTestCase{Name: "build", ClassName: name, Failure: &junitFailure{Message: r.Error, Text: r.Error}})
+			suite.Failures++
+		}
+
+		suite.Tests = len(suite.Cases)
+		added = append(added, suite)
+	}
+
+	for _, suite := range existing {
+		if !slices.ContainsFunc(added, func(a junitTestSuite) bool { return a.Name == suite.Name }) {
+			suites.Suites = append(suites.Suites, suite)
+		}
+	}
+	suites.Suites = append(suites.Suites, added...)
+
+	for _, suite := range suites.Suites {
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Time += suite.Time
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suites); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
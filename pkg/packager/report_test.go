@@ -0,0 +1,91 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestReportTestSuite(t *testing.T) {
+	suite.Run(t, new(ReportTestSuite))
+}
+
+type ReportTestSuite struct {
+	suite.Suite
+}
+
+func (s *ReportTestSuite) TestWriteJUnit() {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	reports := []*Report{
+		{
+			Name:      "app",
+			SetupFile: "setup.msi",
+			Started:   started,
+			Seconds:   1.5,
+			Stages:    []Stage{{Name: StageDetect, Seconds: 0.5}, {Name: StageCompress, Seconds: 1}},
+			Warnings:  []string{"setup.exe: not signed"},
+		},
+		{
+			SetupFile: "broken.exe",
+			Started:   started,
+			Stages:    []Stage{{Name: StageDetect}, {Name: StageScan, Error: "threat detected in 1 of 2 files"}},
+			Error:     "threat detected in 1 of 2 files",
+		},
+		{
+			Error: "failed to open source",
+		},
+	}
+
+	var buf bytes.Buffer
+	s.Require().NoError(WriteJUnit(&buf, reports...))
+
+	var suites junitTestSuites
+	s.Require().NoError(xml.Unmarshal(buf.Bytes(), &suites))
+
+	s.Require().Contains(buf.String(), `<testsuite name="app" tests="2" failures="0" time="1.500"`)
+
+	s.Require().Equal(5, suites.Tests)
+	s.Require().Equal(2, suites.Failures)
+	s.Require().Len(suites.Suites, 3)
+
+	s.Require().Equal("app", suites.Suites[0].Name)
+	s.Require().Equal("2024-05-01T12:00:00", suites.Suites[0].Timestamp)
+	s.Require().Equal(0, suites.Suites[0].Failures)
+	s.Require().Equal("setup.exe: not signed", suites.Suites[0].SystemOut)
+
+	s.Require().Equal("broken.exe", suites.Suites[1].Name)
+	s.Require().Equal(1, suites.Suites[1].Failures)
+	s.Require().Nil(suites.Suites[1].Cases[0].Failure)
+	s.Require().Equal("threat detected in 1 of 2 files", suites.Suites[1].Cases[1].Failure.Message)
+
+	s.Require().Equal("build", suites.Suites[2].Cases[0].Name)
+	s.Require().Equal("failed to open source", suites.Suites[2].Cases[0].Failure.Message)
+}
+
+func (s *ReportTestSuite) TestAppendJUnit() {
+	started := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	report := func(name string, err string) *Report {
+		return &Report{Name: name, Started: started, Seconds: 1, Stages: []Stage{{Name: StageDetect, Seconds: 1, Error: err}}, Error: err}
+	}
+
+	var first, second, third bytes.Buffer
+	s.Require().NoError(AppendJUnit(&bytes.Buffer{}, &first, report("app", "")))
+	s.Require().NoError(AppendJUnit(&first, &second, report("tool", "")))
+	s.Require().NoError(AppendJUnit(bytes.NewReader(second.Bytes()), &third, report("app", "failed")))
+
+	var suites junitTestSuites
+	s.Require().NoError(xml.Unmarshal(third.Bytes(), &suites))
+	s.Require().Len(suites.Suites, 2)
+	s.Require().Equal("tool", suites.Suites[0].Name)
+	s.Require().Equal("app", suites.Suites[1].Name)
+	s.Require().Equal(2, suites.Tests)
+	s.Require().Equal(1, suites.Failures)
+	s.Require().Contains(third.String(), `<testsuites name="content-prep" tests="2" failures="1" time="2.000">`)
+
+	s.Require().Error(AppendJUnit(strings.NewReader("<testsuites"), &bytes.Buffer{}, report("app", "")))
+}
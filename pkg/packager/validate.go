@@ -0,0 +1,285 @@
+package packager
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ValidationError describes a single violation of the Detection.xml schema.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (v ValidationError) Error() string {
+	return v.Path + ": " + v.Message
+}
+
+// ValidationErrors is returned by ValidateDetectionXML.
+type ValidationErrors []ValidationError
+
+func (v ValidationErrors) Error() string {
+	messages := make([]string, len(v))
+	for i, err := range v {
+		messages[i] = err.Error()
+	}
+
+	return "invalid Detection.xml: " + strings.Join(messages, "; ")
+}
+
+var (
+	toolVersionPattern = regexp.MustCompile(`^\d+(\.\d+){1,3}$`)
+	guidPattern        = regexp.MustCompile(`^\{[0-9A-Fa-f]{8}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{4}-[0-9A-Fa-f]{12}\}$`)
+)
+
+// elementSchema is the equivalent of an XSD element declaration. Children are
+// declared as xs:all, i.e. every child may occur at most once in any order.
+type elementSchema struct {
+	name     string
+	required bool
+	check    func(value string) string
+	children []elementSchema
+}
+
+var detectionSchema = elementSchema{
+	name:     "ApplicationInfo",
+	required: true,
+	children: []elementSchema{
+		{name: "Name", required: true, check: checkNonEmpty},
+		{name: "UnencryptedContentSize", required: true, check: checkNonNegativeLong},
+		{name: "FileName", required: true, check: checkNonEmpty},
+		{name: "SetupFile", required: true, check: checkNonEmpty},
+		{name: "EncryptionInfo", required: true, children: []elementSchema{
+			{name: "EncryptionKey", required: true, check: checkBase64(32)},
+			{name: "MacKey", required: true, check: checkBase64(32)},
+			{name: "InitializationVector", required: true, check: checkBase64(16)},
+			{name: "Mac", required: true, check: checkBase64(32)},
+			{name: "ProfileIdentifier", required: true, check: checkEnum(ProfileVersion1)},
+			{name: "FileDigest", required: true, check: checkBase64(32)},
+			{name: "FileDigestAlgorithm", required: true, check: checkEnum(FileDigestAlgorithmSHA256)},
+		}},
+		{name: "MsiInfo", children: []elementSchema{
+			{name: "MsiProductCode", check: checkGUID},
+			{name: "MsiProductVersion"},
+			{name: "MsiPackageCode", check: checkGUID},
+			{name: "MsiUpgradeCode", check: checkGUID},
+			{name: "MsiExecutionContext", check: checkEnum("Any", "User", "System")},
+			{name: "MsiRequiresLogon", check: checkBoolean},
+			{name: "MsiRequiresReboot", check: checkBoolean},
+			{name: "MsiIsMachineInstall", check: checkBoolean},
+			{name: "MsiIsUserInstall", check: checkBoolean},
+			{name: "MsiIncludesServices", check: checkBoolean},
+			{name: "MsiIncludesODBCDataSource", check: checkBoolean},
+			{name: "MsiContainsSystemRegistryKeys", check: checkBoolean},
+			{name: "MsiContainsSystemFolders", check: checkBoolean},
+			{name: "MsiPublisher"},
+		}},
+	},
+}
+
+// node is a generic element of the document under validation.
+type node struct {
+	name     xml.Name
+	attrs    []xml.Attr
+	text     string
+	children []*node
+}
+
+// ValidateDetectionXML checks a Detection.xml against the schema of the files
+// written by the Microsoft tool. In strict mode unknown elements and attributes
+// are reported as well, otherwise they are tolerated as future extensions.
+func ValidateDetectionXML(r io.Reader, strict bool) error {
+	root, err := parseNode(xml.NewDecoder(r))
+	if err != nil {
+		return errors.Wrapf(err, "failed to parse Detection.xml")
+	}
+
+	var errs ValidationErrors
+
+	if root.name.Local != detectionSchema.name {
+		errs = append(errs, ValidationError{Path: "/" + root.name.Local, Message: fmt.Sprintf("root element must be %s", detectionSchema.name)})
+		return errs
+	}
+
+	path := "/" + detectionSchema.name
+
+	toolVersion := ""
+	for _, attr := range root.attrs {
+		switch {
+		case attr.Name.Space == "" && attr.Name.Local == "ToolVersion":
+			toolVersion = attr.Value
+		case attr.Name.Space == "xmlns" || attr.Name.Local == "xmlns":
+		default:
+			if strict {
+				errs = append(errs, ValidationError{Path: path + "/@" + attr.Name.Local, Message: "unknown attribute"})
+			}
+		}
+	}
+
+	if !toolVersionPattern.MatchString(toolVersion) {
+		errs = append(errs, ValidationError{Path: path + "/@ToolVersion", Message: fmt.Sprintf("invalid tool version %q", toolVersion)})
+	}
+
+	errs = append(errs, validateChildren(path, root, detectionSchema, strict)...)
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+func validateChildren(path string, n *node, schema elementSchema, strict bool) ValidationErrors {
+	var errs ValidationErrors
+
+	seen := map[string]bool{}
+	for _, child := range n.children {
+		childPath := path + "/" + child.name.Local
+
+		childSchema, ok := findSchema(schema.children, child.name.Local)
+		if !ok {
+			if strict {
+				errs = append(errs, ValidationError{Path: childPath, Message: "unknown element"})
+			}
+			continue
+		}
+
+		if seen[child.name.Local] {
+			errs = append(errs, ValidationError{Path: childPath, Message: "element must not occur more than once"})
+			continue
+		}
+		seen[child.name.Local] = true
+
+		if len(childSchema.children) > 0 {
+			errs = append(errs, validateChildren(childPath, child, childSchema, strict)...)
+			continue
+		}
+
+		if len(child.children) > 0 {
+			errs = append(errs, ValidationError{Path: childPath, Message: "element must not have child elements"})
+			continue
+		}
+
+		if childSchema.check != nil {
+			if msg := childSchema.check(strings.TrimSpace(child.text)); msg != "" {
+				errs = append(errs, ValidationError{Path: childPath, Message: msg})
+			}
+		}
+	}
+
+	for _, childSchema := range schema.children {
+		if childSchema.required && !seen[childSchema.name] {
+			errs = append(errs, ValidationError{Path: path + "/" + childSchema.name, Message: "required element is missing"})
+		}
+	}
+
+	return errs
+}
+
+func findSchema(schemas []elementSchema, name string) (elementSchema, bool) {
+	for _, s := range schemas {
+		if s.name == name {
+			return s, true
+		}
+	}
+
+	return elementSchema{}, false
+}
+
+func parseNode(d *xml.Decoder) (*node, error) {
+	var stack []*node
+	for {
+		token, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			n := &node{name: t.Name, attrs: t.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			}
+			stack = append(stack, n)
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if len(stack) == 0 {
+				return n, nil
+			}
+		}
+	}
+}
+
+func checkNonEmpty(value string) string {
+	if value == "" {
+		return "must not be empty"
+	}
+
+	return ""
+}
+
+func checkNonNegativeLong(value string) string {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return fmt.Sprintf("%q is not a non-negative integer", value)
+	}
+
+	return ""
+}
+
+func checkBoolean(value string) string {
+	switch value {
+	case "true", "false", "1", "0":
+		return ""
+	default:
+		return fmt.Sprintf("%q is not a boolean", value)
+	}
+}
+
+func checkGUID(value string) string {
+	if !guidPattern.MatchString(value) {
+		return fmt.Sprintf("%q is not a GUID", value)
+	}
+
+	return ""
+}
+
+func checkBase64(length int) func(string) string {
+	return func(value string) string {
+		b, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return fmt.Sprintf("%q is not valid base64", value)
+		}
+
+		if len(b) != length {
+			return fmt.Sprintf("must decode to %d bytes, got %d", length, len(b))
+		}
+
+		return ""
+	}
+}
+
+func checkEnum(values ...string) func(string) string {
+	return func(value string) string {
+		for _, v := range values {
+			if v == value {
+				return ""
+			}
+		}
+
+		return fmt.Sprintf("%q must be one of %s", value, strings.Join(values, ", "))
+	}
+}
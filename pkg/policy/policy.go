@@ -0,0 +1,334 @@
+// Package policy evaluates declarative build rules against the source and the
+// application info of a package.
+package policy
+
+import (
+	"bytes"
+	"content-prep/pkg/logger"
+	"content-prep/pkg/packager"
+	"content-prep/pkg/zipper"
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrViolation is returned when a rule of severity error is violated.
+var ErrViolation = errors.New("policy violation")
+
+// Severity is the level of a rule. Only violations of rules of severity error
+// fail the build.
+type Severity string
+
+const (
+	SeverityInfo    Severity = "info"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// RuleType selects one of the built-in rules.
+type RuleType string
+
+const (
+	// RuleMaxPackageSize limits the size of the packaged content.
+	RuleMaxPackageSize RuleType = "maxPackageSize"
+	// RuleForbiddenExtensions rejects files with one of the extensions.
+	RuleForbiddenExtensions RuleType = "forbiddenExtensions"
+	// RuleRequiredFiles requires a file matching each of the patterns.
+	RuleRequiredFiles RuleType = "requiredFiles"
+	// RuleSetupFileName requires the name of the setup file to match a
+	// regular expression.
+	RuleSetupFileName RuleType = "setupFileName"
+	// RuleSecrets looks for credentials in scripts and configuration files.
+	RuleSecrets RuleType = "secrets"
+)
+
+// Policy is a list of rules.
+type Policy struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule configures a built-in rule. Which fields apply depends on the type.
+type Rule struct {
+	Type RuleType `yaml:"type"`
+
+	// Name identifies the rule in violations, defaults to the type.
+	Name string `yaml:"name,omitempty"`
+
+	// Severity defaults to error.
+	Severity Severity `yaml:"severity,omitempty"`
+
+	// MaxSize is the limit of maxPackageSize rules.
+	MaxSize ByteSize `yaml:"maxSize,omitempty"`
+
+	// Extensions are forbidden by forbiddenExtensions rules, and the files
+	// searched by secrets rules (defaults to DefaultSecretExtensions).
+	Extensions []string `yaml:"extensions,omitempty"`
+
+	// Files are the path patterns required by requiredFiles rules.
+	Files []string `yaml:"files,omitempty"`
+
+	// Pattern is the regular expression of setupFileName rules.
+	Pattern string `yaml:"pattern,omitempty"`
+
+	// Patterns are searched by secrets rules in addition to the built-in
+	// patterns.
+	Patterns []SecretPattern `yaml:"patterns,omitempty"`
+
+	pattern  *regexp.Regexp
+	patterns []SecretPattern
+}
+
+// Violation is a rule a package does not comply with.
+type Violation struct {
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	File     string   `json:"file,omitempty"`
+	Line     int      `json:"line,omitempty"`
+	Message  string   `json:"message"`
+}
+
+func (v Violation) String() string {
+	location := v.File
+	if v.Line > 0 {
+		location = fmt.Sprintf("%s:%d", v.File, v.Line)
+	}
+	if location != "" {
+		return fmt.Sprintf("%s: %s: %s", v.Rule, location, v.Message)
+	}
+
+	return fmt.Sprintf("%s: %s", v.Rule, v.Message)
+}
+
+// Report lists the violations found by Check.
+type Report struct {
+	Violations []Violation `json:"violations"`
+}
+
+// Errors returns the number of violations of severity error.
+func (r *Report) Errors() int {
+	var n int
+	for _, v := range r.Violations {
+		if v.Severity == SeverityError {
+			n++
+		}
+	}
+
+	return n
+}
+
+// ViolationError is returned by Evaluate when rules of severity error are
+// violated.
+type ViolationError struct {
+	Report *Report
+}
+
+func (e *ViolationError) Error() string {
+	var errs []string
+	for _, v := range e.Report.Violations {
+		if v.Severity == SeverityError {
+			errs = append(errs, v.String())
+		}
+	}
+
+	return fmt.Sprintf("%s: %s", ErrViolation, strings.Join(errs, "; "))
+}
+
+func (e *ViolationError) Is(target error) bool {
+	return target == ErrViolation
+}
+
+// Load reads a policy file.
+func Load(policyFilePath string) (*Policy, error) {
+	data, err := os.ReadFile(policyFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read policy")
+	}
+
+	policy, err := Parse(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid policy %s", policyFilePath)
+	}
+
+	return policy, nil
+}
+
+// Parse decodes and validates a YAML policy.
+func Parse(data []byte) (*Policy, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var policy Policy
+	if err := dec.Decode(&policy); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode policy")
+	}
+
+	for i := range policy.Rules {
+		if err := policy.Rules[i].compile(); err != nil {
+			return nil, errors.Wrapf(err, "rule %d", i+1)
+		}
+	}
+
+	return &policy, nil
+}
+
+func (r *Rule) compile() error {
+	if r.Name == "" {
+		r.Name = string(r.Type)
+	}
+
+	switch r.Severity {
+	case "":
+		r.Severity = SeverityError
+	case SeverityInfo, SeverityWarning, SeverityError:
+	default:
+		return errors.Errorf("unknown severity %q, expected info, warning or error", r.Severity)
+	}
+
+	switch r.Type {
+	case RuleMaxPackageSize:
+		if r.MaxSize <= 0 {
+			return errors.New("maxPackageSize requires maxSize")
+		}
+	case RuleForbiddenExtensions:
+		if len(r.Extensions) == 0 {
+			return errors.New("forbiddenExtensions requires extensions")
+		}
+	case RuleRequiredFiles:
+		if len(r.Files) == 0 {
+			return errors.New("requiredFiles requires files")
+		}
+
+		for _, pattern := range r.Files {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return errors.Wrapf(err, "invalid file pattern %q", pattern)
+			}
+		}
+	case RuleSetupFileName:
+		pattern, err := regexp.Compile(r.Pattern)
+		if err != nil || r.Pattern == "" {
+			return errors.Errorf("setupFileName requires a valid pattern, got %q", r.Pattern)
+		}
+		r.pattern = pattern
+	case RuleSecrets:
+		if len(r.Extensions) == 0 {
+			r.Extensions = DefaultSecretExtensions
+		}
+
+		r.patterns = append(r.patterns, defaultSecretPatterns...)
+		for _, p := range r.Patterns {
+			if err := p.compile(); err != nil {
+				return err
+			}
+			r.patterns = append(r.patterns, p)
+		}
+	default:
+		return errors.Errorf("unknown rule type %q", r.Type)
+	}
+
+	return nil
+}
+
+// file is a file of the source that is part of the package.
+type file struct {
+	name string
+	size int64
+}
+
+// Check evaluates the rules against the files of the source that are not
+// excluded and the application info of the package.
+func (p *Policy) Check(source fs.FS, exclude []string, info *packager.ApplicationInfo) (*Report, error) {
+	var files []file
+	err := fs.WalkDir(source, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if zipper.Excluded(name, exclude) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, file{name: name, size: fi.Size()})
+
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to walk source")
+	}
+
+	report := &Report{}
+	for i := range p.Rules {
+		rule := &p.Rules[i]
+
+		violations, err := rule.check(source, files, info)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to check rule %s", rule.Name)
+		}
+
+		for _, v := range violations {
+			v.Rule, v.Severity = rule.Name, rule.Severity
+			report.Violations = append(report.Violations, v)
+		}
+	}
+
+	return report, nil
+}
+
+// Evaluate checks the package, logs the violations and fails on violations of
+// severity error. Violations of severity warning are returned as warnings. It
+// implements packager.Policy.
+func (p *Policy) Evaluate(ctx context.Context, source fs.FS, exclude []string, info *packager.ApplicationInfo) ([]string, error) {
+	log := logger.FromContext(ctx).With("component", "policy", "action", "evaluate")
+
+	report, err := p.Check(source, exclude, info)
+	if err != nil {
+		return nil, err
+	}
+
+	var warnings []string
+	for _, v := range report.Violations {
+		attrs := []any{"rule", v.Rule, "message", v.Message}
+		if v.File != "" {
+			attrs = append(attrs, "file", v.File)
+		}
+		if v.Line > 0 {
+			attrs = append(attrs, "line", v.Line)
+		}
+
+		switch v.Severity {
+		case SeverityError:
+			log.Error("policy violation", attrs...)
+		case SeverityWarning:
+			log.Warn("policy violation", attrs...)
+			warnings = append(warnings, v.String())
+		default:
+			log.Info("policy violation", attrs...)
+		}
+	}
+	log.Info("evaluated policy", "rules", len(p.Rules), "violations", len(report.Violations), "errors", report.Errors())
+
+	if report.Errors() > 0 {
+		return warnings, &ViolationError{Report: report}
+	}
+
+	return warnings, nil
+}
@@ -0,0 +1,154 @@
+package policy
+
+import (
+	"content-prep/pkg/packager"
+	"fmt"
+	"io/fs"
+	"path"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+func (r *Rule) check(source fs.FS, files []file, info *packager.ApplicationInfo) ([]Violation, error) {
+	switch r.Type {
+	case RuleMaxPackageSize:
+		return r.checkMaxPackageSize(info), nil
+	case RuleForbiddenExtensions:
+		return r.checkForbiddenExtensions(files), nil
+	case RuleRequiredFiles:
+		return r.checkRequiredFiles(files), nil
+	case RuleSetupFileName:
+		return r.checkSetupFileName(info), nil
+	case RuleSecrets:
+		return r.checkSecrets(source, files)
+	default:
+		return nil, errors.Errorf("unknown rule type %q", r.Type)
+	}
+}
+
+// checkMaxPackageSize compares the size of the unencrypted content, which the
+// package exceeds only by its metadata and the encryption overhead.
+func (r *Rule) checkMaxPackageSize(info *packager.ApplicationInfo) []Violation {
+	if info.UnencryptedContentSize <= int64(r.MaxSize) {
+		return nil
+	}
+
+	return []Violation{{
+		Message: fmt.Sprintf("package content is %s, exceeding the limit of %s", ByteSize(info.UnencryptedContentSize), r.MaxSize),
+	}}
+}
+
+func (r *Rule) checkForbiddenExtensions(files []file) []Violation {
+	var violations []Violation
+	for _, f := range files {
+		if ext := hasExtension(f.name, r.Extensions); ext != "" {
+			violations = append(violations, Violation{File: f.name, Message: fmt.Sprintf("files with extension %s are not allowed", ext)})
+		}
+	}
+
+	return violations
+}
+
+func (r *Rule) checkRequiredFiles(files []file) []Violation {
+	var violations []Violation
+	for _, pattern := range r.Files {
+		found := false
+		for _, f := range files {
+			if ok, _ := path.Match(pattern, f.name); ok {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			violations = append(violations, Violation{Message: fmt.Sprintf("no file matches %s", pattern)})
+		}
+	}
+
+	return violations
+}
+
+func (r *Rule) checkSetupFileName(info *packager.ApplicationInfo) []Violation {
+	if r.pattern.MatchString(info.SetupFile) {
+		return nil
+	}
+
+	return []Violation{{File: info.SetupFile, Message: fmt.Sprintf("setup file name does not match %s", r.Pattern)}}
+}
+
+// hasExtension returns the extension of the list the name ends with.
+func hasExtension(name string, extensions []string) string {
+	lower := strings.ToLower(name)
+	for _, ext := range extensions {
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+
+		if strings.HasSuffix(lower, strings.ToLower(ext)) {
+			return ext
+		}
+	}
+
+	return ""
+}
+
+// ByteSize is a size in bytes, written as a number or with a unit, e.g. 500MB
+// or 2GiB.
+type ByteSize int64
+
+var byteUnits = []struct {
+	suffix string
+	size   int64
+}{
+	{"KIB", 1 << 10}, {"MIB", 1 << 20}, {"GIB", 1 << 30}, {"TIB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// ParseByteSize parses a size with an optional unit.
+func ParseByteSize(s string) (ByteSize, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+
+	unit := int64(1)
+	for _, u := range byteUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value, unit = strings.TrimSpace(strings.TrimSuffix(value, u.suffix)), u.size
+			break
+		}
+	}
+
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil || n < 0 || strings.IndexFunc(value, unicode.IsLetter) >= 0 {
+		return 0, errors.Errorf("invalid size %q", s)
+	}
+
+	return ByteSize(n * float64(unit)), nil
+}
+
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return err
+	}
+
+	*b = size
+
+	return nil
+}
+
+func (b ByteSize) String() string {
+	for _, u := range []struct {
+		suffix string
+		size   int64
+	}{{"TiB", 1 << 40}, {"GiB", 1 << 30}, {"MiB", 1 << 20}, {"KiB", 1 << 10}} {
+		if int64(b) >= u.size {
+			return strconv.FormatFloat(float64(b)/float64(u.size), 'f', 1, 64) + u.suffix
+		}
+	}
+
+	return strconv.FormatInt(int64(b), 10) + "B"
+}
@@ -0,0 +1,120 @@
+package policy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"regexp"
+	"unicode/utf16"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultSecretExtensions are the files searched by secrets rules without
+// explicit extensions.
+var DefaultSecretExtensions = []string{".ps1", ".psm1", ".psd1", ".cmd", ".bat", ".ini", ".xml", ".config"}
+
+// maxSecretFileSize skips larger files, which are unlikely to be handwritten.
+const maxSecretFileSize = 16 << 20
+
+// SecretPattern is a regular expression matching a kind of credential.
+type SecretPattern struct {
+	Name  string `yaml:"name"`
+	Regex string `yaml:"regex"`
+
+	re *regexp.Regexp
+}
+
+func (p *SecretPattern) compile() error {
+	if p.Name == "" {
+		return errors.Errorf("secret pattern %q requires a name", p.Regex)
+	}
+
+	re, err := regexp.Compile(p.Regex)
+	if err != nil || p.Regex == "" {
+		return errors.Errorf("invalid secret pattern %q: %q", p.Name, p.Regex)
+	}
+	p.re = re
+
+	return nil
+}
+
+// Values referring to variables ($x, %x%, ${x}) or parameters are not
+// considered secrets.
+var defaultSecretPatterns = mustCompile(
+	SecretPattern{Name: "password assignment", Regex: `(?i)(?:password|passwd|pwd|secret|api_?key|client_?secret|access_?token)["']?\s*[:=]\s*["']([^"'$%{\s][^"']{3,})["']`},
+	SecretPattern{Name: "password setting", Regex: `(?im)^\s*(?:password|passwd|pwd|secret|api_?key|client_?secret|access_?token)\s*=\s*[^\s"'$%{;][^\s;]{3,}`},
+	SecretPattern{Name: "password element", Regex: `(?i)<(?:password|pwd|secret|apikey|clientsecret)>[^<$%{\s][^<]{3,}</`},
+	SecretPattern{Name: "password parameter", Regex: `(?i)\s-(?:password|secret|token|apikey)\s+["'][^"'$][^"']{3,}["']`},
+	SecretPattern{Name: "plain text secure string", Regex: `(?i)ConvertTo-SecureString\s+(?:-String\s+)?["'][^"']+["']\s+-AsPlainText`},
+	SecretPattern{Name: "private key", Regex: `-----BEGIN (?:[A-Z]+ )?PRIVATE KEY-----`},
+	SecretPattern{Name: "AWS access key", Regex: `\bAKIA[0-9A-Z]{16}\b`},
+	SecretPattern{Name: "Azure storage account key", Regex: `(?i)AccountKey=[A-Za-z0-9+/]{40,}={0,2}`},
+	SecretPattern{Name: "GitHub token", Regex: `\bgh[pousr]_[A-Za-z0-9]{36,}\b`},
+)
+
+func mustCompile(patterns ...SecretPattern) []SecretPattern {
+	for i := range patterns {
+		if err := patterns[i].compile(); err != nil {
+			panic(err)
+		}
+	}
+
+	return patterns
+}
+
+// checkSecrets reports the lines matching a secret pattern, naming the first
+// pattern that matches. The matched text is not part of the violation, so the
+// report does not leak the secret.
+func (r *Rule) checkSecrets(source fs.FS, files []file) ([]Violation, error) {
+	var violations []Violation
+	for _, f := range files {
+		if hasExtension(f.name, r.Extensions) == "" || f.size > maxSecretFileSize {
+			continue
+		}
+
+		data, err := fs.ReadFile(source, f.name)
+		if err != nil {
+			return nil, err
+		}
+
+		lines := bufio.NewScanner(bytes.NewReader(decodeText(data)))
+		lines.Buffer(nil, maxSecretFileSize)
+		for line := 1; lines.Scan(); line++ {
+			for _, p := range r.patterns {
+				if p.re.Match(lines.Bytes()) {
+					violations = append(violations, Violation{File: f.name, Line: line, Message: fmt.Sprintf("possible %s", p.Name)})
+					break
+				}
+			}
+		}
+		if err := lines.Err(); err != nil && err != io.EOF {
+			return nil, errors.Wrapf(err, "failed to read %s", f.name)
+		}
+	}
+
+	return violations, nil
+}
+
+// decodeText converts UTF-16 text with a byte order mark to UTF-8, as written
+// by Windows PowerShell and many Windows editors.
+func decodeText(data []byte) []byte {
+	var order func([]byte) uint16
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		order = func(b []byte) uint16 { return uint16(b[0]) | uint16(b[1])<<8 }
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		order = func(b []byte) uint16 { return uint16(b[0])<<8 | uint16(b[1]) }
+	default:
+		return data
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		units = append(units, order(data[i:]))
+	}
+
+	return []byte(string(utf16.Decode(units)))
+}
@@ -0,0 +1,245 @@
+// Package provenance creates and verifies signed SLSA provenance attestations
+// of packages. Statements follow the in-toto v1 statement format and are
+// signed as DSSE envelopes.
+package provenance
+
+import (
+	"bytes"
+	"content-prep/pkg/signing"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	PayloadType   = "application/vnd.in-toto+json"
+	StatementType = "https://in-toto.io/Statement/v1"
+	PredicateType = "https://slsa.dev/provenance/v1"
+	BuildType     = "https://github.com/maxihafer/content-prep/new@v1"
+
+	// FileSuffix is the suffix of provenance files written next to a package.
+	FileSuffix = ".intoto.json"
+)
+
+var ErrSubjectMismatch = errors.New("package does not match the provenance subject")
+
+// Statement is an in-toto statement with a SLSA provenance predicate.
+type Statement struct {
+	Type          string     `json:"_type"`
+	Subject       []Subject  `json:"subject"`
+	PredicateType string     `json:"predicateType"`
+	Predicate     Provenance `json:"predicate"`
+}
+
+// Subject is an artifact the statement is about.
+type Subject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+// Provenance is the SLSA v1 provenance predicate.
+type Provenance struct {
+	BuildDefinition BuildDefinition `json:"buildDefinition"`
+	RunDetails      RunDetails      `json:"runDetails"`
+}
+
+type BuildDefinition struct {
+	BuildType            string               `json:"buildType"`
+	ExternalParameters   map[string]any       `json:"externalParameters"`
+	InternalParameters   map[string]any       `json:"internalParameters,omitempty"`
+	ResolvedDependencies []ResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type ResourceDescriptor struct {
+	URI    string            `json:"uri,omitempty"`
+	Name   string            `json:"name,omitempty"`
+	Digest map[string]string `json:"digest,omitempty"`
+}
+
+type RunDetails struct {
+	Builder  Builder       `json:"builder"`
+	Metadata BuildMetadata `json:"metadata"`
+}
+
+type Builder struct {
+	ID      string            `json:"id"`
+	Version map[string]string `json:"version,omitempty"`
+}
+
+type BuildMetadata struct {
+	InvocationID string     `json:"invocationId,omitempty"`
+	StartedOn    *time.Time `json:"startedOn,omitempty"`
+	FinishedOn   *time.Time `json:"finishedOn,omitempty"`
+}
+
+// Build describes how a package was built.
+type Build struct {
+	// Source is the location of the source, SourceDigest the SHA-256 digest of
+	// its file tree.
+	Source       string
+	SourceDigest string
+	SetupFile    string
+
+	// Package is the file name of the package, PackageDigest the SHA-256 digest
+	// of its contents.
+	Package       string
+	PackageDigest string
+
+	// Parameters are the options the package was built with.
+	Parameters map[string]any
+
+	BuilderID    string
+	InvocationID string
+	ToolVersion  string
+
+	Started, Finished time.Time
+}
+
+// NewStatement returns the provenance statement of the build.
+func NewStatement(b Build) *Statement {
+	parameters := map[string]any{
+		"source":    b.Source,
+		"setupFile": b.SetupFile,
+	}
+	for k, v := range b.Parameters {
+		parameters[k] = v
+	}
+
+	started, finished := b.Started.UTC(), b.Finished.UTC()
+
+	return &Statement{
+		Type: StatementType,
+		Subject: []Subject{{
+			Name:   b.Package,
+			Digest: map[string]string{"sha256": b.PackageDigest},
+		}},
+		PredicateType: PredicateType,
+		Predicate: Provenance{
+			BuildDefinition: BuildDefinition{
+				BuildType:          BuildType,
+				ExternalParameters: parameters,
+				ResolvedDependencies: []ResourceDescriptor{{
+					URI:    b.Source,
+					Digest: map[string]string{"sha256": b.SourceDigest},
+				}},
+			},
+			RunDetails: RunDetails{
+				Builder: Builder{
+					ID:      b.BuilderID,
+					Version: map[string]string{"content-prep": b.ToolVersion},
+				},
+				Metadata: BuildMetadata{
+					InvocationID: b.InvocationID,
+					StartedOn:    &started,
+					FinishedOn:   &finished,
+				},
+			},
+		},
+	}
+}
+
+// MatchSubject checks that a subject of the statement has the given SHA-256
+// digest.
+func (s *Statement) MatchSubject(digest string) error {
+	for _, subject := range s.Subject {
+		if subject.Digest["sha256"] == digest {
+			return nil
+		}
+	}
+
+	return errors.Wrapf(ErrSubjectMismatch, "sha256 %s", digest)
+}
+
+// Envelope is a DSSE envelope.
+type Envelope struct {
+	PayloadType string      `json:"payloadType"`
+	Payload     []byte      `json:"payload"`
+	Signatures  []Signature `json:"signatures"`
+}
+
+type Signature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// Sign serializes the statement and signs it.
+func Sign(s *Statement, signer *signing.Signer) (*Envelope, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode statement")
+	}
+
+	sig, err := signer.Sign(pae(PayloadType, payload))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to sign statement")
+	}
+
+	return &Envelope{
+		PayloadType: PayloadType,
+		Payload:     payload,
+		Signatures:  []Signature{{KeyID: signer.KeyID, Sig: sig}},
+	}, nil
+}
+
+// Verify checks that a trusted key signed the envelope and returns its
+// statement.
+func Verify(e *Envelope, keys *signing.KeySet) (*Statement, error) {
+	if e.PayloadType != PayloadType {
+		return nil, errors.Errorf("unexpected payload type %q", e.PayloadType)
+	}
+
+	if len(e.Signatures) == 0 {
+		return nil, errors.Wrap(signing.ErrInvalidSignature, "envelope is not signed")
+	}
+
+	var err error
+	for _, sig := range e.Signatures {
+		if err = keys.Verify(sig.KeyID, pae(e.PayloadType, e.Payload), sig.Sig); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var s Statement
+	if err := json.Unmarshal(e.Payload, &s); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode statement")
+	}
+
+	if s.Type != StatementType || s.PredicateType != PredicateType {
+		return nil, errors.Errorf("unexpected statement %s with predicate %s", s.Type, s.PredicateType)
+	}
+
+	return &s, nil
+}
+
+// Read decodes an envelope.
+func Read(r io.Reader) (*Envelope, error) {
+	var e Envelope
+	if err := json.NewDecoder(r).Decode(&e); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode envelope")
+	}
+
+	return &e, nil
+}
+
+// Write encodes an envelope.
+func Write(w io.Writer, e *Envelope) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(e)
+}
+
+// pae is the DSSE pre-authentication encoding of the payload.
+func pae(payloadType string, payload []byte) []byte {
+	buf := &bytes.Buffer{}
+	_, _ = fmt.Fprintf(buf, "DSSEv1 %d %s %d ", len(payloadType), payloadType, len(payload))
+	buf.Write(payload)
+
+	return buf.Bytes()
+}
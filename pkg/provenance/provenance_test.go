@@ -0,0 +1,101 @@
+package provenance
+
+import (
+	"bytes"
+	"content-prep/pkg/signing"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestProvenanceTestSuite(t *testing.T) {
+	suite.Run(t, new(ProvenanceTestSuite))
+}
+
+type ProvenanceTestSuite struct {
+	suite.Suite
+
+	signer *signing.Signer
+	keys   *signing.KeySet
+}
+
+func newSigner() *signing.Signer {
+	_, key, _ := ed25519.GenerateKey(rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+
+	signer, err := signing.ParseSigner(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		panic(err)
+	}
+
+	return signer
+}
+
+func (s *ProvenanceTestSuite) SetupTest() {
+	s.signer = newSigner()
+	s.keys = &signing.KeySet{}
+	s.Require().NoError(s.keys.Add(s.signer.Public()))
+}
+
+func (s *ProvenanceTestSuite) build() Build {
+	return Build{
+		Source:        "/src",
+		SourceDigest:  "aa",
+		SetupFile:     "setup.exe",
+		Package:       "setup.intunewin",
+		PackageDigest: "bb",
+		Parameters:    map[string]any{"compression": "store"},
+		BuilderID:     "https://ci.example.com/pipelines/1",
+		ToolVersion:   "1.2.3",
+		Started:       time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+		Finished:      time.Date(2024, 1, 1, 0, 1, 0, 0, time.UTC),
+	}
+}
+
+func (s *ProvenanceTestSuite) TestSignAndVerify() {
+	envelope, err := Sign(NewStatement(s.build()), s.signer)
+	s.Require().NoError(err)
+	s.Require().Equal(s.signer.KeyID, envelope.Signatures[0].KeyID)
+
+	buf := &bytes.Buffer{}
+	s.Require().NoError(Write(buf, envelope))
+
+	read, err := Read(buf)
+	s.Require().NoError(err)
+
+	statement, err := Verify(read, s.keys)
+	s.Require().NoError(err)
+	s.Require().Equal("setup.intunewin", statement.Subject[0].Name)
+	s.Require().Equal("store", statement.Predicate.BuildDefinition.ExternalParameters["compression"])
+	s.Require().Equal("setup.exe", statement.Predicate.BuildDefinition.ExternalParameters["setupFile"])
+	s.Require().Equal("aa", statement.Predicate.BuildDefinition.ResolvedDependencies[0].Digest["sha256"])
+	s.Require().Equal("1.2.3", statement.Predicate.RunDetails.Builder.Version["content-prep"])
+
+	s.Require().NoError(statement.MatchSubject("bb"))
+	s.Require().ErrorIs(statement.MatchSubject("cc"), ErrSubjectMismatch)
+}
+
+func (s *ProvenanceTestSuite) TestVerifyInvalid() {
+	envelope, err := Sign(NewStatement(s.build()), s.signer)
+	s.Require().NoError(err)
+
+	tampered := *envelope
+	tampered.Payload = bytes.Replace(envelope.Payload, []byte(`"bb"`), []byte(`"cc"`), 1)
+	_, err = Verify(&tampered, s.keys)
+	s.Require().ErrorIs(err, signing.ErrInvalidSignature)
+
+	other := &signing.KeySet{}
+	s.Require().NoError(other.Add(newSigner().Public()))
+	_, err = Verify(envelope, other)
+	s.Require().ErrorIs(err, signing.ErrUntrustedKey)
+
+	unsigned := *envelope
+	unsigned.Signatures = nil
+	_, err = Verify(&unsigned, s.keys)
+	s.Require().ErrorIs(err, signing.ErrInvalidSignature)
+}
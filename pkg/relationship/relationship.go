@@ -0,0 +1,359 @@
+// Package relationship declares dependencies and supersedence between apps and
+// converts them into the payloads of Graph's mobileAppRelationship resources.
+package relationship
+
+import (
+	"bytes"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// FileSuffix is appended to the package name for the relationships written
+// next to the package.
+const FileSuffix = ".relationships.json"
+
+// ErrInvalid is returned for relationships that reference undeclared apps or
+// contain cycles.
+var ErrInvalid = errors.New("invalid relationships")
+
+const (
+	graphDependencyType   = "#microsoft.graph.mobileAppDependency"
+	graphSupersedenceType = "#microsoft.graph.mobileAppSupersedence"
+
+	DependencyTypeAutoInstall = "autoInstall"
+	DependencyTypeDetect      = "detect"
+
+	SupersedenceTypeReplace = "replace"
+	SupersedenceTypeUpdate  = "update"
+)
+
+// Graph is a relationships file: the apps and their relationships.
+type Graph struct {
+	Apps []App `yaml:"apps"`
+}
+
+// Ref identifies an app by the name and version recorded in the application
+// info of its package.
+type Ref struct {
+	Name string `yaml:"name"`
+
+	// Version matches any version when empty.
+	Version string `yaml:"version,omitempty"`
+}
+
+func (r Ref) String() string {
+	if r.Version == "" {
+		return r.Name
+	}
+
+	return r.Name + " " + r.Version
+}
+
+// App is an app with the apps it depends on and supersedes.
+type App struct {
+	Ref `yaml:",inline"`
+
+	// ID is the Intune app ID of apps that were uploaded before. Apps without
+	// ID are expected to be built alongside.
+	ID string `yaml:"id,omitempty"`
+
+	Dependencies []Dependency   `yaml:"dependencies,omitempty"`
+	Supersedes   []Supersedence `yaml:"supersedes,omitempty"`
+}
+
+// Dependency is an app that must be installed before the app.
+type Dependency struct {
+	Ref `yaml:",inline"`
+
+	// DetectOnly requires the dependency to be installed already instead of
+	// installing it automatically.
+	DetectOnly bool `yaml:"detectOnly,omitempty"`
+
+	target int
+}
+
+// Supersedence is an app replaced by the app.
+type Supersedence struct {
+	Ref `yaml:",inline"`
+
+	// UninstallPrevious uninstalls the superseded app before the app is
+	// installed, instead of updating it in place.
+	UninstallPrevious bool `yaml:"uninstallPrevious,omitempty"`
+
+	target int
+}
+
+// Payload is the body of Graph's updateRelationships action of an app.
+type Payload struct {
+	Relationships []GraphRelationship `json:"relationships"`
+}
+
+// GraphRelationship is a Graph mobileAppDependency or mobileAppSupersedence.
+// TargetID is empty for apps without ID and has to be resolved once the
+// target is uploaded.
+type GraphRelationship struct {
+	ODataType        string `json:"@odata.type"`
+	TargetID         string `json:"targetId"`
+	DependencyType   string `json:"dependencyType,omitempty"`
+	SupersedenceType string `json:"supersedenceType,omitempty"`
+
+	// TargetDisplayName and TargetDisplayVersion name the target to resolve
+	// its ID. Graph sets them itself, they are not part of the payload.
+	TargetDisplayName    string `json:"-"`
+	TargetDisplayVersion string `json:"-"`
+}
+
+// Load reads a relationships file.
+func Load(relationshipsFilePath string) (*Graph, error) {
+	data, err := os.ReadFile(relationshipsFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read relationships")
+	}
+
+	g, err := Parse(data)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid relationships %s", relationshipsFilePath)
+	}
+
+	return g, nil
+}
+
+// Parse decodes a YAML relationships file and validates it: every referenced
+// app must be declared and neither dependencies nor supersedence may form a
+// cycle.
+func Parse(data []byte) (*Graph, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var g Graph
+	if err := dec.Decode(&g); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode relationships")
+	}
+
+	if err := g.resolve(); err != nil {
+		return nil, err
+	}
+
+	if err := g.checkCycles("dependency", func(app *App) []int {
+		targets := make([]int, 0, len(app.Dependencies))
+		for _, d := range app.Dependencies {
+			targets = append(targets, d.target)
+		}
+
+		return targets
+	}); err != nil {
+		return nil, err
+	}
+
+	if err := g.checkCycles("supersedence", func(app *App) []int {
+		targets := make([]int, 0, len(app.Supersedes))
+		for _, s := range app.Supersedes {
+			targets = append(targets, s.target)
+		}
+
+		return targets
+	}); err != nil {
+		return nil, err
+	}
+
+	return &g, nil
+}
+
+// resolve links the references to the declared apps.
+func (g *Graph) resolve() error {
+	declared := map[Ref]bool{}
+	for _, app := range g.Apps {
+		if app.Name == "" {
+			return errors.Wrap(ErrInvalid, "app without name")
+		}
+
+		if declared[app.Ref] {
+			return errors.Wrapf(ErrInvalid, "app %s is declared twice", app.Ref)
+		}
+		declared[app.Ref] = true
+	}
+
+	for i := range g.Apps {
+		app := &g.Apps[i]
+
+		for j := range app.Dependencies {
+			target, err := g.lookup(app, app.Dependencies[j].Ref)
+			if err != nil {
+				return err
+			}
+			app.Dependencies[j].target = target
+		}
+
+		for j := range app.Supersedes {
+			target, err := g.lookup(app, app.Supersedes[j].Ref)
+			if err != nil {
+				return err
+			}
+
+			for _, d := range app.Dependencies {
+				if d.target == target {
+					return errors.Wrapf(ErrInvalid, "app %s both depends on and supersedes %s", app.Ref, g.Apps[target].Ref)
+				}
+			}
+			app.Supersedes[j].target = target
+		}
+	}
+
+	return nil
+}
+
+// lookup returns the index of the declared app a reference of app points to.
+// References without version must be unambiguous.
+func (g *Graph) lookup(app *App, ref Ref) (int, error) {
+	var matches []int
+	for i, candidate := range g.Apps {
+		if candidate.Name == ref.Name && (ref.Version == "" || candidate.Version == ref.Version) {
+			matches = append(matches, i)
+		}
+	}
+
+	switch {
+	case len(matches) == 0:
+		return 0, errors.Wrapf(ErrInvalid, "app %s references %s, which is not declared", app.Ref, ref)
+	case len(matches) > 1:
+		return 0, errors.Wrapf(ErrInvalid, "app %s references %s, which matches %d apps, add a version", app.Ref, ref, len(matches))
+	case &g.Apps[matches[0]] == app:
+		return 0, errors.Wrapf(ErrInvalid, "app %s references itself", app.Ref)
+	}
+
+	return matches[0], nil
+}
+
+// checkCycles fails if the edges form a cycle, naming the apps on it.
+func (g *Graph) checkCycles(kind string, edges func(app *App) []int) error {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+
+	state := make([]int, len(g.Apps))
+	var stack []int
+
+	var visit func(i int) error
+	visit = func(i int) error {
+		switch state[i] {
+		case visited:
+			return nil
+		case visiting:
+			var names []string
+			for _, j := range append(stack[slices.Index(stack, i):], i) {
+				names = append(names, g.Apps[j].Ref.String())
+			}
+
+			return errors.Wrapf(ErrInvalid, "%s cycle: %s", kind, strings.Join(names, " -> "))
+		}
+
+		state[i] = visiting
+		stack = append(stack, i)
+		for _, j := range edges(&g.Apps[i]) {
+			if err := visit(j); err != nil {
+				return err
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[i] = visited
+
+		return nil
+	}
+
+	for i := range g.Apps {
+		if err := visit(i); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Find returns the app declared for a package with the given name and
+// version, preferring apps declared with the exact version over apps declared
+// without version, or nil. A package of unknown version, e.g. a plain exe,
+// matches the only app declared with its name.
+func (g *Graph) Find(name, version string) *App {
+	var (
+		found *App
+		named []*App
+	)
+	for i := range g.Apps {
+		app := &g.Apps[i]
+		if app.Name != name {
+			continue
+		}
+		named = append(named, app)
+
+		if app.Version == version && version != "" {
+			return app
+		}
+		if app.Version == "" {
+			found = app
+		}
+	}
+
+	if found == nil && version == "" && len(named) == 1 {
+		return named[0]
+	}
+
+	return found
+}
+
+// Payload returns the relationships of the app as Graph payload.
+func (g *Graph) Payload(app *App) *Payload {
+	payload := &Payload{Relationships: []GraphRelationship{}}
+
+	for _, d := range app.Dependencies {
+		r := g.relationship(graphDependencyType, d.target)
+		r.DependencyType = DependencyTypeAutoInstall
+		if d.DetectOnly {
+			r.DependencyType = DependencyTypeDetect
+		}
+
+		payload.Relationships = append(payload.Relationships, r)
+	}
+
+	for _, s := range app.Supersedes {
+		r := g.relationship(graphSupersedenceType, s.target)
+		r.SupersedenceType = SupersedenceTypeUpdate
+		if s.UninstallPrevious {
+			r.SupersedenceType = SupersedenceTypeReplace
+		}
+
+		payload.Relationships = append(payload.Relationships, r)
+	}
+
+	return payload
+}
+
+func (g *Graph) relationship(odataType string, target int) GraphRelationship {
+	app := g.Apps[target]
+
+	return GraphRelationship{
+		ODataType:            odataType,
+		TargetID:             app.ID,
+		TargetDisplayName:    app.Name,
+		TargetDisplayVersion: app.Version,
+	}
+}
+
+// Missing returns the apps without ID that are not among the built apps, so
+// their relationships could never be resolved.
+func (g *Graph) Missing(built []*App) []string {
+	var missing []string
+	for i := range g.Apps {
+		app := &g.Apps[i]
+		if app.ID == "" && !slices.Contains(built, app) {
+			missing = append(missing, app.Ref.String())
+		}
+	}
+
+	return missing
+}
@@ -0,0 +1,83 @@
+package relationship
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestRelationshipTestSuite(t *testing.T) {
+	suite.Run(t, new(RelationshipTestSuite))
+}
+
+type RelationshipTestSuite struct {
+	suite.Suite
+}
+
+const testRelationships = `
+apps:
+  - name: VC Redist
+    version: "14.38"
+    id: 2f1c7e0a-1111-4d2b-9c3e-000000000001
+  - name: Contoso Runtime
+    dependencies:
+      - name: VC Redist
+        detectOnly: true
+  - name: Contoso App
+    version: "2.0"
+    dependencies:
+      - name: Contoso Runtime
+    supersedes:
+      - name: Contoso App
+        version: "1.0"
+        uninstallPrevious: true
+  - name: Contoso App
+    version: "1.0"
+    id: 2f1c7e0a-1111-4d2b-9c3e-000000000002
+`
+
+func (s *RelationshipTestSuite) TestPayload() {
+	g, err := Parse([]byte(testRelationships))
+	s.Require().NoError(err)
+
+	app := g.Find("Contoso App", "2.0")
+	s.Require().NotNil(app)
+	s.Require().Equal(&Payload{Relationships: []GraphRelationship{
+		{ODataType: graphDependencyType, TargetDisplayName: "Contoso Runtime", DependencyType: DependencyTypeAutoInstall},
+		{ODataType: graphSupersedenceType, TargetID: "2f1c7e0a-1111-4d2b-9c3e-000000000002", TargetDisplayName: "Contoso App", TargetDisplayVersion: "1.0", SupersedenceType: SupersedenceTypeReplace},
+	}}, g.Payload(app))
+
+	data, err := json.Marshal(g.Payload(app).Relationships[1])
+	s.Require().NoError(err)
+	s.Require().JSONEq(`{"@odata.type":"#microsoft.graph.mobileAppSupersedence","targetId":"2f1c7e0a-1111-4d2b-9c3e-000000000002","supersedenceType":"replace"}`, string(data))
+
+	runtime := g.Find("Contoso Runtime", "3.1")
+	s.Require().NotNil(runtime)
+	s.Require().Equal(DependencyTypeDetect, g.Payload(runtime).Relationships[0].DependencyType)
+
+	s.Require().Nil(g.Find("Contoso App", "3.0"))
+	s.Require().Nil(g.Find("Contoso App", ""), "ambiguous without version")
+	s.Require().Equal("14.38", g.Find("VC Redist", "").Version)
+	s.Require().Empty(g.Payload(g.Find("VC Redist", "14.38")).Relationships)
+
+	s.Require().Equal([]string{"Contoso Runtime", "Contoso App 2.0"}, g.Missing(nil))
+	s.Require().Empty(g.Missing([]*App{app, runtime}))
+}
+
+func (s *RelationshipTestSuite) TestParseInvalid() {
+	for data, message := range map[string]string{
+		"apps:\n  - version: '1'\n":                           "app without name",
+		"apps:\n  - name: a\n  - name: a\n":                   "app a is declared twice",
+		"apps:\n  - name: a\n    dependencies: [{name: b}]\n": "app a references b, which is not declared",
+		"apps:\n  - name: a\n    dependencies: [{name: a}]\n": "app a references itself",
+		"apps:\n  - name: a\n    version: '1'\n  - name: a\n    version: '2'\n  - name: b\n    supersedes: [{name: a}]\n":                             "matches 2 apps",
+		"apps:\n  - name: a\n    dependencies: [{name: b}]\n    supersedes: [{name: b}]\n  - name: b\n":                                               "app a both depends on and supersedes b",
+		"apps:\n  - name: a\n    dependencies: [{name: b}]\n  - name: b\n    dependencies: [{name: c}]\n  - name: c\n    dependencies: [{name: a}]\n": "dependency cycle: a -> b -> c -> a",
+		"apps:\n  - name: a\n    supersedes: [{name: b}]\n  - name: b\n    supersedes: [{name: a}]\n":                                                 "supersedence cycle: a -> b -> a",
+		"apps:\n  - name: a\n    typo: true\n": "field typo not found",
+	} {
+		_, err := Parse([]byte(data))
+		s.Require().ErrorContains(err, message, data)
+	}
+}
@@ -0,0 +1,106 @@
+package sbom
+
+import (
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+)
+
+type cdxBOM struct {
+	BOMFormat    string         `json:"bomFormat"`
+	SpecVersion  string         `json:"specVersion"`
+	SerialNumber string         `json:"serialNumber"`
+	Version      int            `json:"version"`
+	Metadata     cdxMetadata    `json:"metadata"`
+	Components   []cdxComponent `json:"components"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type       string        `json:"type"`
+	BOMRef     string        `json:"bom-ref,omitempty"`
+	Name       string        `json:"name"`
+	Version    string        `json:"version,omitempty"`
+	Publisher  string        `json:"publisher,omitempty"`
+	Hashes     []cdxHash     `json:"hashes,omitempty"`
+	Properties []cdxProperty `json:"properties,omitempty"`
+}
+
+type cdxHash struct {
+	Algorithm string `json:"alg"`
+	Content   string `json:"content"`
+}
+
+type cdxProperty struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// WriteCycloneDX writes the document as CycloneDX 1.5 JSON. Every file is a
+// component of type file.
+func WriteCycloneDX(w io.Writer, d *Document) error {
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + d.ID,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: d.Created.Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: toolName}}},
+			Component: cdxComponent{Type: "application", BOMRef: "application", Name: d.Name, Version: d.Version, Publisher: d.Publisher},
+		},
+		Components: make([]cdxComponent, 0, len(d.Files)),
+	}
+
+	for _, f := range d.Files {
+		c := cdxComponent{
+			Type:   "file",
+			BOMRef: "file:" + f.Name,
+			Name:   f.Name,
+			Hashes: []cdxHash{
+				{Algorithm: "SHA-256", Content: f.SHA256},
+				{Algorithm: "SHA-1", Content: f.SHA1},
+			},
+			Properties: []cdxProperty{{Name: toolName + ":size", Value: strconv.FormatInt(f.Size, 10)}},
+		}
+
+		if v := f.Version; v != nil {
+			c.Version = v.ProductVersion
+			c.Publisher = v.Publisher
+			c.Properties = appendProperties(c.Properties,
+				"productName", v.ProductName,
+				"fileVersion", v.FileVersion,
+				"productCode", v.ProductCode,
+				"upgradeCode", v.UpgradeCode,
+			)
+		}
+
+		bom.Components = append(bom.Components, c)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(bom)
+}
+
+// appendProperties appends the non-empty name and value pairs.
+func appendProperties(properties []cdxProperty, pairs ...string) []cdxProperty {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			properties = append(properties, cdxProperty{Name: toolName + ":" + pairs[i], Value: pairs[i+1]})
+		}
+	}
+
+	return properties
+}
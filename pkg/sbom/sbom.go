@@ -0,0 +1,228 @@
+// Package sbom describes the files of a package as CycloneDX or SPDX software
+// bill of materials.
+package sbom
+
+import (
+	"content-prep/pkg/installer"
+	"content-prep/pkg/source"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	FormatCycloneDX = "cyclonedx"
+	FormatSPDX      = "spdx"
+
+	toolName = "content-prep"
+)
+
+// File describes a single file of a package.
+type File struct {
+	Name   string
+	Size   int64
+	SHA256 string
+	SHA1   string
+
+	// Version is the version metadata of PE files and MSI databases.
+	Version *installer.VersionInfo
+}
+
+// Document is a bill of materials of an application package.
+type Document struct {
+	// ID is a random UUID identifying the document.
+	ID        string
+	Name      string
+	Version   string
+	Publisher string
+	Created   time.Time
+	Files     []File
+}
+
+// NewDocument returns a document for the application with the given files.
+// Version and publisher default to the version metadata of the setup file.
+func NewDocument(name, setupFile string, files []File) *Document {
+	d := &Document{
+		ID:      newUUID(),
+		Name:    name,
+		Created: time.Now().UTC().Truncate(time.Second),
+		Files:   files,
+	}
+
+	for _, f := range files {
+		if f.Version != nil && (f.Name == setupFile || path.Base(f.Name) == setupFile) {
+			d.Version = f.Version.ProductVersion
+			d.Publisher = f.Version.Publisher
+			break
+		}
+	}
+
+	return d
+}
+
+// Write writes the document in the given format.
+func Write(w io.Writer, d *Document, format string) error {
+	switch strings.ToLower(format) {
+	case FormatCycloneDX:
+		return WriteCycloneDX(w, d)
+	case FormatSPDX:
+		return WriteSPDX(w, d)
+	default:
+		return errors.Errorf("unknown SBOM format %q, expected %s or %s", format, FormatCycloneDX, FormatSPDX)
+	}
+}
+
+// FileSuffix returns the suffix of SBOM files written next to a package.
+func FileSuffix(format string) string {
+	switch strings.ToLower(format) {
+	case FormatCycloneDX:
+		return ".cdx.json"
+	case FormatSPDX:
+		return ".spdx.json"
+	default:
+		return "." + format + ".json"
+	}
+}
+
+// Collector gathers the files added to an archive, see zipper.WithVisitor.
+// Version metadata of binaries is read from fsys, files it cannot be read for
+// are listed without it.
+type Collector struct {
+	fsys fs.FS
+
+	mu    sync.Mutex
+	files []File
+}
+
+func NewCollector(fsys fs.FS) *Collector {
+	return &Collector{fsys: fsys}
+}
+
+// Visit implements zipper.Visitor.
+func (c *Collector) Visit(name string, _ fs.FileInfo) (io.WriteCloser, error) {
+	return &fileDigester{
+		collector: c,
+		name:      name,
+		sha256:    sha256.New(),
+		sha1:      sha1.New(),
+	}, nil
+}
+
+// Files returns the collected files sorted by name.
+func (c *Collector) Files() []File {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files := append([]File(nil), c.files...)
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+
+	return files
+}
+
+func (c *Collector) add(f File) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.files = append(c.files, f)
+}
+
+// Collect describes all files of fsys.
+func Collect(fsys fs.FS) ([]File, error) {
+	c := NewCollector(fsys)
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		w, _ := c.Visit(name, info)
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		if _, err := io.Copy(w, f); err != nil {
+			return errors.Wrapf(err, "failed to read %s", name)
+		}
+
+		return w.Close()
+	})
+
+	return c.Files(), err
+}
+
+type fileDigester struct {
+	collector    *Collector
+	name         string
+	size         int64
+	sha256, sha1 hash.Hash
+}
+
+func (d *fileDigester) Write(p []byte) (int, error) {
+	d.size += int64(len(p))
+	d.sha256.Write(p)
+	d.sha1.Write(p)
+
+	return len(p), nil
+}
+
+func (d *fileDigester) Close() error {
+	d.collector.add(File{
+		Name:    d.name,
+		Size:    d.size,
+		SHA256:  hex.EncodeToString(d.sha256.Sum(nil)),
+		SHA1:    hex.EncodeToString(d.sha1.Sum(nil)),
+		Version: versionOf(d.collector.fsys, d.name),
+	})
+
+	return nil
+}
+
+func versionOf(fsys fs.FS, name string) *installer.VersionInfo {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".exe", ".dll", ".msi":
+	default:
+		return nil
+	}
+
+	f, err := source.OpenFile(fsys, name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	info, err := installer.ReadVersion(name, f, f.Size)
+	if err != nil {
+		return nil
+	}
+
+	return info
+}
+
+func newUUID() string {
+	var b [16]byte
+	_, _ = rand.Read(b[:])
+	b[6] = b[6]&0x0F | 0x40
+	b[8] = b[8]&0x3F | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
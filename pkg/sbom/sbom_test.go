@@ -0,0 +1,100 @@
+package sbom
+
+import (
+	"bytes"
+	"content-prep/pkg/installer"
+	"encoding/json"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSBOMTestSuite(t *testing.T) {
+	suite.Run(t, new(SBOMTestSuite))
+}
+
+type SBOMTestSuite struct {
+	suite.Suite
+
+	fsys fstest.MapFS
+}
+
+func (s *SBOMTestSuite) SetupTest() {
+	s.fsys = fstest.MapFS{
+		"setup.exe":       {Data: []byte("MZ not really a PE file")},
+		"docs/readme.txt": {Data: []byte("hello")},
+	}
+}
+
+func (s *SBOMTestSuite) TestCollect() {
+	files, err := Collect(s.fsys)
+	s.Require().NoError(err)
+	s.Require().Len(files, 2)
+
+	s.Require().Equal("docs/readme.txt", files[0].Name)
+	s.Require().Equal(int64(5), files[0].Size)
+	s.Require().Equal("2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824", files[0].SHA256)
+	s.Require().Equal("aaf4c61ddcc5e8a2dabede0f3b482cd9aea9434d", files[0].SHA1)
+	s.Require().Nil(files[0].Version)
+
+	s.Require().Equal("setup.exe", files[1].Name)
+	s.Require().Nil(files[1].Version)
+}
+
+func (s *SBOMTestSuite) TestNewDocument() {
+	files := []File{
+		{Name: "bin/setup.exe", Version: &installer.VersionInfo{ProductVersion: "1.2.3", Publisher: "Contoso Ltd"}},
+		{Name: "helper.dll", Version: &installer.VersionInfo{ProductVersion: "9.9"}},
+	}
+
+	d := NewDocument("Contoso App", "setup.exe", files)
+	s.Require().Equal("Contoso App", d.Name)
+	s.Require().Equal("1.2.3", d.Version)
+	s.Require().Equal("Contoso Ltd", d.Publisher)
+	s.Require().Regexp(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`, d.ID)
+}
+
+func (s *SBOMTestSuite) TestWrite() {
+	files, err := Collect(s.fsys)
+	s.Require().NoError(err)
+	files[1].Version = &installer.VersionInfo{ProductName: "Contoso App", ProductVersion: "1.2.3", Publisher: "Contoso Ltd"}
+
+	d := NewDocument("Contoso App", "setup.exe", files)
+
+	buf := &bytes.Buffer{}
+	s.Require().NoError(Write(buf, d, FormatCycloneDX))
+
+	var bom struct {
+		BOMFormat    string
+		SpecVersion  string
+		SerialNumber string
+		Metadata     struct{ Component cdxComponent }
+		Components   []cdxComponent
+	}
+	s.Require().NoError(json.Unmarshal(buf.Bytes(), &bom))
+	s.Require().Equal("CycloneDX", bom.BOMFormat)
+	s.Require().Equal("urn:uuid:"+d.ID, bom.SerialNumber)
+	s.Require().Equal("1.2.3", bom.Metadata.Component.Version)
+	s.Require().Len(bom.Components, 2)
+	s.Require().Equal("file", bom.Components[1].Type)
+	s.Require().Equal("Contoso Ltd", bom.Components[1].Publisher)
+	s.Require().Contains(bom.Components[0].Hashes, cdxHash{Algorithm: "SHA-256", Content: files[0].SHA256})
+	s.Require().Contains(bom.Components[1].Properties, cdxProperty{Name: "content-prep:productName", Value: "Contoso App"})
+
+	buf.Reset()
+	s.Require().NoError(Write(buf, d, FormatSPDX))
+
+	var doc spdxDocument
+	s.Require().NoError(json.Unmarshal(buf.Bytes(), &doc))
+	s.Require().Equal("SPDX-2.3", doc.SPDXVersion)
+	s.Require().Equal("Organization: Contoso Ltd", doc.Packages[0].Supplier)
+	s.Require().Len(doc.Files, 2)
+	s.Require().Equal("./docs/readme.txt", doc.Files[0].FileName)
+	s.Require().Contains(doc.Files[0].Checksums, spdxChecksum{Algorithm: "SHA1", Value: files[0].SHA1})
+	s.Require().Len(doc.Relationships, 3)
+
+	s.Require().Error(Write(buf, d, "swid"))
+	s.Require().Equal(".cdx.json", FileSuffix(FormatCycloneDX))
+	s.Require().Equal(".spdx.json", FileSuffix(FormatSPDX))
+}
@@ -0,0 +1,128 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Files             []spdxFile         `json:"files"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string `json:"name"`
+	SPDXID           string `json:"SPDXID"`
+	VersionInfo      string `json:"versionInfo,omitempty"`
+	Supplier         string `json:"supplier,omitempty"`
+	DownloadLocation string `json:"downloadLocation"`
+	FilesAnalyzed    bool   `json:"filesAnalyzed"`
+}
+
+type spdxFile struct {
+	FileName  string         `json:"fileName"`
+	SPDXID    string         `json:"SPDXID"`
+	Checksums []spdxChecksum `json:"checksums"`
+	Comment   string         `json:"comment,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"checksumValue"`
+}
+
+type spdxRelationship struct {
+	Element string `json:"spdxElementId"`
+	Type    string `json:"relationshipType"`
+	Related string `json:"relatedSpdxElement"`
+}
+
+const spdxPackageID = "SPDXRef-Package"
+
+// WriteSPDX writes the document as SPDX 2.3 JSON. The application is a package
+// that contains every file.
+func WriteSPDX(w io.Writer, d *Document) error {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              d.Name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + url.PathEscape(d.Name) + "-" + d.ID,
+		CreationInfo: spdxCreationInfo{
+			Created:  d.Created.Format(time.RFC3339),
+			Creators: []string{"Tool: " + toolName},
+		},
+		Packages: []spdxPackage{{
+			Name:             d.Name,
+			SPDXID:           spdxPackageID,
+			VersionInfo:      d.Version,
+			DownloadLocation: "NOASSERTION",
+		}},
+		Files:         make([]spdxFile, 0, len(d.Files)),
+		Relationships: []spdxRelationship{{Element: "SPDXRef-DOCUMENT", Type: "DESCRIBES", Related: spdxPackageID}},
+	}
+
+	if d.Publisher != "" {
+		doc.Packages[0].Supplier = "Organization: " + d.Publisher
+	}
+
+	for i, f := range d.Files {
+		id := fmt.Sprintf("SPDXRef-File-%d", i+1)
+
+		file := spdxFile{
+			FileName: "./" + f.Name,
+			SPDXID:   id,
+			Checksums: []spdxChecksum{
+				{Algorithm: "SHA1", Value: f.SHA1},
+				{Algorithm: "SHA256", Value: f.SHA256},
+			},
+		}
+
+		if v := f.Version; v != nil {
+			file.Comment = versionComment(v.ProductName, v.ProductVersion, v.FileVersion, v.Publisher, v.ProductCode)
+		}
+
+		doc.Files = append(doc.Files, file)
+		doc.Relationships = append(doc.Relationships, spdxRelationship{Element: spdxPackageID, Type: "CONTAINS", Related: id})
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(doc)
+}
+
+// versionComment describes the version metadata of a file, which SPDX has no
+// fields for.
+func versionComment(productName, productVersion, fileVersion, publisher, productCode string) string {
+	var parts []string
+	for _, p := range [][2]string{
+		{"ProductName", productName},
+		{"ProductVersion", productVersion},
+		{"FileVersion", fileVersion},
+		{"Publisher", publisher},
+		{"ProductCode", productCode},
+	} {
+		if p[1] != "" {
+			parts = append(parts, p[0]+": "+p[1])
+		}
+	}
+
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,191 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// DefaultClamdTimeout bounds a single clamd command.
+const DefaultClamdTimeout = 5 * time.Minute
+
+// clamdChunkSize is the size of the INSTREAM chunks. clamd rejects streams
+// longer than its StreamMaxLength regardless of the chunk size.
+const clamdChunkSize = 64 << 10
+
+// Clamd scans files with a ClamAV daemon using the INSTREAM command.
+type Clamd struct {
+	network string
+	address string
+
+	// Timeout bounds each command, defaults to DefaultClamdTimeout.
+	Timeout time.Duration
+}
+
+// NewClamd returns a client for the clamd listening on the address, either
+// unix:///path/to/clamd.sock, tcp://host:port, a socket path or host:port.
+func NewClamd(address string) (*Clamd, error) {
+	c := &Clamd{Timeout: DefaultClamdTimeout}
+
+	switch {
+	case strings.Contains(address, "://"):
+		u, err := url.Parse(address)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid clamd address %q", address)
+		}
+
+		switch u.Scheme {
+		case "unix":
+			c.network, c.address = "unix", u.Path
+		case "tcp":
+			c.network, c.address = "tcp", u.Host
+		default:
+			return nil, errors.Errorf("unsupported clamd address scheme %q, expected unix or tcp", u.Scheme)
+		}
+	case strings.HasPrefix(address, "/"):
+		c.network, c.address = "unix", address
+	default:
+		c.network, c.address = "tcp", address
+	}
+
+	if c.address == "" {
+		return nil, errors.Errorf("invalid clamd address %q", address)
+	}
+
+	return c, nil
+}
+
+func (c *Clamd) Name() string {
+	return "clamd"
+}
+
+// Ping checks that clamd is reachable.
+func (c *Clamd) Ping(ctx context.Context) error {
+	reply, err := c.command(ctx, "PING", nil)
+	if err != nil {
+		return err
+	}
+
+	if reply != "PONG" {
+		return errors.Errorf("unexpected clamd reply %q", reply)
+	}
+
+	return nil
+}
+
+// Scan streams r to clamd and returns the signature name of a detection.
+func (c *Clamd) Scan(ctx context.Context, name string, r io.Reader) (string, error) {
+	reply, err := c.command(ctx, "INSTREAM", r)
+	if err != nil {
+		return "", err
+	}
+
+	// stream: OK, stream: <signature> FOUND or <message> ERROR
+	status := strings.TrimPrefix(reply, "stream: ")
+	switch {
+	case status == "OK":
+		return "", nil
+	case strings.HasSuffix(status, " FOUND"):
+		return strings.TrimSuffix(status, " FOUND"), nil
+	default:
+		return "", errors.Errorf("clamd: %s", strings.TrimSuffix(status, " ERROR"))
+	}
+}
+
+// command sends a null terminated command, followed by r in INSTREAM chunks,
+// and reads the reply.
+func (c *Clamd) command(ctx context.Context, command string, r io.Reader) (string, error) {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, c.network, c.address)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to connect to clamd")
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(c.Timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	if err := conn.SetDeadline(deadline); err != nil {
+		return "", err
+	}
+
+	w := bufio.NewWriterSize(conn, clamdChunkSize+4)
+	if _, err := w.WriteString("z" + command + "\x00"); err != nil {
+		return "", errors.Wrapf(err, "failed to send clamd command")
+	}
+
+	var sendErr error
+	if r != nil {
+		src := &recordingReader{r: r}
+		if sendErr = writeChunks(w, src); src.err != nil {
+			return "", src.err
+		}
+	}
+	if sendErr == nil {
+		sendErr = errors.Wrapf(w.Flush(), "failed to send clamd command")
+	}
+
+	// clamd replies and closes the connection early when a stream exceeds
+	// its limits, so the reply is read even if sending failed.
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		if sendErr != nil {
+			return "", sendErr
+		}
+
+		return "", errors.Wrapf(err, "failed to read clamd reply")
+	}
+
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
+
+// writeChunks writes r as length prefixed chunks terminated by an empty chunk.
+func writeChunks(w io.Writer, r io.Reader) error {
+	chunk := make([]byte, 4+clamdChunkSize)
+
+	for {
+		n, err := io.ReadFull(r, chunk[4:])
+		if n > 0 {
+			binary.BigEndian.PutUint32(chunk, uint32(n))
+			if _, err := w.Write(chunk[:4+n]); err != nil {
+				return errors.Wrapf(err, "failed to stream to clamd")
+			}
+		}
+
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err := w.Write([]byte{0, 0, 0, 0})
+
+	return errors.Wrapf(err, "failed to stream to clamd")
+}
+
+// recordingReader records the error of the underlying reader, to tell it
+// apart from errors talking to clamd.
+type recordingReader struct {
+	r   io.Reader
+	err error
+}
+
+func (r *recordingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	if err != nil && err != io.EOF {
+		r.err = err
+	}
+
+	return n, err
+}
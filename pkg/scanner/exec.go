@@ -0,0 +1,168 @@
+package scanner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FilePlaceholder is replaced by the path of a temporary copy of the file in
+// the arguments of an exec scanner.
+const FilePlaceholder = "{}"
+
+// Exec scans files with an external command. The content of the file is
+// passed on stdin, or as a temporary file when an argument contains
+// FilePlaceholder. Following clamscan, exit code 0 means clean and 1 means a
+// threat was found, whose name is the last line of the output. Any other exit
+// code fails the scan.
+type Exec struct {
+	name string
+	args []string
+}
+
+// NewExec returns a scanner running the command with the arguments.
+func NewExec(name string, args ...string) *Exec {
+	return &Exec{name: name, args: args}
+}
+
+// ParseCommand splits a command line into the command and its arguments.
+// Arguments are separated by whitespace and may be quoted with single or double
+// quotes, e.g. to keep paths with spaces together. Backslashes are kept, so
+// Windows paths need no escaping; inside double quotes \" is a literal quote.
+func ParseCommand(commandLine string) ([]string, error) {
+	var (
+		args    []string
+		arg     strings.Builder
+		inArg   bool
+		quote   rune
+		escaped bool
+	)
+
+	for _, c := range commandLine {
+		switch {
+		case escaped:
+			if c != '"' {
+				arg.WriteRune('\\')
+			}
+			arg.WriteRune(c)
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0 && c == quote:
+			quote = 0
+		case quote != 0:
+			arg.WriteRune(c)
+		case c == '\'' || c == '"':
+			quote, inArg = c, true
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(c)
+			inArg = true
+		}
+	}
+
+	if escaped {
+		arg.WriteRune('\\')
+	}
+	if quote != 0 {
+		return nil, errors.Errorf("unterminated %c quote in command %q", quote, commandLine)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+
+	return args, nil
+}
+
+func (e *Exec) Name() string {
+	return path.Base(strings.ReplaceAll(e.name, `\`, "/"))
+}
+
+func (e *Exec) Scan(ctx context.Context, name string, r io.Reader) (string, error) {
+	args := make([]string, len(e.args))
+	copy(args, e.args)
+
+	var stdin io.Reader = r
+	var tempFilePath string
+	for i, arg := range args {
+		if !strings.Contains(arg, FilePlaceholder) {
+			continue
+		}
+
+		if tempFilePath == "" {
+			var err error
+			if tempFilePath, err = tempCopy(name, r); err != nil {
+				return "", err
+			}
+			defer os.RemoveAll(path.Dir(tempFilePath))
+
+			stdin = nil
+		}
+
+		args[i] = strings.ReplaceAll(arg, FilePlaceholder, tempFilePath)
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, e.name, args...)
+	cmd.Stdin = stdin
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	err := cmd.Run()
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return "", nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return threatName(output.String()), nil
+	default:
+		return "", errors.Wrapf(err, "%s: %s", e.Name(), strings.TrimSpace(output.String()))
+	}
+}
+
+// tempCopy copies r to a file with the base name of the scanned file in a new
+// temporary directory, as scanners may rely on the extension.
+func tempCopy(name string, r io.Reader) (string, error) {
+	dir, err := os.MkdirTemp(os.TempDir(), "content-prep-scan-*")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create temporary directory")
+	}
+
+	tempFilePath := path.Join(dir, path.Base(name))
+	f, err := os.Create(tempFilePath)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrapf(err, "failed to create temporary file")
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		os.RemoveAll(dir)
+		return "", errors.Wrapf(err, "failed to copy %s", name)
+	}
+
+	return tempFilePath, nil
+}
+
+// threatName returns the last non-empty line of the scanner output.
+func threatName(output string) string {
+	lines := strings.Split(strings.TrimSpace(output), "\n")
+	threat := strings.TrimSpace(lines[len(lines)-1])
+	if threat == "" {
+		return "unknown threat"
+	}
+
+	return threat
+}
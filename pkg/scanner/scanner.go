@@ -0,0 +1,220 @@
+// Package scanner streams the files of a package source to content scanners,
+// e.g. antivirus engines, before they are encrypted.
+package scanner
+
+import (
+	"content-prep/pkg/zipper"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FileSuffix is appended to the package name to name the scan report.
+const FileSuffix = ".scan.json"
+
+// ErrDetected is returned when a scanner reports a threat.
+var ErrDetected = errors.New("threat detected")
+
+// ErrChanged is returned when a file archived after the scan is not the file
+// that was scanned.
+var ErrChanged = errors.New("file changed after it was scanned")
+
+// Scanner inspects the content of a single file.
+type Scanner interface {
+	// Name identifies the scanner in reports.
+	Name() string
+
+	// Scan reads the content of the file from r and reports the threat it
+	// found, or an empty string when the file is clean.
+	Scan(ctx context.Context, name string, r io.Reader) (string, error)
+}
+
+// Report records the results of a scan.
+type Report struct {
+	Scanners []string     `json:"scanners"`
+	Started  time.Time    `json:"started"`
+	Finished time.Time    `json:"finished"`
+	Files    []FileResult `json:"files"`
+}
+
+// FileResult is the result of scanning a file with every scanner.
+type FileResult struct {
+	File       string      `json:"file"`
+	Size       int64       `json:"size"`
+	SHA256     string      `json:"sha256"`
+	Detections []Detection `json:"detections,omitempty"`
+}
+
+// Detection is a threat reported by a scanner.
+type Detection struct {
+	Scanner string `json:"scanner"`
+	Threat  string `json:"threat"`
+}
+
+// Detections returns the number of files with detections.
+func (r *Report) Detections() int {
+	var n int
+	for _, file := range r.Files {
+		if len(file.Detections) > 0 {
+			n++
+		}
+	}
+
+	return n
+}
+
+// DetectionError is returned by Scan when threats were detected. It carries
+// the report, so it can be recorded even though the build fails.
+type DetectionError struct {
+	Report *Report
+}
+
+func (e *DetectionError) Error() string {
+	return fmt.Sprintf("%s in %d of %d files", ErrDetected, e.Report.Detections(), len(e.Report.Files))
+}
+
+func (e *DetectionError) Is(target error) bool {
+	return target == ErrDetected
+}
+
+// Scan streams every file of fsys that is not excluded to each scanner. It
+// returns a *DetectionError when any scanner reports a threat.
+func Scan(ctx context.Context, fsys fs.FS, scanners []Scanner, exclude []string) (*Report, error) {
+	report := &Report{Started: time.Now()}
+	for _, s := range scanners {
+		report.Scanners = append(report.Scanners, s.Name())
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if zipper.Excluded(name, exclude) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		result, err := scanFile(ctx, fsys, name, scanners)
+		if err != nil {
+			return errors.Wrapf(err, "failed to scan %s", name)
+		}
+
+		report.Files = append(report.Files, *result)
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	report.Finished = time.Now()
+
+	if report.Detections() > 0 {
+		return report, &DetectionError{Report: report}
+	}
+
+	return report, nil
+}
+
+func scanFile(ctx context.Context, fsys fs.FS, name string, scanners []Scanner) (*FileResult, error) {
+	result := &FileResult{File: name}
+
+	for i, s := range scanners {
+		f, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+
+		var r io.Reader = f
+		h := sha256.New()
+		if i == 0 {
+			r = io.TeeReader(f, h)
+		}
+
+		threat, err := s.Scan(ctx, name, r)
+		if err == nil && i == 0 {
+			// Drain what the scanner did not read, so the digest covers
+			// the whole file.
+			_, err = io.Copy(io.Discard, r)
+		}
+		f.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s", s.Name())
+		}
+
+		if i == 0 {
+			result.SHA256 = hex.EncodeToString(h.Sum(nil))
+			if info, err := fs.Stat(fsys, name); err == nil {
+				result.Size = info.Size()
+			}
+		}
+
+		if threat != "" {
+			result.Detections = append(result.Detections, Detection{Scanner: s.Name(), Threat: threat})
+		}
+	}
+
+	return result, nil
+}
+
+// Visitor returns a zipper visitor checking that every archived file is one of
+// the scanned files with the digest it was scanned with, so a file replaced
+// between scanning and archiving fails the build instead of being packaged
+// unscanned.
+func (r *Report) Visitor() zipper.Visitor {
+	digests := make(map[string]string, len(r.Files))
+	for _, file := range r.Files {
+		digests[file.File] = file.SHA256
+	}
+
+	return scannedFiles(digests)
+}
+
+// scannedFiles maps the names of the scanned files to their SHA256.
+type scannedFiles map[string]string
+
+func (s scannedFiles) Visit(name string, _ fs.FileInfo) (io.WriteCloser, error) {
+	digest, ok := s[name]
+	if !ok {
+		return nil, errors.Wrapf(ErrChanged, "%s was not scanned", name)
+	}
+
+	return &digestCheck{name: name, expected: digest, h: sha256.New()}, nil
+}
+
+type digestCheck struct {
+	name     string
+	expected string
+	h        hash.Hash
+}
+
+func (c *digestCheck) Write(p []byte) (int, error) {
+	return c.h.Write(p)
+}
+
+func (c *digestCheck) Close() error {
+	if digest := hex.EncodeToString(c.h.Sum(nil)); digest != c.expected {
+		return errors.Wrapf(ErrChanged, "%s has SHA256 %s, scanned %s", c.name, digest, c.expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package scanner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+func TestScannerTestSuite(t *testing.T) {
+	suite.Run(t, new(ScannerTestSuite))
+}
+
+type ScannerTestSuite struct {
+	suite.Suite
+}
+
+// fakeClamd serves the clamd PING and INSTREAM commands, detecting the EICAR
+// test string and rejecting streams longer than maxLength.
+func (s *ScannerTestSuite) fakeClamd(network, address string, maxLength int) string {
+	l, err := net.Listen(network, address)
+	s.Require().NoError(err)
+	s.T().Cleanup(func() { l.Close() })
+
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+
+			go func() {
+				defer conn.Close()
+
+				r := bufio.NewReader(conn)
+				command, err := r.ReadString(0)
+				if err != nil {
+					return
+				}
+
+				switch command {
+				case "zPING\x00":
+					conn.Write([]byte("PONG\x00"))
+				case "zINSTREAM\x00":
+					var stream bytes.Buffer
+					for {
+						var size uint32
+						if err := binary.Read(r, binary.BigEndian, &size); err != nil {
+							return
+						}
+						if size == 0 {
+							break
+						}
+
+						if stream.Len()+int(size) > maxLength {
+							conn.Write([]byte("INSTREAM size limit exceeded. ERROR\x00"))
+							return
+						}
+
+						if _, err := io.CopyN(&stream, r, int64(size)); err != nil {
+							return
+						}
+					}
+
+					if strings.Contains(stream.String(), eicar) {
+						conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+					} else {
+						conn.Write([]byte("stream: OK\x00"))
+					}
+				default:
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+				}
+			}()
+		}
+	}()
+
+	return l.Addr().String()
+}
+
+func (s *ScannerTestSuite) TestClamd() {
+	ctx := context.Background()
+
+	address := s.fakeClamd("tcp", "127.0.0.1:0", 1<<20)
+
+	c, err := NewClamd("tcp://" + address)
+	s.Require().NoError(err)
+	s.Require().NoError(c.Ping(ctx))
+
+	threat, err := c.Scan(ctx, "clean.txt", strings.NewReader("clean"))
+	s.Require().NoError(err)
+	s.Require().Empty(threat)
+
+	// spans several chunks
+	large := strings.Repeat("a", 3*clamdChunkSize+1) + eicar
+	threat, err = c.Scan(ctx, "eicar.com", strings.NewReader(large))
+	s.Require().NoError(err)
+	s.Require().Equal("Eicar-Test-Signature", threat)
+
+	_, err = c.Scan(ctx, "large.bin", bytes.NewReader(make([]byte, 2<<20)))
+	s.Require().ErrorContains(err, "size limit exceeded")
+
+	_, err = c.Scan(ctx, "broken.bin", io.MultiReader(strings.NewReader("a"), errReader{}))
+	s.Require().ErrorIs(err, os.ErrClosed)
+}
+
+func (s *ScannerTestSuite) TestClamdUnixSocket() {
+	socketPath := path.Join(s.T().TempDir(), "clamd.sock")
+	s.fakeClamd("unix", socketPath, 1<<20)
+
+	for _, address := range []string{socketPath, "unix://" + socketPath} {
+		c, err := NewClamd(address)
+		s.Require().NoError(err)
+		s.Require().NoError(c.Ping(context.Background()), address)
+	}
+}
+
+func (s *ScannerTestSuite) TestNewClamdInvalid() {
+	for _, address := range []string{"http://localhost:3310", "tcp://", "unix://"} {
+		_, err := NewClamd(address)
+		s.Require().Error(err, address)
+	}
+}
+
+func (s *ScannerTestSuite) TestExec() {
+	ctx := context.Background()
+
+	stdin := NewExec("sh", "-c", `if grep -q EICAR; then echo "stdin: Eicar-Test-Signature FOUND"; exit 1; fi`)
+	file := NewExec("sh", "-c", `case "$1" in *.com) ;; *) exit 2;; esac; if grep -q EICAR "$1"; then echo "Eicar-Test-Signature"; exit 1; fi`, "sh", FilePlaceholder)
+	failing := NewExec("sh", "-c", "echo engine failure; exit 2")
+
+	s.Require().Equal("sh", stdin.Name())
+
+	for _, scanner := range []*Exec{stdin, file} {
+		threat, err := scanner.Scan(ctx, "clean.com", strings.NewReader("clean"))
+		s.Require().NoError(err)
+		s.Require().Empty(threat)
+
+		threat, err = scanner.Scan(ctx, "dir/eicar.com", strings.NewReader(eicar))
+		s.Require().NoError(err)
+		s.Require().Contains(threat, "Eicar-Test-Signature")
+	}
+
+	_, err := failing.Scan(ctx, "clean.com", strings.NewReader("clean"))
+	s.Require().ErrorContains(err, "engine failure")
+}
+
+func (s *ScannerTestSuite) TestScan() {
+	fsys := fstest.MapFS{
+		"setup.exe":          {Data: []byte("setup")},
+		"files/eicar.com":    {Data: []byte(eicar)},
+		"excluded/eicar.com": {Data: []byte(eicar)},
+	}
+
+	address := s.fakeClamd("tcp", "127.0.0.1:0", 1<<20)
+	c, err := NewClamd(address)
+	s.Require().NoError(err)
+
+	scanners := []Scanner{c, NewExec("sh", "-c", `if grep -q EICAR; then echo Eicar; exit 1; fi`)}
+
+	report, err := Scan(context.Background(), fsys, scanners, []string{"excluded"})
+	s.Require().ErrorIs(err, ErrDetected)
+
+	var detectionErr *DetectionError
+	s.Require().True(errors.As(err, &detectionErr))
+	s.Require().Same(report, detectionErr.Report)
+
+	s.Require().Equal([]string{"clamd", "sh"}, report.Scanners)
+	s.Require().Len(report.Files, 2)
+	s.Require().Equal(1, report.Detections())
+	s.Require().Equal("files/eicar.com", report.Files[0].File)
+	s.Require().Equal(int64(len(eicar)), report.Files[0].Size)
+	s.Require().Len(report.Files[0].SHA256, 64)
+	s.Require().Equal([]Detection{{Scanner: "clamd", Threat: "Eicar-Test-Signature"}, {Scanner: "sh", Threat: "Eicar"}}, report.Files[0].Detections)
+	s.Require().Empty(report.Files[1].Detections)
+
+	data, err := json.Marshal(report)
+	s.Require().NoError(err)
+
+	var decoded Report
+	s.Require().NoError(json.Unmarshal(data, &decoded))
+	s.Require().Equal(report.Files, decoded.Files)
+
+	report, err = Scan(context.Background(), fsys, scanners, []string{"eicar.com"})
+	s.Require().NoError(err)
+	s.Require().Zero(report.Detections())
+}
+
+func (s *ScannerTestSuite) TestParseCommand() {
+	for commandLine, expected := range map[string][]string{
+		"":                                   nil,
+		"clamscan --no-summary {}":           {"clamscan", "--no-summary", "{}"},
+		`"/opt/My Scanner/scan" -f {}`:       {"/opt/My Scanner/scan", "-f", "{}"},
+		`'C:\Program Files\scan.exe' {}`:     {`C:\Program Files\scan.exe`, "{}"},
+		`C:\tools\scan.exe  --tag "a \"b\""`: {`C:\tools\scan.exe`, "--tag", `a "b"`},
+		`scan --empty ""`:                    {"scan", "--empty", ""},
+	} {
+		command, err := ParseCommand(commandLine)
+		s.Require().NoError(err, commandLine)
+		s.Require().Equal(expected, command, commandLine)
+	}
+
+	_, err := ParseCommand(`"/opt/My Scanner/scan {}`)
+	s.Require().Error(err)
+}
+
+func (s *ScannerTestSuite) TestVisitor() {
+	fsys := fstest.MapFS{"setup.exe": {Data: []byte("setup")}}
+
+	report, err := Scan(context.Background(), fsys, []Scanner{NewExec("true")}, nil)
+	s.Require().NoError(err)
+
+	archive := func(name, content string) error {
+		w, err := report.Visitor().Visit(name, nil)
+		if err != nil {
+			return err
+		}
+		_, _ = io.WriteString(w, content)
+
+		return w.Close()
+	}
+
+	s.Require().NoError(archive("setup.exe", "setup"))
+	s.Require().ErrorIs(archive("setup.exe", "replaced"), ErrChanged)
+	s.Require().ErrorIs(archive("added.exe", "setup"), ErrChanged)
+}
+
+type errReader struct{}
+
+func (errReader) Read([]byte) (int, error) {
+	return 0, os.ErrClosed
+}
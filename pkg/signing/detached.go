@@ -0,0 +1,100 @@
+package signing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// SignatureFileSuffix is appended to the name of a file to name its detached
+// signature.
+const SignatureFileSuffix = ".sig"
+
+// detachedDomain separates detached signatures from other signatures made
+// with the same key.
+const detachedDomain = "content-prep detached signature v1\n"
+
+// Detached is a signature over the SHA-256 of a file, stored next to it.
+type Detached struct {
+	Digest    Digest `json:"digest"`
+	KeyID     string `json:"keyid"`
+	Signature []byte `json:"sig"`
+}
+
+// Digest is the digest a detached signature covers.
+type Digest struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// SignDetached hashes the content of r and signs the digest.
+func SignDetached(r io.Reader, signer *Signer) (*Detached, error) {
+	digest, err := sha256Digest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := signer.Sign(digest.payload())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Detached{Digest: digest, KeyID: signer.KeyID, Signature: signature}, nil
+}
+
+// VerifyDetached checks that the content of r matches the signed digest and
+// that the signature was made by a trusted key.
+func VerifyDetached(r io.Reader, d *Detached, keys *KeySet) error {
+	if d.Digest.Algorithm != "sha256" {
+		return errors.Wrapf(ErrInvalidSignature, "unsupported digest algorithm %q", d.Digest.Algorithm)
+	}
+
+	if err := keys.Verify(d.KeyID, d.Digest.payload(), d.Signature); err != nil {
+		return err
+	}
+
+	digest, err := sha256Digest(r)
+	if err != nil {
+		return err
+	}
+
+	if digest.Value != d.Digest.Value {
+		return errors.Wrapf(ErrInvalidSignature, "content digest %s does not match signed digest %s", digest.Value, d.Digest.Value)
+	}
+
+	return nil
+}
+
+// ReadDetached decodes a detached signature.
+func ReadDetached(r io.Reader) (*Detached, error) {
+	var d Detached
+	if err := json.NewDecoder(r).Decode(&d); err != nil {
+		return nil, errors.Wrapf(err, "failed to decode detached signature")
+	}
+
+	return &d, nil
+}
+
+// WriteDetached encodes a detached signature.
+func WriteDetached(w io.Writer, d *Detached) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(d)
+}
+
+func sha256Digest(r io.Reader) (Digest, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return Digest{}, errors.Wrapf(err, "failed to hash content")
+	}
+
+	return Digest{Algorithm: "sha256", Value: hex.EncodeToString(h.Sum(nil))}, nil
+}
+
+func (d Digest) payload() []byte {
+	return []byte(detachedDomain + d.Algorithm + ":" + d.Value + "\n")
+}
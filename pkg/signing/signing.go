@@ -0,0 +1,273 @@
+// Package signing signs and verifies data with ed25519 and ECDSA keys stored
+// as PEM files.
+package signing
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrUntrustedKey     = errors.New("untrusted key")
+)
+
+// Signer signs data with a private key.
+type Signer struct {
+	// KeyID identifies the public key, see KeyID.
+	KeyID string
+
+	key crypto.Signer
+}
+
+// LoadSigner reads a PEM encoded ed25519 or ECDSA private key (PKCS#8 or SEC 1).
+func LoadSigner(keyFilePath string) (*Signer, error) {
+	data, err := os.ReadFile(keyFilePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read signing key")
+	}
+
+	return ParseSigner(data)
+}
+
+// ParseSigner parses a PEM encoded ed25519 or ECDSA private key.
+func ParseSigner(data []byte) (*Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("signing key is not PEM encoded")
+	}
+
+	var key any
+	var err error
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return nil, errors.Errorf("unsupported signing key type %q", block.Type)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse signing key")
+	}
+
+	switch key := key.(type) {
+	case ed25519.PrivateKey:
+		return newSigner(key)
+	case *ecdsa.PrivateKey:
+		return newSigner(key)
+	default:
+		return nil, errors.Errorf("unsupported signing key %T, expected ed25519 or ECDSA", key)
+	}
+}
+
+func newSigner(key crypto.Signer) (*Signer, error) {
+	keyID, err := KeyID(key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Signer{KeyID: keyID, key: key}, nil
+}
+
+// Public returns the public key of the signer.
+func (s *Signer) Public() crypto.PublicKey {
+	return s.key.Public()
+}
+
+// Sign signs data. ECDSA signatures are ASN.1 encoded and use the hash matching
+// the curve.
+func (s *Signer) Sign(data []byte) ([]byte, error) {
+	switch key := s.key.(type) {
+	case *ecdsa.PrivateKey:
+		hash := curveHash(key.Curve)
+		h := hash.New()
+		h.Write(data)
+
+		return key.Sign(rand.Reader, h.Sum(nil), hash)
+	default:
+		return s.key.Sign(rand.Reader, data, crypto.Hash(0))
+	}
+}
+
+// KeyID is the hex encoded SHA-256 of the PKIX encoding of the public key.
+func KeyID(pub crypto.PublicKey) (string, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode public key")
+	}
+
+	sum := sha256.Sum256(der)
+
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// MarshalPublicKey returns the PEM encoding of the public key.
+func MarshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to encode public key")
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), nil
+}
+
+// KeySet holds trusted public keys by their key ID.
+type KeySet struct {
+	keys map[string]crypto.PublicKey
+}
+
+// LoadKeySet reads trusted keys from PEM files. Each file may hold public keys,
+// certificates or private keys, of which the public keys are trusted.
+func LoadKeySet(keyFilePaths ...string) (*KeySet, error) {
+	ks := &KeySet{keys: map[string]crypto.PublicKey{}}
+
+	for _, keyFilePath := range keyFilePaths {
+		data, err := os.ReadFile(keyFilePath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read trusted key")
+		}
+
+		if err := ks.AddPEM(data); err != nil {
+			return nil, errors.Wrapf(err, "%s", keyFilePath)
+		}
+	}
+
+	return ks, nil
+}
+
+// AddPEM adds all keys of the PEM data.
+func (ks *KeySet) AddPEM(data []byte) error {
+	var found bool
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var pub crypto.PublicKey
+		switch block.Type {
+		case "PUBLIC KEY":
+			key, err := x509.ParsePKIXPublicKey(block.Bytes)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse public key")
+			}
+			pub = key
+		case "CERTIFICATE":
+			certificate, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return errors.Wrapf(err, "failed to parse certificate")
+			}
+			pub = certificate.PublicKey
+		case "PRIVATE KEY", "EC PRIVATE KEY":
+			signer, err := ParseSigner(pem.EncodeToMemory(block))
+			if err != nil {
+				return err
+			}
+			pub = signer.Public()
+		default:
+			continue
+		}
+
+		if err := ks.Add(pub); err != nil {
+			return err
+		}
+		found = true
+	}
+
+	if !found {
+		return errors.New("no keys found")
+	}
+
+	return nil
+}
+
+// Add trusts the public key.
+func (ks *KeySet) Add(pub crypto.PublicKey) error {
+	switch pub.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+	default:
+		return errors.Errorf("unsupported public key %T, expected ed25519 or ECDSA", pub)
+	}
+
+	keyID, err := KeyID(pub)
+	if err != nil {
+		return err
+	}
+
+	if ks.keys == nil {
+		ks.keys = map[string]crypto.PublicKey{}
+	}
+	ks.keys[keyID] = pub
+
+	return nil
+}
+
+// Len returns the number of trusted keys.
+func (ks *KeySet) Len() int {
+	return len(ks.keys)
+}
+
+// Verify checks the signature over data with the trusted key of the given ID.
+// Without a key ID every trusted key is tried.
+func (ks *KeySet) Verify(keyID string, data, signature []byte) error {
+	if keyID != "" {
+		pub, ok := ks.keys[keyID]
+		if !ok {
+			return errors.Wrapf(ErrUntrustedKey, "%s", keyID)
+		}
+
+		return verify(pub, data, signature)
+	}
+
+	for _, pub := range ks.keys {
+		if verify(pub, data, signature) == nil {
+			return nil
+		}
+	}
+
+	return errors.Wrap(ErrInvalidSignature, "no trusted key matches the signature")
+}
+
+func verify(pub crypto.PublicKey, data, signature []byte) error {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, data, signature) {
+			return ErrInvalidSignature
+		}
+	case *ecdsa.PublicKey:
+		hash := curveHash(pub.Curve)
+		h := hash.New()
+		h.Write(data)
+
+		if !ecdsa.VerifyASN1(pub, h.Sum(nil), signature) {
+			return ErrInvalidSignature
+		}
+	default:
+		return errors.Errorf("unsupported public key %T", pub)
+	}
+
+	return nil
+}
+
+func curveHash(curve elliptic.Curve) crypto.Hash {
+	switch curve {
+	case elliptic.P384():
+		return crypto.SHA384
+	case elliptic.P521():
+		return crypto.SHA512
+	default:
+		return crypto.SHA256
+	}
+}
@@ -0,0 +1,136 @@
+package signing
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSigningTestSuite(t *testing.T) {
+	suite.Run(t, new(SigningTestSuite))
+}
+
+type SigningTestSuite struct {
+	suite.Suite
+}
+
+func pkcs8PEM(key crypto.PrivateKey) []byte {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		panic(err)
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func (s *SigningTestSuite) keys() map[string][]byte {
+	_, ed, _ := ed25519.GenerateKey(rand.Reader)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p384, _ := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+
+	sec1, err := x509.MarshalECPrivateKey(p384)
+	s.Require().NoError(err)
+
+	return map[string][]byte{
+		"ed25519": pkcs8PEM(ed),
+		"p256":    pkcs8PEM(p256),
+		"p384":    pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: sec1}),
+	}
+}
+
+func (s *SigningTestSuite) TestSignAndVerify() {
+	dir := s.T().TempDir()
+
+	for name, key := range s.keys() {
+		signer, err := ParseSigner(key)
+		s.Require().NoError(err, name)
+		s.Require().Len(signer.KeyID, 64, name)
+
+		signature, err := signer.Sign([]byte("payload"))
+		s.Require().NoError(err, name)
+
+		pub, err := MarshalPublicKey(signer.Public())
+		s.Require().NoError(err, name)
+
+		keyFilePath := path.Join(dir, name+".pub")
+		s.Require().NoError(os.WriteFile(keyFilePath, pub, 0644))
+
+		ks, err := LoadKeySet(keyFilePath)
+		s.Require().NoError(err, name)
+		s.Require().Equal(1, ks.Len())
+
+		s.Require().NoError(ks.Verify(signer.KeyID, []byte("payload"), signature), name)
+		s.Require().NoError(ks.Verify("", []byte("payload"), signature), name)
+		s.Require().ErrorIs(ks.Verify(signer.KeyID, []byte("tampered"), signature), ErrInvalidSignature, name)
+		s.Require().ErrorIs(ks.Verify("", []byte("tampered"), signature), ErrInvalidSignature, name)
+		s.Require().ErrorIs(ks.Verify("other", []byte("payload"), signature), ErrUntrustedKey, name)
+	}
+}
+
+func (s *SigningTestSuite) TestKeySetFromPrivateKey() {
+	key := s.keys()["ed25519"]
+
+	signer, err := ParseSigner(key)
+	s.Require().NoError(err)
+
+	ks := &KeySet{}
+	s.Require().NoError(ks.AddPEM(key))
+
+	signature, err := signer.Sign([]byte("payload"))
+	s.Require().NoError(err)
+	s.Require().NoError(ks.Verify(signer.KeyID, []byte("payload"), signature))
+}
+
+func (s *SigningTestSuite) TestDetached() {
+	keys := s.keys()
+
+	for name, key := range keys {
+		signer, err := ParseSigner(key)
+		s.Require().NoError(err, name)
+
+		d, err := SignDetached(strings.NewReader("package"), signer)
+		s.Require().NoError(err, name)
+
+		var buf bytes.Buffer
+		s.Require().NoError(WriteDetached(&buf, d), name)
+
+		d, err = ReadDetached(&buf)
+		s.Require().NoError(err, name)
+		s.Require().Equal(signer.KeyID, d.KeyID, name)
+
+		ks := &KeySet{}
+		s.Require().NoError(ks.AddPEM(key), name)
+
+		s.Require().NoError(VerifyDetached(strings.NewReader("package"), d, ks), name)
+		s.Require().ErrorIs(VerifyDetached(strings.NewReader("tampered"), d, ks), ErrInvalidSignature, name)
+
+		forged := *d
+		forged.Digest.Value = strings.Repeat("0", 64)
+		s.Require().ErrorIs(VerifyDetached(strings.NewReader("package"), &forged, ks), ErrInvalidSignature, name)
+
+		other := &KeySet{}
+		s.Require().NoError(other.AddPEM(keys[map[string]string{"ed25519": "p256", "p256": "p384", "p384": "ed25519"}[name]]), name)
+		s.Require().ErrorIs(VerifyDetached(strings.NewReader("package"), d, other), ErrUntrustedKey, name)
+	}
+}
+
+func (s *SigningTestSuite) TestParseInvalid() {
+	_, err := ParseSigner([]byte("not pem"))
+	s.Require().Error(err)
+
+	_, err = ParseSigner(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: []byte{1}}))
+	s.Require().Error(err)
+
+	s.Require().Error((&KeySet{}).AddPEM([]byte("no keys")))
+}
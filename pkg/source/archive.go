@@ -0,0 +1,147 @@
+package source
+
+import (
+	"archive/zip"
+	"compress/gzip"
+	"content-prep/pkg/logger"
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// IsArchive reports whether the location names an archive that can be mounted.
+func IsArchive(location string) bool {
+	return archiveFormat(location) != ""
+}
+
+const (
+	formatZip   = "zip"
+	formatTar   = "tar"
+	formatTarGz = "tar.gz"
+)
+
+func archiveFormat(location string) string {
+	name := strings.ToLower(location)
+
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return formatZip
+	case strings.HasSuffix(name, ".tar"):
+		return formatTar
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return formatTarGz
+	default:
+		return ""
+	}
+}
+
+func openArchive(ctx context.Context, location string) (*Source, error) {
+	log := logger.FromContext(ctx).With("component", "source", "action", "open")
+
+	switch archiveFormat(location) {
+	case formatZip:
+		r, err := zip.OpenReader(location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open zip archive")
+		}
+		log.Debug("mounted zip archive", "archive", location, "files", len(r.File))
+
+		return &Source{FS: namedFS{r, location}, closers: []func() error{r.Close}}, nil
+	case formatTar:
+		f, err := os.Open(location)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open tar archive")
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			_ = f.Close()
+			return nil, errors.Wrapf(err, "failed to get tar archive info")
+		}
+
+		fsys, err := NewTarFS(f, info.Size())
+		if err != nil {
+			_ = f.Close()
+			return nil, err
+		}
+		log.Debug("mounted tar archive", "archive", location, "files", len(fsys.entries))
+
+		return &Source{FS: namedFS{fsys, location}, closers: []func() error{f.Close}}, nil
+	case formatTarGz:
+		return openTarGz(ctx, location)
+	default:
+		return nil, errors.Errorf("unsupported source %s, expected a directory or a .zip, .tar or .tar.gz archive", location)
+	}
+}
+
+// openTarGz decompresses the archive into a temporary file once, so the
+// entries of the tarball can be read at random afterwards.
+func openTarGz(ctx context.Context, location string) (*Source, error) {
+	log := logger.FromContext(ctx).With("component", "source", "action", "open")
+
+	f, err := os.Open(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open tar.gz archive")
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read gzip stream")
+	}
+	defer gz.Close()
+
+	tmp, err := os.CreateTemp(os.TempDir(), "content-prep-source-*.tar")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create temporary file")
+	}
+
+	closeTmp := func() error {
+		_ = tmp.Close()
+		return os.Remove(tmp.Name())
+	}
+
+	size, err := io.Copy(tmp, gz)
+	if err != nil {
+		_ = closeTmp()
+		return nil, errors.Wrapf(err, "failed to decompress tar.gz archive")
+	}
+	log.Debug("decompressed tar.gz archive", "archive", location, "tar", tmp.Name(), "size", size)
+
+	fsys, err := NewTarFS(tmp, size)
+	if err != nil {
+		_ = closeTmp()
+		return nil, err
+	}
+
+	return &Source{FS: namedFS{fsys, location}, closers: []func() error{closeTmp}}, nil
+}
+
+// namedFS prints as the location it was mounted from, like os.DirFS does.
+type namedFS struct {
+	fs.FS
+	name string
+}
+
+func (n namedFS) String() string { return n.name }
+
+func (n namedFS) ReadDir(name string) ([]fs.DirEntry, error) { return fs.ReadDir(n.FS, name) }
+
+func (n namedFS) Stat(name string) (fs.FileInfo, error) { return fs.Stat(n.FS, name) }
+
+// countingReader tracks the offset of the underlying reader.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+
+	return n, err
+}
@@ -0,0 +1,67 @@
+package source
+
+import (
+	"io"
+	"io/fs"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// File is a file of a source opened for random access.
+type File struct {
+	io.ReaderAt
+	Size int64
+
+	closers []func() error
+}
+
+func (f *File) Close() error {
+	var err error
+	for _, c := range f.closers {
+		if cErr := c(); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	f.closers = nil
+
+	return err
+}
+
+// OpenFile opens a file of fsys for random access. Files of file systems that
+// do not support io.ReaderAt are spooled into a temporary file.
+func OpenFile(fsys fs.FS, name string) (*File, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+
+	if r, ok := f.(io.ReaderAt); ok {
+		return &File{ReaderAt: r, Size: info.Size(), closers: []func() error{f.Close}}, nil
+	}
+	defer f.Close()
+
+	tmp, err := os.CreateTemp(os.TempDir(), "content-prep-file-*")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create temporary file")
+	}
+
+	closeTmp := func() error {
+		_ = tmp.Close()
+		return os.Remove(tmp.Name())
+	}
+
+	n, err := io.Copy(tmp, f)
+	if err != nil {
+		_ = closeTmp()
+		return nil, err
+	}
+
+	return &File{ReaderAt: tmp, Size: n, closers: []func() error{closeTmp}}, nil
+}
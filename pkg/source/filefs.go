@@ -0,0 +1,210 @@
+package source
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ fs.ReadDirFS = &FileFS{}
+	_ fs.StatFS    = &FileFS{}
+)
+
+// FileFS is a read-only fs.FS of files on disk, each mounted under a name of
+// its own, e.g. downloaded installers stored under their digest. Directories
+// are implied by the names.
+type FileFS struct {
+	files map[string]string
+	dirs  map[string][]string
+}
+
+// NewFileFS mounts the files, given as a map of names to paths on disk.
+func NewFileFS(files map[string]string) (*FileFS, error) {
+	f := &FileFS{
+		files: make(map[string]string, len(files)),
+		dirs:  map[string][]string{".": nil},
+	}
+
+	for name, filePath := range files {
+		if !fs.ValidPath(name) || name == "." {
+			return nil, errors.Errorf("invalid file name %q", name)
+		}
+
+		f.files[name] = filePath
+		f.addChild(name)
+	}
+
+	for name := range f.files {
+		if _, ok := f.dirs[name]; ok {
+			return nil, errors.Errorf("%s is both a file and a directory", name)
+		}
+	}
+
+	for _, children := range f.dirs {
+		sort.Strings(children)
+	}
+
+	return f, nil
+}
+
+// String lists the files on disk, sorted.
+func (f *FileFS) String() string {
+	filePaths := make([]string, 0, len(f.files))
+	for _, filePath := range f.files {
+		filePaths = append(filePaths, filePath)
+	}
+	sort.Strings(filePaths)
+
+	return strings.Join(filePaths, ", ")
+}
+
+// addChild adds the name to its directory, adding the directory and all its
+// parents if they are not known yet.
+func (f *FileFS) addChild(name string) {
+	dir := path.Dir(name)
+	if _, ok := f.dirs[dir]; !ok {
+		f.addChild(dir)
+	}
+
+	f.dirs[dir] = append(f.dirs[dir], name)
+}
+
+func (f *FileFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if filePath, ok := f.files[name]; ok {
+		file, err := os.Open(filePath)
+		if err != nil {
+			return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+		}
+
+		return &mountedFile{File: file, name: name}, nil
+	}
+
+	if _, ok := f.dirs[name]; ok {
+		return &mountedDir{fsys: f, name: name}, nil
+	}
+
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f *FileFS) Stat(name string) (fs.FileInfo, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrInvalid}
+	}
+
+	if filePath, ok := f.files[name]; ok {
+		info, err := os.Stat(filePath)
+		if err != nil {
+			return nil, &fs.PathError{Op: "stat", Path: name, Err: err}
+		}
+
+		return mountedInfo{FileInfo: info, name: path.Base(name)}, nil
+	}
+
+	if _, ok := f.dirs[name]; ok {
+		return dirInfo(path.Base(name)), nil
+	}
+
+	return nil, &fs.PathError{Op: "stat", Path: name, Err: fs.ErrNotExist}
+}
+
+func (f *FileFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	children, ok := f.dirs[name]
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: fs.ErrNotExist}
+	}
+
+	entries := make([]fs.DirEntry, 0, len(children))
+	for _, child := range children {
+		info, err := f.Stat(child)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, fs.FileInfoToDirEntry(info))
+	}
+
+	return entries, nil
+}
+
+// mountedFile is a file on disk with the info of its mounted name.
+type mountedFile struct {
+	*os.File
+	name string
+}
+
+func (f *mountedFile) Stat() (fs.FileInfo, error) {
+	info, err := f.File.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	return mountedInfo{FileInfo: info, name: path.Base(f.name)}, nil
+}
+
+type mountedInfo struct {
+	fs.FileInfo
+	name string
+}
+
+func (i mountedInfo) Name() string { return i.name }
+
+// dirInfo is the info of an implied directory.
+type dirInfo string
+
+func (d dirInfo) Name() string       { return string(d) }
+func (d dirInfo) Size() int64        { return 0 }
+func (d dirInfo) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d dirInfo) ModTime() time.Time { return time.Time{} }
+func (d dirInfo) IsDir() bool        { return true }
+func (d dirInfo) Sys() any           { return nil }
+
+type mountedDir struct {
+	fsys    *FileFS
+	name    string
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *mountedDir) Stat() (fs.FileInfo, error) { return dirInfo(path.Base(d.name)), nil }
+func (d *mountedDir) Close() error               { return nil }
+
+func (d *mountedDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: errors.New("is a directory")}
+}
+
+func (d *mountedDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		entries, err := d.fsys.ReadDir(d.name)
+		if err != nil {
+			return nil, err
+		}
+		d.entries, d.read = entries, true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
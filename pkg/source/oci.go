@@ -0,0 +1,491 @@
+package source
+
+import (
+	"compress/gzip"
+	"content-prep/pkg/logger"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// SchemeOCI is the URL scheme of sources pulled from OCI registries.
+const SchemeOCI = "oci"
+
+// Media types of manifests and layers understood by the OCI resolver.
+const (
+	MediaTypeOCIManifest     = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeDockerManifest  = "application/vnd.docker.distribution.manifest.v2+json"
+	MediaTypeOCILayer        = "application/vnd.oci.image.layer.v1.tar"
+	MediaTypeOCILayerGzip    = "application/vnd.oci.image.layer.v1.tar+gzip"
+	MediaTypeDockerLayerGzip = "application/vnd.docker.image.rootfs.diff.tar.gzip"
+
+	// AnnotationTitle names the file a non-tar layer is stored as.
+	AnnotationTitle = "org.opencontainers.image.title"
+)
+
+// ErrDigestMismatch is returned when downloaded content does not match its digest.
+var ErrDigestMismatch = errors.New("digest mismatch")
+
+// Reference points to a manifest in an OCI registry.
+type Reference struct {
+	Registry   string
+	Repository string
+	// Reference is either a tag or a digest.
+	Reference string
+}
+
+func (r Reference) String() string {
+	sep := ":"
+	if strings.Contains(r.Reference, ":") {
+		sep = "@"
+	}
+
+	return fmt.Sprintf("%s://%s/%s%s%s", SchemeOCI, r.Registry, r.Repository, sep, r.Reference)
+}
+
+// ParseReference parses oci://registry/repository[:tag|@digest]. The tag
+// defaults to latest.
+func ParseReference(location string) (Reference, error) {
+	rest, ok := strings.CutPrefix(location, SchemeOCI+"://")
+	if !ok {
+		return Reference{}, errors.Errorf("invalid OCI reference %q, expected %s://registry/repository:tag", location, SchemeOCI)
+	}
+
+	registry, repository, ok := strings.Cut(rest, "/")
+	if !ok || registry == "" || repository == "" {
+		return Reference{}, errors.Errorf("invalid OCI reference %q, expected %s://registry/repository:tag", location, SchemeOCI)
+	}
+
+	ref := Reference{Registry: registry, Reference: "latest"}
+
+	if repo, digest, ok := strings.Cut(repository, "@"); ok {
+		if _, _, err := parseDigest(digest); err != nil {
+			return Reference{}, err
+		}
+		repository, ref.Reference = repo, digest
+	} else if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, ref.Reference = repository[:i], repository[i+1:]
+	}
+
+	if repository == "" || ref.Reference == "" {
+		return Reference{}, errors.Errorf("invalid OCI reference %q", location)
+	}
+	ref.Repository = repository
+
+	return ref, nil
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+type ociManifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	Config        ociDescriptor   `json:"config"`
+	Layers        []ociDescriptor `json:"layers"`
+}
+
+// openOCI pulls all layers of the artifact into a temporary directory. Tar
+// layers are unpacked, any other layer is stored under its title annotation.
+func openOCI(ctx context.Context, location string, o *options) (*Source, error) {
+	log := logger.FromContext(ctx).With("component", "source", "action", "pull")
+
+	ref, err := ParseReference(location)
+	if err != nil {
+		return nil, err
+	}
+
+	reg := &registry{ref: ref, options: o}
+
+	manifest, err := reg.manifest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	log.Debug("fetched manifest", "reference", ref.String(), "layers", len(manifest.Layers))
+
+	dir, err := os.MkdirTemp(os.TempDir(), "content-prep-oci-*")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create temporary directory")
+	}
+
+	src := &Source{
+		FS:      namedFS{os.DirFS(dir), ref.String()},
+		closers: []func() error{func() error { return os.RemoveAll(dir) }},
+	}
+
+	for _, layer := range manifest.Layers {
+		if err := reg.pullLayer(ctx, layer, dir); err != nil {
+			_ = src.Close()
+			return nil, errors.Wrapf(err, "failed to pull layer %s", layer.Digest)
+		}
+		log.Debug("pulled layer", "digest", layer.Digest, "mediaType", layer.MediaType, "size", layer.Size)
+	}
+
+	return src, nil
+}
+
+type registry struct {
+	ref     Reference
+	options *options
+	token   string
+}
+
+func (r *registry) url(kind, reference string) string {
+	scheme := "https"
+	if r.options.plainHTTP {
+		scheme = "http"
+	}
+
+	return fmt.Sprintf("%s://%s/v2/%s/%s/%s", scheme, r.ref.Registry, r.ref.Repository, kind, reference)
+}
+
+func (r *registry) manifest(ctx context.Context) (*ociManifest, error) {
+	resp, err := r.get(ctx, r.url("manifests", r.ref.Reference), MediaTypeOCIManifest+", "+MediaTypeDockerManifest)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to fetch manifest of %s", r.ref)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read manifest")
+	}
+
+	if strings.Contains(r.ref.Reference, ":") {
+		if err := verifyDigest(r.ref.Reference, data); err != nil {
+			return nil, errors.Wrapf(err, "manifest of %s", r.ref)
+		}
+	}
+
+	manifest := &ociManifest{}
+	if err := json.Unmarshal(data, manifest); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse manifest")
+	}
+
+	mediaType := manifest.MediaType
+	if mediaType == "" {
+		mediaType = strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0])
+	}
+
+	if mediaType != MediaTypeOCIManifest && mediaType != MediaTypeDockerManifest {
+		return nil, errors.Errorf("unsupported manifest media type %q of %s", mediaType, r.ref)
+	}
+
+	return manifest, nil
+}
+
+// pullLayer downloads the layer into a temporary file, verifies its digest
+// and size and unpacks it into dir.
+func (r *registry) pullLayer(ctx context.Context, layer ociDescriptor, dir string) error {
+	algorithm, expected, err := parseDigest(layer.Digest)
+	if err != nil {
+		return err
+	}
+
+	if layer.Size < 0 {
+		return errors.Wrapf(ErrDigestMismatch, "blob %s has negative size %d", layer.Digest, layer.Size)
+	}
+
+	resp, err := r.get(ctx, r.url("blobs", layer.Digest), "")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	tmp, err := os.CreateTemp(os.TempDir(), "content-prep-blob-*")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file")
+	}
+	defer func() {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+	}()
+
+	// Reading one byte more than announced tells a longer blob from one of
+	// the expected size without downloading all of it.
+	h := algorithm()
+	n, err := io.Copy(io.MultiWriter(tmp, h), io.LimitReader(resp.Body, layer.Size+1))
+	if err != nil {
+		return errors.Wrapf(err, "failed to download blob")
+	}
+
+	if n > layer.Size {
+		return errors.Wrapf(ErrDigestMismatch, "blob %s has more than %d bytes", layer.Digest, layer.Size)
+	}
+	if n != layer.Size {
+		return errors.Wrapf(ErrDigestMismatch, "blob %s has %d bytes, expected %d", layer.Digest, n, layer.Size)
+	}
+
+	if actual := hex.EncodeToString(h.Sum(nil)); actual != expected {
+		return errors.Wrapf(ErrDigestMismatch, "blob %s", layer.Digest)
+	}
+
+	switch layer.MediaType {
+	case MediaTypeOCILayer:
+		return unpackTar(tmp, n, dir)
+	case MediaTypeOCILayerGzip, MediaTypeDockerLayerGzip:
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		gz, err := gzip.NewReader(tmp)
+		if err != nil {
+			return errors.Wrapf(err, "failed to read gzip stream")
+		}
+		defer gz.Close()
+
+		tar, err := os.CreateTemp(os.TempDir(), "content-prep-blob-*.tar")
+		if err != nil {
+			return errors.Wrapf(err, "failed to create temporary file")
+		}
+		defer func() {
+			_ = tar.Close()
+			_ = os.Remove(tar.Name())
+		}()
+
+		size, err := io.Copy(tar, gz)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decompress layer")
+		}
+
+		return unpackTar(tar, size, dir)
+	default:
+		title := layer.Annotations[AnnotationTitle]
+		if title == "" || !fs.ValidPath(title) || title == "." {
+			return errors.Errorf("layer of media type %q needs a valid %s annotation", layer.MediaType, AnnotationTitle)
+		}
+
+		if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+
+		return writeFile(filepath.Join(dir, filepath.FromSlash(title)), tmp)
+	}
+}
+
+// get performs a GET request, answering a bearer or basic auth challenge once.
+func (r *registry) get(ctx context.Context, target string, accept string) (*http.Response, error) {
+	do := func() (*http.Response, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+
+		switch {
+		case r.token != "":
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		case r.options.username != "":
+			req.SetBasicAuth(r.options.username, r.options.password)
+		}
+
+		return r.options.client.Do(req)
+	}
+
+	resp, err := do()
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized && r.token == "" {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		_ = resp.Body.Close()
+
+		if err := r.authenticate(ctx, challenge); err != nil {
+			return nil, err
+		}
+
+		if resp, err = do(); err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		_ = resp.Body.Close()
+		return nil, errors.Errorf("registry returned %s for %s", resp.Status, target)
+	}
+
+	return resp, nil
+}
+
+// authenticate fetches a bearer token as described by the challenge of the
+// registry. Basic challenges are answered with the configured credentials.
+func (r *registry) authenticate(ctx context.Context, challenge string) error {
+	scheme, params, _ := strings.Cut(challenge, " ")
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if r.options.username == "" {
+			return errors.New("registry requires credentials")
+		}
+
+		return nil
+	case "bearer":
+	default:
+		return errors.Errorf("unsupported registry authentication %q", challenge)
+	}
+
+	values := parseChallenge(params)
+
+	realm, err := url.Parse(values["realm"])
+	if err != nil || values["realm"] == "" {
+		return errors.Errorf("invalid registry authentication realm %q", values["realm"])
+	}
+
+	query := realm.Query()
+	for _, key := range []string{"service", "scope"} {
+		if values[key] != "" {
+			query.Set(key, values[key])
+		}
+	}
+	if query.Get("scope") == "" {
+		query.Set("scope", fmt.Sprintf("repository:%s:pull", r.ref.Repository))
+	}
+	realm.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	if r.options.username != "" {
+		req.SetBasicAuth(r.options.username, r.options.password)
+	}
+
+	resp, err := r.options.client.Do(req)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch registry token")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.Errorf("registry token service returned %s", resp.Status)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return errors.Wrapf(err, "failed to read registry token")
+	}
+
+	r.token = token.Token
+	if r.token == "" {
+		r.token = token.AccessToken
+	}
+
+	if r.token == "" {
+		return errors.New("registry token service returned no token")
+	}
+
+	return nil
+}
+
+// parseChallenge parses the comma separated key="value" pairs of a
+// WWW-Authenticate header.
+func parseChallenge(params string) map[string]string {
+	values := map[string]string{}
+
+	for params != "" {
+		var key, value string
+
+		key, params, _ = strings.Cut(strings.TrimLeft(params, ", "), "=")
+		if strings.HasPrefix(params, `"`) {
+			value, params, _ = strings.Cut(params[1:], `"`)
+		} else {
+			value, params, _ = strings.Cut(params, ",")
+		}
+
+		values[strings.ToLower(strings.TrimSpace(key))] = value
+	}
+
+	return values
+}
+
+func parseDigest(digest string) (func() hash.Hash, string, error) {
+	algorithm, encoded, _ := strings.Cut(digest, ":")
+
+	switch algorithm {
+	case "sha256":
+		return sha256.New, encoded, nil
+	case "sha512":
+		return sha512.New, encoded, nil
+	default:
+		return nil, "", errors.Errorf("unsupported digest %q", digest)
+	}
+}
+
+func verifyDigest(digest string, data []byte) error {
+	algorithm, expected, err := parseDigest(digest)
+	if err != nil {
+		return err
+	}
+
+	h := algorithm()
+	h.Write(data)
+
+	if hex.EncodeToString(h.Sum(nil)) != expected {
+		return errors.Wrapf(ErrDigestMismatch, "expected %s", digest)
+	}
+
+	return nil
+}
+
+// unpackTar extracts the regular files of the tarball into dir.
+func unpackTar(r io.ReaderAt, size int64, dir string) error {
+	fsys, err := NewTarFS(r, size)
+	if err != nil {
+		return err
+	}
+
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+
+		f, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		return writeFile(filepath.Join(dir, filepath.FromSlash(name)), f)
+	})
+}
+
+func writeFile(name string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(name), os.ModePerm); err != nil {
+		return err
+	}
+
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = io.Copy(f, r)
+
+	return err
+}
@@ -0,0 +1,110 @@
+package source
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Source is the content of a package, mounted as a file system.
+type Source struct {
+	FS fs.FS
+
+	// Dir is the directory on disk backing FS, empty if the source is not a
+	// plain directory.
+	Dir string
+
+	closers []func() error
+}
+
+// Close releases the resources held by the source, e.g. temporary files.
+func (s *Source) Close() error {
+	var err error
+	for i := len(s.closers) - 1; i >= 0; i-- {
+		if cErr := s.closers[i](); cErr != nil && err == nil {
+			err = cErr
+		}
+	}
+	s.closers = nil
+
+	return err
+}
+
+type options struct {
+	client    *http.Client
+	username  string
+	password  string
+	plainHTTP bool
+}
+
+type Option func(*options)
+
+// WithHTTPClient sets the client used for remote sources.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.client = client
+	}
+}
+
+// WithRegistryAuth sets the credentials used to authenticate against registries.
+func WithRegistryAuth(username, password string) Option {
+	return func(o *options) {
+		o.username = username
+		o.password = password
+	}
+}
+
+// WithPlainHTTP talks to registries over HTTP instead of HTTPS.
+func WithPlainHTTP(plainHTTP bool) Option {
+	return func(o *options) {
+		o.plainHTTP = plainHTTP
+	}
+}
+
+// resolver mounts the sources of a URL scheme.
+type resolver func(ctx context.Context, location string, o *options) (*Source, error)
+
+var resolvers = map[string]resolver{
+	SchemeOCI: openOCI,
+}
+
+// Open mounts the given location, which is either a directory, an archive or
+// a URL of a supported scheme such as oci://registry/repository:tag.
+func Open(ctx context.Context, location string, opts ...Option) (*Source, error) {
+	o := &options{client: http.DefaultClient}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if scheme, _, ok := strings.Cut(location, "://"); ok {
+		resolve, ok := resolvers[scheme]
+		if !ok {
+			return nil, errors.Errorf("unsupported source scheme %q", scheme)
+		}
+
+		return resolve(ctx, location, o)
+	}
+
+	info, err := os.Stat(location)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open source")
+	}
+
+	if info.IsDir() {
+		return &Source{
+			FS:  os.DirFS(location),
+			Dir: location,
+		}, nil
+	}
+
+	return openArchive(ctx, location)
+}
+
+// IsRemote reports whether the location is a URL rather than a local path.
+func IsRemote(location string) bool {
+	return strings.Contains(location, "://")
+}
@@ -0,0 +1,350 @@
+package source
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/suite"
+)
+
+func TestSourceTestSuite(t *testing.T) {
+	suite.Run(t, new(SourceTestSuite))
+}
+
+type SourceTestSuite struct {
+	suite.Suite
+
+	testDir string
+	files   map[string]string
+}
+
+func (s *SourceTestSuite) SetupTest() {
+	var err error
+
+	s.testDir, err = os.MkdirTemp("", "source-test-*")
+	s.Require().NoError(err)
+
+	s.files = map[string]string{
+		"setup.exe":         "Hello, World!",
+		"subdir/test2":      "Hello, World 2!",
+		"subdir/deep/test3": "Hello, World 3!",
+	}
+}
+
+func (s *SourceTestSuite) TearDownTest() {
+	s.Require().NoError(os.RemoveAll(s.testDir))
+}
+
+func (s *SourceTestSuite) writeZip(name string) string {
+	buf := &bytes.Buffer{}
+	w := zip.NewWriter(buf)
+	for name, content := range s.files {
+		f, err := w.Create(name)
+		s.Require().NoError(err)
+		_, err = f.Write([]byte(content))
+		s.Require().NoError(err)
+	}
+	s.Require().NoError(w.Close())
+
+	return s.write(name, buf.Bytes())
+}
+
+func (s *SourceTestSuite) tarball() []byte {
+	buf := &bytes.Buffer{}
+	w := tar.NewWriter(buf)
+	s.Require().NoError(w.WriteHeader(&tar.Header{Name: "./subdir/", Typeflag: tar.TypeDir, Mode: 0755}))
+	for name, content := range s.files {
+		s.Require().NoError(w.WriteHeader(&tar.Header{Name: "./" + name, Typeflag: tar.TypeReg, Mode: 0644, Size: int64(len(content))}))
+		_, err := w.Write([]byte(content))
+		s.Require().NoError(err)
+	}
+	s.Require().NoError(w.Close())
+
+	return buf.Bytes()
+}
+
+func (s *SourceTestSuite) write(name string, data []byte) string {
+	p := path.Join(s.testDir, name)
+	s.Require().NoError(os.WriteFile(p, data, 0644))
+
+	return p
+}
+
+func (s *SourceTestSuite) assertSource(location string) {
+	src, err := Open(context.Background(), location)
+	s.Require().NoError(err)
+	defer func() { s.Require().NoError(src.Close()) }()
+
+	expected := make([]string, 0, len(s.files))
+	for name, content := range s.files {
+		expected = append(expected, name)
+
+		data, err := fs.ReadFile(src.FS, name)
+		s.Require().NoError(err)
+		s.Require().Equal(content, string(data))
+	}
+
+	s.Require().NoError(fstest.TestFS(src.FS, expected...))
+}
+
+func (s *SourceTestSuite) TestDirectory() {
+	dir := path.Join(s.testDir, "src")
+	for name, content := range s.files {
+		s.Require().NoError(os.MkdirAll(path.Dir(path.Join(dir, name)), 0755))
+		s.Require().NoError(os.WriteFile(path.Join(dir, name), []byte(content), 0644))
+	}
+
+	s.assertSource(dir)
+
+	src, err := Open(context.Background(), dir)
+	s.Require().NoError(err)
+	s.Require().Equal(dir, src.Dir)
+}
+
+func (s *SourceTestSuite) TestZip() {
+	s.assertSource(s.writeZip("src.zip"))
+}
+
+func (s *SourceTestSuite) TestTar() {
+	s.assertSource(s.write("src.tar", s.tarball()))
+}
+
+func (s *SourceTestSuite) TestTarGz() {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	_, err := io.Copy(gz, bytes.NewReader(s.tarball()))
+	s.Require().NoError(err)
+	s.Require().NoError(gz.Close())
+
+	s.assertSource(s.write("src.tar.gz", buf.Bytes()))
+	s.assertSource(s.write("src.tgz", buf.Bytes()))
+}
+
+func (s *SourceTestSuite) TestTarRejectsUnsupportedEntries() {
+	for _, hdr := range []*tar.Header{
+		{Name: "../escape", Typeflag: tar.TypeReg},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "setup.exe"},
+	} {
+		buf := &bytes.Buffer{}
+		w := tar.NewWriter(buf)
+		s.Require().NoError(w.WriteHeader(hdr))
+		s.Require().NoError(w.Close())
+
+		_, err := NewTarFS(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+		s.Require().Error(err, hdr.Name)
+	}
+}
+
+func (s *SourceTestSuite) TestFileFS() {
+	files := make(map[string]string, len(s.files))
+	expected := make([]string, 0, len(s.files))
+	for name, content := range s.files {
+		files[name] = s.write(strings.ReplaceAll(name, "/", "_")+".cached", []byte(content))
+		expected = append(expected, name)
+	}
+
+	fsys, err := NewFileFS(files)
+	s.Require().NoError(err)
+	s.Require().NoError(fstest.TestFS(fsys, expected...))
+
+	data, err := fs.ReadFile(fsys, "subdir/deep/test3")
+	s.Require().NoError(err)
+	s.Require().Equal("Hello, World 3!", string(data))
+
+	info, err := fs.Stat(fsys, "setup.exe")
+	s.Require().NoError(err)
+	s.Require().Equal("setup.exe", info.Name())
+
+	_, err = NewFileFS(map[string]string{"a": files["setup.exe"], "a/b": files["setup.exe"]})
+	s.Require().ErrorContains(err, "a is both a file and a directory")
+
+	_, err = NewFileFS(map[string]string{"../a": files["setup.exe"]})
+	s.Require().ErrorContains(err, "invalid file name")
+}
+
+func (s *SourceTestSuite) TestUnsupported() {
+	_, err := Open(context.Background(), s.write("src.7z", []byte("7z")))
+	s.Require().Error(err)
+
+	_, err = Open(context.Background(), path.Join(s.testDir, "missing"))
+	s.Require().Error(err)
+}
+
+// fakeRegistry implements the pull side of the OCI distribution spec.
+type fakeRegistry struct {
+	blobs     map[string][]byte
+	manifests map[string][]byte
+	token     string
+}
+
+func newFakeRegistry() *fakeRegistry {
+	return &fakeRegistry{blobs: map[string][]byte{}, manifests: map[string][]byte{}}
+}
+
+func (r *fakeRegistry) addBlob(data []byte) string {
+	sum := sha256.Sum256(data)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.blobs[digest] = data
+
+	return digest
+}
+
+// push stores a manifest with the given layers under the tag and its digest.
+func (r *fakeRegistry) push(tag string, layers ...ociDescriptor) string {
+	manifest, _ := json.Marshal(ociManifest{
+		SchemaVersion: 2,
+		MediaType:     MediaTypeOCIManifest,
+		Config:        ociDescriptor{MediaType: "application/vnd.oci.empty.v1+json", Digest: r.addBlob([]byte("{}")), Size: 2},
+		Layers:        layers,
+	})
+
+	sum := sha256.Sum256(manifest)
+	digest := "sha256:" + hex.EncodeToString(sum[:])
+	r.manifests[tag] = manifest
+	r.manifests[digest] = manifest
+
+	return digest
+}
+
+func (r *fakeRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.URL.Path == "/token" {
+		_ = json.NewEncoder(w).Encode(map[string]string{"token": r.token})
+		return
+	}
+
+	if r.token != "" && req.Header.Get("Authorization") != "Bearer "+r.token {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="http://%s/token",service="fake"`, req.Host))
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(req.URL.Path, "/v2/"), "/")
+	if len(parts) < 3 {
+		http.NotFound(w, req)
+		return
+	}
+
+	var data []byte
+	switch kind, reference := parts[len(parts)-2], parts[len(parts)-1]; kind {
+	case "manifests":
+		data = r.manifests[reference]
+		w.Header().Set("Content-Type", MediaTypeOCIManifest)
+	case "blobs":
+		data = r.blobs[reference]
+	}
+
+	if data == nil {
+		http.NotFound(w, req)
+		return
+	}
+
+	_, _ = w.Write(data)
+}
+
+func (s *SourceTestSuite) openOCI(registry *fakeRegistry, reference string) (*Source, error) {
+	server := httptest.NewServer(registry)
+	s.T().Cleanup(server.Close)
+
+	location := fmt.Sprintf("oci://%s/installers/app%s", strings.TrimPrefix(server.URL, "http://"), reference)
+
+	return Open(context.Background(), location, WithPlainHTTP(true), WithHTTPClient(server.Client()))
+}
+
+func (s *SourceTestSuite) TestOCI() {
+	registry := newFakeRegistry()
+	registry.token = "secret"
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	_, err := gz.Write(s.tarball())
+	s.Require().NoError(err)
+	s.Require().NoError(gz.Close())
+
+	readme := []byte("read me")
+	digest := registry.push("1.0.0",
+		ociDescriptor{MediaType: MediaTypeOCILayerGzip, Digest: registry.addBlob(buf.Bytes()), Size: int64(buf.Len())},
+		ociDescriptor{MediaType: "text/plain", Digest: registry.addBlob(readme), Size: int64(len(readme)), Annotations: map[string]string{AnnotationTitle: "README.txt"}},
+	)
+
+	for _, reference := range []string{":1.0.0", "@" + digest} {
+		src, err := s.openOCI(registry, reference)
+		s.Require().NoError(err, reference)
+
+		data, err := fs.ReadFile(src.FS, "README.txt")
+		s.Require().NoError(err)
+		s.Require().Equal(readme, data)
+
+		for name, content := range s.files {
+			data, err := fs.ReadFile(src.FS, name)
+			s.Require().NoError(err)
+			s.Require().Equal(content, string(data))
+		}
+
+		s.Require().NoError(src.Close())
+	}
+}
+
+func (s *SourceTestSuite) TestOCIVerifiesDigests() {
+	registry := newFakeRegistry()
+
+	layer := s.tarball()
+	digest := registry.addBlob(layer)
+	registry.push("tampered", ociDescriptor{MediaType: MediaTypeOCILayer, Digest: digest, Size: int64(len(layer))})
+	registry.blobs[digest] = append([]byte(nil), layer...)
+	registry.blobs[digest][0] ^= 0xff
+
+	_, err := s.openOCI(registry, ":tampered")
+	s.Require().ErrorIs(err, ErrDigestMismatch)
+
+	registry.push("short", ociDescriptor{MediaType: MediaTypeOCILayer, Digest: registry.addBlob([]byte("x")), Size: 2})
+	_, err = s.openOCI(registry, ":short")
+	s.Require().ErrorIs(err, ErrDigestMismatch)
+
+	long := registry.addBlob([]byte("xx"))
+	registry.push("long", ociDescriptor{MediaType: MediaTypeOCILayer, Digest: long, Size: 1})
+	_, err = s.openOCI(registry, ":long")
+	s.Require().ErrorIs(err, ErrDigestMismatch)
+	s.Require().ErrorContains(err, "more than 1 bytes")
+
+	_, err = s.openOCI(registry, "@sha256:"+strings.Repeat("0", 64))
+	s.Require().Error(err)
+
+	registry.push("untitled", ociDescriptor{MediaType: "text/plain", Digest: registry.addBlob([]byte("x")), Size: 1})
+	_, err = s.openOCI(registry, ":untitled")
+	s.Require().Error(err)
+}
+
+func (s *SourceTestSuite) TestParseReference() {
+	zero := strings.Repeat("0", 64)
+
+	for location, expected := range map[string]Reference{
+		"oci://registry.local/app":                {Registry: "registry.local", Repository: "app", Reference: "latest"},
+		"oci://localhost:5000/team/app:1.2":       {Registry: "localhost:5000", Repository: "team/app", Reference: "1.2"},
+		"oci://registry.local/app@sha256:" + zero: {Registry: "registry.local", Repository: "app", Reference: "sha256:" + zero},
+	} {
+		ref, err := ParseReference(location)
+		s.Require().NoError(err, location)
+		s.Require().Equal(expected, ref)
+	}
+
+	for _, location := range []string{"registry.local/app", "oci://registry.local", "oci://registry.local/app@md5:00"} {
+		_, err := ParseReference(location)
+		s.Require().Error(err, location)
+	}
+}
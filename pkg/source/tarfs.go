@@ -0,0 +1,230 @@
+package source
+
+import (
+	"archive/tar"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	_ fs.ReadDirFS = &TarFS{}
+	_ fs.StatFS    = &TarFS{}
+)
+
+// TarFS is a read-only fs.FS over an uncompressed tarball. The tarball is
+// indexed once, afterwards files are read in place from the underlying reader.
+type TarFS struct {
+	r       io.ReaderAt
+	entries map[string]*tarEntry
+}
+
+type tarEntry struct {
+	name     string
+	offset   int64
+	size     int64
+	mode     fs.FileMode
+	modTime  time.Time
+	children []string
+}
+
+// NewTarFS indexes the tarball of the given size. Only regular files and
+// directories are supported.
+func NewTarFS(r io.ReaderAt, size int64) (*TarFS, error) {
+	t := &TarFS{
+		r: r,
+		entries: map[string]*tarEntry{
+			".": {name: ".", mode: fs.ModeDir | 0o555},
+		},
+	}
+
+	counter := &countingReader{r: io.NewSectionReader(r, 0, size)}
+	tr := tar.NewReader(counter)
+
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read tar archive")
+		}
+
+		name := strings.TrimSuffix(path.Clean(strings.TrimPrefix(hdr.Name, "./")), "/")
+		if name == "." {
+			continue
+		}
+		if !fs.ValidPath(name) {
+			return nil, errors.Errorf("invalid path %q in tar archive", hdr.Name)
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			t.addDir(name).modTime = hdr.ModTime
+		case tar.TypeReg:
+			if _, ok := t.entries[name]; ok {
+				return nil, errors.Errorf("duplicate path %q in tar archive", hdr.Name)
+			}
+
+			t.addDir(path.Dir(name))
+			t.addChild(name)
+			t.entries[name] = &tarEntry{
+				name:    name,
+				offset:  counter.n,
+				size:    hdr.Size,
+				mode:    fs.FileMode(hdr.Mode).Perm(),
+				modTime: hdr.ModTime,
+			}
+		case tar.TypeXGlobalHeader:
+			continue
+		default:
+			return nil, errors.Errorf("unsupported entry %q of type %q in tar archive", hdr.Name, hdr.Typeflag)
+		}
+	}
+
+	for _, e := range t.entries {
+		sort.Strings(e.children)
+	}
+
+	return t, nil
+}
+
+// addDir adds the directory and all its parents if they are not known yet.
+func (t *TarFS) addDir(name string) *tarEntry {
+	if e, ok := t.entries[name]; ok {
+		return e
+	}
+
+	t.addDir(path.Dir(name))
+	t.addChild(name)
+
+	e := &tarEntry{name: name, mode: fs.ModeDir | 0o555}
+	t.entries[name] = e
+
+	return e
+}
+
+func (t *TarFS) addChild(name string) {
+	parent := t.entries[path.Dir(name)]
+	parent.children = append(parent.children, name)
+}
+
+func (t *TarFS) lookup(op, name string) (*tarEntry, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+
+	e, ok := t.entries[name]
+	if !ok {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+	}
+
+	return e, nil
+}
+
+func (t *TarFS) Open(name string) (fs.File, error) {
+	e, err := t.lookup("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.mode.IsDir() {
+		return &tarDir{fsys: t, entry: e}, nil
+	}
+
+	return &tarFile{
+		SectionReader: io.NewSectionReader(t.r, e.offset, e.size),
+		entry:         e,
+	}, nil
+}
+
+func (t *TarFS) Stat(name string) (fs.FileInfo, error) {
+	e, err := t.lookup("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+func (t *TarFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	e, err := t.lookup("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errors.New("not a directory")}
+	}
+
+	return t.dirEntries(e), nil
+}
+
+func (t *TarFS) dirEntries(e *tarEntry) []fs.DirEntry {
+	entries := make([]fs.DirEntry, 0, len(e.children))
+	for _, child := range e.children {
+		entries = append(entries, fs.FileInfoToDirEntry(t.entries[child]))
+	}
+
+	return entries
+}
+
+// tarEntry implements fs.FileInfo.
+
+func (e *tarEntry) Name() string       { return path.Base(e.name) }
+func (e *tarEntry) Size() int64        { return e.size }
+func (e *tarEntry) Mode() fs.FileMode  { return e.mode }
+func (e *tarEntry) ModTime() time.Time { return e.modTime }
+func (e *tarEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *tarEntry) Sys() any           { return nil }
+
+type tarFile struct {
+	*io.SectionReader
+	entry *tarEntry
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.entry, nil }
+func (f *tarFile) Close() error               { return nil }
+
+type tarDir struct {
+	fsys    *TarFS
+	entry   *tarEntry
+	entries []fs.DirEntry
+	read    bool
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d.entry, nil }
+func (d *tarDir) Close() error               { return nil }
+
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.entry.name, Err: errors.New("is a directory")}
+}
+
+func (d *tarDir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if !d.read {
+		d.entries = d.fsys.dirEntries(d.entry)
+		d.read = true
+	}
+
+	if n <= 0 {
+		entries := d.entries
+		d.entries = nil
+
+		return entries, nil
+	}
+
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+
+	n = min(n, len(d.entries))
+	entries := d.entries[:n]
+	d.entries = d.entries[n:]
+
+	return entries, nil
+}
@@ -0,0 +1,239 @@
+package winget
+
+import (
+	"content-prep/pkg/installer"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// FileSuffix is appended to the package name for the definition written next
+// to the package.
+const FileSuffix = ".app.json"
+
+// ErrChecksum is returned when an installer file does not match the
+// InstallerSha256 of its manifest.
+var ErrChecksum = errors.New("installer checksum mismatch")
+
+const (
+	graphProductCodeDetectionType = "#microsoft.graph.win32LobAppProductCodeDetection"
+	graphRegistryDetectionType    = "#microsoft.graph.win32LobAppRegistryDetection"
+
+	uninstallKey = `\SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall\`
+)
+
+// Definition is how Intune installs, uninstalls and detects the app of a
+// winget installer.
+type Definition struct {
+	PackageIdentifier string `json:"packageIdentifier"`
+	DisplayName       string `json:"displayName"`
+	Publisher         string `json:"publisher"`
+	Version           string `json:"version"`
+	Description       string `json:"description,omitempty"`
+
+	InstallerType   string `json:"installerType"`
+	Architecture    string `json:"architecture"`
+	Scope           string `json:"scope,omitempty"`
+	InstallerURL    string `json:"installerUrl"`
+	InstallerSha256 string `json:"installerSha256"`
+
+	// SetupFile is the name of the installer in the package.
+	SetupFile            string          `json:"setupFile"`
+	InstallCommandLine   string          `json:"installCommandLine"`
+	UninstallCommandLine string          `json:"uninstallCommandLine,omitempty"`
+	DetectionRules       []DetectionRule `json:"detectionRules"`
+
+	// Warnings name what has to be completed by hand before uploading.
+	Warnings []string `json:"warnings,omitempty"`
+}
+
+// DetectionRule is a Graph win32LobAppProductCodeDetection or
+// win32LobAppRegistryDetection.
+type DetectionRule struct {
+	ODataType string `json:"@odata.type"`
+
+	ProductCode            string `json:"productCode,omitempty"`
+	ProductVersionOperator string `json:"productVersionOperator,omitempty"`
+	ProductVersion         string `json:"productVersion,omitempty"`
+
+	Check32BitOn64System bool   `json:"check32BitOn64System,omitempty"`
+	KeyPath              string `json:"keyPath,omitempty"`
+	ValueName            string `json:"valueName,omitempty"`
+	DetectionType        string `json:"detectionType,omitempty"`
+	Operator             string `json:"operator,omitempty"`
+	DetectionValue       string `json:"detectionValue,omitempty"`
+}
+
+// defaultSwitches are the silent switches of installer types, used when the
+// manifest has none.
+var defaultSwitches = map[string]string{
+	"msi":      "/qn /norestart",
+	"wix":      "/qn /norestart",
+	"inno":     "/VERYSILENT /SUPPRESSMSGBOXES /NORESTART /SP-",
+	"nullsoft": "/S",
+	"burn":     "/quiet /norestart",
+}
+
+// Definition maps an installer selected from the manifest to its definition.
+func (m *Manifest) Definition(inst *Installer) (*Definition, error) {
+	d := &Definition{
+		PackageIdentifier: m.PackageIdentifier,
+		DisplayName:       m.Locale.PackageName,
+		Publisher:         m.Locale.Publisher,
+		Version:           m.PackageVersion,
+		Description:       m.Locale.ShortDescription,
+		InstallerType:     strings.ToLower(inst.InstallerType),
+		Architecture:      strings.ToLower(inst.Architecture),
+		Scope:             strings.ToLower(inst.Scope),
+		InstallerURL:      inst.InstallerURL,
+		InstallerSha256:   strings.ToLower(inst.InstallerSha256),
+	}
+
+	var productCode string
+	for _, entry := range inst.AppsAndFeaturesEntries {
+		if d.DisplayName == "" {
+			d.DisplayName = entry.DisplayName
+		}
+		if d.Publisher == "" {
+			d.Publisher = entry.Publisher
+		}
+		if productCode == "" {
+			productCode = entry.ProductCode
+		}
+	}
+	if inst.ProductCode != "" {
+		productCode = inst.ProductCode
+	}
+	if d.DisplayName == "" {
+		d.DisplayName = m.PackageIdentifier
+	}
+
+	switches := inst.InstallerSwitches.Silent
+	if switches == "" {
+		switches = inst.InstallerSwitches.SilentWithProgress
+	}
+	if switches == "" {
+		switches = defaultSwitches[d.InstallerType]
+	}
+
+	var ext string
+	switch d.InstallerType {
+	case "msi", "wix":
+		ext = ".msi"
+	case "msix", "appx":
+		ext = "." + d.InstallerType
+	case "exe", "inno", "nullsoft", "burn":
+		ext = ".exe"
+		if switches == "" {
+			return nil, errors.Wrapf(ErrUnsupported, "%s installer without silent switches", d.InstallerType)
+		}
+	case "":
+		return nil, errors.Wrap(ErrInvalid, "installer without InstallerType")
+	default:
+		return nil, errors.Wrapf(ErrUnsupported, "installer type %s", d.InstallerType)
+	}
+	d.SetupFile = setupFileName(inst.InstallerURL, m.PackageIdentifier, ext)
+
+	file := strings.ReplaceAll(d.SetupFile, "/", `\`)
+	switch d.InstallerType {
+	case "msi", "wix":
+		d.InstallCommandLine = `msiexec /i "` + file + `" ` + switches
+		_, d.UninstallCommandLine = installer.CommandLines(&installer.Info{Type: installer.TypeMSI, SetupFile: d.SetupFile})
+		if productCode != "" {
+			d.UninstallCommandLine = `msiexec /x ` + productCode + ` /qn /norestart`
+		}
+	case "msix", "appx":
+		info := &installer.Info{Type: installer.TypeMSIX, SetupFile: d.SetupFile}
+		if name, _, ok := strings.Cut(inst.PackageFamilyName, "_"); ok {
+			info.Msix = &installer.MsixInfo{Name: name}
+		}
+		d.InstallCommandLine, d.UninstallCommandLine = installer.CommandLines(info)
+	default:
+		d.InstallCommandLine = `"` + file + `" ` + switches
+	}
+	if custom := inst.InstallerSwitches.Custom; custom != "" {
+		d.InstallCommandLine += " " + custom
+	}
+	if d.UninstallCommandLine == "" {
+		d.Warnings = append(d.Warnings, "no uninstall command known, set it before uploading")
+	}
+
+	d.DetectionRules = []DetectionRule{}
+	version := m.PackageVersion
+	for _, entry := range inst.AppsAndFeaturesEntries {
+		if entry.DisplayVersion != "" {
+			version = entry.DisplayVersion
+			break
+		}
+	}
+
+	switch {
+	case productCode == "":
+		d.Warnings = append(d.Warnings, "no product code known, add a detection rule before uploading")
+	case d.InstallerType == "msi" || d.InstallerType == "wix":
+		d.DetectionRules = append(d.DetectionRules, DetectionRule{
+			ODataType:              graphProductCodeDetectionType,
+			ProductCode:            productCode,
+			ProductVersionOperator: "greaterThanOrEqual",
+			ProductVersion:         version,
+		})
+	default:
+		hive := "HKEY_LOCAL_MACHINE"
+		if d.Scope == "user" {
+			hive = "HKEY_CURRENT_USER"
+		}
+
+		d.DetectionRules = append(d.DetectionRules, DetectionRule{
+			ODataType:            graphRegistryDetectionType,
+			Check32BitOn64System: d.Architecture == "x86",
+			KeyPath:              hive + uninstallKey + productCode,
+			ValueName:            "DisplayVersion",
+			DetectionType:        "version",
+			Operator:             "greaterThanOrEqual",
+			DetectionValue:       version,
+		})
+	}
+
+	return d, nil
+}
+
+// setupFileName returns the file name of the installer URL, or the package
+// identifier with the extension of the installer type if the URL does not end
+// in such a file name.
+func setupFileName(installerURL, packageIdentifier, ext string) string {
+	if u, err := url.Parse(installerURL); err == nil {
+		name := path.Base(u.Path)
+		if strings.EqualFold(path.Ext(name), ext) && !strings.ContainsAny(name, `\:*?"<>|`) {
+			return name
+		}
+	}
+
+	return packageIdentifier + ext
+}
+
+// CheckInstaller verifies that the file on disk is the installer of the
+// definition.
+func (d *Definition) CheckInstaller(installerFilePath string) error {
+	f, err := os.Open(installerFilePath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open installer")
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return errors.Wrapf(err, "failed to read installer")
+	}
+
+	if digest := hex.EncodeToString(h.Sum(nil)); digest != d.InstallerSha256 {
+		return errors.Wrapf(ErrChecksum, "%s has SHA256 %s, expected %s", installerFilePath, digest, d.InstallerSha256)
+	}
+
+	return nil
+}
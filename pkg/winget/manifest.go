@@ -0,0 +1,313 @@
+// Package winget reads winget manifests and maps their installers to the
+// install, uninstall and detection definition of an Intune app.
+package winget
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// ErrInvalid is returned for manifests that are incomplete or contradict
+// themselves.
+var ErrInvalid = errors.New("invalid winget manifest")
+
+// ErrUnsupported is returned for installers that cannot be packaged.
+var ErrUnsupported = errors.New("unsupported winget installer")
+
+const (
+	manifestTypeSingleton     = "singleton"
+	manifestTypeVersion       = "version"
+	manifestTypeInstaller     = "installer"
+	manifestTypeDefaultLocale = "defaultLocale"
+	manifestTypeLocale        = "locale"
+)
+
+// Manifest is a package version described by a singleton manifest or by a
+// version, installer and locale manifests.
+type Manifest struct {
+	PackageIdentifier string
+	PackageVersion    string
+
+	// Locale is the default locale, overlaid with the requested locale.
+	Locale    Locale
+	Installer InstallerManifest
+}
+
+// Locale is the package description of a locale manifest.
+type Locale struct {
+	PackageLocale    string `yaml:"PackageLocale"`
+	Publisher        string `yaml:"Publisher"`
+	PackageName      string `yaml:"PackageName"`
+	ShortDescription string `yaml:"ShortDescription"`
+	Description      string `yaml:"Description"`
+	License          string `yaml:"License"`
+	PackageURL       string `yaml:"PackageUrl"`
+}
+
+// InstallerManifest holds the installers of an installer manifest. The fields
+// on the root are defaults for all installers.
+type InstallerManifest struct {
+	Fields     `yaml:",inline"`
+	Installers []Installer `yaml:"Installers"`
+}
+
+// Fields are the installer fields that can be set on the root of an installer
+// manifest and on each installer.
+type Fields struct {
+	InstallerType          string                 `yaml:"InstallerType"`
+	NestedInstallerType    string                 `yaml:"NestedInstallerType"`
+	Scope                  string                 `yaml:"Scope"`
+	InstallerSwitches      Switches               `yaml:"InstallerSwitches"`
+	ProductCode            string                 `yaml:"ProductCode"`
+	PackageFamilyName      string                 `yaml:"PackageFamilyName"`
+	AppsAndFeaturesEntries []AppsAndFeaturesEntry `yaml:"AppsAndFeaturesEntries"`
+}
+
+// Installer is a single installer of a package version.
+type Installer struct {
+	Fields `yaml:",inline"`
+
+	Architecture    string `yaml:"Architecture"`
+	InstallerLocale string `yaml:"InstallerLocale"`
+	InstallerURL    string `yaml:"InstallerUrl"`
+	InstallerSha256 string `yaml:"InstallerSha256"`
+}
+
+// Switches are the command line switches of an installer. Silent and
+// SilentWithProgress replace the default switches of the installer type,
+// Custom is appended to them.
+type Switches struct {
+	Silent             string `yaml:"Silent"`
+	SilentWithProgress string `yaml:"SilentWithProgress"`
+	Custom             string `yaml:"Custom"`
+}
+
+// AppsAndFeaturesEntry is how the installed app shows up in Apps & Features.
+type AppsAndFeaturesEntry struct {
+	DisplayName    string `yaml:"DisplayName"`
+	Publisher      string `yaml:"Publisher"`
+	DisplayVersion string `yaml:"DisplayVersion"`
+	ProductCode    string `yaml:"ProductCode"`
+	UpgradeCode    string `yaml:"UpgradeCode"`
+	InstallerType  string `yaml:"InstallerType"`
+}
+
+// header is common to all manifest types.
+type header struct {
+	PackageIdentifier string `yaml:"PackageIdentifier"`
+	PackageVersion    string `yaml:"PackageVersion"`
+	ManifestType      string `yaml:"ManifestType"`
+	PackageLocale     string `yaml:"PackageLocale"`
+}
+
+// Load reads the manifests of a package version from a directory, or a
+// singleton manifest file. The locale manifest matching locale, if any,
+// overrides the fields of the default locale.
+func Load(manifestPath string, locale string) (*Manifest, error) {
+	info, err := os.Stat(manifestPath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read manifest")
+	}
+
+	files := []string{manifestPath}
+	if info.IsDir() {
+		entries, err := os.ReadDir(manifestPath)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read manifest")
+		}
+
+		files = files[:0]
+		for _, entry := range entries {
+			ext := strings.ToLower(filepath.Ext(entry.Name()))
+			if !entry.IsDir() && (ext == ".yaml" || ext == ".yml") {
+				files = append(files, filepath.Join(manifestPath, entry.Name()))
+			}
+		}
+	}
+
+	documents := make([][]byte, 0, len(files))
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read manifest")
+		}
+		documents = append(documents, data)
+	}
+
+	m, err := Parse(locale, documents...)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid manifest %s", manifestPath)
+	}
+
+	return m, nil
+}
+
+// Parse decodes the manifests of a package version.
+func Parse(locale string, documents ...[]byte) (*Manifest, error) {
+	var (
+		m         Manifest
+		seen      = map[string]bool{}
+		overrides *Locale
+	)
+
+	for _, data := range documents {
+		var h header
+		if err := yaml.Unmarshal(data, &h); err != nil {
+			return nil, errors.Wrapf(err, "failed to decode manifest")
+		}
+
+		if h.PackageIdentifier == "" || h.PackageVersion == "" {
+			return nil, errors.Wrap(ErrInvalid, "manifest without PackageIdentifier or PackageVersion")
+		}
+		if m.PackageIdentifier == "" {
+			m.PackageIdentifier, m.PackageVersion = h.PackageIdentifier, h.PackageVersion
+		}
+		if h.PackageIdentifier != m.PackageIdentifier || h.PackageVersion != m.PackageVersion {
+			return nil, errors.Wrapf(ErrInvalid, "manifests of %s %s and %s %s mixed", m.PackageIdentifier, m.PackageVersion, h.PackageIdentifier, h.PackageVersion)
+		}
+
+		if h.ManifestType != manifestTypeLocale {
+			if seen[h.ManifestType] {
+				return nil, errors.Wrapf(ErrInvalid, "%s manifest given twice", h.ManifestType)
+			}
+			seen[h.ManifestType] = true
+		}
+
+		var err error
+		switch h.ManifestType {
+		case manifestTypeSingleton:
+			if err = yaml.Unmarshal(data, &m.Locale); err == nil {
+				err = yaml.Unmarshal(data, &m.Installer)
+			}
+		case manifestTypeVersion:
+		case manifestTypeInstaller:
+			err = yaml.Unmarshal(data, &m.Installer)
+		case manifestTypeDefaultLocale:
+			err = yaml.Unmarshal(data, &m.Locale)
+		case manifestTypeLocale:
+			if locale != "" && strings.EqualFold(h.PackageLocale, locale) {
+				overrides = &Locale{}
+				err = yaml.Unmarshal(data, overrides)
+			}
+		default:
+			return nil, errors.Wrapf(ErrInvalid, "unknown manifest type %q", h.ManifestType)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to decode %s manifest", h.ManifestType)
+		}
+	}
+
+	switch {
+	case seen[manifestTypeSingleton] && len(seen) > 1:
+		return nil, errors.Wrap(ErrInvalid, "singleton manifest mixed with other manifests")
+	case !seen[manifestTypeSingleton] && !seen[manifestTypeInstaller]:
+		return nil, errors.Wrap(ErrInvalid, "no installer manifest")
+	case !seen[manifestTypeSingleton] && !seen[manifestTypeDefaultLocale]:
+		return nil, errors.Wrap(ErrInvalid, "no default locale manifest")
+	case len(m.Installer.Installers) == 0:
+		return nil, errors.Wrap(ErrInvalid, "no installers")
+	}
+
+	if overrides != nil {
+		m.Locale.overlay(overrides)
+	}
+
+	return &m, nil
+}
+
+// overlay replaces the fields that are set in o.
+func (l *Locale) overlay(o *Locale) {
+	for dst, src := range map[*string]string{
+		&l.PackageLocale:    o.PackageLocale,
+		&l.Publisher:        o.Publisher,
+		&l.PackageName:      o.PackageName,
+		&l.ShortDescription: o.ShortDescription,
+		&l.Description:      o.Description,
+		&l.License:          o.License,
+		&l.PackageURL:       o.PackageURL,
+	} {
+		if src != "" {
+			*dst = src
+		}
+	}
+}
+
+// Select returns the installer for the architecture and scope with the
+// defaults of the manifest applied. Without architecture x64 is preferred
+// over x86 and neutral installers, without scope machine installers are
+// preferred.
+func (m *Manifest) Select(architecture, scope string) (*Installer, error) {
+	architectures := []string{"x64", "x86", "neutral"}
+	if architecture != "" {
+		architectures = []string{strings.ToLower(architecture), "neutral"}
+	}
+
+	scopes := []string{"machine", "", "user"}
+	if scope != "" {
+		scopes = []string{strings.ToLower(scope), ""}
+	}
+
+	var (
+		selected *Installer
+		rank     int
+	)
+	for i := range m.Installer.Installers {
+		installer := m.Installer.Installers[i]
+		installer.Fields = m.Installer.Fields.merge(installer.Fields)
+
+		a := slices.Index(architectures, strings.ToLower(installer.Architecture))
+		s := slices.Index(scopes, strings.ToLower(installer.Scope))
+		if a < 0 || s < 0 {
+			continue
+		}
+
+		if r := a*len(scopes) + s; selected == nil || r < rank {
+			selected, rank = &installer, r
+		}
+	}
+
+	if selected == nil {
+		if scope != "" {
+			return nil, errors.Wrapf(ErrUnsupported, "no %s installer for architecture %s", scope, strings.Join(architectures, " or "))
+		}
+
+		return nil, errors.Wrapf(ErrUnsupported, "no installer for architecture %s", strings.Join(architectures, " or "))
+	}
+
+	if selected.InstallerURL == "" || selected.InstallerSha256 == "" {
+		return nil, errors.Wrapf(ErrInvalid, "%s installer without InstallerUrl or InstallerSha256", selected.Architecture)
+	}
+
+	return selected, nil
+}
+
+// merge returns the fields of the installer with unset fields taken from the
+// root of the manifest.
+func (f Fields) merge(installer Fields) Fields {
+	merged := installer
+	for dst, src := range map[*string]string{
+		&merged.InstallerType:                        f.InstallerType,
+		&merged.NestedInstallerType:                  f.NestedInstallerType,
+		&merged.Scope:                                f.Scope,
+		&merged.ProductCode:                          f.ProductCode,
+		&merged.PackageFamilyName:                    f.PackageFamilyName,
+		&merged.InstallerSwitches.Silent:             f.InstallerSwitches.Silent,
+		&merged.InstallerSwitches.SilentWithProgress: f.InstallerSwitches.SilentWithProgress,
+		&merged.InstallerSwitches.Custom:             f.InstallerSwitches.Custom,
+	} {
+		if *dst == "" {
+			*dst = src
+		}
+	}
+
+	if len(merged.AppsAndFeaturesEntries) == 0 {
+		merged.AppsAndFeaturesEntries = f.AppsAndFeaturesEntries
+	}
+
+	return merged
+}
@@ -0,0 +1,143 @@
+package winget
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestWingetTestSuite(t *testing.T) {
+	suite.Run(t, new(WingetTestSuite))
+}
+
+type WingetTestSuite struct {
+	suite.Suite
+}
+
+const productCode = "{6A3C1E4F-0B1D-4C7B-9A55-1F2E3D4C5B6A}"
+
+func (s *WingetTestSuite) definition(manifestPath, locale, architecture, scope string) *Definition {
+	m, err := Load(manifestPath, locale)
+	s.Require().NoError(err)
+
+	inst, err := m.Select(architecture, scope)
+	s.Require().NoError(err)
+
+	d, err := m.Definition(inst)
+	s.Require().NoError(err)
+
+	return d
+}
+
+func (s *WingetTestSuite) TestMSI() {
+	d := s.definition("testdata/Contoso.App", "", "", "")
+
+	s.Require().Equal(&Definition{
+		PackageIdentifier:    "Contoso.App",
+		DisplayName:          "Contoso App",
+		Publisher:            "Contoso Ltd.",
+		Version:              "2.1.0",
+		Description:          "Makes things.",
+		InstallerType:        "msi",
+		Architecture:         "x64",
+		Scope:                "machine",
+		InstallerURL:         "https://downloads.contoso.com/app/2.1.0/ContosoApp-x64.msi",
+		InstallerSha256:      "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+		SetupFile:            "ContosoApp-x64.msi",
+		InstallCommandLine:   `msiexec /i "ContosoApp-x64.msi" /qn /norestart ALLUSERS=1`,
+		UninstallCommandLine: "msiexec /x " + productCode + " /qn /norestart",
+		DetectionRules: []DetectionRule{{
+			ODataType:              graphProductCodeDetectionType,
+			ProductCode:            productCode,
+			ProductVersionOperator: "greaterThanOrEqual",
+			ProductVersion:         "2.1.0.42",
+		}},
+	}, d)
+}
+
+func (s *WingetTestSuite) TestEXE() {
+	d := s.definition("testdata/Contoso.App", "", "x86", "")
+
+	s.Require().Equal("inno", d.InstallerType)
+	s.Require().Equal("Contoso.App.exe", d.SetupFile)
+	s.Require().Equal(`"Contoso.App.exe" /VERYSILENT /SUPPRESSMSGBOXES /NORESTART /SP- ALLUSERS=1`, d.InstallCommandLine)
+	s.Require().Empty(d.UninstallCommandLine)
+	s.Require().Equal([]DetectionRule{{
+		ODataType:            graphRegistryDetectionType,
+		Check32BitOn64System: true,
+		KeyPath:              `HKEY_LOCAL_MACHINE\SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall\ContosoApp_is1`,
+		ValueName:            "DisplayVersion",
+		DetectionType:        "version",
+		Operator:             "greaterThanOrEqual",
+		DetectionValue:       "2.1.0.42",
+	}}, d.DetectionRules)
+	s.Require().Len(d.Warnings, 1)
+
+	d = s.definition("testdata/Contoso.App", "de-DE", "arm64", "")
+	s.Require().Equal("user", d.Scope)
+	s.Require().Equal("Contoso App", d.DisplayName)
+	s.Require().Equal("Macht Dinge.", d.Description)
+	s.Require().Equal(`"ContosoApp-arm64.exe" /S ALLUSERS=1`, d.InstallCommandLine)
+	s.Require().Equal(`HKEY_CURRENT_USER\SOFTWARE\Microsoft\Windows\CurrentVersion\Uninstall\`+productCode, d.DetectionRules[0].KeyPath)
+
+	m, err := Load("testdata/Contoso.App", "")
+	s.Require().NoError(err)
+	_, err = m.Select("arm64", "machine")
+	s.Require().ErrorIs(err, ErrUnsupported)
+}
+
+func (s *WingetTestSuite) TestSingleton() {
+	d := s.definition("testdata/Contoso.Tool.yaml", "", "", "")
+
+	s.Require().Equal("Contoso Tool", d.DisplayName)
+	s.Require().Equal("neutral", d.Architecture)
+	s.Require().Equal(`"tool-setup.exe" /silent`, d.InstallCommandLine)
+	s.Require().Empty(d.DetectionRules)
+	s.Require().Len(d.Warnings, 2)
+}
+
+func (s *WingetTestSuite) TestParseInvalid() {
+	const (
+		version   = "PackageIdentifier: a\nPackageVersion: '1'\nManifestType: version\n"
+		locale    = "PackageIdentifier: a\nPackageVersion: '1'\nManifestType: defaultLocale\n"
+		installer = "PackageIdentifier: a\nPackageVersion: '1'\nManifestType: installer\n"
+	)
+
+	for message, documents := range map[string][]string{
+		"no installer manifest":          {version, locale},
+		"no default locale manifest":     {version, installer + "Installers: [{Architecture: x64}]\n"},
+		"no installers":                  {version, locale, installer},
+		"installer manifest given twice": {installer, installer},
+		"manifests of a 1 and b 1 mixed": {version, "PackageIdentifier: b\nPackageVersion: '1'\nManifestType: locale\n"},
+		"unknown manifest type":          {"PackageIdentifier: a\nPackageVersion: '1'\nManifestType: merged\n"},
+	} {
+		data := make([][]byte, 0, len(documents))
+		for _, document := range documents {
+			data = append(data, []byte(document))
+		}
+
+		_, err := Parse("", data...)
+		s.Require().ErrorContains(err, message)
+	}
+
+	m, err := Parse("", []byte(locale), []byte(installer+"Installers: [{Architecture: x64, InstallerType: zip, InstallerUrl: u, InstallerSha256: x}]\n"))
+	s.Require().NoError(err)
+	inst, err := m.Select("", "")
+	s.Require().NoError(err)
+	_, err = m.Definition(inst)
+	s.Require().ErrorIs(err, ErrUnsupported)
+}
+
+func (s *WingetTestSuite) TestCheckInstaller() {
+	d := s.definition("testdata/Contoso.App", "", "", "")
+
+	installerFilePath := filepath.Join(s.T().TempDir(), "installer")
+	s.Require().NoError(os.WriteFile(installerFilePath, []byte("test"), 0644))
+	s.Require().NoError(d.CheckInstaller(installerFilePath))
+
+	s.Require().NoError(os.WriteFile(installerFilePath, []byte("tampered"), 0644))
+	s.Require().True(errors.Is(d.CheckInstaller(installerFilePath), ErrChecksum))
+}
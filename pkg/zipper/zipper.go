@@ -12,20 +12,93 @@ import (
 	"github.com/pkg/errors"
 )
 
-func Zip(fsys fs.FS, out io.Writer) error {
+type options struct {
+	method   uint16
+	exclude  []string
+	visitors []Visitor
+}
+
+// Visitor is notified of every file added to an archive.
+type Visitor interface {
+	// Visit returns a writer that receives the content of the file while it is
+	// archived, or nil. The writer is closed after the file has been written.
+	Visit(name string, info fs.FileInfo) (io.WriteCloser, error)
+}
+
+type Option func(o *options)
+
+// WithMethod sets the compression method of the archive entries, zip.Store by default.
+func WithMethod(method uint16) Option {
+	return func(o *options) {
+		o.method = method
+	}
+}
+
+// WithExclude skips files and folders matching any of the given path.Match
+// patterns. Patterns are matched against the full path and the base name.
+func WithExclude(patterns ...string) Option {
+	return func(o *options) {
+		o.exclude = append(o.exclude, patterns...)
+	}
+}
+
+// WithVisitor notifies v of every file added to the archive.
+func WithVisitor(v Visitor) Option {
+	return func(o *options) {
+		o.visitors = append(o.visitors, v)
+	}
+}
+
+func Zip(fsys fs.FS, out io.Writer, opts ...Option) error {
+	o := &options{
+		method: zip.Store,
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	for _, pattern := range o.exclude {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return errors.Wrapf(err, "invalid exclude pattern %q", pattern)
+		}
+	}
+
 	zipper := zip.NewWriter(out)
 	defer zipper.Close()
 
-	return addFs(zipper, fsys)
+	return addFs(zipper, fsys, o)
+}
+
+// Excluded reports whether name matches any of the exclude patterns.
+func Excluded(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+
+		if ok, _ := path.Match(pattern, path.Base(name)); ok {
+			return true
+		}
+	}
+
+	return false
 }
 
 // We need to copypasta the AddFS method from the zip.Writer because it does not allow us to set the desired compression method
-func addFs(w *zip.Writer, fsys fs.FS) error {
+func addFs(w *zip.Writer, fsys fs.FS, o *options) error {
 	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
 
+		if name != "." && Excluded(name, o.exclude) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+
+			return nil
+		}
+
 		if d.IsDir() {
 			return nil
 		}
@@ -45,7 +118,7 @@ func addFs(w *zip.Writer, fsys fs.FS) error {
 		}
 
 		h.Name = name
-		h.Method = zip.Store
+		h.Method = o.method
 
 		fw, err := w.CreateHeader(h)
 		if err != nil {
@@ -58,11 +131,31 @@ func addFs(w *zip.Writer, fsys fs.FS) error {
 		}
 		defer f.Close()
 
-		_, err = io.Copy(fw, f)
+		dst := io.Writer(fw)
+		var visitors []io.WriteCloser
+		for _, v := range o.visitors {
+			vw, err := v.Visit(name, info)
+			if err != nil {
+				return err
+			}
+
+			if vw != nil {
+				visitors = append(visitors, vw)
+				dst = io.MultiWriter(dst, vw)
+			}
+		}
+
+		_, err = io.Copy(dst, f)
 		if err != nil {
 			return err
 		}
 
+		for _, vw := range visitors {
+			if err := vw.Close(); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 }
@@ -127,3 +220,42 @@ func Unzip(archive *os.File, dest string) error {
 
 	return nil
 }
+
+// ReadFile reads a single entry of the archive, refusing entries larger than
+// limit bytes.
+func ReadFile(r *zip.Reader, name string, limit int64) ([]byte, error) {
+	for _, f := range r.File {
+		if f.Name != name {
+			continue
+		}
+
+		if f.UncompressedSize64 > uint64(limit) {
+			return nil, errors.Errorf("%s is larger than %d bytes", name, limit)
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+
+		return io.ReadAll(io.LimitReader(rc, limit))
+	}
+
+	return nil, errors.Wrapf(fs.ErrNotExist, "%s", name)
+}
+
+// StoredSection returns the contents of an uncompressed entry of the archive
+// read from r, without copying it.
+func StoredSection(r io.ReaderAt, f *zip.File) (*io.SectionReader, error) {
+	if f.Method != zip.Store {
+		return nil, errors.Errorf("%s is compressed", f.Name)
+	}
+
+	offset, err := f.DataOffset()
+	if err != nil {
+		return nil, err
+	}
+
+	return io.NewSectionReader(r, offset, int64(f.UncompressedSize64)), nil
+}
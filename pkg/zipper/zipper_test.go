@@ -1,6 +1,9 @@
 package zipper
 
 import (
+	"archive/zip"
+	"io"
+	"io/fs"
 	"os"
 	"path"
 	"testing"
@@ -94,3 +97,89 @@ func (s *ZipperTestSuite) TestUnzip() {
 	s.Require().Equal("Hello, World 2!", string(buf2))
 
 }
+
+func (s *ZipperTestSuite) TestZipOptions() {
+	err := os.WriteFile(path.Join(s.srcDir, "debug.log"), []byte("log"), 0644)
+	s.Require().NoError(err)
+
+	err = Zip(os.DirFS(s.srcDir), s.destFile, WithMethod(zip.Deflate), WithExclude("*.log", "subdir"))
+	s.Require().NoError(err)
+
+	info, err := s.destFile.Stat()
+	s.Require().NoError(err)
+
+	r, err := zip.NewReader(s.destFile, info.Size())
+	s.Require().NoError(err)
+
+	s.Require().Len(r.File, 1)
+	s.Require().Equal("test", r.File[0].Name)
+	s.Require().Equal(zip.Deflate, r.File[0].Method)
+
+	err = Zip(os.DirFS(s.srcDir), s.destFile, WithExclude("["))
+	s.Require().Error(err)
+}
+
+func (s *ZipperTestSuite) TestReadFile() {
+	err := Zip(os.DirFS(s.srcDir), s.destFile)
+	s.Require().NoError(err)
+
+	info, err := s.destFile.Stat()
+	s.Require().NoError(err)
+
+	r, err := zip.NewReader(s.destFile, info.Size())
+	s.Require().NoError(err)
+
+	data, err := ReadFile(r, "subdir/test2", 1024)
+	s.Require().NoError(err)
+	s.Require().Equal("Hello, World 2!", string(data))
+
+	_, err = ReadFile(r, "subdir/test2", 4)
+	s.Require().Error(err)
+
+	_, err = ReadFile(r, "missing", 1024)
+	s.Require().ErrorIs(err, fs.ErrNotExist)
+
+	section, err := StoredSection(s.destFile, r.File[0])
+	s.Require().NoError(err)
+
+	data, err = io.ReadAll(section)
+	s.Require().NoError(err)
+	s.Require().Equal("Hello, World 2!", string(data))
+}
+
+type recordingVisitor struct {
+	files map[string]*bufferCloser
+}
+
+type bufferCloser struct {
+	data   []byte
+	closed bool
+}
+
+func (b *bufferCloser) Write(p []byte) (int, error) {
+	b.data = append(b.data, p...)
+	return len(p), nil
+}
+
+func (b *bufferCloser) Close() error {
+	b.closed = true
+	return nil
+}
+
+func (v *recordingVisitor) Visit(name string, _ fs.FileInfo) (io.WriteCloser, error) {
+	b := &bufferCloser{}
+	v.files[name] = b
+
+	return b, nil
+}
+
+func (s *ZipperTestSuite) TestZipVisitor() {
+	v := &recordingVisitor{files: map[string]*bufferCloser{}}
+
+	err := Zip(os.DirFS(s.srcDir), s.destFile, WithVisitor(v), WithExclude("test"))
+	s.Require().NoError(err)
+
+	s.Require().Len(v.files, 1)
+	s.Require().Equal("Hello, World 2!", string(v.files["subdir/test2"].data))
+	s.Require().True(v.files["subdir/test2"].closed)
+}